@@ -17,6 +17,8 @@ const (
 	actionKindBlockUser    = "block_user"
 	actionKindRedirectIP   = "redirect_ip"
 	actionKindRedirectUser = "redirect_user"
+	actionKindThrottleIP   = "throttle_ip"
+	actionKindThrottleUser = "throttle_user"
 )
 
 // Action is an interface common to each concrete action type stored in the data
@@ -78,9 +80,15 @@ type timedAction struct {
 // withDuration sets a time duration to an action. The returned value implements
 // the Action and Timed interfaces.
 func withDuration(action Action, duration time.Duration) *timedAction {
+	return withDeadline(action, time.Now().Add(duration))
+}
+
+// withDeadline sets an expiration time to an action. The returned value
+// implements the Action and Timed interfaces.
+func withDeadline(action Action, deadline time.Time) *timedAction {
 	return &timedAction{
 		Action:   action,
-		deadline: time.Now().Add(duration),
+		deadline: deadline,
 	}
 }
 
@@ -8,6 +8,7 @@ import (
 	"crypto/sha256"
 	"math"
 	"net"
+	"sync"
 	"sync/atomic"
 	"time"
 	"unsafe"
@@ -16,6 +17,7 @@ import (
 	"github.com/pkg/errors"
 	"github.com/sqreen/go-agent/internal/backend/api"
 	"github.com/sqreen/go-agent/internal/plog"
+	"github.com/sqreen/go-agent/internal/sqlib/sqmmdb"
 )
 
 // Store is the structure associating IP addresses or user IDs to security
@@ -37,6 +39,13 @@ type Store struct {
 	cidrIPPasslistStore *CIDRIPListStore
 	// The store of the path passlist.
 	pathPasslistStore *PathListStore
+	// The optional geoip database used to find the security actions by country
+	// or autonomous system number.
+	geoIPDatabase *sqmmdb.Reader
+	// The optional file where the security actions are saved in order to be
+	// restored on startup.
+	snapshotFile string
+	snapshotMu   sync.Mutex
 
 	logger plog.DebugLevelLogger
 }
@@ -119,7 +128,9 @@ func (s *Store) setActionStore(store *actionStore) {
 
 // FindIP returns the security action of the given IP v4/v6 address. The
 // returned boolean `exists` is `false` when it is not present in the
-// actionStore, `true` otherwise.
+// actionStore, `true` otherwise. The geoip database is looked up when no
+// action matched the IP address, in order to find the action of its country or
+// autonomous system number.
 func (s *Store) FindIP(ip net.IP) (action Action, exists bool, err error) {
 	store := s.getActionStore()
 	if store == nil {
@@ -127,28 +138,31 @@ func (s *Store) FindIP(ip net.IP) (action Action, exists bool, err error) {
 	}
 
 	if stdIPv4 := ip.To4(); stdIPv4 != nil {
-		tree := store.treeV4
-		if tree == nil {
-			return nil, false, nil
+		if tree := store.treeV4; tree != nil {
+			IPv4 := patricia.NewIPv4AddressFromBytes(stdIPv4, ipv4Bits)
+			action, err = tree.findAction(&IPv4)
 		}
-		IPv4 := patricia.NewIPv4AddressFromBytes(stdIPv4, ipv4Bits)
-		action, err = tree.findAction(&IPv4)
 	} else if stdIPv6 := ip.To16(); stdIPv6 != nil {
 		// warning: the previous condition is also true with ipv4 address (as they
 		// can be represented using ipv6 ::ffff:ipv4), so testing the ipv4 first is
 		// important to avoid entering this case with ipv4 addresses.
-		tree := store.treeV6
-		if tree == nil {
-			return nil, false, nil
+		if tree := store.treeV6; tree != nil {
+			IPv6 := patricia.NewIPv6Address(stdIPv6, ipv6Bits)
+			action, err = tree.findAction(&IPv6)
 		}
-		IPv6 := patricia.NewIPv6Address(stdIPv6, ipv6Bits)
-		action, err = tree.findAction(&IPv6)
 	}
 
 	if err != nil {
 		return nil, false, err
 	}
 
+	if action == nil && store.hasGeoIPActions() {
+		action, err = s.findGeoIPAction(store, ip)
+		if err != nil {
+			return nil, false, err
+		}
+	}
+
 	// action may be nil if ip does not exist in the tree.
 	return action, action != nil, nil
 }
@@ -187,6 +201,7 @@ func (s *Store) SetActions(actions []api.ActionsPackResponse_Action) error {
 		return err
 	}
 	s.setActionStore(store)
+	s.saveSnapshot()
 	return nil
 }
 
@@ -199,89 +214,122 @@ type actionStore struct {
 	treeV4 *actionTreeV4
 	treeV6 *actionTreeV6
 	users  userActionMap
+	// Actions by country code and autonomous system number.
+	countries map[string]Action
+	asns      map[uint32]Action
+	// The list of actions the store was built from, in order to be able to
+	// rebuild it or save it.
+	entries []actionEntry
 }
 
 type userActionMap map[UserIdentifiersHash]Action
 
 func newActionStore(actions []api.ActionsPackResponse_Action) (*actionStore, error) {
-	if len(actions) == 0 {
+	return buildActionStore(nil, actions)
+}
+
+// buildActionStore returns a new action store of the given entries and
+// actions. The entry action values are reused so that their state is kept,
+// such as the token buckets of throttling actions. The actions are added after
+// the entries and therefore have the priority when they overlap.
+func buildActionStore(entries []actionEntry, actions []api.ActionsPackResponse_Action) (*actionStore, error) {
+	if len(entries) == 0 && len(actions) == 0 {
 		return nil, nil
 	}
 
 	store := new(actionStore)
 
+	for _, entry := range entries {
+		if err := store.placeAction(entry.api, entry.action); err != nil {
+			return nil, err
+		}
+		store.entries = append(store.entries, entry)
+	}
+
+	now := time.Now()
 	for _, action := range actions {
-		err := store.addAction(action)
+		deadline, err := actionDeadline(action, now)
 		if err != nil {
 			return nil, err
 		}
+		if err := store.addAction(action, deadline); err != nil {
+			return nil, err
+		}
 	}
 
 	return store, nil
 }
 
-func (s *actionStore) addAction(action api.ActionsPackResponse_Action) (err error) {
-	switch action.Action {
-	case actionKindBlockIP:
-		err = s.addBlockIPAction(action)
-	case actionKindBlockUser:
-		err = s.addBlockUserAction(action)
-	case actionKindRedirectIP:
-		err = s.addRedirectIPAction(action)
-	case actionKindRedirectUser:
-		err = s.addRedirectUserAction(action)
-	}
-	return err
-}
-
-func (s *actionStore) addBlockIPAction(action api.ActionsPackResponse_Action) error {
+// actionDeadline returns the expiration time of the action, or the zero time
+// when the action doesn't expire.
+func actionDeadline(action api.ActionsPackResponse_Action, now time.Time) (time.Time, error) {
 	duration, err := float64ToDuration(action.Duration)
 	if err != nil {
-		return err
+		return time.Time{}, err
 	}
-	var blockIP Action = newBlockAction(action.ActionId)
-	if duration > 0 {
-		blockIP = withDuration(blockIP, duration)
+	if duration <= 0 {
+		return time.Time{}, nil
 	}
-	cidrs := action.Parameters.IpCidr
-	if len(cidrs) == 0 {
-		return errors.Errorf("could not add action `%s`: empty list of CIDRs", action.ActionId)
-	}
-	return s.addCIDRList(cidrs, blockIP)
+	return now.Add(duration), nil
 }
 
-func (s *actionStore) addRedirectIPAction(action api.ActionsPackResponse_Action) error {
-	duration, err := float64ToDuration(action.Duration)
+// addAction creates the action of the given kind and adds it to the store. The
+// action expires at the given deadline unless it is zero. Unknown action kinds
+// are ignored.
+func (s *actionStore) addAction(action api.ActionsPackResponse_Action, deadline time.Time) (err error) {
+	var value Action
+	switch action.Action {
+	case actionKindBlockIP, actionKindBlockUser:
+		value = newBlockAction(action.ActionId)
+	case actionKindRedirectIP, actionKindRedirectUser:
+		value, err = newRedirectAction(action.ActionId, action.Parameters.Url)
+	case actionKindThrottleIP, actionKindThrottleUser:
+		value, err = newThrottleActionFromAPI(action, deadline)
+	default:
+		return nil
+	}
 	if err != nil {
 		return err
 	}
-	var redirectIP Action
-	redirectIP, err = newRedirectAction(action.ActionId, action.Parameters.Url)
-	if duration > 0 {
-		redirectIP = withDuration(redirectIP, duration)
-	}
-	cidrs := action.Parameters.IpCidr
-	if len(cidrs) == 0 {
-		return errors.Errorf("could not add action `%s`: empty list of CIDRs", action.ActionId)
+
+	// Throttling actions already implement the Timed interface
+	if _, timed := value.(Timed); !timed && !deadline.IsZero() {
+		value = withDeadline(value, deadline)
 	}
-	return s.addCIDRList(cidrs, redirectIP)
-}
 
-func (s *actionStore) addRedirectUserAction(action api.ActionsPackResponse_Action) error {
-	duration, err := float64ToDuration(action.Duration)
-	if err != nil {
+	if err := s.placeAction(action, value); err != nil {
 		return err
 	}
-	var redirectUser Action
-	redirectUser, err = newRedirectAction(action.ActionId, action.Parameters.Url)
-	if duration > 0 {
-		redirectUser = withDuration(redirectUser, duration)
+	s.entries = append(s.entries, actionEntry{
+		api:      action,
+		action:   value,
+		deadline: deadline,
+	})
+	return nil
+}
+
+// placeAction adds the action value to the data structures of the IP
+// addresses or users of the action parameters.
+func (s *actionStore) placeAction(action api.ActionsPackResponse_Action, value Action) error {
+	switch action.Action {
+	case actionKindBlockIP, actionKindRedirectIP, actionKindThrottleIP:
+		return s.addIPActorList(action, value)
+	default:
+		users := action.Parameters.Users
+		if len(users) == 0 {
+			return errors.Errorf("could not add action `%s`: empty list of users", action.ActionId)
+		}
+		return s.addUserList(users, value)
 	}
-	users := action.Parameters.Users
-	if len(users) == 0 {
-		return errors.Errorf("could not add action `%s`: empty list of users", action.ActionId)
+}
+
+func newThrottleActionFromAPI(action api.ActionsPackResponse_Action, deadline time.Time) (*throttleAction, error) {
+	// The time window can be a fraction of seconds
+	window := action.Parameters.Window * float64(time.Second)
+	if window >= math.MaxInt64 {
+		return nil, errors.Errorf("could not convert the time window `%f` to seconds due to int64 overflow", action.Parameters.Window)
 	}
-	return s.addUserList(users, redirectUser)
+	return newThrottleAction(action.ActionId, action.Parameters.Limit, time.Duration(window), deadline)
 }
 
 // Convert a float64 to a `time.Duration` by making sure it doesn't overflow.
@@ -292,6 +340,25 @@ func float64ToDuration(duration float64) (time.Duration, error) {
 	return time.Duration(duration) * time.Second, nil
 }
 
+// addIPActorList adds the action to the CIDRs, countries and autonomous system
+// numbers of the action parameters.
+func (s *actionStore) addIPActorList(action api.ActionsPackResponse_Action, ipAction Action) error {
+	params := action.Parameters
+	if len(params.IpCidr) == 0 && len(params.Countries) == 0 && len(params.Asns) == 0 {
+		return errors.Errorf("could not add action `%s`: empty list of CIDRs, countries and ASNs", action.ActionId)
+	}
+	if err := s.addCIDRList(params.IpCidr, ipAction); err != nil {
+		return err
+	}
+	if err := s.addCountryList(params.Countries, ipAction); err != nil {
+		return errors.Wrapf(err, "could not add action `%s`", action.ActionId)
+	}
+	if err := s.addASNList(params.Asns, ipAction); err != nil {
+		return errors.Wrapf(err, "could not add action `%s`", action.ActionId)
+	}
+	return nil
+}
+
 func (s *actionStore) addCIDRList(cidrs []string, action Action) error {
 	for _, cidr := range cidrs {
 		ip4, ip6, err := patricia.ParseIPFromString(cidr)
@@ -325,22 +392,6 @@ func (s *actionStore) addCIDRv6(ip *patricia.IPv6Address, action Action) error {
 	return s.treeV6.addAction(ip, action)
 }
 
-func (s *actionStore) addBlockUserAction(action api.ActionsPackResponse_Action) error {
-	duration, err := float64ToDuration(action.Duration)
-	if err != nil {
-		return err
-	}
-	var blockUser Action = newBlockAction(action.ActionId)
-	if duration > 0 {
-		blockUser = withDuration(blockUser, duration)
-	}
-	users := action.Parameters.Users
-	if len(users) == 0 {
-		return errors.Errorf("could not add action `%s`: empty list of users", action.ActionId)
-	}
-	return s.addUserList(users, blockUser)
-}
-
 func (s *actionStore) addUserList(users []map[string]string, action Action) error {
 	if len(s.users)+len(users) >= maxStoreActions {
 		return errors.Errorf("number of actions `%d` exceeds `%d`", len(users), maxStoreActions)
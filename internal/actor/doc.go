@@ -40,6 +40,12 @@
 // response. The user and IP address are used as properties of events performed
 // by handlers.
 //
+// GeoIP Database
+//
+// IP address security actions can also apply to countries and autonomous
+// system numbers when a MaxMind DB file is configured. The database is only
+// looked up when no CIDR matched the IP address.
+//
 // CIDR Whitelist Store
 //
 // The CIDR whitelist store is a set of radix trees simply storing CIDRs that
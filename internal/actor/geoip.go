@@ -0,0 +1,141 @@
+// Copyright (c) 2016 - 2020 Sqreen. All Rights Reserved.
+// Please refer to our terms for more information:
+// https://www.sqreen.io/terms.html
+
+package actor
+
+import (
+	"math"
+	"net"
+	"strings"
+	"sync/atomic"
+	"unsafe"
+
+	"github.com/sqreen/go-agent/internal/sqlib/sqerrors"
+	"github.com/sqreen/go-agent/internal/sqlib/sqmmdb"
+)
+
+// GeoIPInfo is the geolocation information of an IP address.
+type GeoIPInfo struct {
+	// Country is the ISO 3166-1 alpha-2 country code.
+	Country string
+	// ASN is the autonomous system number.
+	ASN uint32
+}
+
+// SetGeoIPDatabase loads the MaxMind DB file at the given path and then
+// replaces the current one. The database is expected to provide the country
+// code and/or the autonomous system number of IP addresses, as the GeoIP2 and
+// GeoLite2 Country, City and ASN databases do. Security actions by country or
+// ASN are ignored when no database is set.
+func (s *Store) SetGeoIPDatabase(path string) error {
+	db, err := sqmmdb.Open(path)
+	if err != nil {
+		return err
+	}
+	s.logger.Debugf("actor: using the geoip database `%s` of type `%s`", path, db.Metadata.DatabaseType)
+	s.setGeoIPDatabase(db)
+	return nil
+}
+
+// getGeoIPDatabase is a thread-safe database getter.
+func (s *Store) getGeoIPDatabase() *sqmmdb.Reader {
+	return (*sqmmdb.Reader)(atomic.LoadPointer((*unsafe.Pointer)(unsafe.Pointer(&s.geoIPDatabase))))
+}
+
+// setGeoIPDatabase is a thread-safe database setter.
+func (s *Store) setGeoIPDatabase(db *sqmmdb.Reader) {
+	atomic.StorePointer((*unsafe.Pointer)(unsafe.Pointer(&s.geoIPDatabase)), unsafe.Pointer(db))
+}
+
+// LookupGeoIP returns the geolocation information of the given IP address. The
+// returned boolean `found` is `false` when no database is set or when the IP
+// address is not in the database, `true` otherwise.
+func (s *Store) LookupGeoIP(ip net.IP) (info GeoIPInfo, found bool, err error) {
+	db := s.getGeoIPDatabase()
+	if db == nil || ip == nil {
+		return GeoIPInfo{}, false, nil
+	}
+	record, found, err := db.Lookup(ip)
+	if err != nil || !found {
+		return GeoIPInfo{}, false, err
+	}
+	info = newGeoIPInfo(record)
+	return info, info != GeoIPInfo{}, nil
+}
+
+// newGeoIPInfo returns the geolocation information of a database record
+// following the GeoIP2 record structure.
+func newGeoIPInfo(record interface{}) (info GeoIPInfo) {
+	m, ok := record.(map[string]interface{})
+	if !ok {
+		return GeoIPInfo{}
+	}
+	// The country is preferably the one of the IP address location, and
+	// otherwise the one the IP address is registered in.
+	for _, key := range []string{"country", "registered_country"} {
+		if country, ok := m[key].(map[string]interface{}); ok {
+			if code, ok := country["iso_code"].(string); ok && code != "" {
+				info.Country = code
+				break
+			}
+		}
+	}
+	if asn, ok := m["autonomous_system_number"].(uint64); ok && asn <= math.MaxUint32 {
+		info.ASN = uint32(asn)
+	}
+	return info
+}
+
+// findGeoIPAction returns the security action of the country or the ASN of the
+// given IP address. The ASN actions are more specific and therefore have the
+// priority over country actions.
+func (s *Store) findGeoIPAction(store *actionStore, ip net.IP) (Action, error) {
+	info, found, err := s.LookupGeoIP(ip)
+	if err != nil || !found {
+		return nil, err
+	}
+	if action, exists := store.asns[info.ASN]; exists && !isExpired(action) {
+		return action, nil
+	}
+	if action, exists := store.countries[info.Country]; exists && !isExpired(action) {
+		return action, nil
+	}
+	return nil, nil
+}
+
+func isExpired(action Action) bool {
+	timed, implementsTimed := action.(Timed)
+	return implementsTimed && timed.Expired()
+}
+
+func (s *actionStore) addCountryList(countries []string, action Action) error {
+	if s.countries == nil {
+		s.countries = make(map[string]Action, len(countries))
+	}
+	for _, country := range countries {
+		if len(country) != 2 {
+			return sqerrors.Errorf("unexpected country code `%s`", country)
+		}
+		s.countries[strings.ToUpper(country)] = action
+	}
+	return nil
+}
+
+func (s *actionStore) addASNList(asns []uint32, action Action) error {
+	if s.asns == nil {
+		s.asns = make(map[uint32]Action, len(asns))
+	}
+	for _, asn := range asns {
+		if asn == 0 {
+			return sqerrors.New("unexpected zero autonomous system number")
+		}
+		s.asns[asn] = action
+	}
+	return nil
+}
+
+// hasGeoIPActions returns true when the store has actions by country or ASN.
+func (s *actionStore) hasGeoIPActions() bool {
+	return len(s.countries) > 0 || len(s.asns) > 0
+}
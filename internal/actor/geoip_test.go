@@ -0,0 +1,148 @@
+// Copyright (c) 2016 - 2020 Sqreen. All Rights Reserved.
+// Please refer to our terms for more information:
+// https://www.sqreen.io/terms.html
+
+package actor_test
+
+import (
+	"io/ioutil"
+	"net"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/sqreen/go-agent/internal/actor"
+	"github.com/sqreen/go-agent/internal/backend/api"
+	"github.com/sqreen/go-agent/internal/sqlib/sqmmdb/_testlib"
+	"github.com/stretchr/testify/require"
+)
+
+func NewGeoIPAction(kind string, countries []string, asns []uint32) *api.ActionsPackResponse_Action {
+	action := &api.ActionsPackResponse_Action{
+		Action: kind,
+		Parameters: api.ActionsPackResponse_Action_Params{
+			Countries: countries,
+			Asns:      asns,
+			Url:       "http://sqreen.com",
+			Limit:     1,
+			Window:    1,
+		},
+	}
+	fuzzer.Fuzz(&action.ActionId)
+	return action
+}
+
+func newTestGeoIPDatabase(t *testing.T) (path string, remove func()) {
+	dir, err := ioutil.TempDir("", "actor")
+	require.NoError(t, err)
+	path = filepath.Join(dir, "geoip.mmdb")
+	db := testlib.NewMMDB(6, 28, map[string]interface{}{
+		"1.2.3.0/24": map[string]interface{}{
+			"country":                  map[string]interface{}{"iso_code": "FR"},
+			"autonomous_system_number": uint32(3215),
+		},
+		"5.6.7.0/24": map[string]interface{}{
+			"country": map[string]interface{}{"iso_code": "US"},
+		},
+		"9.9.9.0/24": map[string]interface{}{
+			"registered_country":       map[string]interface{}{"iso_code": "DE"},
+			"autonomous_system_number": uint32(64500),
+		},
+		"2001:db8::/32": map[string]interface{}{
+			"country": map[string]interface{}{"iso_code": "FR"},
+		},
+	})
+	require.NoError(t, ioutil.WriteFile(path, db, 0644))
+	return path, func() { os.RemoveAll(dir) }
+}
+
+func TestGeoIP(t *testing.T) {
+	path, remove := newTestGeoIPDatabase(t)
+	defer remove()
+
+	t.Run("LookupGeoIP", func(t *testing.T) {
+		store := actor.NewStore(logger)
+		_, found, err := store.LookupGeoIP(net.ParseIP("1.2.3.4"))
+		require.NoError(t, err)
+		require.False(t, found)
+
+		require.Error(t, store.SetGeoIPDatabase(filepath.Join(filepath.Dir(path), "not-found.mmdb")))
+		require.NoError(t, store.SetGeoIPDatabase(path))
+
+		for _, tc := range []struct {
+			ip       string
+			expected actor.GeoIPInfo
+		}{
+			{ip: "1.2.3.4", expected: actor.GeoIPInfo{Country: "FR", ASN: 3215}},
+			{ip: "5.6.7.8", expected: actor.GeoIPInfo{Country: "US"}},
+			{ip: "9.9.9.9", expected: actor.GeoIPInfo{Country: "DE", ASN: 64500}},
+			{ip: "2001:db8::1", expected: actor.GeoIPInfo{Country: "FR"}},
+			{ip: "8.8.8.8"},
+		} {
+			info, found, err := store.LookupGeoIP(net.ParseIP(tc.ip))
+			require.NoError(t, err, tc.ip)
+			require.Equal(t, tc.expected != actor.GeoIPInfo{}, found, tc.ip)
+			require.Equal(t, tc.expected, info, tc.ip)
+		}
+	})
+
+	t.Run("Malformed actions", func(t *testing.T) {
+		for _, action := range []*api.ActionsPackResponse_Action{
+			NewGeoIPAction("block_ip", []string{"FRA"}, nil),
+			NewGeoIPAction("block_ip", nil, []uint32{0}),
+			NewGeoIPAction("block_ip", nil, nil),
+		} {
+			store := actor.NewStore(logger)
+			require.Error(t, store.SetActions([]api.ActionsPackResponse_Action{*action}))
+		}
+	})
+
+	t.Run("FindIP", func(t *testing.T) {
+		blockCIDR := NewBlockIPAction("1.2.3.4/32")
+		blockCountry := NewGeoIPAction("block_ip", []string{"fr"}, nil)
+		redirectASN := NewGeoIPAction("redirect_ip", nil, []uint32{64500, 3215})
+		throttleCountry := NewGeoIPAction("throttle_ip", []string{"US"}, nil)
+		actions := []api.ActionsPackResponse_Action{*blockCIDR, *blockCountry, *redirectASN, *throttleCountry}
+
+		t.Run("without database", func(t *testing.T) {
+			store := actor.NewStore(logger)
+			require.NoError(t, store.SetActions(actions))
+			_, exists, err := store.FindIP(net.ParseIP("1.2.3.5"))
+			require.NoError(t, err)
+			require.False(t, exists)
+		})
+
+		t.Run("with database", func(t *testing.T) {
+			store := actor.NewStore(logger)
+			require.NoError(t, store.SetActions(actions))
+			require.NoError(t, store.SetGeoIPDatabase(path))
+
+			for _, tc := range []struct {
+				ip       string
+				expected *api.ActionsPackResponse_Action
+			}{
+				// CIDRs have the priority
+				{ip: "1.2.3.4", expected: blockCIDR},
+				// ASNs have the priority over countries
+				{ip: "1.2.3.5", expected: redirectASN},
+				{ip: "9.9.9.9", expected: redirectASN},
+				{ip: "5.6.7.8", expected: throttleCountry},
+				{ip: "2001:db8::1", expected: blockCountry},
+				{ip: "8.8.8.8"},
+			} {
+				action, exists, err := store.FindIP(net.ParseIP(tc.ip))
+				require.NoError(t, err, tc.ip)
+				if tc.expected == nil {
+					require.False(t, exists, tc.ip)
+					continue
+				}
+				require.True(t, exists, tc.ip)
+				require.Equal(t, tc.expected.ActionId, action.ActionID(), tc.ip)
+			}
+
+			action, _, _ := store.FindIP(net.ParseIP("5.6.7.8"))
+			_, ok := action.(actor.ThrottleAction)
+			require.True(t, ok)
+		})
+	})
+}
@@ -0,0 +1,221 @@
+// Copyright (c) 2016 - 2020 Sqreen. All Rights Reserved.
+// Please refer to our terms for more information:
+// https://www.sqreen.io/terms.html
+
+package actor
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sync/atomic"
+	"time"
+	"unsafe"
+
+	"github.com/sqreen/go-agent/internal/backend/api"
+	"github.com/sqreen/go-agent/internal/sqlib/sqerrors"
+)
+
+// actionEntry is an action of the store along with the backend action it was
+// created from and its expiration time.
+type actionEntry struct {
+	api    api.ActionsPackResponse_Action
+	action Action
+	// deadline is the zero time when the action doesn't expire.
+	deadline time.Time
+}
+
+func (e *actionEntry) expired(now time.Time) bool {
+	return !e.deadline.IsZero() && !now.Before(e.deadline)
+}
+
+// Version of the snapshot file format.
+const actionsSnapshotVersion = 1
+
+// actionsSnapshot is the content of the snapshot file. The expiration times
+// are absolute so that the remaining durations can be computed when restoring
+// the actions.
+type actionsSnapshot struct {
+	Version int                    `json:"version"`
+	Actions []actionsSnapshotEntry `json:"actions"`
+}
+
+type actionsSnapshotEntry struct {
+	Action   api.ActionsPackResponse_Action `json:"action"`
+	Deadline *time.Time                     `json:"deadline,omitempty"`
+}
+
+// SetActionsSnapshotFile sets the file where the security actions are saved
+// every time they change, and restores the actions it may already contain
+// without the expired ones. The restored actions are kept by `AddActions()`
+// until they are replaced by `SetActions()`. It must be called before any other
+// method of the store.
+func (s *Store) SetActionsSnapshotFile(path string) error {
+	s.snapshotFile = path
+
+	buf, err := ioutil.ReadFile(path)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil
+		}
+		return sqerrors.Wrap(err, "could not read the actions snapshot file")
+	}
+
+	var snapshot actionsSnapshot
+	if err := json.Unmarshal(buf, &snapshot); err != nil {
+		return sqerrors.Wrapf(err, "could not parse the actions snapshot file `%s`", path)
+	}
+	if snapshot.Version != actionsSnapshotVersion {
+		return sqerrors.Errorf("unexpected actions snapshot file version `%d`", snapshot.Version)
+	}
+
+	store := new(actionStore)
+	now := time.Now()
+	for _, entry := range snapshot.Actions {
+		var deadline time.Time
+		if entry.Deadline != nil {
+			deadline = *entry.Deadline
+			if !now.Before(deadline) {
+				continue
+			}
+		}
+		if err := store.addAction(entry.Action, deadline); err != nil {
+			return sqerrors.Wrap(err, "could not restore the actions snapshot")
+		}
+	}
+	restored := len(store.entries)
+	if restored == 0 {
+		store = nil
+	}
+	s.setActionStore(store)
+	s.logger.Debugf("actor: restored %d actions from the snapshot file `%s`", restored, path)
+	return nil
+}
+
+// AddActions creates a new action store of the given actions along with the
+// current ones which have not expired, and then replaces the current one. The
+// current actions having the same ID as a given one are replaced.
+func (s *Store) AddActions(actions []api.ActionsPackResponse_Action) error {
+	var entries []actionEntry
+	if current := s.getActionStore(); current != nil {
+		ids := make(map[string]struct{}, len(actions))
+		for _, action := range actions {
+			ids[action.ActionId] = struct{}{}
+		}
+		now := time.Now()
+		for _, entry := range current.entries {
+			if _, replaced := ids[entry.api.ActionId]; !replaced && !entry.expired(now) {
+				entries = append(entries, entry)
+			}
+		}
+	}
+
+	store, err := buildActionStore(entries, actions)
+	if err != nil {
+		s.logger.Error(err)
+		return err
+	}
+	s.setActionStore(store)
+	s.saveSnapshot()
+	return nil
+}
+
+// SweepExpiredActions removes the expired actions from the store, so that they
+// no longer need to be filtered out at lookup time. The current store is
+// replaced by a new one without the expired actions, unless it was replaced
+// concurrently.
+func (s *Store) SweepExpiredActions() {
+	current := s.getActionStore()
+	if current == nil {
+		return
+	}
+
+	now := time.Now()
+	entries := make([]actionEntry, 0, len(current.entries))
+	for _, entry := range current.entries {
+		if !entry.expired(now) {
+			entries = append(entries, entry)
+		}
+	}
+	if len(entries) == len(current.entries) {
+		return
+	}
+
+	store, err := buildActionStore(entries, nil)
+	if err != nil {
+		s.logger.Error(sqerrors.Wrap(err, "could not sweep the expired actions"))
+		return
+	}
+	if !atomic.CompareAndSwapPointer((*unsafe.Pointer)(unsafe.Pointer(&s.actionStore)), unsafe.Pointer(current), unsafe.Pointer(store)) {
+		// The store was concurrently replaced by a new one
+		return
+	}
+	s.logger.Debugf("actor: swept %d expired actions", len(current.entries)-len(entries))
+	s.saveSnapshot()
+}
+
+// saveSnapshot writes the current actions to the snapshot file when set. The
+// file is written atomically by renaming a temporary file.
+func (s *Store) saveSnapshot() {
+	if s.snapshotFile == "" {
+		return
+	}
+
+	s.snapshotMu.Lock()
+	defer s.snapshotMu.Unlock()
+
+	// Save the current store so that concurrent saves write the latest one.
+	snapshot := actionsSnapshot{
+		Version: actionsSnapshotVersion,
+		Actions: []actionsSnapshotEntry{},
+	}
+	if store := s.getActionStore(); store != nil {
+		now := time.Now()
+		for _, entry := range store.entries {
+			if entry.expired(now) {
+				continue
+			}
+			snapshotEntry := actionsSnapshotEntry{Action: entry.api}
+			if !entry.deadline.IsZero() {
+				deadline := entry.deadline
+				snapshotEntry.Deadline = &deadline
+			}
+			snapshot.Actions = append(snapshot.Actions, snapshotEntry)
+		}
+	}
+
+	if err := writeFileAtomically(s.snapshotFile, snapshot); err != nil {
+		s.logger.Error(sqerrors.Wrapf(err, "could not save the actions snapshot file `%s`", s.snapshotFile))
+	}
+}
+
+func writeFileAtomically(path string, v interface{}) error {
+	buf, err := json.Marshal(v)
+	if err != nil {
+		return err
+	}
+	// The temporary file is created in the same directory so that it can be
+	// renamed.
+	f, err := ioutil.TempFile(filepath.Dir(path), filepath.Base(path)+".tmp")
+	if err != nil {
+		return err
+	}
+	tmp := f.Name()
+	// The snapshot can contain user identifiers and is therefore only readable
+	// by its owner, as created by ioutil.TempFile().
+	if _, err := f.Write(buf); err != nil {
+		f.Close()
+		os.Remove(tmp)
+		return err
+	}
+	if err := f.Close(); err != nil {
+		os.Remove(tmp)
+		return err
+	}
+	if err := os.Rename(tmp, path); err != nil {
+		os.Remove(tmp)
+		return err
+	}
+	return nil
+}
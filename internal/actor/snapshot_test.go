@@ -0,0 +1,179 @@
+// Copyright (c) 2016 - 2020 Sqreen. All Rights Reserved.
+// Please refer to our terms for more information:
+// https://www.sqreen.io/terms.html
+
+package actor_test
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"net"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/sqreen/go-agent/internal/actor"
+	"github.com/sqreen/go-agent/internal/backend/api"
+	"github.com/stretchr/testify/require"
+)
+
+func writeTestSnapshot(t *testing.T, path string, version int, actions []api.ActionsPackResponse_Action, deadlines []time.Time) {
+	type entry struct {
+		Action   api.ActionsPackResponse_Action `json:"action"`
+		Deadline *time.Time                     `json:"deadline,omitempty"`
+	}
+	snapshot := struct {
+		Version int     `json:"version"`
+		Actions []entry `json:"actions"`
+	}{Version: version}
+	for i, action := range actions {
+		e := entry{Action: action}
+		if !deadlines[i].IsZero() {
+			e.Deadline = &deadlines[i]
+		}
+		snapshot.Actions = append(snapshot.Actions, e)
+	}
+	buf, err := json.Marshal(snapshot)
+	require.NoError(t, err)
+	require.NoError(t, ioutil.WriteFile(path, buf, 0600))
+}
+
+func TestActionsSnapshot(t *testing.T) {
+	dir, err := ioutil.TempDir("", "actor")
+	require.NoError(t, err)
+	defer os.RemoveAll(dir)
+
+	uid := map[string]string{"uid": "my uid"}
+
+	t.Run("save and restore", func(t *testing.T) {
+		path := filepath.Join(dir, "save-and-restore.json")
+
+		store := actor.NewStore(logger)
+		require.NoError(t, store.SetActionsSnapshotFile(path))
+		blockIP := NewBlockIPAction("1.2.3.4/32")
+		timedBlockUser := NewTimedBlockUserAction(3600, uid)
+		throttleIP := NewThrottleIPAction(10, 60, "5.6.7.8")
+		throttleIP.Duration = 3600
+		require.NoError(t, store.SetActions([]api.ActionsPackResponse_Action{*blockIP, *timedBlockUser, *throttleIP}))
+
+		info, err := os.Stat(path)
+		require.NoError(t, err)
+		require.Equal(t, os.FileMode(0600), info.Mode().Perm())
+
+		restored := actor.NewStore(logger)
+		require.NoError(t, restored.SetActionsSnapshotFile(path))
+
+		action, exists, err := restored.FindIP(net.ParseIP("1.2.3.4"))
+		require.NoError(t, err)
+		require.True(t, exists)
+		require.Equal(t, blockIP.ActionId, action.ActionID())
+		_, timed := action.(actor.Timed)
+		require.False(t, timed)
+
+		action, exists = restored.FindUser(uid)
+		require.True(t, exists)
+		require.Equal(t, timedBlockUser.ActionId, action.ActionID())
+		require.False(t, action.(actor.Timed).Expired())
+
+		action, exists, err = restored.FindIP(net.ParseIP("5.6.7.8"))
+		require.NoError(t, err)
+		require.True(t, exists)
+		require.Equal(t, throttleIP.ActionId, action.ActionID())
+		_, throttle := action.(actor.ThrottleAction)
+		require.True(t, throttle)
+	})
+
+	t.Run("expired actions are not restored", func(t *testing.T) {
+		path := filepath.Join(dir, "expired.json")
+		expired := NewTimedBlockIPAction(10, "1.2.3.4/32")
+		notExpired := NewTimedBlockIPAction(10, "5.6.7.8/32")
+		writeTestSnapshot(t, path, 1, []api.ActionsPackResponse_Action{*expired, *notExpired}, []time.Time{time.Now().Add(-time.Second), time.Now().Add(time.Hour)})
+
+		store := actor.NewStore(logger)
+		require.NoError(t, store.SetActionsSnapshotFile(path))
+		_, exists, err := store.FindIP(net.ParseIP("1.2.3.4"))
+		require.NoError(t, err)
+		require.False(t, exists)
+		_, exists, err = store.FindIP(net.ParseIP("5.6.7.8"))
+		require.NoError(t, err)
+		require.True(t, exists)
+	})
+
+	t.Run("restored actions are kept by AddActions", func(t *testing.T) {
+		path := filepath.Join(dir, "add-actions.json")
+		restoredBlock := NewBlockIPAction("1.2.3.4/32")
+		replacedBlock := NewBlockIPAction("5.6.7.8/32")
+		writeTestSnapshot(t, path, 1, []api.ActionsPackResponse_Action{*restoredBlock, *replacedBlock}, make([]time.Time, 2))
+
+		store := actor.NewStore(logger)
+		require.NoError(t, store.SetActionsSnapshotFile(path))
+
+		replacingBlock := NewBlockIPAction("9.9.9.9/32")
+		replacingBlock.ActionId = replacedBlock.ActionId
+		require.NoError(t, store.AddActions([]api.ActionsPackResponse_Action{*replacingBlock}))
+
+		for _, tc := range []struct {
+			ip     string
+			exists bool
+		}{
+			{ip: "1.2.3.4", exists: true},
+			{ip: "5.6.7.8", exists: false},
+			{ip: "9.9.9.9", exists: true},
+		} {
+			_, exists, err := store.FindIP(net.ParseIP(tc.ip))
+			require.NoError(t, err)
+			require.Equal(t, tc.exists, exists, tc.ip)
+		}
+
+		// SetActions replaces every action
+		require.NoError(t, store.SetActions(nil))
+		restored := actor.NewStore(logger)
+		require.NoError(t, restored.SetActionsSnapshotFile(path))
+		_, exists, err := restored.FindIP(net.ParseIP("1.2.3.4"))
+		require.NoError(t, err)
+		require.False(t, exists)
+	})
+
+	t.Run("expired actions are swept", func(t *testing.T) {
+		path := filepath.Join(dir, "sweep.json")
+		timed := NewTimedBlockIPAction(10, "1.2.3.4/32")
+		untimed := NewBlockIPAction("5.6.7.8/32")
+		writeTestSnapshot(t, path, 1, []api.ActionsPackResponse_Action{*timed, *untimed}, []time.Time{time.Now().Add(50 * time.Millisecond), {}})
+
+		store := actor.NewStore(logger)
+		require.NoError(t, store.SetActionsSnapshotFile(path))
+		_, exists, err := store.FindIP(net.ParseIP("1.2.3.4"))
+		require.NoError(t, err)
+		require.True(t, exists)
+
+		time.Sleep(100 * time.Millisecond)
+		store.SweepExpiredActions()
+
+		_, exists, err = store.FindIP(net.ParseIP("1.2.3.4"))
+		require.NoError(t, err)
+		require.False(t, exists)
+		_, exists, err = store.FindIP(net.ParseIP("5.6.7.8"))
+		require.NoError(t, err)
+		require.True(t, exists)
+
+		// The snapshot file no longer has the expired action
+		buf, err := ioutil.ReadFile(path)
+		require.NoError(t, err)
+		require.NotContains(t, string(buf), "1.2.3.4")
+		require.Contains(t, string(buf), "5.6.7.8")
+	})
+
+	t.Run("invalid snapshot files", func(t *testing.T) {
+		path := filepath.Join(dir, "invalid.json")
+
+		require.NoError(t, ioutil.WriteFile(path, []byte("oops"), 0600))
+		require.Error(t, actor.NewStore(logger).SetActionsSnapshotFile(path))
+
+		writeTestSnapshot(t, path, 2, nil, nil)
+		require.Error(t, actor.NewStore(logger).SetActionsSnapshotFile(path))
+
+		// No snapshot file yet
+		require.NoError(t, actor.NewStore(logger).SetActionsSnapshotFile(filepath.Join(dir, "not-found.json")))
+	})
+}
@@ -0,0 +1,140 @@
+// Copyright (c) 2016 - 2020 Sqreen. All Rights Reserved.
+// Please refer to our terms for more information:
+// https://www.sqreen.io/terms.html
+
+package actor
+
+import (
+	"hash/fnv"
+	"math"
+	"sync"
+	"time"
+
+	"github.com/sqreen/go-agent/internal/sqlib/sqerrors"
+)
+
+const (
+	// Number of token bucket shards of a throttling action, so that concurrent
+	// requests of distinct clients rarely contend on the same lock.
+	throttleShards = 32
+	// Maximum number of token buckets per shard.
+	maxThrottleShardBuckets = 4096
+)
+
+// ThrottleAction is an action limiting the number of requests per time window
+// of every IP address or user it applies to.
+type ThrottleAction interface {
+	Action
+	// Allow takes a request token out of the token bucket of the given key, such
+	// as the IP address or the user identifiers. When the request is not
+	// allowed, the returned duration is the time after which a new request
+	// will be allowed.
+	Allow(key string) (allowed bool, retryAfter time.Duration)
+}
+
+// throttleAction implements the ThrottleAction interface using in-memory token
+// buckets refilled at the rate of `limit` tokens per window, with a maximum of
+// `limit` tokens allowing bursts of requests.
+type throttleAction struct {
+	ID string
+	// rate is the number of tokens per second.
+	rate  float64
+	limit float64
+	// deadline is the expiration time of the action, zero if it doesn't expire.
+	deadline time.Time
+	shards   [throttleShards]throttleShard
+}
+
+type throttleShard struct {
+	sync.Mutex
+	buckets map[string]*tokenBucket
+}
+
+type tokenBucket struct {
+	tokens float64
+	last   time.Time
+}
+
+func newThrottleAction(id string, limit uint64, window time.Duration, deadline time.Time) (*throttleAction, error) {
+	if limit == 0 {
+		return nil, sqerrors.Errorf("could not add action `%s`: unexpected zero request limit", id)
+	}
+	if window <= 0 {
+		return nil, sqerrors.Errorf("could not add action `%s`: unexpected time window `%s`", id, window)
+	}
+	return &throttleAction{
+		ID:       id,
+		rate:     float64(limit) / window.Seconds(),
+		limit:    float64(limit),
+		deadline: deadline,
+	}, nil
+}
+
+func (a *throttleAction) ActionID() string {
+	return a.ID
+}
+
+// Expired is true when the action has a deadline which has expired, false
+// otherwise.
+func (a *throttleAction) Expired() bool {
+	return !a.deadline.IsZero() && time.Since(a.deadline) >= 0
+}
+
+func (a *throttleAction) Allow(key string) (allowed bool, retryAfter time.Duration) {
+	return a.allow(key, time.Now())
+}
+
+func (a *throttleAction) allow(key string, now time.Time) (allowed bool, retryAfter time.Duration) {
+	h := fnv.New32a()
+	_, _ = h.Write([]byte(key))
+	shard := &a.shards[h.Sum32()%throttleShards]
+
+	shard.Lock()
+	defer shard.Unlock()
+
+	bucket, exists := shard.buckets[key]
+	if !exists {
+		if shard.buckets == nil {
+			shard.buckets = make(map[string]*tokenBucket)
+		} else if len(shard.buckets) >= maxThrottleShardBuckets {
+			a.evictBuckets(shard, now)
+		}
+		bucket = &tokenBucket{tokens: a.limit, last: now}
+		shard.buckets[key] = bucket
+	} else {
+		a.refill(bucket, now)
+	}
+
+	if bucket.tokens >= 1 {
+		bucket.tokens--
+		return true, 0
+	}
+	missing := (1 - bucket.tokens) / a.rate
+	return false, time.Duration(math.Ceil(missing * float64(time.Second)))
+}
+
+func (a *throttleAction) refill(bucket *tokenBucket, now time.Time) {
+	if elapsed := now.Sub(bucket.last).Seconds(); elapsed > 0 {
+		bucket.tokens = math.Min(a.limit, bucket.tokens+elapsed*a.rate)
+		bucket.last = now
+	}
+}
+
+// evictBuckets removes the full buckets of the shard, which are equivalent to
+// missing ones. When none is full, an arbitrary one is removed in order to
+// bound the memory usage.
+func (a *throttleAction) evictBuckets(shard *throttleShard, now time.Time) {
+	for key, bucket := range shard.buckets {
+		a.refill(bucket, now)
+		if bucket.tokens >= a.limit {
+			delete(shard.buckets, key)
+		}
+	}
+	if len(shard.buckets) < maxThrottleShardBuckets {
+		return
+	}
+	for key := range shard.buckets {
+		delete(shard.buckets, key)
+		return
+	}
+}
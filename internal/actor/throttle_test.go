@@ -0,0 +1,151 @@
+// Copyright (c) 2016 - 2020 Sqreen. All Rights Reserved.
+// Please refer to our terms for more information:
+// https://www.sqreen.io/terms.html
+
+package actor_test
+
+import (
+	"fmt"
+	"net"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/sqreen/go-agent/internal/actor"
+	"github.com/sqreen/go-agent/internal/backend/api"
+	"github.com/stretchr/testify/require"
+)
+
+func NewThrottleIPAction(limit uint64, window float64, cidrs ...string) *api.ActionsPackResponse_Action {
+	return &api.ActionsPackResponse_Action{
+		ActionId: fmt.Sprintf("throttle-ip-%d", limit),
+		Action:   "throttle_ip",
+		Parameters: api.ActionsPackResponse_Action_Params{
+			IpCidr: cidrs,
+			Limit:  limit,
+			Window: window,
+		},
+	}
+}
+
+func NewThrottleUserAction(limit uint64, window float64, users ...map[string]string) *api.ActionsPackResponse_Action {
+	return &api.ActionsPackResponse_Action{
+		ActionId: fmt.Sprintf("throttle-user-%d", limit),
+		Action:   "throttle_user",
+		Parameters: api.ActionsPackResponse_Action_Params{
+			Users:  users,
+			Limit:  limit,
+			Window: window,
+		},
+	}
+}
+
+func TestThrottleAction(t *testing.T) {
+	t.Run("Malformed actions", func(t *testing.T) {
+		for _, action := range []*api.ActionsPackResponse_Action{
+			NewThrottleIPAction(0, 60, "1.2.3.4"),
+			NewThrottleIPAction(10, 0, "1.2.3.4"),
+			NewThrottleIPAction(10, -1, "1.2.3.4"),
+			NewThrottleIPAction(10, 60),
+			NewThrottleUserAction(10, 60),
+		} {
+			actors := actor.NewStore(logger)
+			require.Error(t, actors.SetActions([]api.ActionsPackResponse_Action{*action}))
+		}
+	})
+
+	t.Run("IP", func(t *testing.T) {
+		actors := actor.NewStore(logger)
+		action := NewThrottleIPAction(3, 60, "1.2.3.0/24")
+		require.NoError(t, actors.SetActions([]api.ActionsPackResponse_Action{*action}))
+
+		found, exists, err := actors.FindIP(net.ParseIP("1.2.3.4"))
+		require.NoError(t, err)
+		require.True(t, exists)
+		require.Equal(t, action.ActionId, found.ActionID())
+		throttle, ok := found.(actor.ThrottleAction)
+		require.True(t, ok)
+
+		// Every IP address of the CIDR has its own limit
+		for _, ip := range []string{"1.2.3.4", "1.2.3.5"} {
+			for i := 0; i < 3; i++ {
+				allowed, _ := throttle.Allow(ip)
+				require.True(t, allowed)
+			}
+			allowed, retryAfter := throttle.Allow(ip)
+			require.False(t, allowed)
+			require.True(t, retryAfter > 0 && retryAfter <= 20*time.Second)
+		}
+
+		_, exists, err = actors.FindIP(net.ParseIP("1.2.4.4"))
+		require.NoError(t, err)
+		require.False(t, exists)
+	})
+
+	t.Run("User", func(t *testing.T) {
+		actors := actor.NewStore(logger)
+		uid := map[string]string{"uid": "my uid"}
+		action := NewThrottleUserAction(1, 60, uid)
+		require.NoError(t, actors.SetActions([]api.ActionsPackResponse_Action{*action}))
+
+		found, exists := actors.FindUser(uid)
+		require.True(t, exists)
+		throttle, ok := found.(actor.ThrottleAction)
+		require.True(t, ok)
+
+		allowed, _ := throttle.Allow("my uid")
+		require.True(t, allowed)
+		allowed, _ = throttle.Allow("my uid")
+		require.False(t, allowed)
+	})
+
+	t.Run("Refill", func(t *testing.T) {
+		actors := actor.NewStore(logger)
+		action := NewThrottleIPAction(1, 0.05, "1.2.3.4")
+		require.NoError(t, actors.SetActions([]api.ActionsPackResponse_Action{*action}))
+		found, _, err := actors.FindIP(net.ParseIP("1.2.3.4"))
+		require.NoError(t, err)
+		throttle := found.(actor.ThrottleAction)
+
+		allowed, _ := throttle.Allow("1.2.3.4")
+		require.True(t, allowed)
+		allowed, retryAfter := throttle.Allow("1.2.3.4")
+		require.False(t, allowed)
+		time.Sleep(retryAfter)
+		allowed, _ = throttle.Allow("1.2.3.4")
+		require.True(t, allowed)
+	})
+
+	t.Run("Concurrency", func(t *testing.T) {
+		actors := actor.NewStore(logger)
+		action := NewThrottleIPAction(100, 3600, "0.0.0.0/0")
+		require.NoError(t, actors.SetActions([]api.ActionsPackResponse_Action{*action}))
+		found, _, err := actors.FindIP(net.ParseIP("1.2.3.4"))
+		require.NoError(t, err)
+		throttle := found.(actor.ThrottleAction)
+
+		var (
+			wg      sync.WaitGroup
+			mu      sync.Mutex
+			allowed = map[string]int{}
+		)
+		for g := 0; g < 8; g++ {
+			wg.Add(1)
+			go func() {
+				defer wg.Done()
+				for i := 0; i < 1000; i++ {
+					ip := fmt.Sprintf("10.0.0.%d", i%10)
+					if ok, _ := throttle.Allow(ip); ok {
+						mu.Lock()
+						allowed[ip]++
+						mu.Unlock()
+					}
+				}
+			}()
+		}
+		wg.Wait()
+		for ip, n := range allowed {
+			require.Equal(t, 100, n, ip)
+		}
+	})
+}
@@ -14,6 +14,7 @@ import (
 	"time"
 
 	"github.com/pkg/errors"
+	"github.com/sqreen/go-agent/internal/actor"
 	"github.com/sqreen/go-agent/internal/app"
 	"github.com/sqreen/go-agent/internal/backend/api"
 	"github.com/sqreen/go-agent/internal/config"
@@ -29,13 +30,15 @@ type closedHTTPRequestContextEventAPIAdapter struct {
 	adaptee            *closedHTTPRequestContextEvent
 	stripHTTPReferer   bool
 	httpClientIPHeader string
+	geoIP              actor.GeoIPInfo
 }
 
-func newProtectedHTTPRequestEventAPIAdapter(event *closedHTTPRequestContextEvent, stripHTTPReferer bool, httpClientIPHeader string) *closedHTTPRequestContextEventAPIAdapter {
+func newProtectedHTTPRequestEventAPIAdapter(event *closedHTTPRequestContextEvent, stripHTTPReferer bool, httpClientIPHeader string, geoIP actor.GeoIPInfo) *closedHTTPRequestContextEventAPIAdapter {
 	return &closedHTTPRequestContextEventAPIAdapter{
 		adaptee:            event,
 		stripHTTPReferer:   stripHTTPReferer,
 		httpClientIPHeader: httpClientIPHeader,
+		geoIP:              geoIP,
 	}
 }
 
@@ -51,6 +54,14 @@ func (a *closedHTTPRequestContextEventAPIAdapter) GetClientIp() string {
 	return a.adaptee.request.ClientIP().String()
 }
 
+func (a *closedHTTPRequestContextEventAPIAdapter) GetClientCountry() string {
+	return a.geoIP.Country
+}
+
+func (a *closedHTTPRequestContextEventAPIAdapter) GetClientAsn() uint32 {
+	return a.geoIP.ASN
+}
+
 func (a *closedHTTPRequestContextEventAPIAdapter) GetStart() time.Time {
 	return a.adaptee.start
 }
@@ -63,13 +74,15 @@ type httpRequestAPIAdapter struct {
 	adaptee            types.RequestReader
 	stripHTTPReferer   bool
 	httpClientIPHeader string
+	requestID          string
 }
 
 func (a *httpRequestAPIAdapter) GetRid() string {
 	if rid := a.adaptee.Header("X-Request-Id"); rid != nil {
 		return *rid
 	}
-	return ""
+	// Fallback to the request ID generated for the blocking response, if any.
+	return a.requestID
 }
 
 func (a *httpRequestAPIAdapter) GetHeaders() []api.RequestRecord_Request_Header {
@@ -153,11 +166,49 @@ func (a *httpRequestAPIAdapter) GetParameters() api.RequestRecord_Request_Parame
 	}
 }
 
+func (a *httpRequestAPIAdapter) GetGraphQL() *api.RequestRecord_Request_GraphQL {
+	r, ok := a.adaptee.(types.GraphQLOperationReader)
+	if !ok {
+		return nil
+	}
+	op := r.GraphQLOperation()
+	if op == nil {
+		return nil
+	}
+	return &api.RequestRecord_Request_GraphQL{
+		OperationType: op.Type,
+		OperationName: op.Name,
+	}
+}
+
+func (a *httpRequestAPIAdapter) GetRoute() string {
+	if r, ok := a.adaptee.(types.RouteReader); ok {
+		return r.Route()
+	}
+	return ""
+}
+
+func (a *httpRequestAPIAdapter) GetUpstream() *api.RequestRecord_Request_Upstream {
+	r, ok := a.adaptee.(types.UpstreamReader)
+	if !ok {
+		return nil
+	}
+	u := r.Upstream()
+	if u == nil {
+		return nil
+	}
+	return &api.RequestRecord_Request_Upstream{
+		Target:  u.Target,
+		Latency: float64(u.Latency) / float64(time.Millisecond),
+	}
+}
+
 func (a closedHTTPRequestContextEventAPIAdapter) GetRequest() api.RequestRecord_Request {
 	return *api.NewRequestRecord_RequestFromFace(&httpRequestAPIAdapter{
 		adaptee:            a.adaptee.request,
 		stripHTTPReferer:   a.stripHTTPReferer,
 		httpClientIPHeader: a.httpClientIPHeader,
+		requestID:          a.adaptee.requestID,
 	})
 }
 
@@ -302,7 +353,10 @@ func (a *closedHTTPRequestContextEventAPIAdapter) GetObserved() api.RequestRecor
 }
 
 func (a *closedHTTPRequestContextEventAPIAdapter) GetSdk() []*api.RequestRecord_Observed_SDKEvent {
-	events := a.adaptee.events.CustomEvents
+	return sdkEventsAPIAdapter(a.adaptee.events.CustomEvents)
+}
+
+func sdkEventsAPIAdapter(events []*event.CustomEvent) []*api.RequestRecord_Observed_SDKEvent {
 	observed := make([]*api.RequestRecord_Observed_SDKEvent, len(events))
 	for i, e := range events {
 		observed[i] = api.NewRequestRecord_Observed_SDKEventFromFace((*customEventAPIAdapter)(e))
@@ -310,6 +364,36 @@ func (a *closedHTTPRequestContextEventAPIAdapter) GetSdk() []*api.RequestRecord_
 	return observed
 }
 
+type jobEventAPIAdapter closedJobContextEvent
+
+func newJobEventAPIAdapter(e *closedJobContextEvent) *jobEventAPIAdapter {
+	return (*jobEventAPIAdapter)(e)
+}
+
+func (a *jobEventAPIAdapter) GetVersion() string {
+	return api.RequestRecordVersion
+}
+
+func (a *jobEventAPIAdapter) GetRulespackId() string {
+	return a.rulepackID
+}
+
+func (a *jobEventAPIAdapter) GetName() string {
+	return a.name
+}
+
+func (a *jobEventAPIAdapter) GetObserved() api.JobRecord_Observed {
+	return api.JobRecord_Observed{Sdk: sdkEventsAPIAdapter(a.events.CustomEvents)}
+}
+
+func (a *jobEventAPIAdapter) GetStart() time.Time {
+	return a.start
+}
+
+func (a *jobEventAPIAdapter) GetEnd() time.Time {
+	return a.finish
+}
+
 func (a *closedHTTPRequestContextEventAPIAdapter) GetSqreenExceptions() []*api.RequestRecord_Observed_Exception {
 	return nil
 }
@@ -25,6 +25,7 @@ import (
 	"github.com/sqreen/go-agent/internal/backend"
 	"github.com/sqreen/go-agent/internal/backend/api"
 	"github.com/sqreen/go-agent/internal/config"
+	"github.com/sqreen/go-agent/internal/event"
 	"github.com/sqreen/go-agent/internal/metrics"
 	"github.com/sqreen/go-agent/internal/plog"
 	http_protection_types "github.com/sqreen/go-agent/internal/protection/http/types"
@@ -268,6 +269,18 @@ func New(cfg *config.Config) *AgentType {
 		logger.Error(sqerrors.Wrap(err, "`pct` performance histogram constructor error"))
 	}
 
+	actors := actor.NewStore(logger)
+	if path := cfg.GeoIPDatabase(); path != "" {
+		if err := actors.SetGeoIPDatabase(path); err != nil {
+			logger.Error(sqerrors.Wrap(err, "agent: could not load the geoip database"))
+		}
+	}
+	if path := cfg.ActionsSnapshotFile(); path != "" {
+		if err := actors.SetActionsSnapshotFile(path); err != nil {
+			logger.Error(sqerrors.Wrap(err, "agent: could not restore the actions snapshot"))
+		}
+	}
+
 	// AgentType graceful stopping using context cancellation.
 	ctx, cancel := context.WithCancel(context.Background())
 	return &AgentType{
@@ -290,7 +303,7 @@ func New(cfg *config.Config) *AgentType {
 		config:      cfg,
 		appInfo:     app.NewInfo(logger),
 		client:      client,
-		actors:      actor.NewStore(logger),
+		actors:      actors,
 		rules:       rulesEngine,
 		piiScrubber: piiScrubber,
 	}
@@ -314,6 +327,14 @@ func (a *AgentType) sendClosedHTTPProtectionContext(ctx http_protection_types.Cl
 		a.addUserEvent(event)
 	}
 
+	// Custom events whose structured properties were invalid are still sent
+	// without their properties
+	for _, event := range events.CustomEvents {
+		if err := event.PropertiesError; err != nil {
+			a.logger.Error(sqerrors.Wrap(err, "sdk"))
+		}
+	}
+
 	start := ctx.Start()
 	duration := ctx.Duration()
 	finish := start.Add(duration)
@@ -335,7 +356,31 @@ func (a *AgentType) sendClosedHTTPProtectionContext(ctx http_protection_types.Cl
 		}
 	}
 
-	event := newClosedHTTPRequestContextEvent(a.RulespackID(), start, finish, ctx.Response(), ctx.Request(), events)
+	event := newClosedHTTPRequestContextEvent(a.RulespackID(), start, finish, ctx.Response(), ctx.Request(), events, ctx.RequestID())
+	if !event.shouldSend() {
+		return
+	}
+	a.eventMng.send(event)
+}
+
+func (a *AgentType) sendClosedJobProtectionContext(name string, start, finish time.Time, events event.Recorded) {
+	if !a.isRunning() {
+		a.logger.Debug("agent not running: ignoring the closed job protection context")
+		return
+	}
+
+	// User events are not part of the job record
+	for _, event := range events.UserEvents {
+		a.addUserEvent(event)
+	}
+
+	for _, event := range events.CustomEvents {
+		if err := event.PropertiesError; err != nil {
+			a.logger.Error(sqerrors.Wrap(err, "sdk"))
+		}
+	}
+
+	event := newClosedJobContextEvent(a.RulespackID(), name, start, finish, events)
 	if !event.shouldSend() {
 		return
 	}
@@ -394,8 +439,9 @@ func (a *AgentType) Serve() error {
 
 	// Load the rulepack side car
 	a.rules.SetRules(appLoginRes.PackID, appLoginRes.Rules)
-	// Load the actionpack side car
-	if err := a.actors.SetActions(appLoginRes.Actions); err != nil {
+	// Load the actionpack side car along with the actions that may have been
+	// restored from the snapshot file until the next actions reload.
+	if err := a.actors.AddActions(appLoginRes.Actions); err != nil {
 		a.logger.Error(sqerrors.Wrap(err, "could not load the list of actions taken from the login response"))
 	}
 
@@ -439,6 +485,8 @@ func (a *AgentType) Serve() error {
 		case <-ticker:
 			a.logger.Debug("heartbeat")
 
+			a.actors.SweepExpiredActions()
+
 			appBeatReq := api.AppBeatRequest{
 				Metrics:        newMetricsAPIAdapter(a.logger, a.metrics.ReadyMetrics()),
 				CommandResults: commandResults,
@@ -711,8 +759,14 @@ func (m *eventManager) sendBatch(ctx context.Context, client *backend.Client, ba
 		switch actual := e.(type) {
 		case *closedHTTPRequestContextEvent:
 			cfg := m.agent.config
-			adapter := newProtectedHTTPRequestEventAPIAdapter(actual, cfg.StripHTTPReferer(), cfg.HTTPClientIPHeader())
+			geoIP, _, err := m.agent.actors.LookupGeoIP(actual.request.ClientIP())
+			if err != nil {
+				m.agent.logger.Error(sqerrors.Wrap(err, "could not geolocate the client ip address"))
+			}
+			adapter := newProtectedHTTPRequestEventAPIAdapter(actual, cfg.StripHTTPReferer(), cfg.HTTPClientIPHeader(), geoIP)
 			event = api.RequestRecordEvent{api.NewRequestRecordFromFace(adapter)}
+		case *closedJobContextEvent:
+			event = api.JobRecordEvent{JobRecord: api.NewJobRecordFromFace(newJobEventAPIAdapter(actual))}
 		case *ExceptionEvent:
 			event = api.NewExceptionEventFromFace(actual)
 		}
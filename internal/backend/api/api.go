@@ -130,6 +130,16 @@ func (e RequestRecordEvent) GetEvent() Struct {
 	return Struct{e}
 }
 
+type JobRecordEvent struct{ *JobRecord }
+
+func (JobRecordEvent) GetEventType() string {
+	return "job_record"
+}
+
+func (e JobRecordEvent) GetEvent() Struct {
+	return Struct{e}
+}
+
 type ExceptionEvent struct {
 	Time        time.Time        `json:"time"`
 	Klass       string           `json:"klass"`
@@ -350,13 +360,19 @@ type Dependency_Source struct {
 }
 
 type RequestRecord struct {
-	Version     string                 `json:"version"`
-	RulespackId string                 `json:"rulespack_id"`
-	ClientIp    string                 `json:"client_ip"`
-	Request     RequestRecord_Request  `json:"request"`
-	Response    RequestRecord_Response `json:"response"`
-	Observed    RequestRecord_Observed `json:"observed"`
-	Start, End  time.Time
+	Version     string `json:"version"`
+	RulespackId string `json:"rulespack_id"`
+	ClientIp    string `json:"client_ip"`
+	// ClientCountry is the country code of the client IP address when a geoip
+	// database is configured.
+	ClientCountry string `json:"client_country,omitempty"`
+	// ClientAsn is the autonomous system number of the client IP address when a
+	// geoip database is configured.
+	ClientAsn  uint32                 `json:"client_asn,omitempty"`
+	Request    RequestRecord_Request  `json:"request"`
+	Response   RequestRecord_Response `json:"response"`
+	Observed   RequestRecord_Observed `json:"observed"`
+	Start, End time.Time
 }
 
 func (rr *RequestRecord) Scrub(scrubber *sqsanitize.Scrubber, info sqsanitize.Info) (scrubbed bool, err error) {
@@ -382,11 +398,27 @@ func (rr *RequestRecord) Scrub(scrubber *sqsanitize.Scrubber, info sqsanitize.In
 	return scrubbedRequest || scrubbedObserved, nil
 }
 
+// JobRecord is the record of the SDK events of a background job, which is not
+// an HTTP request.
+type JobRecord struct {
+	Version     string             `json:"version"`
+	RulespackId string             `json:"rulespack_id"`
+	Name        string             `json:"name"`
+	Observed    JobRecord_Observed `json:"observed"`
+	Start       time.Time          `json:"start"`
+	End         time.Time          `json:"end"`
+}
+
+type JobRecord_Observed struct {
+	Sdk []*RequestRecord_Observed_SDKEvent `json:"sdk,omitempty"`
+}
+
 type RequestRecord_Request struct {
 	Rid        string                           `json:"rid"`
 	Headers    []RequestRecord_Request_Header   `json:"headers"`
 	Verb       string                           `json:"verb"`
 	Path       string                           `json:"path"`
+	Route      string                           `json:"route,omitempty"`
 	Host       string                           `json:"host"`
 	Port       string                           `json:"port"`
 	RemoteIp   string                           `json:"remote_ip"`
@@ -395,6 +427,8 @@ type RequestRecord_Request struct {
 	UserAgent  string                           `json:"user_agent"`
 	Referer    string                           `json:"referer"`
 	Parameters RequestRecord_Request_Parameters `json:"parameters"`
+	GraphQL    *RequestRecord_Request_GraphQL   `json:"graphql,omitempty"`
+	Upstream   *RequestRecord_Request_Upstream  `json:"upstream,omitempty"`
 }
 
 type RequestRecord_Request_Header struct {
@@ -413,6 +447,17 @@ type RequestRecord_Request_Parameters struct {
 	RawBody string `json:"rawbody,omitempty"`
 }
 
+type RequestRecord_Request_GraphQL struct {
+	OperationType string `json:"operation_type"`
+	OperationName string `json:"operation_name,omitempty"`
+}
+
+type RequestRecord_Request_Upstream struct {
+	Target string `json:"target"`
+	// Latency in milliseconds
+	Latency float64 `json:"latency"`
+}
+
 type RequestRecord_Response struct {
 	Status        int    `json:"status"`
 	ContentLength int64  `json:"content_length"`
@@ -699,6 +744,8 @@ type RequestRecordFace interface {
 	GetVersion() string
 	GetRulespackId() string
 	GetClientIp() string
+	GetClientCountry() string
+	GetClientAsn() uint32
 	GetRequest() RequestRecord_Request
 	GetResponse() RequestRecord_Response
 	GetObserved() RequestRecord_Observed
@@ -708,14 +755,36 @@ type RequestRecordFace interface {
 
 func NewRequestRecordFromFace(that RequestRecordFace) *RequestRecord {
 	return &RequestRecord{
-		Start:       that.GetStart(),
-		End:         that.GetEnd(),
+		Start:         that.GetStart(),
+		End:           that.GetEnd(),
+		Version:       that.GetVersion(),
+		RulespackId:   that.GetRulespackId(),
+		ClientIp:      that.GetClientIp(),
+		ClientCountry: that.GetClientCountry(),
+		ClientAsn:     that.GetClientAsn(),
+		Request:       that.GetRequest(),
+		Response:      that.GetResponse(),
+		Observed:      that.GetObserved(),
+	}
+}
+
+type JobRecordFace interface {
+	GetVersion() string
+	GetRulespackId() string
+	GetName() string
+	GetObserved() JobRecord_Observed
+	GetStart() time.Time
+	GetEnd() time.Time
+}
+
+func NewJobRecordFromFace(that JobRecordFace) *JobRecord {
+	return &JobRecord{
 		Version:     that.GetVersion(),
 		RulespackId: that.GetRulespackId(),
-		ClientIp:    that.GetClientIp(),
-		Request:     that.GetRequest(),
-		Response:    that.GetResponse(),
+		Name:        that.GetName(),
 		Observed:    that.GetObserved(),
+		Start:       that.GetStart(),
+		End:         that.GetEnd(),
 	}
 }
 
@@ -724,6 +793,7 @@ type RequestRecord_RequestFace interface {
 	GetHeaders() []RequestRecord_Request_Header
 	GetVerb() string
 	GetPath() string
+	GetRoute() string
 	GetHost() string
 	GetPort() string
 	GetRemoteIp() string
@@ -732,6 +802,8 @@ type RequestRecord_RequestFace interface {
 	GetUserAgent() string
 	GetReferer() string
 	GetParameters() RequestRecord_Request_Parameters
+	GetGraphQL() *RequestRecord_Request_GraphQL
+	GetUpstream() *RequestRecord_Request_Upstream
 }
 
 func NewRequestRecord_RequestFromFace(that RequestRecord_RequestFace) *RequestRecord_Request {
@@ -740,6 +812,7 @@ func NewRequestRecord_RequestFromFace(that RequestRecord_RequestFace) *RequestRe
 		Headers:    that.GetHeaders(),
 		Verb:       that.GetVerb(),
 		Path:       that.GetPath(),
+		Route:      that.GetRoute(),
 		Host:       that.GetHost(),
 		Port:       that.GetPort(),
 		RemoteIp:   that.GetRemoteIp(),
@@ -748,6 +821,8 @@ func NewRequestRecord_RequestFromFace(that RequestRecord_RequestFace) *RequestRe
 		UserAgent:  that.GetUserAgent(),
 		Referer:    that.GetReferer(),
 		Parameters: that.GetParameters(),
+		GraphQL:    that.GetGraphQL(),
+		Upstream:   that.GetUpstream(),
 	}
 }
 
@@ -871,6 +946,15 @@ type ActionsPackResponse_Action_Params struct {
 	Url    string              `json:"url"`
 	Users  []map[string]string `json:"users"`
 	IpCidr []string            `json:"ip_cidr"`
+	// Countries is the list of ISO 3166-1 alpha-2 country codes of IP actions.
+	Countries []string `json:"countries"`
+	// Asns is the list of autonomous system numbers of IP actions.
+	Asns []uint32 `json:"asns"`
+	// Limit is the maximum number of requests per time window of throttling
+	// actions.
+	Limit uint64 `json:"limit"`
+	// Window is the time window of throttling actions in seconds.
+	Window float64 `json:"window"`
 }
 
 type BlockedIPEventProperties struct {
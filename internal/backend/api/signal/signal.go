@@ -59,19 +59,67 @@ func fromLegacyRequestRecord(record *legacy_api.RequestRecord, infra *AgentInfra
 
 	req := http_trace.NewRequestContext(record.Start, record.End, record.Request.Rid, headers, record.Request.UserAgent, record.Request.Scheme, record.Request.Verb, record.Request.Host, record.Request.RemoteIp, record.Request.Path, record.Request.Referer, port, remotePort, record.Request.Parameters)
 	resp := http_trace.NewResponseContext(record.Response.Status, record.Response.ContentType, record.Response.ContentLength)
-	traceCtx := http_trace.NewContext(req, resp)
+	traceCtx := &httpContext{
+		Request: httpRequestContext{
+			RequestContext: *req,
+			Route:          record.Request.Route,
+		},
+		Response: *resp,
+	}
+
+	// Convert SDK events
+	globalUserID, signals := fromLegacySDKEvents(record.Observed.Sdk)
 
-	var (
-		// The global user id can be set with the Identify SDK method. It needs to be
-		// globally set in the actor field of the HTTP trace if any.
-		globalUserID map[string]string
+	// Convert attacks
+	for _, a := range record.Observed.Attacks {
+		attack := fromLegacyAttack(a, record.RulespackId)
+		signals = append(signals, (*api.Signal)(attack))
+	}
 
-		// The set of signals to add to the HTTP trace
-		signals []*api.Signal
-	)
+	actor := &httpActor{
+		Actor:   *http_trace.NewActor([]string{record.ClientIp}, record.Request.UserAgent, globalUserID),
+		Country: record.ClientCountry,
+		ASN:     record.ClientAsn,
+	}
 
-	// Convert SDK events
-	for _, e := range record.Observed.Sdk {
+	// The trace can be now created. Note that the source is not set so that it
+	// doesn't overwrite
+	trace := api.NewTrace("", "", t, actor, nil, infra, nil, api.NewContext(httpContextSchema, traceCtx), nil, signals)
+
+	return (*http_trace.Trace)(trace), nil
+}
+
+// httpContextSchema is the schema of the HTTP trace context.
+const httpContextSchema = "http/2020-01-01T00:00:00.000Z"
+
+type (
+	// httpContext is the HTTP trace context whose request context extends the
+	// SDK one with the route template of the request.
+	httpContext struct {
+		Request  httpRequestContext         `json:"request"`
+		Response http_trace.ResponseContext `json:"response"`
+	}
+
+	httpRequestContext struct {
+		http_trace.RequestContext
+		Route string `json:"route,omitempty"`
+	}
+
+	// httpActor is the HTTP trace actor extending the SDK one with the country
+	// and autonomous system number of the client IP address, when a geoip
+	// database is configured.
+	httpActor struct {
+		http_trace.Actor
+		Country string `json:"country,omitempty"`
+		ASN     uint32 `json:"asn,omitempty"`
+	}
+)
+
+// fromLegacySDKEvents converts the given SDK events into signals. The global
+// user id set with the Identify SDK method is rather returned so that it goes
+// into the actor field of the trace.
+func fromLegacySDKEvents(events []*legacy_api.RequestRecord_Observed_SDKEvent) (globalUserID map[string]string, signals []*api.Signal) {
+	for _, e := range events {
 		switch e.Name {
 		case event.SDKMethodIdentify:
 			if actual, ok := e.Args.Args.(*legacy_api.RequestRecord_Observed_SDKEvent_Args_Identify_); ok {
@@ -88,20 +136,41 @@ func fromLegacyRequestRecord(record *legacy_api.RequestRecord, infra *AgentInfra
 			}
 		}
 	}
+	return globalUserID, signals
+}
 
-	// Convert attacks
-	for _, a := range record.Observed.Attacks {
-		attack := fromLegacyAttack(a, record.RulespackId)
-		signals = append(signals, (*api.Signal)(attack))
-	}
+// jobContextSchema is the schema of the job trace context.
+const jobContextSchema = "job/2020-01-01T00:00:00.000Z"
 
-	actor := http_trace.NewActor([]string{record.ClientIp}, record.Request.UserAgent, globalUserID)
+// JobContext is the context of a job trace, describing the job instead of an
+// HTTP request.
+type JobContext struct {
+	Name  string    `json:"name"`
+	Start time.Time `json:"start_processing_time"`
+	End   time.Time `json:"end_processing_time"`
+}
 
-	// The trace can be now created. Note that the source is not set so that it
-	// doesn't overwrite
-	trace := http_trace.NewTrace("", t, actor, infra, traceCtx, signals)
+// JobActor is the actor of a job trace, which has no client IP address.
+type JobActor struct {
+	Identifiers map[string]string `json:"identifiers,omitempty"`
+}
+
+func fromLegacyJobRecord(record *legacy_api.JobRecord, infra *AgentInfra) *api.Trace {
+	globalUserID, signals := fromLegacySDKEvents(record.Observed.Sdk)
 
-	return trace, nil
+	var actor interface{}
+	if globalUserID != nil {
+		actor = &JobActor{Identifiers: globalUserID}
+	}
+
+	traceCtx := &JobContext{
+		Name:  record.Name,
+		Start: record.Start,
+		End:   record.End,
+	}
+
+	// Note that the source is not set, as for HTTP traces
+	return api.NewTrace("", "", time.Now(), actor, nil, infra, nil, api.NewContext(jobContextSchema, traceCtx), nil, signals)
 }
 
 type Attack api.Point
@@ -171,6 +240,9 @@ func FromLegacyBatch(b []legacy_api.BatchRequest_Event, infra *AgentInfra, logge
 			}
 			signal = trace
 
+		case legacy_api.JobRecordEvent:
+			signal = fromLegacyJobRecord(evt.JobRecord, infra)
+
 		case *legacy_api.ExceptionEvent:
 			exception := fromLegacyAgentException(evt, infra)
 			signal = (*api.Point)(exception)
@@ -0,0 +1,89 @@
+// Copyright (c) 2016 - 2020 Sqreen. All Rights Reserved.
+// Please refer to our terms for more information:
+// https://www.sqreen.io/terms.html
+
+package signal_test
+
+import (
+	"encoding/json"
+	"testing"
+	"time"
+
+	legacy_api "github.com/sqreen/go-agent/internal/backend/api"
+	"github.com/sqreen/go-agent/internal/backend/api/signal"
+	"github.com/sqreen/go-agent/internal/event"
+	"github.com/stretchr/testify/require"
+)
+
+func TestFromLegacyBatch(t *testing.T) {
+	t.Run("structured track event properties", func(t *testing.T) {
+		properties, err := event.NewStructuredProperties(map[string]interface{}{
+			"amount": 4.2,
+			"paid":   true,
+			"cart": map[string]interface{}{
+				"items": []interface{}{
+					map[string]interface{}{"sku": "a", "count": 2},
+				},
+				"gift": false,
+			},
+			"none": nil,
+		})
+		require.NoError(t, err)
+
+		sdk := []*legacy_api.RequestRecord_Observed_SDKEvent{
+			{
+				Time: time.Now(),
+				Name: event.SDKMethodTrack,
+				Args: legacy_api.RequestRecord_Observed_SDKEvent_Args{
+					Args: &legacy_api.RequestRecord_Observed_SDKEvent_Args_Track_{
+						Track: &legacy_api.RequestRecord_Observed_SDKEvent_Args_Track{
+							Event: "purchase",
+							Options: &legacy_api.RequestRecord_Observed_SDKEvent_Args_Track_Options{
+								Properties: &legacy_api.Struct{Value: properties},
+							},
+						},
+					},
+				},
+			},
+		}
+		batch := []legacy_api.BatchRequest_Event{
+			*legacy_api.NewBatchRequest_EventFromFace(legacy_api.RequestRecordEvent{RequestRecord: &legacy_api.RequestRecord{
+				Observed: legacy_api.RequestRecord_Observed{Sdk: sdk},
+			}}),
+			*legacy_api.NewBatchRequest_EventFromFace(legacy_api.JobRecordEvent{JobRecord: &legacy_api.JobRecord{
+				Name:     "job",
+				Observed: legacy_api.JobRecord_Observed{Sdk: sdk},
+			}}),
+		}
+
+		signals := signal.FromLegacyBatch(batch, signal.NewAgentInfra("1.2.3", "linux", "host", "go1"), errorLoggerFunc(func(err error) {
+			t.Error(err)
+		}))
+		require.Len(t, signals, 2)
+
+		buf, err := json.Marshal(signals)
+		require.NoError(t, err)
+		var traces []struct {
+			Data []struct {
+				Name    string `json:"signal_name"`
+				Schema  string `json:"payload_schema"`
+				Payload struct {
+					Properties json.RawMessage `json:"properties"`
+				} `json:"payload"`
+			} `json:"data"`
+		}
+		require.NoError(t, json.Unmarshal(buf, &traces))
+		require.Len(t, traces, 2)
+		for _, trace := range traces {
+			require.Len(t, trace.Data, 1)
+			point := trace.Data[0]
+			require.Equal(t, "sq.sdk.purchase", point.Name)
+			require.Equal(t, "track_event/2020-01-01T00:00:00.000Z", point.Schema)
+			require.JSONEq(t, `{"amount":4.2,"paid":true,"cart":{"items":[{"sku":"a","count":2}],"gift":false},"none":null}`, string(point.Payload.Properties))
+		}
+	})
+}
+
+type errorLoggerFunc func(err error)
+
+func (f errorLoggerFunc) Error(err error) { f(err) }
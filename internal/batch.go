@@ -86,6 +86,7 @@ type closedHTTPRequestContextEvent struct {
 	request       types.RequestReader
 	response      types.ResponseFace
 	events        event.Recorded
+	requestID     string
 }
 
 func (e *closedHTTPRequestContextEvent) shouldSend() bool {
@@ -95,21 +96,25 @@ func (e *closedHTTPRequestContextEvent) shouldSend() bool {
 		return true
 	}
 
-	onlyIdentifies := true
-	for _, e := range e.events.CustomEvents {
-		if e.Method != event.SDKMethodIdentify {
-			onlyIdentifies = false
-			break
-		}
-	}
-	if !onlyIdentifies {
+	if !onlyIdentifies(e.events.CustomEvents) {
 		return true
 	}
 
 	return false
 }
 
-func newClosedHTTPRequestContextEvent(rulepackID string, start, finish time.Time, response types.ResponseFace, request types.RequestReader, events event.Recorded) *closedHTTPRequestContextEvent {
+// onlyIdentifies returns true when the given custom events are only user
+// identifications, or when there are none.
+func onlyIdentifies(events []*event.CustomEvent) bool {
+	for _, e := range events {
+		if e.Method != event.SDKMethodIdentify {
+			return false
+		}
+	}
+	return true
+}
+
+func newClosedHTTPRequestContextEvent(rulepackID string, start, finish time.Time, response types.ResponseFace, request types.RequestReader, events event.Recorded, requestID string) *closedHTTPRequestContextEvent {
 	return &closedHTTPRequestContextEvent{
 		start:      start,
 		finish:     finish,
@@ -117,5 +122,29 @@ func newClosedHTTPRequestContextEvent(rulepackID string, start, finish time.Time
 		request:    request,
 		response:   response,
 		events:     events,
+		requestID:  requestID,
+	}
+}
+
+type closedJobContextEvent struct {
+	start, finish time.Time
+	rulepackID    string
+	name          string
+	events        event.Recorded
+}
+
+func newClosedJobContextEvent(rulepackID, name string, start, finish time.Time, events event.Recorded) *closedJobContextEvent {
+	return &closedJobContextEvent{
+		start:      start,
+		finish:     finish,
+		rulepackID: rulepackID,
+		name:       name,
+		events:     events,
 	}
 }
+
+// shouldSend returns true when the job has custom events to send. User
+// identifications alone are not worth sending.
+func (e *closedJobContextEvent) shouldSend() bool {
+	return !onlyIdentifies(e.events.CustomEvents)
+}
@@ -341,6 +341,20 @@ func compileTransformation(buf string) (transformationFunc, error) {
 		return execFlatValues, nil
 	case "flat_keys":
 		return execFlatKeys, nil
+	case "url_decode":
+		return execURLDecode, nil
+	case "base64_decode":
+		return execBase64Decode, nil
+	case "html_entity_decode":
+		return execHTMLEntityDecode, nil
+	case "lowercase":
+		return execLowercase, nil
+	case "json_parse":
+		return execJSONParse, nil
+	case "headers_to_map":
+		return execHeadersToMap, nil
+	case "length":
+		return execLength, nil
 	default:
 		return nil, sqerrors.Errorf("unexpected transformation function `%s`", buf)
 	}
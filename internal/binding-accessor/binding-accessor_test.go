@@ -10,9 +10,11 @@ import (
 	"net/http"
 	"net/http/httptest"
 	"net/url"
+	"sync"
 	"testing"
 
 	bindingaccessor "github.com/sqreen/go-agent/internal/binding-accessor"
+	"github.com/stretchr/testify/assert"
 	"github.com/stretchr/testify/require"
 	"golang.org/x/xerrors"
 )
@@ -384,6 +386,46 @@ func TestBindingAccessor(t *testing.T) {
 			},
 			ExpectedValue: FlattenedResult{1, 2, "Sqreen", 1, "Two", 27, 28},
 		},
+		{
+			Title:      "transformation pipeline",
+			Expression: "#.A | flat_values | url_decode | html_entity_decode | lowercase",
+			Context: struct {
+				A map[string][]string
+			}{
+				A: map[string][]string{"k": {"%26LT%3BSCRIPT%26GT%3B"}},
+			},
+			ExpectedValue: []interface{}{"<script>"},
+		},
+		{
+			Title:      "json parse transformation",
+			Expression: "#.Body | base64_decode | json_parse",
+			Context: struct {
+				Body []byte
+			}{
+				Body: []byte("eyJ1c2VyIjogeyIkbmUiOiAxfX0="),
+			},
+			ExpectedValue: map[string]interface{}{"user": map[string]interface{}{"$ne": float64(1)}},
+		},
+		{
+			Title:      "headers to map transformation",
+			Expression: "#.Header | headers_to_map",
+			Context: struct {
+				Header http.Header
+			}{
+				Header: http.Header{"User-Agent": {"Arachni"}},
+			},
+			ExpectedValue: map[string]string{"user-agent": "Arachni"},
+		},
+		{
+			Title:      "length transformation",
+			Expression: "#.A | flat_values | length",
+			Context: struct {
+				A map[string][]string
+			}{
+				A: map[string][]string{"k1": {"1", "2"}, "k2": {"3"}},
+			},
+			ExpectedValue: 3,
+		},
 
 		//
 		// Error cases
@@ -435,6 +477,11 @@ func TestBindingAccessor(t *testing.T) {
 			Expression:               "#.A | ",
 			ExpectedCompilationError: true,
 		},
+		{
+			Title:                    "unknown transformation",
+			Expression:               "#.A | to_upper",
+			ExpectedCompilationError: true,
+		},
 		{
 			Title:                  "field access to nil value",
 			Expression:             "#.Foo",
@@ -514,6 +561,14 @@ func TestBindingAccessor(t *testing.T) {
 			}{},
 			ExpectedExecutionError: bindingaccessor.ErrMaxExecutionDepth,
 		},
+		{
+			Title:      "field access through a nil pointer",
+			Expression: `#.A.B`,
+			Context: struct {
+				A *struct{ B int }
+			}{},
+			ExpectedExecutionError: true,
+		},
 	} {
 		tc := tc
 		t.Run(tc.Title, func(t *testing.T) {
@@ -657,6 +712,58 @@ func TestBindingAccessorUsage(t *testing.T) {
 	})
 }
 
+type fieldA struct{ A string }
+
+type methodA struct{}
+
+func (methodA) A() string { return "method" }
+
+type methodWithArgA struct{}
+
+func (methodWithArgA) A(s string) string { return s }
+
+func TestConcurrentBindingAccessor(t *testing.T) {
+	// The same expression is executed concurrently on different types
+	// resolving the member `A` differently, so that the type cache of the
+	// field and method lookups is concurrently accessed.
+	p, err := bindingaccessor.Compile(`#.V.A`)
+	require.NoError(t, err)
+
+	type Context struct{ V interface{} }
+
+	var wg sync.WaitGroup
+	for i := 0; i < 8; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for n := 0; n < 100; n++ {
+				v, err := p(Context{V: fieldA{A: "field"}})
+				assert.NoError(t, err)
+				assert.Equal(t, "field", v)
+
+				v, err = p(Context{V: &fieldA{A: "field"}})
+				assert.NoError(t, err)
+				assert.Equal(t, "field", v)
+
+				v, err = p(Context{V: methodA{}})
+				assert.NoError(t, err)
+				assert.Equal(t, "method", v)
+
+				v, err = p(&Context{V: methodWithArgA{}})
+				assert.NoError(t, err)
+				fn, ok := v.(func(string) string)
+				if assert.True(t, ok) {
+					assert.Equal(t, "arg", fn("arg"))
+				}
+
+				_, err = p(Context{V: 33})
+				assert.Error(t, err)
+			}
+		}()
+	}
+	wg.Wait()
+}
+
 type FlattenedResult []interface{}
 
 func requireEqualFlatResult(t *testing.T, expected FlattenedResult, value interface{}) {
@@ -7,7 +7,13 @@
 package bindingaccessor
 
 import (
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"html"
+	"io"
 	"reflect"
+	"strings"
 	"unicode"
 
 	"github.com/sqreen/go-agent/internal/sqlib/sqerrors"
@@ -43,70 +49,49 @@ doExecIndexAccess:
 
 func execFieldAccess(value interface{}, field string) (interface{}, error) {
 	v := reflect.ValueOf(value)
-	for {
-		switch v.Kind() {
-		case reflect.Interface, reflect.Ptr:
-			if value, ok, err := tryExecMethodAccess(v, field); ok {
-				return value, nil
-			} else if err != nil {
-				return nil, err
-			}
-			v = v.Elem()
-			continue
-
-		case reflect.Struct:
-			// Try to access a field first
-			if f := v.FieldByName(field); f.IsValid() {
-				return f.Interface(), nil
+	for v.IsValid() {
+		kind := v.Kind()
+		switch m := lookupMember(v.Type(), field); m.kind {
+		case fieldMember:
+			return v.FieldByIndex(m.fieldIndex).Interface(), nil
+		case methodMember:
+			method := v.Method(m.methodIndex)
+			if !m.call {
+				// Return the method interface value
+				return method.Interface(), nil
 			}
-			fallthrough
+			return callValue(method)
+		}
 
-		default:
-			if value, ok, err := tryExecMethodAccess(v, field); ok {
-				return value, nil
-			} else if err != nil {
-				return nil, err
-			}
-			return nil, sqerrors.Errorf("no field nor method `%s` found in value of type `%T`", field, value)
+		if kind != reflect.Interface && kind != reflect.Ptr {
+			break
 		}
+		v = v.Elem()
 	}
+	return nil, sqerrors.Errorf("no field nor method `%s` found in value of type `%T`", field, value)
 }
 
-func tryExecMethodAccess(v reflect.Value, method string) (result interface{}, ok bool, err error) {
-	m := v.MethodByName(method)
-
-	if !m.IsValid() {
-		return nil, false, nil
-	}
-
-	mt := m.Type()
-	if mt.NumIn() != 0 {
-		// Return the method interface value
-		return m.Interface(), true, nil
-	}
-
-	res, err := execCall(m.Interface())
-	return res, true, err
+func execCall(fn interface{}, args ...interface{}) (interface{}, error) {
+	return callValue(reflect.ValueOf(fn), args...)
 }
 
-func execCall(fn interface{}, args ...interface{}) (interface{}, error) {
-	fnValue := reflect.ValueOf(fn)
+func callValue(fnValue reflect.Value, args ...interface{}) (interface{}, error) {
 	fnType := fnValue.Type()
-
-	if nbResults := fnType.NumOut(); nbResults != 1 && nbResults != 2 {
-		return nil, sqerrors.Errorf("unexpected number of function results of function `%s`", fnType)
-	} else if nbResults == 2 && !fnType.Out(1).Implements(reflect.TypeOf((*error)(nil)).Elem()) {
-		return nil, sqerrors.Errorf("unexpected second function results type of function `%s`: expected `error`", fnType)
+	if err := checkFuncResults(fnType); err != nil {
+		return nil, err
 	}
 
-	argValues := make([]reflect.Value, len(args))
-	for i, a := range args {
-		if a != nil {
-			argValues[i] = reflect.ValueOf(a)
-		} else {
-			// Don't use nil to avoid panics when calling the function with reflect.
-			// Use instead the expected zero value for that argument type.
-			argValues[i] = reflect.Zero(fnType.In(i))
+	var argValues []reflect.Value
+	if len(args) > 0 {
+		argValues = make([]reflect.Value, len(args))
+		for i, a := range args {
+			if a != nil {
+				argValues[i] = reflect.ValueOf(a)
+			} else {
+				// Don't use nil to avoid panics when calling the function with reflect.
+				// Use instead the expected zero value for that argument type.
+				argValues[i] = reflect.Zero(fnType.In(i))
+			}
 		}
 	}
 	results := fnValue.Call(argValues)
@@ -289,3 +274,398 @@ func flatKeys(v reflect.Value, depth int, elements *int) []interface{} {
 	}
 	return values
 }
+
+// stringTransformationFunc transforms a string value into another value. The
+// function is responsible for decrementing the number of remaining elements
+// according to the size of the value it returns.
+type stringTransformationFunc func(s string, depth int, elements *int) interface{}
+
+// transformStrings applies the string transformation function to the given
+// value when it is a string or a byte slice, or to every string it contains
+// when it is a slice, an array or a map. Other values are returned as is.
+// Slices and arrays are returned as `[]interface{}` and maps as
+// `map[string]interface{}`. The traversal cannot go deeper than `depth` and the
+// result cannot have more than `elements` values.
+func transformStrings(v reflect.Value, depth int, elements *int, fn stringTransformationFunc) interface{} {
+	if *elements <= 0 {
+		return nil
+	}
+
+	switch v.Kind() {
+	case reflect.String:
+		return fn(v.String(), depth, elements)
+
+	case reflect.Slice:
+		if v.Type().Elem().Kind() == reflect.Uint8 {
+			return fn(string(v.Bytes()), depth, elements)
+		}
+		fallthrough
+	case reflect.Array:
+		if depth == 0 {
+			// do not traverse this value
+			return nil
+		}
+		l := v.Len()
+		values := make([]interface{}, 0, l)
+		for i := 0; i < l && *elements > 0; i++ {
+			values = append(values, transformStrings(v.Index(i), depth-1, elements, fn))
+		}
+		return values
+
+	case reflect.Map:
+		if depth == 0 {
+			// do not traverse this value
+			return nil
+		}
+		values := make(map[string]interface{}, v.Len())
+		for iter := v.MapRange(); iter.Next() && *elements > 0; {
+			values[mapKeyString(iter.Key())] = transformStrings(iter.Value(), depth-1, elements, fn)
+		}
+		return values
+
+	case reflect.Ptr, reflect.Interface:
+		if v.IsNil() {
+			return nil
+		}
+		// do not count this step as a deeper level (no depth -= 1)
+		return transformStrings(v.Elem(), depth, elements, fn)
+
+	default:
+		if !v.IsValid() || !v.CanInterface() {
+			return nil
+		}
+		*elements -= 1
+		return v.Interface()
+	}
+}
+
+func mapKeyString(k reflect.Value) string {
+	for k.Kind() == reflect.Interface && !k.IsNil() {
+		k = k.Elem()
+	}
+	if k.Kind() == reflect.String {
+		return k.String()
+	}
+	return fmt.Sprint(k.Interface())
+}
+
+// simpleStringTransformation returns a string transformation function
+// returning one single string value.
+func simpleStringTransformation(fn func(string) string) stringTransformationFunc {
+	return func(s string, _ int, elements *int) interface{} {
+		*elements -= 1
+		return fn(s)
+	}
+}
+
+func execStringTransformation(v interface{}, maxDepth, maxElements int, fn stringTransformationFunc) interface{} {
+	if v == nil {
+		return nil
+	}
+	return transformStrings(reflect.ValueOf(v), maxDepth, &maxElements, fn)
+}
+
+func execURLDecode(ctx Context, v interface{}, maxDepth, maxElements int) interface{} {
+	return execStringTransformation(v, maxDepth, maxElements, simpleStringTransformation(urlDecode))
+}
+
+func execBase64Decode(ctx Context, v interface{}, maxDepth, maxElements int) interface{} {
+	return execStringTransformation(v, maxDepth, maxElements, simpleStringTransformation(base64Decode))
+}
+
+func execHTMLEntityDecode(ctx Context, v interface{}, maxDepth, maxElements int) interface{} {
+	return execStringTransformation(v, maxDepth, maxElements, simpleStringTransformation(html.UnescapeString))
+}
+
+func execLowercase(ctx Context, v interface{}, maxDepth, maxElements int) interface{} {
+	return execStringTransformation(v, maxDepth, maxElements, simpleStringTransformation(strings.ToLower))
+}
+
+func execJSONParse(ctx Context, v interface{}, maxDepth, maxElements int) interface{} {
+	return execStringTransformation(v, maxDepth, maxElements, jsonParse)
+}
+
+// Maximum number of URL-decoding passes. Attackers can encode their payloads
+// several times in order to bypass security checks, so the decoding is repeated
+// until the value no longer changes.
+const urlDecodeMaxPasses = 5
+
+func urlDecode(s string) string {
+	for i := 0; i < urlDecodeMaxPasses; i++ {
+		decoded := urlUnescape(s)
+		if decoded == s {
+			break
+		}
+		s = decoded
+	}
+	return s
+}
+
+// urlUnescape is a lenient version of `url.QueryUnescape()` leaving invalid
+// escape sequences unchanged instead of returning an error.
+func urlUnescape(s string) string {
+	if strings.IndexAny(s, "%+") == -1 {
+		return s
+	}
+
+	var b strings.Builder
+	b.Grow(len(s))
+	for i := 0; i < len(s); i++ {
+		switch c := s[i]; c {
+		case '+':
+			b.WriteByte(' ')
+		case '%':
+			if i+2 < len(s) && isHex(s[i+1]) && isHex(s[i+2]) {
+				b.WriteByte(unhex(s[i+1])<<4 | unhex(s[i+2]))
+				i += 2
+				continue
+			}
+			b.WriteByte(c)
+		default:
+			b.WriteByte(c)
+		}
+	}
+	return b.String()
+}
+
+func isHex(c byte) bool {
+	return '0' <= c && c <= '9' || 'a' <= c && c <= 'f' || 'A' <= c && c <= 'F'
+}
+
+func unhex(c byte) byte {
+	switch {
+	case '0' <= c && c <= '9':
+		return c - '0'
+	case 'a' <= c && c <= 'f':
+		return c - 'a' + 10
+	default:
+		return c - 'A' + 10
+	}
+}
+
+var base64Encodings = []*base64.Encoding{
+	base64.StdEncoding,
+	base64.URLEncoding,
+	base64.RawStdEncoding,
+	base64.RawURLEncoding,
+}
+
+// base64Decode returns the decoded value of the base64-encoded string, using
+// the first successful encoding among the standard and URL ones, with or
+// without padding. The string is returned unchanged when it is not a valid
+// base64 value.
+func base64Decode(s string) string {
+	trimmed := strings.TrimSpace(s)
+	if trimmed == "" {
+		return s
+	}
+	for _, enc := range base64Encodings {
+		if decoded, err := enc.DecodeString(trimmed); err == nil {
+			return string(decoded)
+		}
+	}
+	return s
+}
+
+// jsonParse returns the Go value of the JSON string. The string is returned
+// unchanged when it is not a valid JSON value.
+func jsonParse(s string, depth int, elements *int) interface{} {
+	remaining := *elements
+	dec := json.NewDecoder(strings.NewReader(s))
+	v, err := decodeJSONValue(dec, depth, elements)
+	if err != nil {
+		*elements = remaining - 1
+		return s
+	}
+	return v
+}
+
+// decodeJSONValue decodes the next JSON value using the JSON tokenizer so that
+// the decoding stops as soon as the maximum number of elements is reached, and
+// so that JSON objects and arrays deeper than `depth` are skipped.
+func decodeJSONValue(dec *json.Decoder, depth int, elements *int) (interface{}, error) {
+	tok, err := dec.Token()
+	if err != nil {
+		return nil, err
+	}
+
+	delim, ok := tok.(json.Delim)
+	if !ok {
+		*elements -= 1
+		return tok, nil
+	}
+
+	if depth == 0 {
+		// do not traverse this value
+		return nil, skipJSONValue(dec)
+	}
+
+	switch delim {
+	case '[':
+		var values []interface{}
+		for dec.More() {
+			if *elements <= 0 {
+				return values, nil
+			}
+			v, err := decodeJSONValue(dec, depth-1, elements)
+			if err != nil {
+				return nil, err
+			}
+			values = append(values, v)
+		}
+		// Consume the closing delimiter
+		if _, err := dec.Token(); err != nil {
+			return nil, err
+		}
+		return values, nil
+
+	case '{':
+		values := make(map[string]interface{})
+		for dec.More() {
+			if *elements <= 0 {
+				return values, nil
+			}
+			k, err := dec.Token()
+			if err != nil {
+				return nil, err
+			}
+			v, err := decodeJSONValue(dec, depth-1, elements)
+			if err != nil {
+				return nil, err
+			}
+			values[k.(string)] = v
+		}
+		// Consume the closing delimiter
+		if _, err := dec.Token(); err != nil {
+			return nil, err
+		}
+		return values, nil
+
+	default:
+		return nil, sqerrors.Errorf("unexpected json delimiter `%s`", delim)
+	}
+}
+
+// skipJSONValue consumes the tokens of the current JSON array or object until
+// its closing delimiter.
+func skipJSONValue(dec *json.Decoder) error {
+	for level := 1; level > 0; {
+		tok, err := dec.Token()
+		if err != nil {
+			if err == io.EOF {
+				return io.ErrUnexpectedEOF
+			}
+			return err
+		}
+		if delim, ok := tok.(json.Delim); ok {
+			switch delim {
+			case '[', '{':
+				level++
+			default:
+				level--
+			}
+		}
+	}
+	return nil
+}
+
+// execHeadersToMap returns the map of header values indexed by their lowercase
+// header names, from either a map of header values such as `http.Header`, a
+// string of header lines, or a slice of header lines. Multiple values of the
+// same header are joined by a comma.
+func execHeadersToMap(ctx Context, v interface{}, maxDepth, maxElements int) interface{} {
+	if v == nil {
+		return nil
+	}
+	headers := make(map[string]string)
+	addHeaders(reflect.ValueOf(v), headers, maxDepth, &maxElements)
+	if len(headers) == 0 {
+		return nil
+	}
+	return headers
+}
+
+func addHeaders(v reflect.Value, headers map[string]string, depth int, elements *int) {
+	switch v.Kind() {
+	case reflect.String:
+		for _, line := range strings.Split(v.String(), "\n") {
+			if *elements <= 0 {
+				return
+			}
+			sep := strings.IndexByte(line, ':')
+			if sep <= 0 {
+				continue
+			}
+			addHeader(headers, line[:sep], strings.TrimSpace(line[sep+1:]), elements)
+		}
+
+	case reflect.Slice, reflect.Array:
+		if depth == 0 {
+			// do not traverse this value
+			return
+		}
+		for i := 0; i < v.Len() && *elements > 0; i++ {
+			if line := reflect.Indirect(v.Index(i)); line.Kind() == reflect.String {
+				addHeaders(line, headers, depth-1, elements)
+			}
+		}
+
+	case reflect.Map:
+		if depth == 0 {
+			// do not traverse this value
+			return
+		}
+		for iter := v.MapRange(); iter.Next() && *elements > 0; {
+			name := mapKeyString(iter.Key())
+			values := flatValues(iter.Value(), depth-1, elements)
+			strValues := make([]string, 0, len(values))
+			for _, value := range values {
+				strValues = append(strValues, fmt.Sprint(value))
+			}
+			if len(strValues) == 0 {
+				continue
+			}
+			// The element count was already decremented by flatValues()
+			*elements += 1
+			addHeader(headers, name, strings.Join(strValues, ", "), elements)
+		}
+
+	case reflect.Ptr, reflect.Interface:
+		if v.IsNil() {
+			return
+		}
+		addHeaders(v.Elem(), headers, depth, elements)
+	}
+}
+
+func addHeader(headers map[string]string, name, value string, elements *int) {
+	name = strings.ToLower(strings.TrimSpace(name))
+	if prev, exists := headers[name]; exists {
+		headers[name] = prev + ", " + value
+		return
+	}
+	*elements -= 1
+	headers[name] = value
+}
+
+// execLength returns the length of the string, slice, array or map value. Nil
+// values have a zero length. Other values have no length and nil is returned.
+func execLength(ctx Context, v interface{}, _, _ int) interface{} {
+	if v == nil {
+		return 0
+	}
+	rv := reflect.ValueOf(v)
+	for {
+		switch rv.Kind() {
+		case reflect.String, reflect.Slice, reflect.Array, reflect.Map:
+			return rv.Len()
+		case reflect.Ptr, reflect.Interface:
+			if rv.IsNil() {
+				return 0
+			}
+			rv = rv.Elem()
+		default:
+			return nil
+		}
+	}
+}
@@ -7,6 +7,7 @@ package bindingaccessor
 import (
 	"context"
 	"fmt"
+	"net/http"
 	"net/url"
 	"testing"
 
@@ -660,6 +661,248 @@ func TestFlatValues(t *testing.T) {
 	})
 }
 
+func TestURLDecode(t *testing.T) {
+	for _, tc := range []struct {
+		in       interface{}
+		expected interface{}
+	}{
+		{in: nil, expected: nil},
+		{in: "", expected: ""},
+		{in: "no encoding", expected: "no encoding"},
+		{in: "%3Cscript%3E+alert(1)", expected: "<script> alert(1)"},
+		{in: []byte("%3Cscript%3E"), expected: "<script>"},
+		// Multiple encodings
+		{in: "%253Cscript%253E", expected: "<script>"},
+		{in: "%25253Cscript%25253E", expected: "<script>"},
+		// Invalid escape sequences are kept as is
+		{in: "100%", expected: "100%"},
+		{in: "%zz%3C", expected: "%zz<"},
+		// Non-string values
+		{in: 33, expected: 33},
+		{in: []string{"%3C", "%3E"}, expected: []interface{}{"<", ">"}},
+		{in: map[string][]string{"k": {"%3C"}}, expected: map[string]interface{}{"k": []interface{}{"<"}}},
+		{in: []interface{}{"%3C", 33, nil}, expected: []interface{}{"<", 33, nil}},
+	} {
+		tc := tc
+		t.Run(fmt.Sprintf("%#v", tc.in), func(t *testing.T) {
+			out := execURLDecode(context.Background(), tc.in, newValueMaxDepth, NewValueMaxElements)
+			require.Equal(t, tc.expected, out)
+		})
+	}
+
+	t.Run("max decoding passes", func(t *testing.T) {
+		in := "<"
+		for i := 0; i < urlDecodeMaxPasses+1; i++ {
+			in = url.QueryEscape(in)
+		}
+		out := execURLDecode(context.Background(), in, newValueMaxDepth, NewValueMaxElements)
+		require.Equal(t, "%3C", out)
+	})
+}
+
+func TestBase64Decode(t *testing.T) {
+	for _, tc := range []struct {
+		in       interface{}
+		expected interface{}
+	}{
+		{in: nil, expected: nil},
+		{in: "", expected: ""},
+		{in: "PHNjcmlwdD4=", expected: "<script>"},
+		{in: " PHNjcmlwdD4=\n", expected: "<script>"},
+		{in: "PHNjcmlwdD4", expected: "<script>"},
+		{in: "Pz8_", expected: "???"},
+		{in: []byte("PHNjcmlwdD4="), expected: "<script>"},
+		// Invalid values are kept as is
+		{in: "not base64!", expected: "not base64!"},
+		{in: []interface{}{"PHNjcmlwdD4=", "oops!", 33}, expected: []interface{}{"<script>", "oops!", 33}},
+	} {
+		tc := tc
+		t.Run(fmt.Sprintf("%#v", tc.in), func(t *testing.T) {
+			out := execBase64Decode(context.Background(), tc.in, newValueMaxDepth, NewValueMaxElements)
+			require.Equal(t, tc.expected, out)
+		})
+	}
+}
+
+func TestHTMLEntityDecode(t *testing.T) {
+	for _, tc := range []struct {
+		in       interface{}
+		expected interface{}
+	}{
+		{in: nil, expected: nil},
+		{in: "", expected: ""},
+		{in: "&lt;script&gt;", expected: "<script>"},
+		{in: "&#60;script&#x3E;", expected: "<script>"},
+		{in: "&quot;&amp;&apos;", expected: `"&'`},
+		{in: "&unknown;", expected: "&unknown;"},
+		{in: map[string]string{"k": "&lt;"}, expected: map[string]interface{}{"k": "<"}},
+	} {
+		tc := tc
+		t.Run(fmt.Sprintf("%#v", tc.in), func(t *testing.T) {
+			out := execHTMLEntityDecode(context.Background(), tc.in, newValueMaxDepth, NewValueMaxElements)
+			require.Equal(t, tc.expected, out)
+		})
+	}
+}
+
+func TestLowercase(t *testing.T) {
+	for _, tc := range []struct {
+		in       interface{}
+		expected interface{}
+	}{
+		{in: nil, expected: nil},
+		{in: "", expected: ""},
+		{in: "SeLeCt * FROM", expected: "select * from"},
+		{in: true, expected: true},
+		{in: &[]string{"ONE", "Two"}, expected: []interface{}{"one", "two"}},
+		// Map keys are unchanged
+		{in: map[interface{}]interface{}{"K": "V", 2: "TWO"}, expected: map[string]interface{}{"K": "v", "2": "two"}},
+	} {
+		tc := tc
+		t.Run(fmt.Sprintf("%#v", tc.in), func(t *testing.T) {
+			out := execLowercase(context.Background(), tc.in, newValueMaxDepth, NewValueMaxElements)
+			require.Equal(t, tc.expected, out)
+		})
+	}
+
+	t.Run("limits", func(t *testing.T) {
+		t.Run("more than max elements", func(t *testing.T) {
+			in := []string{"A", "B", "C", "D", "E"}
+			out := execLowercase(context.Background(), in, newValueMaxDepth, 3)
+			require.Equal(t, []interface{}{"a", "b", "c"}, out)
+		})
+
+		t.Run("more than max depth", func(t *testing.T) {
+			in := []interface{}{"A", []interface{}{"B", []interface{}{"C"}}}
+			out := execLowercase(context.Background(), in, 2, NewValueMaxElements)
+			require.Equal(t, []interface{}{"a", []interface{}{"b", nil}}, out)
+		})
+	})
+}
+
+func TestJSONParse(t *testing.T) {
+	for _, tc := range []struct {
+		in       interface{}
+		expected interface{}
+	}{
+		{in: nil, expected: nil},
+		{in: `"string"`, expected: "string"},
+		{in: `33`, expected: float64(33)},
+		{in: `null`, expected: nil},
+		{in: `true`, expected: true},
+		{in: `[1, "two", null]`, expected: []interface{}{float64(1), "two", nil}},
+		{in: `{"$where": "1 == 1", "a": {"b": [1]}}`, expected: map[string]interface{}{"$where": "1 == 1", "a": map[string]interface{}{"b": []interface{}{float64(1)}}}},
+		{in: []byte(`{"a": 1}`), expected: map[string]interface{}{"a": float64(1)}},
+		{in: []string{`{"a": 1}`, `[2]`}, expected: []interface{}{map[string]interface{}{"a": float64(1)}, []interface{}{float64(2)}}},
+		// Invalid values are kept as is
+		{in: "", expected: ""},
+		{in: "not json", expected: "not json"},
+		{in: `{"a": 1`, expected: `{"a": 1`},
+	} {
+		tc := tc
+		t.Run(fmt.Sprintf("%#v", tc.in), func(t *testing.T) {
+			out := execJSONParse(context.Background(), tc.in, newValueMaxDepth, NewValueMaxElements)
+			require.Equal(t, tc.expected, out)
+		})
+	}
+
+	t.Run("limits", func(t *testing.T) {
+		t.Run("more than max elements", func(t *testing.T) {
+			out := execJSONParse(context.Background(), `[1, 2, 3, 4, 5]`, newValueMaxDepth, 3)
+			require.Equal(t, []interface{}{float64(1), float64(2), float64(3)}, out)
+		})
+
+		t.Run("more than max elements in a slice of values", func(t *testing.T) {
+			out := execJSONParse(context.Background(), []string{`[1, 2]`, `[3, 4]`, `[5]`}, newValueMaxDepth, 3)
+			require.Equal(t, []interface{}{[]interface{}{float64(1), float64(2)}, []interface{}{float64(3)}}, out)
+		})
+
+		t.Run("more than max depth", func(t *testing.T) {
+			out := execJSONParse(context.Background(), `[1, [2, [3, {"a": 4}]], 5]`, 2, NewValueMaxElements)
+			require.Equal(t, []interface{}{float64(1), []interface{}{float64(2), nil}, float64(5)}, out)
+		})
+
+		t.Run("zero max depth", func(t *testing.T) {
+			out := execJSONParse(context.Background(), `{"a": 1}`, 0, NewValueMaxElements)
+			require.Nil(t, out)
+		})
+	})
+}
+
+func TestHeadersToMap(t *testing.T) {
+	for _, tc := range []struct {
+		name     string
+		in       interface{}
+		expected interface{}
+	}{
+		{name: "nil", in: nil, expected: nil},
+		{name: "empty", in: http.Header{}, expected: nil},
+		{
+			name: "http header",
+			in: http.Header{
+				"Content-Type":    {"application/json"},
+				"X-Forwarded-For": {"1.2.3.4", "5.6.7.8"},
+			},
+			expected: map[string]string{
+				"content-type":    "application/json",
+				"x-forwarded-for": "1.2.3.4, 5.6.7.8",
+			},
+		},
+		{
+			name:     "map of strings",
+			in:       map[string]string{"User-Agent": "Arachni"},
+			expected: map[string]string{"user-agent": "Arachni"},
+		},
+		{
+			name:     "header lines",
+			in:       "Host: sqreen.com\r\nAccept: */*\r\naccept: text/html\r\ninvalid line\r\n",
+			expected: map[string]string{"host": "sqreen.com", "accept": "*/*, text/html"},
+		},
+		{
+			name:     "slice of header lines",
+			in:       []string{"Host: sqreen.com", "X-Test: a: b"},
+			expected: map[string]string{"host": "sqreen.com", "x-test": "a: b"},
+		},
+		{name: "unexpected type", in: 33, expected: nil},
+	} {
+		tc := tc
+		t.Run(tc.name, func(t *testing.T) {
+			out := execHeadersToMap(context.Background(), tc.in, newValueMaxDepth, NewValueMaxElements)
+			require.Equal(t, tc.expected, out)
+		})
+	}
+
+	t.Run("more than max elements", func(t *testing.T) {
+		out := execHeadersToMap(context.Background(), "A: 1\nB: 2\nC: 3\nD: 4", newValueMaxDepth, 2)
+		require.Equal(t, map[string]string{"a": "1", "b": "2"}, out)
+	})
+}
+
+func TestLength(t *testing.T) {
+	for _, tc := range []struct {
+		in       interface{}
+		expected interface{}
+	}{
+		{in: nil, expected: 0},
+		{in: "", expected: 0},
+		{in: "Sqreen", expected: 6},
+		{in: []byte("Sqreen"), expected: 6},
+		{in: []int{1, 2, 3}, expected: 3},
+		{in: [2]int{}, expected: 2},
+		{in: map[string]int{"a": 1}, expected: 1},
+		{in: &[]int{1}, expected: 1},
+		{in: (*[]int)(nil), expected: 0},
+		{in: 33, expected: nil},
+		{in: struct{}{}, expected: nil},
+	} {
+		tc := tc
+		t.Run(fmt.Sprintf("%#v", tc.in), func(t *testing.T) {
+			out := execLength(context.Background(), tc.in, newValueMaxDepth, NewValueMaxElements)
+			require.Equal(t, tc.expected, out)
+		})
+	}
+}
+
 // UnorderedEqual checks that two arrays are equal no matter the order of
 // their elements.
 func UnorderedEqual(t *testing.T, expected []interface{}, got []interface{}) {
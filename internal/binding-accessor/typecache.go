@@ -0,0 +1,114 @@
+// Copyright (c) 2016 - 2020 Sqreen. All Rights Reserved.
+// Please refer to our terms for more information:
+// https://www.sqreen.io/terms.html
+
+//sqreen:ignore
+
+package bindingaccessor
+
+import (
+	"reflect"
+	"sync"
+
+	"github.com/sqreen/go-agent/internal/sqlib/sqerrors"
+)
+
+// Binding accessor expressions are executed on every request while the types
+// they access are always the same. Field and method lookups by name are
+// therefore resolved once per type and stored in the following caches. Types
+// being immutable, the cache entries never need to be invalidated.
+var (
+	// Cache of the member accesses indexed by type and member name.
+	memberCache = struct {
+		sync.RWMutex
+		m map[memberKey]member
+	}{m: make(map[memberKey]member)}
+
+	// Cache of the result signature checks of function types, indexed by
+	// reflect.Type, and storing the signature error, if any.
+	funcResultsCache sync.Map
+
+	errorType = reflect.TypeOf((*error)(nil)).Elem()
+)
+
+type memberKey struct {
+	t    reflect.Type
+	name string
+}
+
+type memberKind int
+
+const (
+	noMember memberKind = iota
+	fieldMember
+	methodMember
+)
+
+// member is the result of the lookup of a member name in a type.
+type member struct {
+	kind memberKind
+	// Field index sequence, for fieldMember.
+	fieldIndex []int
+	// Method index, for methodMember.
+	methodIndex int
+	// True when the method doesn't expect any argument and should therefore be
+	// called to get the member value.
+	call bool
+}
+
+// lookupMember returns the member of type t having the given name. Struct
+// fields take precedence over methods.
+func lookupMember(t reflect.Type, name string) member {
+	key := memberKey{t: t, name: name}
+	memberCache.RLock()
+	m, exists := memberCache.m[key]
+	memberCache.RUnlock()
+	if exists {
+		return m
+	}
+
+	m = resolveMember(t, name)
+	memberCache.Lock()
+	memberCache.m[key] = m
+	memberCache.Unlock()
+	return m
+}
+
+func resolveMember(t reflect.Type, name string) member {
+	if t.Kind() == reflect.Struct {
+		if f, ok := t.FieldByName(name); ok {
+			return member{kind: fieldMember, fieldIndex: f.Index}
+		}
+	}
+
+	method, ok := t.MethodByName(name)
+	if !ok {
+		return member{kind: noMember}
+	}
+	numIn := method.Type.NumIn()
+	if t.Kind() != reflect.Interface {
+		// Do not count the receiver argument
+		numIn--
+	}
+	return member{kind: methodMember, methodIndex: method.Index, call: numIn == 0}
+}
+
+// checkFuncResults returns an error when the results of the given function
+// type are not a value and an optional error.
+func checkFuncResults(fnType reflect.Type) error {
+	if err, exists := funcResultsCache.Load(fnType); exists {
+		if err == nil {
+			return nil
+		}
+		return err.(error)
+	}
+
+	var err error
+	if nbResults := fnType.NumOut(); nbResults != 1 && nbResults != 2 {
+		err = sqerrors.Errorf("unexpected number of function results of function `%s`", fnType)
+	} else if nbResults == 2 && !fnType.Out(1).Implements(errorType) {
+		err = sqerrors.Errorf("unexpected second function results type of function `%s`: expected `error`", fnType)
+	}
+	funcResultsCache.Store(fnType, err)
+	return err
+}
@@ -28,6 +28,10 @@ import (
 
 type Config struct {
 	*viper.Viper
+
+	// trustedProxies is the list of trusted proxies parsed by health() so that
+	// it is not parsed every time it is used by HTTP requests.
+	trustedProxies []*net.IPNet
 }
 
 // Error messages.
@@ -222,6 +226,8 @@ const (
 	configKeyAppName                        = `app_name`
 	configKeyHTTPClientIPHeader             = `ip_header`
 	configKeyHTTPClientIPHeaderFormat       = `ip_header_format`
+	configKeyHTTPTrustedProxies             = `trusted_proxies`
+	configKeyHTTPMaxBodyCaptureSize         = `max_body_capture_size`
 	configKeyBackendHTTPAPIProxy            = `proxy`
 	configKeyDisable                        = `disable`
 	configKeyStripHTTPReferer               = `strip_http_referer`
@@ -231,6 +237,11 @@ const (
 	configKeyDisableSignalBackend           = `disable_signal_backend`
 	configKeyStripSensitiveKeyRegexp        = `strip_sensitive_key_regexp`
 	configKeyStripSensitiveValueRegexp      = `strip_sensitive_value_regexp`
+	configKeyGeoIPDatabase                  = `geoip_database`
+	configKeyActionsSnapshotFile            = `actions_snapshot_file`
+	configKeyBlockingHTMLTemplate           = `blocking_html_template`
+	configKeyBlockingJSONTemplate           = `blocking_json_template`
+	configKeyBlockingTextTemplate           = `blocking_text_template`
 )
 
 // User configuration's default values.
@@ -238,9 +249,10 @@ const (
 	configDefaultBackendHTTPAPIBaseURL          = `https://back.sqreen.com`
 	configDefaultIngestionBackendHTTPAPIBaseURL = "https://ingestion.sqreen.com/"
 
-	configDefaultLogLevel              = `info`
-	configDefaultSDKMetricsPeriod      = 60
-	configDefaultMaxMetricsStoreLength = 100 * 1024 * 1024
+	configDefaultLogLevel               = `info`
+	configDefaultSDKMetricsPeriod       = 60
+	configDefaultMaxMetricsStoreLength  = 100 * 1024 * 1024
+	configDefaultHTTPMaxBodyCaptureSize = 1024 * 1024
 
 	// configDefaultStripSensitiveKeyRegexp is the scrubber key regular expression (cf. scrubber doc
 	// for usage). It is a case-insensitive regexp matching passwd, password,
@@ -274,6 +286,8 @@ func New(logger *plog.Logger) (*Config, error) {
 		{key: configKeyAppName, defaultValue: ""},
 		{key: configKeyHTTPClientIPHeader, defaultValue: ""},
 		{key: configKeyHTTPClientIPHeaderFormat, defaultValue: ""},
+		{key: configKeyHTTPTrustedProxies, defaultValue: ""},
+		{key: configKeyHTTPMaxBodyCaptureSize, defaultValue: configDefaultHTTPMaxBodyCaptureSize},
 		{key: configKeyBackendHTTPAPIProxy, defaultValue: ""},
 		{key: configKeyDisable, defaultValue: ""},
 		{key: configKeyStripHTTPReferer, defaultValue: ""},
@@ -283,6 +297,11 @@ func New(logger *plog.Logger) (*Config, error) {
 		{key: configKeyDisableSignalBackend, defaultValue: "", hidden: true},
 		{key: configKeyStripSensitiveKeyRegexp, defaultValue: configDefaultStripSensitiveKeyRegexp},
 		{key: configKeyStripSensitiveValueRegexp, defaultValue: configDefaultStripSensitiveValueRegexp},
+		{key: configKeyGeoIPDatabase, defaultValue: ""},
+		{key: configKeyActionsSnapshotFile, defaultValue: ""},
+		{key: configKeyBlockingHTMLTemplate, defaultValue: ""},
+		{key: configKeyBlockingJSONTemplate, defaultValue: ""},
+		{key: configKeyBlockingTextTemplate, defaultValue: ""},
 	}
 	for _, p := range parameters {
 		manager.SetDefault(p.key, p.defaultValue)
@@ -375,10 +394,75 @@ func (c *Config) HTTPClientIPHeader() string {
 }
 
 // HTTPClientIPHeaderFormat returns the header format of the `ip_header` value.
+// It is either empty for a comma-separated list of IP addresses such as
+// `X-Forwarded-For`, `forwarded` for the RFC 7239 `Forwarded` header format, or
+// `haproxy` for the HAProxy `%ci:%cp` unique ID format. Other values are
+// considered as the HAProxy format for backward compatibility.
 func (c *Config) HTTPClientIPHeaderFormat() string {
 	return sanitizeString(c.GetString(configKeyHTTPClientIPHeaderFormat))
 }
 
+// HTTPTrustedProxies returns the IP networks of the trusted reverse proxies.
+// When set, the client IP address is only taken from the HTTP headers when the
+// request comes from a trusted proxy, and is the right-most IP address of the
+// forwarding chain that is not a trusted proxy.
+func (c *Config) HTTPTrustedProxies() []*net.IPNet {
+	// The networks are parsed and checked once by health() so this function
+	// doesn't need to return an error.
+	return c.trustedProxies
+}
+
+// httpTrustedProxies parses the list of trusted proxies, given either as a
+// list or as a string of comma or space separated IP addresses or CIDRs.
+func (c *Config) httpTrustedProxies() ([]*net.IPNet, error) {
+	var networks []*net.IPNet
+	for _, values := range c.GetStringSlice(configKeyHTTPTrustedProxies) {
+		for _, value := range strings.Split(values, ",") {
+			value = sanitizeString(value)
+			if value == "" {
+				continue
+			}
+			network, err := parseIPNetwork(value)
+			if err != nil {
+				return nil, err
+			}
+			networks = append(networks, network)
+		}
+	}
+	return networks, nil
+}
+
+// parseIPNetwork parses the given CIDR or IP address. An IP address is the
+// network of this single address.
+func parseIPNetwork(value string) (*net.IPNet, error) {
+	if !strings.Contains(value, "/") {
+		ip := net.ParseIP(value)
+		if ip == nil {
+			return nil, sqerrors.Errorf("unexpected IP address `%s`", value)
+		}
+		if ipv4 := ip.To4(); ipv4 != nil {
+			ip = ipv4
+		}
+		return &net.IPNet{IP: ip, Mask: net.CIDRMask(len(ip)*8, len(ip)*8)}, nil
+	}
+	_, network, err := net.ParseCIDR(value)
+	if err != nil {
+		return nil, sqerrors.Wrapf(err, "unexpected CIDR `%s`", value)
+	}
+	return network, nil
+}
+
+// HTTPMaxBodyCaptureSize returns the maximum number of request body bytes
+// captured for the protections, beyond which the captured body is truncated. The
+// request body is not captured when zero.
+func (c *Config) HTTPMaxBodyCaptureSize() int {
+	n := c.GetInt(configKeyHTTPMaxBodyCaptureSize)
+	if n < 0 {
+		return configDefaultHTTPMaxBodyCaptureSize
+	}
+	return n
+}
+
 // Proxy returns the proxy configuration to use for backend HTTP calls.
 func (c *Config) BackendHTTPAPIProxy() string {
 	return sanitizeString(c.GetString(configKeyBackendHTTPAPIProxy))
@@ -403,6 +487,36 @@ func (c *Config) LocalRulesFile() string {
 	return sanitizeString(c.GetString(configKeyRules))
 }
 
+// GeoIPDatabase returns the path of the MaxMind DB file to use to geolocate
+// IP addresses by country and autonomous system number.
+func (c *Config) GeoIPDatabase() string {
+	return sanitizeString(c.GetString(configKeyGeoIPDatabase))
+}
+
+// ActionsSnapshotFile returns the path of the file where the security actions
+// are saved in order to be restored when the agent restarts.
+func (c *Config) ActionsSnapshotFile() string {
+	return sanitizeString(c.GetString(configKeyActionsSnapshotFile))
+}
+
+// BlockingHTMLTemplate returns the path of the template file of the blocking
+// response to write when the client accepts HTML.
+func (c *Config) BlockingHTMLTemplate() string {
+	return sanitizeString(c.GetString(configKeyBlockingHTMLTemplate))
+}
+
+// BlockingJSONTemplate returns the path of the template file of the blocking
+// response to write when the client accepts JSON.
+func (c *Config) BlockingJSONTemplate() string {
+	return sanitizeString(c.GetString(configKeyBlockingJSONTemplate))
+}
+
+// BlockingTextTemplate returns the path of the template file of the blocking
+// response to write when the client accepts neither HTML nor JSON.
+func (c *Config) BlockingTextTemplate() string {
+	return sanitizeString(c.GetString(configKeyBlockingTextTemplate))
+}
+
 // SDKMetricsPeriod returns the period to use for the SDK metric stores.
 // This is temporary until the SDK rules are implemented and required for
 // integration tests which require a shorter time.
@@ -485,6 +599,12 @@ func (c *Config) health() error {
 		return sqerrors.Wrapf(err, "config: invalid regular expression for sensitive values")
 	}
 
+	trustedProxies, err := c.httpTrustedProxies()
+	if err != nil {
+		return sqerrors.Wrapf(err, "config: invalid trusted proxies")
+	}
+	c.trustedProxies = trustedProxies
+
 	return nil
 }
 
@@ -61,6 +61,36 @@ func TestUserConfig(t *testing.T) {
 			ConfigKey:   configKeyBackendHTTPAPIProxy,
 			SomeValue:   testlib.RandUTF8String(2, 30),
 		},
+		{
+			Name:        "GeoIP Database",
+			GetCfgValue: cfg.GeoIPDatabase,
+			ConfigKey:   configKeyGeoIPDatabase,
+			SomeValue:   testlib.RandUTF8String(2, 30),
+		},
+		{
+			Name:        "Actions Snapshot File",
+			GetCfgValue: cfg.ActionsSnapshotFile,
+			ConfigKey:   configKeyActionsSnapshotFile,
+			SomeValue:   testlib.RandUTF8String(2, 30),
+		},
+		{
+			Name:        "Blocking HTML Template",
+			GetCfgValue: cfg.BlockingHTMLTemplate,
+			ConfigKey:   configKeyBlockingHTMLTemplate,
+			SomeValue:   testlib.RandUTF8String(2, 30),
+		},
+		{
+			Name:        "Blocking JSON Template",
+			GetCfgValue: cfg.BlockingJSONTemplate,
+			ConfigKey:   configKeyBlockingJSONTemplate,
+			SomeValue:   testlib.RandUTF8String(2, 30),
+		},
+		{
+			Name:        "Blocking Text Template",
+			GetCfgValue: cfg.BlockingTextTemplate,
+			ConfigKey:   configKeyBlockingTextTemplate,
+			SomeValue:   testlib.RandUTF8String(2, 30),
+		},
 	}
 	for _, tc := range stringValueTests {
 		testStringValue(t, cfg, tc.Name, tc.GetCfgValue, tc.ConfigKey, tc.DefaultValue, tc.SomeValue)
@@ -139,6 +169,15 @@ func TestConfigValidation(t *testing.T) {
 		require.Error(t, err)
 		require.Nil(t, cfg)
 	})
+
+	t.Run("bad trusted proxies", func(t *testing.T) {
+		cwdFile := newCfgFile(t, ".", `token: mytoken
+`+configKeyHTTPTrustedProxies+`: 10.0.0.0/8, oops`)
+		defer os.Remove(cwdFile)
+		cfg, err := New(logger)
+		require.Error(t, err)
+		require.Nil(t, cfg)
+	})
 }
 
 func TestFileLocation(t *testing.T) {
@@ -317,6 +356,39 @@ func TestStripRegexp(t *testing.T) {
 	})
 }
 
+func TestTrustedProxies(t *testing.T) {
+	logger := plog.NewLogger(plog.Debug, os.Stderr, nil)
+
+	for _, tc := range []struct {
+		name, config string
+	}{
+		{name: "string", config: `10.0.0.0/8, 192.168.1.1 2001:db8::/32`},
+		{name: "list", config: `
+  - 10.0.0.0/8
+  - 192.168.1.1
+  - 2001:db8::/32`},
+	} {
+		tc := tc
+		t.Run(tc.name, func(t *testing.T) {
+			cwdFile := newCfgFile(t, ".", `token: mytoken
+`+configKeyHTTPTrustedProxies+`: `+tc.config)
+			defer os.Remove(cwdFile)
+			cfg, err := New(logger)
+			require.NoError(t, err)
+
+			var networks []string
+			for _, network := range cfg.HTTPTrustedProxies() {
+				networks = append(networks, network.String())
+			}
+			require.Equal(t, []string{"10.0.0.0/8", "192.168.1.1/32", "2001:db8::/32"}, networks)
+
+			// Parsed once when loading the configuration
+			cfg.Set(configKeyHTTPTrustedProxies, "")
+			require.Len(t, cfg.HTTPTrustedProxies(), 3)
+		})
+	}
+}
+
 func TestDefaultConfiguration(t *testing.T) {
 	cwdFile := newCfgFile(t, ".", `token: mytoken`)
 	defer os.Remove(cwdFile)
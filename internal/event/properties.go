@@ -0,0 +1,80 @@
+// Copyright (c) 2016 - 2020 Sqreen. All Rights Reserved.
+// Please refer to our terms for more information:
+// https://www.sqreen.io/terms.html
+
+//sqreen:ignore
+
+package event
+
+import (
+	"encoding/json"
+
+	"github.com/sqreen/go-agent/internal/sqlib/sqerrors"
+)
+
+const (
+	// MaxStructuredPropertiesDepth is the maximum nesting depth of structured
+	// event properties.
+	MaxStructuredPropertiesDepth = 10
+	// MaxStructuredPropertiesSize is the maximum size of the JSON
+	// representation of structured event properties.
+	MaxStructuredPropertiesSize = 8 * 1024
+)
+
+// StructuredProperties are event properties whose values are the generic JSON
+// representation of arbitrary JSON-marshalable values, ie. maps of strings,
+// slices, strings, float64 numbers, booleans and nil values. They can
+// therefore be scrubbed according to their JSON keys and string values.
+type StructuredProperties map[string]interface{}
+
+func (p StructuredProperties) MarshalJSON() ([]byte, error) {
+	return json.Marshal(map[string]interface{}(p))
+}
+
+// NewStructuredProperties returns the structured properties of the given
+// values. An error is returned when they cannot be marshaled to JSON, or when
+// they exceed the maximum depth or size.
+func NewStructuredProperties(props map[string]interface{}) (StructuredProperties, error) {
+	if len(props) == 0 {
+		return nil, nil
+	}
+
+	buf, err := json.Marshal(props)
+	if err != nil {
+		return nil, sqerrors.Wrap(err, "json marshaling")
+	}
+	if size := len(buf); size > MaxStructuredPropertiesSize {
+		return nil, sqerrors.Errorf("the json representation of the properties is too large: %d bytes while the maximum is %d bytes", size, MaxStructuredPropertiesSize)
+	}
+
+	var structured StructuredProperties
+	if err := json.Unmarshal(buf, &structured); err != nil {
+		return nil, sqerrors.Wrap(err, "json unmarshaling")
+	}
+	if depth := propertyDepth(map[string]interface{}(structured)); depth > MaxStructuredPropertiesDepth {
+		return nil, sqerrors.Errorf("the properties are too deep: depth %d while the maximum is %d", depth, MaxStructuredPropertiesDepth)
+	}
+	return structured, nil
+}
+
+// propertyDepth returns the nesting depth of the given generic JSON value.
+func propertyDepth(v interface{}) (depth int) {
+	switch actual := v.(type) {
+	case map[string]interface{}:
+		for _, e := range actual {
+			if d := propertyDepth(e); d > depth {
+				depth = d
+			}
+		}
+		return depth + 1
+	case []interface{}:
+		for _, e := range actual {
+			if d := propertyDepth(e); d > depth {
+				depth = d
+			}
+		}
+		return depth + 1
+	default:
+		return 0
+	}
+}
@@ -12,6 +12,7 @@ import (
 	"time"
 
 	protectioncontext "github.com/sqreen/go-agent/internal/protection/context"
+	"github.com/sqreen/go-agent/internal/sqlib/sqerrors"
 )
 
 type Record struct {
@@ -36,8 +37,11 @@ type CustomEvent struct {
 	Method     string
 	Event      string
 	Properties protectioncontext.EventProperties
-	UserID     UserIdentifierMap
-	Timestamp  time.Time
+	// PropertiesError is the error of the invalid structured properties given
+	// to WithStructuredProperties(), which were therefore not recorded.
+	PropertiesError error
+	UserID          UserIdentifierMap
+	Timestamp       time.Time
 }
 
 type AttackEvent struct {
@@ -160,6 +164,20 @@ func (e *CustomEvent) WithProperties(p protectioncontext.EventProperties) {
 	e.Properties = p
 }
 
+func (e *CustomEvent) WithStructuredProperties(p map[string]interface{}) {
+	structured, err := NewStructuredProperties(p)
+	if err != nil {
+		e.PropertiesError = sqerrors.Wrapf(err, "invalid properties of the custom event `%s`", e.Event)
+		return
+	}
+	e.PropertiesError = nil
+	if len(structured) == 0 {
+		e.Properties = nil
+		return
+	}
+	e.Properties = structured
+}
+
 func (e *CustomEvent) WithUserIdentifiers(id UserIdentifierMap) {
 	e.UserID = id
 }
@@ -5,9 +5,12 @@
 package event_test
 
 import (
+	"regexp"
+	"strings"
 	"testing"
 
 	"github.com/sqreen/go-agent/internal/event"
+	"github.com/sqreen/go-agent/internal/sqlib/sqsanitize"
 	"github.com/stretchr/testify/require"
 )
 
@@ -22,3 +25,79 @@ func TestUsage(t *testing.T) {
 		require.Equal(t, uid, event.UserID)
 	})
 }
+
+func TestStructuredProperties(t *testing.T) {
+	type card struct {
+		Type   string `json:"type"`
+		Number string `json:"number"`
+	}
+
+	t.Run("json values", func(t *testing.T) {
+		var record event.Record
+		record.AddCustomEvent("test").WithStructuredProperties(map[string]interface{}{
+			"amount": 42,
+			"paid":   true,
+			"items":  []string{"a", "b"},
+			"card":   card{Type: "visa", Number: "4111111111111111"},
+			"none":   nil,
+		})
+		e := record.CloseRecord().CustomEvents[0]
+		require.NoError(t, e.PropertiesError)
+		require.Equal(t, event.StructuredProperties{
+			"amount": 42.0,
+			"paid":   true,
+			"items":  []interface{}{"a", "b"},
+			"card":   map[string]interface{}{"type": "visa", "number": "4111111111111111"},
+			"none":   nil,
+		}, e.Properties)
+	})
+
+	t.Run("scrubbing", func(t *testing.T) {
+		props, err := event.NewStructuredProperties(map[string]interface{}{
+			"user": map[string]interface{}{"name": "me", "password": "secret"},
+			"card": card{Type: "visa", Number: "4111111111111111"},
+		})
+		require.NoError(t, err)
+		scrubber := sqsanitize.NewScrubber(regexp.MustCompile(`(?i)password`), regexp.MustCompile(`\d{16}`), "<redacted>")
+		scrubbed, err := scrubber.Scrub(props, nil)
+		require.NoError(t, err)
+		require.True(t, scrubbed)
+		require.Equal(t, event.StructuredProperties{
+			"user": map[string]interface{}{"name": "me", "password": "<redacted>"},
+			"card": map[string]interface{}{"type": "visa", "number": "<redacted>"},
+		}, props)
+	})
+
+	t.Run("empty", func(t *testing.T) {
+		var record event.Record
+		record.AddCustomEvent("test").WithStructuredProperties(nil)
+		e := record.CloseRecord().CustomEvents[0]
+		require.NoError(t, e.PropertiesError)
+		require.Nil(t, e.Properties)
+	})
+
+	t.Run("invalid", func(t *testing.T) {
+		deep := []interface{}{"deep"}
+		for i := 0; i < event.MaxStructuredPropertiesDepth; i++ {
+			deep = []interface{}{deep}
+		}
+
+		for _, tc := range []struct {
+			name  string
+			props map[string]interface{}
+		}{
+			{name: "not json-marshalable", props: map[string]interface{}{"c": make(chan int)}},
+			{name: "too deep", props: map[string]interface{}{"deep": deep}},
+			{name: "too large", props: map[string]interface{}{"large": strings.Repeat("a", event.MaxStructuredPropertiesSize)}},
+		} {
+			tc := tc
+			t.Run(tc.name, func(t *testing.T) {
+				var record event.Record
+				record.AddCustomEvent("test").WithStructuredProperties(tc.props)
+				e := record.CloseRecord().CustomEvents[0]
+				require.Error(t, e.PropertiesError)
+				require.Nil(t, e.Properties)
+			})
+		}
+	})
+}
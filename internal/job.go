@@ -0,0 +1,82 @@
+// Copyright (c) 2016 - 2020 Sqreen. All Rights Reserved.
+// Please refer to our terms for more information:
+// https://www.sqreen.io/terms.html
+
+package internal
+
+import (
+	"context"
+	"time"
+
+	"github.com/sqreen/go-agent/internal/event"
+	protection_context "github.com/sqreen/go-agent/internal/protection/context"
+)
+
+// JobProtectionContext is the protection context of a background job, such as
+// a queue consumer or a cron job, which is not an HTTP request. It records the
+// SDK events of the job which are sent along with the job metadata once it is
+// closed.
+type JobProtectionContext struct {
+	ctx    context.Context
+	cancel context.CancelFunc
+	agent  *AgentType
+	name   string
+	start  time.Time
+	events event.Record
+}
+
+// Static assert that JobProtectionContext implements the expected interfaces.
+var _ protection_context.EventRecorder = (*JobProtectionContext)(nil)
+
+// NewJobProtectionContext returns a new protection context for the job of the
+// given name, nil when the agent is not running. The returned context is
+// canceled when the job protection context is closed.
+func NewJobProtectionContext(ctx context.Context, name string) (*JobProtectionContext, context.Context) {
+	agent := agentInstance.get()
+	if agent == nil || !agent.isRunning() {
+		return nil, ctx
+	}
+
+	ctx, cancel := context.WithCancel(ctx)
+	p := &JobProtectionContext{
+		ctx:    ctx,
+		cancel: cancel,
+		agent:  agent,
+		name:   name,
+		start:  time.Now(),
+	}
+	return p, context.WithValue(ctx, protection_context.ContextKey, p)
+}
+
+func (p *JobProtectionContext) TrackEvent(event string) protection_context.CustomEvent {
+	return p.events.AddCustomEvent(event)
+}
+
+// TrackUserSignup records the user signup without IP address since there is no
+// client in a job.
+func (p *JobProtectionContext) TrackUserSignup(id map[string]string) {
+	p.events.AddUserSignup(id, nil)
+}
+
+// TrackUserAuth records the user authentication without IP address since there
+// is no client in a job.
+func (p *JobProtectionContext) TrackUserAuth(id map[string]string, success bool) {
+	p.events.AddUserAuth(id, nil, success)
+}
+
+// IdentifyUser associates the user to the job. It never returns an error since
+// user security responses only apply to HTTP requests.
+func (p *JobProtectionContext) IdentifyUser(id map[string]string) error {
+	p.events.Identify(id)
+	return nil
+}
+
+// SetRoute does nothing since jobs have no routes.
+func (p *JobProtectionContext) SetRoute(string) {}
+
+// Close closes the job protection context and sends its events. It must be
+// called once the job is done.
+func (p *JobProtectionContext) Close() {
+	p.cancel()
+	p.agent.sendClosedJobProtectionContext(p.name, p.start, time.Now(), p.events.CloseRecord())
+}
@@ -0,0 +1,63 @@
+// Copyright (c) 2016 - 2020 Sqreen. All Rights Reserved.
+// Please refer to our terms for more information:
+// https://www.sqreen.io/terms.html
+
+package internal
+
+import (
+	"encoding/json"
+	"testing"
+	"time"
+
+	"github.com/sqreen/go-agent/internal/backend/api"
+	"github.com/stretchr/testify/require"
+)
+
+func TestJobProtectionContext(t *testing.T) {
+	newClosedJob := func(record func(p *JobProtectionContext)) *closedJobContextEvent {
+		p := &JobProtectionContext{name: "my job", start: time.Now()}
+		record(p)
+		return newClosedJobContextEvent("my rulespack", p.name, p.start, time.Now(), p.events.CloseRecord())
+	}
+
+	t.Run("no events", func(t *testing.T) {
+		e := newClosedJob(func(*JobProtectionContext) {})
+		require.False(t, e.shouldSend())
+	})
+
+	t.Run("only identified", func(t *testing.T) {
+		e := newClosedJob(func(p *JobProtectionContext) {
+			require.NoError(t, p.IdentifyUser(map[string]string{"uid": "me"}))
+		})
+		require.False(t, e.shouldSend())
+	})
+
+	t.Run("user events", func(t *testing.T) {
+		e := newClosedJob(func(p *JobProtectionContext) {
+			p.TrackUserSignup(map[string]string{"uid": "me"})
+			p.TrackUserAuth(map[string]string{"uid": "me"}, true)
+		})
+		require.Len(t, e.events.UserEvents, 2)
+		// User events are sent as metrics
+		require.False(t, e.shouldSend())
+	})
+
+	t.Run("job record", func(t *testing.T) {
+		e := newClosedJob(func(p *JobProtectionContext) {
+			require.NoError(t, p.IdentifyUser(map[string]string{"uid": "me"}))
+			p.TrackEvent("payout").WithStructuredProperties(map[string]interface{}{"amount": 42})
+		})
+		require.True(t, e.shouldSend())
+
+		record := api.NewJobRecordFromFace(newJobEventAPIAdapter(e))
+		require.Equal(t, "my job", record.Name)
+		require.Equal(t, "my rulespack", record.RulespackId)
+		require.Equal(t, e.start, record.Start)
+		require.Equal(t, e.finish, record.End)
+		require.Len(t, record.Observed.Sdk, 2)
+
+		buf, err := json.Marshal(api.JobRecordEvent{JobRecord: record})
+		require.NoError(t, err)
+		require.Contains(t, string(buf), `["payout",{"properties":{"amount":42}}]`)
+	})
+}
@@ -80,9 +80,14 @@ func UserEventMetricsStoreKey(e *event.UserEvent) (json.Marshaler, error) {
 		keys = append(keys, []interface{}{prop, val})
 	}
 	jsonKeys, _ := json.Marshal(keys)
+	// User events of jobs have no IP address
+	var ip string
+	if e.IP != nil {
+		ip = e.IP.String()
+	}
 	return userMetricsKey{
 		Keys: string(jsonKeys),
-		IP:   e.IP.String(),
+		IP:   ip,
 	}, nil
 }
 
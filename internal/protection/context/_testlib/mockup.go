@@ -53,6 +53,14 @@ func (e *EventRecorderMockup) ExpectIdentifyUser(id interface{}) *mock.Call {
 	return e.On("IdentifyUser", id)
 }
 
+func (e *EventRecorderMockup) SetRoute(route string) {
+	e.Called(route)
+}
+
+func (e *EventRecorderMockup) ExpectSetRoute(route interface{}) *mock.Call {
+	return e.On("SetRoute", route)
+}
+
 func (e *EventRecorderMockup) WithTimestamp(t time.Time) {
 	e.Called(t)
 }
@@ -69,6 +77,14 @@ func (e *EventRecorderMockup) ExpectWithProperties(props interface{}) *mock.Call
 	return e.On("WithProperties", props)
 }
 
+func (e *EventRecorderMockup) WithStructuredProperties(props map[string]interface{}) {
+	e.Called(props)
+}
+
+func (e *EventRecorderMockup) ExpectWithStructuredProperties(props interface{}) *mock.Call {
+	return e.On("WithStructuredProperties", props)
+}
+
 func (e *EventRecorderMockup) WithUserIdentifiers(id map[string]string) {
 	e.Called(id)
 }
@@ -40,11 +40,17 @@ type (
 		// request. An non-nil error is returned when a security response matches
 		// the given user id.
 		IdentifyUser(id map[string]string) error
+		// SetRoute sets the route template of the request, such as
+		// `/users/:id`, so that it is added to the request record.
+		SetRoute(route string)
 	}
 
 	CustomEvent interface {
 		WithTimestamp(t time.Time)
 		WithProperties(props EventProperties)
+		// WithStructuredProperties sets properties whose values can be any
+		// JSON-marshalable value. They are dropped when invalid.
+		WithStructuredProperties(props map[string]interface{})
 		WithUserIdentifiers(id map[string]string)
 	}
 
@@ -0,0 +1,280 @@
+// Copyright (c) 2016 - 2020 Sqreen. All Rights Reserved.
+// Please refer to our terms for more information:
+// https://www.sqreen.io/terms.html
+
+//sqreen:ignore
+
+// Package graphql provides the inspection of GraphQL requests so that the
+// values hidden in their query documents and variables can be protected as
+// request parameters.
+package graphql
+
+import (
+	"encoding/json"
+	"math"
+	"mime"
+	"net/http"
+	"net/url"
+	"strconv"
+
+	"github.com/sqreen/go-agent/internal/sqlib/sqerrors"
+)
+
+// Request is a GraphQL request as sent over HTTP.
+type Request struct {
+	Query         string                 `json:"query"`
+	OperationName string                 `json:"operationName"`
+	Variables     map[string]interface{} `json:"variables"`
+}
+
+// ReadHTTPRequest returns the GraphQL request of the given HTTP request, nil
+// when it is not a GraphQL request. GET requests take it from the URL query
+// parameters while POST requests take it from the given body, either in JSON
+// or as an `application/graphql` query document.
+func ReadHTTPRequest(method, contentType string, query url.Values, body []byte) (*Request, error) {
+	switch method {
+	case http.MethodGet:
+		q := query.Get("query")
+		if q == "" {
+			return nil, nil
+		}
+		r := &Request{
+			Query:         q,
+			OperationName: query.Get("operationName"),
+		}
+		if v := query.Get("variables"); v != "" {
+			if err := json.Unmarshal([]byte(v), &r.Variables); err != nil {
+				return nil, sqerrors.Wrap(err, "graphql: json unmarshal error of the variables")
+			}
+		}
+		return r, nil
+
+	case http.MethodPost:
+		mediaType, _, err := mime.ParseMediaType(contentType)
+		if err != nil {
+			return nil, nil
+		}
+		switch mediaType {
+		case "application/graphql":
+			return &Request{Query: string(body)}, nil
+		case "application/json":
+			var r Request
+			if err := json.Unmarshal(body, &r); err != nil {
+				return nil, sqerrors.Wrap(err, "graphql: json unmarshal error of the request")
+			}
+			if r.Query == "" {
+				return nil, nil
+			}
+			return &r, nil
+		}
+	}
+	return nil, nil
+}
+
+// Result is the result of the inspection of a GraphQL request.
+type Result struct {
+	// OperationType is the type of the executed operation: `query`, `mutation`
+	// or `subscription`.
+	OperationType string
+	// OperationName is the name of the executed operation, empty when it is
+	// anonymous.
+	OperationName string
+	// Variables is the flattened map of the request variables whose keys are
+	// the paths to their scalar values.
+	Variables map[string]interface{}
+	// Arguments is the flattened map of the literal argument values of the
+	// executed operation. The keys are the paths to their scalar values from
+	// the root field.
+	Arguments map[string][]interface{}
+	// Depth is the maximum depth of the selection sets of the executed
+	// operation, where the root fields are at depth 1.
+	Depth int
+	// Aliases is the number of aliased fields of the executed operation, with
+	// fragments counted as many times as they are spread.
+	Aliases int
+}
+
+// Inspect parses the query document of the given GraphQL request in order to
+// find its executed operation and to flatten its variables and literal
+// arguments. ErrMaxNesting is returned when the document is too deep to be
+// parsed.
+func Inspect(r *Request) (*Result, error) {
+	doc, err := Parse(r.Query)
+	if err != nil {
+		return nil, err
+	}
+	op, err := doc.operation(r.OperationName)
+	if err != nil {
+		return nil, err
+	}
+
+	result := &Result{
+		OperationType: op.Type,
+		OperationName: op.Name,
+	}
+	if len(r.Variables) > 0 {
+		result.Variables = make(map[string]interface{})
+		for name, value := range r.Variables {
+			flatten(result.Variables, name, value)
+		}
+	}
+
+	w := walker{
+		doc:       doc,
+		fragments: make(map[string]*fragmentInfo),
+		arguments: make(map[string][]interface{}),
+	}
+	result.Depth, result.Aliases = w.walk(op.SelectionSet, "")
+	if len(w.arguments) > 0 {
+		result.Arguments = w.arguments
+	}
+	return result, nil
+}
+
+// operation returns the operation executed by a request with the given
+// operation name.
+func (d *Document) operation(name string) (*Operation, error) {
+	if name == "" {
+		if len(d.Operations) > 1 {
+			return nil, sqerrors.New("graphql: the operation name is required when the document has several operations")
+		}
+		return d.Operations[0], nil
+	}
+	for _, op := range d.Operations {
+		if op.Name == name {
+			return op, nil
+		}
+	}
+	return nil, sqerrors.Errorf("graphql: unknown operation `%s`", name)
+}
+
+// flatten adds the scalar values of the given value to the given map with keys
+// being their paths joined with dots.
+func flatten(m map[string]interface{}, path string, value interface{}) {
+	switch actual := value.(type) {
+	case map[string]interface{}:
+		for k, v := range actual {
+			flatten(m, path+"."+k, v)
+		}
+	case []interface{}:
+		for i, v := range actual {
+			flatten(m, path+"."+strconv.Itoa(i), v)
+		}
+	default:
+		m[path] = value
+	}
+}
+
+type fragmentInfo struct {
+	depth, aliases int
+	// walking is true while walking the fragment to detect fragment cycles.
+	walking bool
+}
+
+// walker walks the selection sets of an operation in order to measure its
+// depth and aliases, and to flatten its literal arguments. Named fragments are
+// walked once in order to be linear with the document size despite the
+// fragments being spread several times.
+type walker struct {
+	doc       *Document
+	fragments map[string]*fragmentInfo
+	arguments map[string][]interface{}
+}
+
+// walk returns the depth and the number of aliases of the given selection set.
+func (w *walker) walk(set []Selection, path string) (depth, aliases int) {
+	for _, selection := range set {
+		var d, a int
+		switch s := selection.(type) {
+		case *Field:
+			fieldPath := s.Name
+			if path != "" {
+				fieldPath = path + "." + s.Name
+			}
+			w.addArguments(fieldPath, s.Arguments)
+			w.addDirectives(fieldPath, s.Directives)
+			d, a = w.walk(s.SelectionSet, fieldPath)
+			d++
+			if s.Alias != "" {
+				a = addCount(a, 1)
+			}
+		case *InlineFragment:
+			w.addDirectives(path, s.Directives)
+			d, a = w.walk(s.SelectionSet, path)
+		case *FragmentSpread:
+			w.addDirectives(path, s.Directives)
+			d, a = w.walkFragment(s.Name)
+		}
+		if d > depth {
+			depth = d
+		}
+		aliases = addCount(aliases, a)
+	}
+	return depth, aliases
+}
+
+// maxCount is the maximum alias count. Fragments spread several times multiply
+// their counts, which can overflow.
+const maxCount = math.MaxInt32
+
+func addCount(a, b int) int {
+	if a > maxCount-b {
+		return maxCount
+	}
+	return a + b
+}
+
+func (w *walker) walkFragment(name string) (depth, aliases int) {
+	info, walked := w.fragments[name]
+	if walked {
+		if info.walking {
+			// Fragment cycle
+			return 0, 0
+		}
+		return info.depth, info.aliases
+	}
+	fragment, exists := w.doc.Fragments[name]
+	if !exists {
+		return 0, 0
+	}
+	info = &fragmentInfo{walking: true}
+	w.fragments[name] = info
+	// The arguments of fragments are keyed by the fragment name since they can
+	// be spread at different paths.
+	info.depth, info.aliases = w.walk(fragment.SelectionSet, "..."+name)
+	info.walking = false
+	return info.depth, info.aliases
+}
+
+func (w *walker) addArguments(path string, args []Argument) {
+	for _, arg := range args {
+		w.addValue(path+"."+arg.Name, arg.Value)
+	}
+}
+
+func (w *walker) addDirectives(path string, directives []Directive) {
+	for _, directive := range directives {
+		prefix := "@" + directive.Name
+		if path != "" {
+			prefix = path + "." + prefix
+		}
+		w.addArguments(prefix, directive.Arguments)
+	}
+}
+
+func (w *walker) addValue(path string, value interface{}) {
+	switch actual := value.(type) {
+	case Variable:
+		// The variable values are added apart
+	case map[string]interface{}:
+		for k, v := range actual {
+			w.addValue(path+"."+k, v)
+		}
+	case []interface{}:
+		for i, v := range actual {
+			w.addValue(path+"."+strconv.Itoa(i), v)
+		}
+	default:
+		w.arguments[path] = append(w.arguments[path], value)
+	}
+}
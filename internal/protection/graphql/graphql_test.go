@@ -0,0 +1,253 @@
+// Copyright (c) 2016 - 2020 Sqreen. All Rights Reserved.
+// Please refer to our terms for more information:
+// https://www.sqreen.io/terms.html
+
+package graphql_test
+
+import (
+	"fmt"
+	"math"
+	"net/url"
+	"strings"
+	"testing"
+
+	"github.com/sqreen/go-agent/internal/protection/graphql"
+	"github.com/stretchr/testify/require"
+)
+
+func TestParse(t *testing.T) {
+	t.Run("valid documents", func(t *testing.T) {
+		for _, doc := range []string{
+			`{ me }`,
+			`query { me { name } }`,
+			`query Q($id: ID! = "1", $l: [[Int!]]) @dir(a: 1) { user(id: $id) { ...F } }  fragment F on User @x { name }`,
+			`mutation M { like(input: {id: 1, tags: ["a", "b"], n: null, e: ENUM, f: -1.5e3, t: true}) { ok } }`,
+			`subscription { events { ... on A { a } ... @include(if: true) { b } } }`,
+			"\uFEFF# comment\n{ a, b, c }",
+			`{ a(s: """
+			    block
+			      string
+			""") }`,
+		} {
+			_, err := graphql.Parse(doc)
+			require.NoError(t, err, doc)
+		}
+	})
+
+	t.Run("invalid documents", func(t *testing.T) {
+		for _, doc := range []string{
+			``,
+			`{}`,
+			`{ a `,
+			`query query`,
+			`{ a(b: ) }`,
+			`{ a(b: "unterminated) }`,
+			`{ a(b: "\q") }`,
+			`{ a(b: 01x) }`,
+			`{ a(b: 1.) }`,
+			`{ a(b: "oops\`,
+			`fragment on on A { a }`,
+			`type A { a: Int }`,
+			`{ a } }`,
+			`{ a.b }`,
+		} {
+			_, err := graphql.Parse(doc)
+			require.Error(t, err, doc)
+		}
+	})
+
+	t.Run("values", func(t *testing.T) {
+		doc, err := graphql.Parse(`{ a(s: "A\n\"", b: """  x \""" y  """, i: 42, big: 99999999999999999999, f: 1.5, l: [$v]) }`)
+		require.NoError(t, err)
+		field := doc.Operations[0].SelectionSet[0].(*graphql.Field)
+		require.Equal(t, []graphql.Argument{
+			{Name: "s", Value: "A\n\""},
+			{Name: "b", Value: `  x """ y  `},
+			{Name: "i", Value: int64(42)},
+			{Name: "big", Value: "99999999999999999999"},
+			{Name: "f", Value: 1.5},
+			{Name: "l", Value: []interface{}{graphql.Variable("v")}},
+		}, field.Arguments)
+	})
+
+	t.Run("maximum nesting", func(t *testing.T) {
+		deep := strings.Repeat("{ a ", 200) + strings.Repeat("}", 200)
+		_, err := graphql.Parse(deep)
+		require.Equal(t, graphql.ErrMaxNesting, err)
+
+		deep = "{ a(b: " + strings.Repeat("[", 200) + strings.Repeat("]", 200) + ") }"
+		_, err = graphql.Parse(deep)
+		require.Equal(t, graphql.ErrMaxNesting, err)
+	})
+}
+
+func TestInspect(t *testing.T) {
+	t.Run("variables and arguments", func(t *testing.T) {
+		result, err := graphql.Inspect(&graphql.Request{
+			Query: `
+				query GetUser($id: ID!) {
+					user(id: $id, filter: {name: "<script>", tags: ["a", "b"]}) {
+						first: posts(first: 10) @include(if: true) { title }
+						last: posts(last: 10) { ...PostFields }
+					}
+				}
+				fragment PostFields on Post { comments(q: "' OR 1=1") { text } }`,
+			Variables: map[string]interface{}{
+				"id": "1",
+				"input": map[string]interface{}{
+					"names": []interface{}{"a", "<b>"},
+				},
+			},
+		})
+		require.NoError(t, err)
+		require.Equal(t, &graphql.Result{
+			OperationType: "query",
+			OperationName: "GetUser",
+			Variables: map[string]interface{}{
+				"id":            "1",
+				"input.names.0": "a",
+				"input.names.1": "<b>",
+			},
+			Arguments: map[string][]interface{}{
+				"user.filter.name":         {"<script>"},
+				"user.filter.tags.0":       {"a"},
+				"user.filter.tags.1":       {"b"},
+				"user.posts.first":         {int64(10)},
+				"user.posts.@include.if":   {true},
+				"user.posts.last":          {int64(10)},
+				"...PostFields.comments.q": {"' OR 1=1"},
+			},
+			Depth:   4,
+			Aliases: 2,
+		}, result)
+	})
+
+	t.Run("operation selection", func(t *testing.T) {
+		doc := `query A { a } mutation B { b(x: 1) }`
+		result, err := graphql.Inspect(&graphql.Request{Query: doc, OperationName: "B"})
+		require.NoError(t, err)
+		require.Equal(t, "mutation", result.OperationType)
+		require.Equal(t, "B", result.OperationName)
+		require.Equal(t, map[string][]interface{}{"b.x": {int64(1)}}, result.Arguments)
+		require.Equal(t, 1, result.Depth)
+
+		_, err = graphql.Inspect(&graphql.Request{Query: doc})
+		require.Error(t, err)
+		_, err = graphql.Inspect(&graphql.Request{Query: doc, OperationName: "C"})
+		require.Error(t, err)
+
+		result, err = graphql.Inspect(&graphql.Request{Query: `{ a }`})
+		require.NoError(t, err)
+		require.Equal(t, "query", result.OperationType)
+		require.Equal(t, "", result.OperationName)
+		require.Nil(t, result.Variables)
+		require.Nil(t, result.Arguments)
+	})
+
+	t.Run("fragments", func(t *testing.T) {
+		// Fragments spread many times are walked once but counted as many times
+		// as they are spread
+		fragments := func(n int) string {
+			var b strings.Builder
+			b.WriteString("{ ...F0 }")
+			for i := 0; i < n; i++ {
+				fmt.Fprintf(&b, " fragment F%d on T { a: f { ...F%d } b: g { ...F%d } }", i, i+1, i+1)
+			}
+			fmt.Fprintf(&b, " fragment F%d on T { x }", n)
+			return b.String()
+		}
+		result, err := graphql.Inspect(&graphql.Request{Query: fragments(10)})
+		require.NoError(t, err)
+		require.Equal(t, 11, result.Depth)
+		require.Equal(t, 2046, result.Aliases)
+
+		result, err = graphql.Inspect(&graphql.Request{Query: fragments(100)})
+		require.NoError(t, err)
+		require.Equal(t, 101, result.Depth)
+		require.Equal(t, math.MaxInt32, result.Aliases)
+
+		// Cycles and unknown fragments are ignored
+		result, err = graphql.Inspect(&graphql.Request{Query: `{ a { ...A ...B } } fragment A on T { b { ...A } }`})
+		require.NoError(t, err)
+		require.Equal(t, 2, result.Depth)
+	})
+}
+
+func TestReadHTTPRequest(t *testing.T) {
+	for _, tc := range []struct {
+		name        string
+		method      string
+		contentType string
+		query       url.Values
+		body        string
+		expected    *graphql.Request
+		expectedErr bool
+	}{
+		{
+			name:     "get",
+			method:   "GET",
+			query:    url.Values{"query": {"{ a }"}, "operationName": {"Op"}, "variables": {`{"v":1}`}},
+			expected: &graphql.Request{Query: "{ a }", OperationName: "Op", Variables: map[string]interface{}{"v": 1.0}},
+		},
+		{
+			name:        "get with invalid variables",
+			method:      "GET",
+			query:       url.Values{"query": {"{ a }"}, "variables": {`{`}},
+			expectedErr: true,
+		},
+		{
+			name:   "get without query",
+			method: "GET",
+			query:  url.Values{"q": {"{ a }"}},
+		},
+		{
+			name:        "post json",
+			method:      "POST",
+			contentType: "application/json; charset=utf-8",
+			body:        `{"query":"{ a }","variables":{"v":"x"}}`,
+			expected:    &graphql.Request{Query: "{ a }", Variables: map[string]interface{}{"v": "x"}},
+		},
+		{
+			name:        "post json without query",
+			method:      "POST",
+			contentType: "application/json",
+			body:        `{"a":"b"}`,
+		},
+		{
+			name:        "post invalid json",
+			method:      "POST",
+			contentType: "application/json",
+			body:        `{"query":`,
+			expectedErr: true,
+		},
+		{
+			name:        "post graphql",
+			method:      "POST",
+			contentType: "application/graphql",
+			body:        `{ a }`,
+			expected:    &graphql.Request{Query: "{ a }"},
+		},
+		{
+			name:        "post other",
+			method:      "POST",
+			contentType: "text/plain",
+			body:        `{ a }`,
+		},
+		{
+			name:   "put",
+			method: "PUT",
+			query:  url.Values{"query": {"{ a }"}},
+		},
+	} {
+		tc := tc
+		t.Run(tc.name, func(t *testing.T) {
+			r, err := graphql.ReadHTTPRequest(tc.method, tc.contentType, tc.query, []byte(tc.body))
+			if tc.expectedErr {
+				require.Error(t, err)
+				return
+			}
+			require.NoError(t, err)
+			require.Equal(t, tc.expected, r)
+		})
+	}
+}
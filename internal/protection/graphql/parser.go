@@ -0,0 +1,741 @@
+// Copyright (c) 2016 - 2020 Sqreen. All Rights Reserved.
+// Please refer to our terms for more information:
+// https://www.sqreen.io/terms.html
+
+//sqreen:ignore
+
+package graphql
+
+import (
+	"strconv"
+	"strings"
+
+	"github.com/sqreen/go-agent/internal/sqlib/sqerrors"
+)
+
+// maxNesting is the maximum nesting of the selection sets and values of a
+// document. Deeper documents are rejected with ErrMaxNesting to bound the
+// recursion of the parser.
+const maxNesting = 128
+
+// ErrMaxNesting is the error returned when parsing a document whose nesting is
+// deeper than what the parser accepts.
+var ErrMaxNesting = sqerrors.New("graphql: maximum nesting exceeded")
+
+// Document is a parsed executable GraphQL document.
+type Document struct {
+	Operations []*Operation
+	Fragments  map[string]*Fragment
+}
+
+// Operation is an operation definition.
+type Operation struct {
+	// Type is either `query`, `mutation` or `subscription`.
+	Type         string
+	Name         string
+	SelectionSet []Selection
+}
+
+// Fragment is a fragment definition.
+type Fragment struct {
+	Name         string
+	SelectionSet []Selection
+}
+
+// Selection is either a *Field, a *FragmentSpread or an *InlineFragment.
+type Selection interface{}
+
+// Field is a field selection.
+type Field struct {
+	Alias        string
+	Name         string
+	Arguments    []Argument
+	Directives   []Directive
+	SelectionSet []Selection
+}
+
+// FragmentSpread is a fragment spread selection.
+type FragmentSpread struct {
+	Name       string
+	Directives []Directive
+}
+
+// InlineFragment is an inline fragment selection.
+type InlineFragment struct {
+	Directives   []Directive
+	SelectionSet []Selection
+}
+
+// Directive is a directive applied to a selection.
+type Directive struct {
+	Name      string
+	Arguments []Argument
+}
+
+// Argument is a field or directive argument. Its value is the Go value of the
+// GraphQL literal: string, bool, nil, int64, float64, []interface{},
+// map[string]interface{}, or Variable. Enum values are strings.
+type Argument struct {
+	Name  string
+	Value interface{}
+}
+
+// Variable is a reference to an operation variable.
+type Variable string
+
+// Parse parses the given executable GraphQL document. Type system definitions
+// are not supported.
+func Parse(document string) (*Document, error) {
+	p := parser{lexer: lexer{src: document}}
+	if err := p.next(); err != nil {
+		return nil, err
+	}
+	doc := &Document{
+		Fragments: make(map[string]*Fragment),
+	}
+	for p.tok.kind != tokenEOF {
+		switch {
+		case p.tok.kind == tokenPunctuator && p.tok.value == "{":
+			set, err := p.parseSelectionSet(0)
+			if err != nil {
+				return nil, err
+			}
+			doc.Operations = append(doc.Operations, &Operation{Type: "query", SelectionSet: set})
+		case p.tok.kind == tokenName && p.tok.value == "fragment":
+			fragment, err := p.parseFragment()
+			if err != nil {
+				return nil, err
+			}
+			doc.Fragments[fragment.Name] = fragment
+		case p.tok.kind == tokenName:
+			op, err := p.parseOperation()
+			if err != nil {
+				return nil, err
+			}
+			doc.Operations = append(doc.Operations, op)
+		default:
+			return nil, p.unexpected()
+		}
+	}
+	if len(doc.Operations) == 0 {
+		return nil, sqerrors.New("graphql: no operation")
+	}
+	return doc, nil
+}
+
+type parser struct {
+	lexer lexer
+	tok   token
+}
+
+func (p *parser) next() (err error) {
+	p.tok, err = p.lexer.next()
+	return err
+}
+
+func (p *parser) unexpected() error {
+	if p.tok.kind == tokenEOF {
+		return sqerrors.New("graphql: unexpected end of document")
+	}
+	return sqerrors.Errorf("graphql: unexpected `%s` at offset %d", p.tok.value, p.tok.offset)
+}
+
+// peek returns true when the current token is the given punctuator.
+func (p *parser) peek(punctuator string) bool {
+	return p.tok.kind == tokenPunctuator && p.tok.value == punctuator
+}
+
+func (p *parser) expect(punctuator string) error {
+	if !p.peek(punctuator) {
+		return p.unexpected()
+	}
+	return p.next()
+}
+
+func (p *parser) parseName() (string, error) {
+	if p.tok.kind != tokenName {
+		return "", p.unexpected()
+	}
+	name := p.tok.value
+	return name, p.next()
+}
+
+func (p *parser) parseOperation() (*Operation, error) {
+	op := &Operation{Type: p.tok.value}
+	switch op.Type {
+	case "query", "mutation", "subscription":
+	default:
+		return nil, p.unexpected()
+	}
+	if err := p.next(); err != nil {
+		return nil, err
+	}
+	if p.tok.kind == tokenName {
+		op.Name = p.tok.value
+		if err := p.next(); err != nil {
+			return nil, err
+		}
+	}
+	if p.peek("(") {
+		if err := p.skipVariableDefinitions(); err != nil {
+			return nil, err
+		}
+	}
+	if _, err := p.parseDirectives(0); err != nil {
+		return nil, err
+	}
+	set, err := p.parseSelectionSet(0)
+	if err != nil {
+		return nil, err
+	}
+	op.SelectionSet = set
+	return op, nil
+}
+
+// skipVariableDefinitions skips the variable definitions, including their
+// default values which are not used by the inspection since the variables are
+// given along with the query.
+func (p *parser) skipVariableDefinitions() error {
+	if err := p.expect("("); err != nil {
+		return err
+	}
+	for !p.peek(")") {
+		if err := p.expect("$"); err != nil {
+			return err
+		}
+		if _, err := p.parseName(); err != nil {
+			return err
+		}
+		if err := p.expect(":"); err != nil {
+			return err
+		}
+		if err := p.skipType(0); err != nil {
+			return err
+		}
+		if p.peek("=") {
+			if err := p.next(); err != nil {
+				return err
+			}
+			if _, err := p.parseValue(0); err != nil {
+				return err
+			}
+		}
+		if _, err := p.parseDirectives(0); err != nil {
+			return err
+		}
+	}
+	return p.next()
+}
+
+func (p *parser) skipType(depth int) error {
+	if depth > maxNesting {
+		return ErrMaxNesting
+	}
+	if p.peek("[") {
+		if err := p.next(); err != nil {
+			return err
+		}
+		if err := p.skipType(depth + 1); err != nil {
+			return err
+		}
+		if err := p.expect("]"); err != nil {
+			return err
+		}
+	} else if _, err := p.parseName(); err != nil {
+		return err
+	}
+	if p.peek("!") {
+		return p.next()
+	}
+	return nil
+}
+
+func (p *parser) parseFragment() (*Fragment, error) {
+	// Skip the `fragment` keyword
+	if err := p.next(); err != nil {
+		return nil, err
+	}
+	name, err := p.parseName()
+	if err != nil {
+		return nil, err
+	}
+	if name == "on" {
+		return nil, sqerrors.New("graphql: unexpected fragment name `on`")
+	}
+	if p.tok.kind != tokenName || p.tok.value != "on" {
+		return nil, p.unexpected()
+	}
+	if err := p.next(); err != nil {
+		return nil, err
+	}
+	if _, err := p.parseName(); err != nil {
+		return nil, err
+	}
+	if _, err := p.parseDirectives(0); err != nil {
+		return nil, err
+	}
+	set, err := p.parseSelectionSet(0)
+	if err != nil {
+		return nil, err
+	}
+	return &Fragment{Name: name, SelectionSet: set}, nil
+}
+
+func (p *parser) parseSelectionSet(depth int) ([]Selection, error) {
+	if depth > maxNesting {
+		return nil, ErrMaxNesting
+	}
+	if err := p.expect("{"); err != nil {
+		return nil, err
+	}
+	var set []Selection
+	for !p.peek("}") {
+		selection, err := p.parseSelection(depth)
+		if err != nil {
+			return nil, err
+		}
+		set = append(set, selection)
+	}
+	if len(set) == 0 {
+		return nil, sqerrors.New("graphql: empty selection set")
+	}
+	return set, p.next()
+}
+
+func (p *parser) parseSelection(depth int) (Selection, error) {
+	if p.peek("...") {
+		return p.parseFragmentSelection(depth)
+	}
+
+	field := &Field{}
+	name, err := p.parseName()
+	if err != nil {
+		return nil, err
+	}
+	if p.peek(":") {
+		if err := p.next(); err != nil {
+			return nil, err
+		}
+		field.Alias = name
+		if name, err = p.parseName(); err != nil {
+			return nil, err
+		}
+	}
+	field.Name = name
+	if p.peek("(") {
+		if field.Arguments, err = p.parseArguments(depth); err != nil {
+			return nil, err
+		}
+	}
+	if field.Directives, err = p.parseDirectives(depth); err != nil {
+		return nil, err
+	}
+	if p.peek("{") {
+		if field.SelectionSet, err = p.parseSelectionSet(depth + 1); err != nil {
+			return nil, err
+		}
+	}
+	return field, nil
+}
+
+func (p *parser) parseFragmentSelection(depth int) (Selection, error) {
+	// Skip the `...` punctuator
+	if err := p.next(); err != nil {
+		return nil, err
+	}
+	if p.tok.kind == tokenName && p.tok.value != "on" {
+		spread := &FragmentSpread{Name: p.tok.value}
+		if err := p.next(); err != nil {
+			return nil, err
+		}
+		directives, err := p.parseDirectives(depth)
+		if err != nil {
+			return nil, err
+		}
+		spread.Directives = directives
+		return spread, nil
+	}
+
+	fragment := &InlineFragment{}
+	if p.tok.kind == tokenName {
+		// Skip the type condition
+		if err := p.next(); err != nil {
+			return nil, err
+		}
+		if _, err := p.parseName(); err != nil {
+			return nil, err
+		}
+	}
+	directives, err := p.parseDirectives(depth)
+	if err != nil {
+		return nil, err
+	}
+	fragment.Directives = directives
+	if fragment.SelectionSet, err = p.parseSelectionSet(depth + 1); err != nil {
+		return nil, err
+	}
+	return fragment, nil
+}
+
+func (p *parser) parseDirectives(depth int) ([]Directive, error) {
+	var directives []Directive
+	for p.peek("@") {
+		if err := p.next(); err != nil {
+			return nil, err
+		}
+		name, err := p.parseName()
+		if err != nil {
+			return nil, err
+		}
+		directive := Directive{Name: name}
+		if p.peek("(") {
+			if directive.Arguments, err = p.parseArguments(depth); err != nil {
+				return nil, err
+			}
+		}
+		directives = append(directives, directive)
+	}
+	return directives, nil
+}
+
+func (p *parser) parseArguments(depth int) ([]Argument, error) {
+	if err := p.expect("("); err != nil {
+		return nil, err
+	}
+	var args []Argument
+	for !p.peek(")") {
+		name, err := p.parseName()
+		if err != nil {
+			return nil, err
+		}
+		if err := p.expect(":"); err != nil {
+			return nil, err
+		}
+		value, err := p.parseValue(depth)
+		if err != nil {
+			return nil, err
+		}
+		args = append(args, Argument{Name: name, Value: value})
+	}
+	if len(args) == 0 {
+		return nil, sqerrors.New("graphql: empty arguments")
+	}
+	return args, p.next()
+}
+
+func (p *parser) parseValue(depth int) (interface{}, error) {
+	if depth > maxNesting {
+		return nil, ErrMaxNesting
+	}
+	tok := p.tok
+	switch tok.kind {
+	case tokenString:
+		return tok.value, p.next()
+	case tokenInt:
+		v, err := strconv.ParseInt(tok.value, 10, 64)
+		if err != nil {
+			// Out of the int64 range but still a valid GraphQL integer
+			return tok.value, p.next()
+		}
+		return v, p.next()
+	case tokenFloat:
+		v, err := strconv.ParseFloat(tok.value, 64)
+		if err != nil {
+			return tok.value, p.next()
+		}
+		return v, p.next()
+	case tokenName:
+		var v interface{}
+		switch tok.value {
+		case "true":
+			v = true
+		case "false":
+			v = false
+		case "null":
+			v = nil
+		default:
+			// Enum value
+			v = tok.value
+		}
+		return v, p.next()
+	case tokenPunctuator:
+		switch tok.value {
+		case "$":
+			if err := p.next(); err != nil {
+				return nil, err
+			}
+			name, err := p.parseName()
+			if err != nil {
+				return nil, err
+			}
+			return Variable(name), nil
+		case "[":
+			if err := p.next(); err != nil {
+				return nil, err
+			}
+			list := []interface{}{}
+			for !p.peek("]") {
+				v, err := p.parseValue(depth + 1)
+				if err != nil {
+					return nil, err
+				}
+				list = append(list, v)
+			}
+			return list, p.next()
+		case "{":
+			if err := p.next(); err != nil {
+				return nil, err
+			}
+			object := map[string]interface{}{}
+			for !p.peek("}") {
+				name, err := p.parseName()
+				if err != nil {
+					return nil, err
+				}
+				if err := p.expect(":"); err != nil {
+					return nil, err
+				}
+				v, err := p.parseValue(depth + 1)
+				if err != nil {
+					return nil, err
+				}
+				object[name] = v
+			}
+			return object, p.next()
+		}
+	}
+	return nil, p.unexpected()
+}
+
+type tokenKind int
+
+const (
+	tokenEOF tokenKind = iota
+	tokenPunctuator
+	tokenName
+	tokenInt
+	tokenFloat
+	tokenString
+)
+
+type token struct {
+	kind tokenKind
+	// value is the token value. String values are unescaped.
+	value  string
+	offset int
+}
+
+// lexer splits a GraphQL document into tokens, ignoring white spaces, line
+// terminators, commas and comments.
+type lexer struct {
+	src string
+	pos int
+}
+
+func (l *lexer) next() (token, error) {
+	l.skipIgnored()
+	if l.pos >= len(l.src) {
+		return token{kind: tokenEOF, offset: l.pos}, nil
+	}
+	start := l.pos
+	c := l.src[l.pos]
+	switch {
+	case strings.IndexByte("!$&():=@[]{|}", c) != -1:
+		l.pos++
+		return token{kind: tokenPunctuator, value: l.src[start:l.pos], offset: start}, nil
+	case c == '.':
+		if !strings.HasPrefix(l.src[l.pos:], "...") {
+			return token{}, sqerrors.Errorf("graphql: unexpected `.` at offset %d", start)
+		}
+		l.pos += 3
+		return token{kind: tokenPunctuator, value: "...", offset: start}, nil
+	case isNameStart(c):
+		for l.pos < len(l.src) && isNameContinue(l.src[l.pos]) {
+			l.pos++
+		}
+		return token{kind: tokenName, value: l.src[start:l.pos], offset: start}, nil
+	case c == '-' || isDigit(c):
+		return l.lexNumber()
+	case c == '"':
+		if strings.HasPrefix(l.src[l.pos:], `"""`) {
+			return l.lexBlockString()
+		}
+		return l.lexString()
+	default:
+		return token{}, sqerrors.Errorf("graphql: unexpected character `%c` at offset %d", c, start)
+	}
+}
+
+func (l *lexer) skipIgnored() {
+	for l.pos < len(l.src) {
+		switch c := l.src[l.pos]; c {
+		case ' ', '\t', '\n', '\r', ',':
+			l.pos++
+		case '#':
+			for l.pos < len(l.src) && l.src[l.pos] != '\n' && l.src[l.pos] != '\r' {
+				l.pos++
+			}
+		case 0xEF:
+			// Unicode BOM
+			if !strings.HasPrefix(l.src[l.pos:], "\uFEFF") {
+				return
+			}
+			l.pos += len("\uFEFF")
+		default:
+			return
+		}
+	}
+}
+
+func (l *lexer) lexNumber() (token, error) {
+	start := l.pos
+	kind := tokenInt
+	if l.src[l.pos] == '-' {
+		l.pos++
+	}
+	if !l.skipDigits() {
+		return token{}, sqerrors.Errorf("graphql: invalid number at offset %d", start)
+	}
+	if l.pos < len(l.src) && l.src[l.pos] == '.' {
+		kind = tokenFloat
+		l.pos++
+		if !l.skipDigits() {
+			return token{}, sqerrors.Errorf("graphql: invalid number at offset %d", start)
+		}
+	}
+	if l.pos < len(l.src) && (l.src[l.pos] == 'e' || l.src[l.pos] == 'E') {
+		kind = tokenFloat
+		l.pos++
+		if l.pos < len(l.src) && (l.src[l.pos] == '+' || l.src[l.pos] == '-') {
+			l.pos++
+		}
+		if !l.skipDigits() {
+			return token{}, sqerrors.Errorf("graphql: invalid number at offset %d", start)
+		}
+	}
+	if l.pos < len(l.src) && (isNameStart(l.src[l.pos]) || l.src[l.pos] == '.') {
+		return token{}, sqerrors.Errorf("graphql: invalid number at offset %d", start)
+	}
+	return token{kind: kind, value: l.src[start:l.pos], offset: start}, nil
+}
+
+func (l *lexer) skipDigits() bool {
+	start := l.pos
+	for l.pos < len(l.src) && isDigit(l.src[l.pos]) {
+		l.pos++
+	}
+	return l.pos > start
+}
+
+func (l *lexer) lexString() (token, error) {
+	start := l.pos
+	l.pos++
+	var b strings.Builder
+	for l.pos < len(l.src) {
+		c := l.src[l.pos]
+		switch {
+		case c == '"':
+			l.pos++
+			return token{kind: tokenString, value: b.String(), offset: start}, nil
+		case c == '\n' || c == '\r':
+			return token{}, sqerrors.Errorf("graphql: unterminated string at offset %d", start)
+		case c == '\\':
+			if l.pos+1 >= len(l.src) {
+				return token{}, sqerrors.Errorf("graphql: unterminated string at offset %d", start)
+			}
+			l.pos++
+			switch e := l.src[l.pos]; e {
+			case '"', '\\', '/':
+				b.WriteByte(e)
+			case 'b':
+				b.WriteByte('\b')
+			case 'f':
+				b.WriteByte('\f')
+			case 'n':
+				b.WriteByte('\n')
+			case 'r':
+				b.WriteByte('\r')
+			case 't':
+				b.WriteByte('\t')
+			case 'u':
+				if l.pos+5 > len(l.src) {
+					return token{}, sqerrors.Errorf("graphql: invalid unicode escape at offset %d", l.pos)
+				}
+				r, err := strconv.ParseUint(l.src[l.pos+1:l.pos+5], 16, 16)
+				if err != nil {
+					return token{}, sqerrors.Errorf("graphql: invalid unicode escape at offset %d", l.pos)
+				}
+				b.WriteRune(rune(r))
+				l.pos += 4
+			default:
+				return token{}, sqerrors.Errorf("graphql: invalid escape sequence at offset %d", l.pos)
+			}
+			l.pos++
+		default:
+			b.WriteByte(c)
+			l.pos++
+		}
+	}
+	return token{}, sqerrors.Errorf("graphql: unterminated string at offset %d", start)
+}
+
+func (l *lexer) lexBlockString() (token, error) {
+	start := l.pos
+	l.pos += 3
+	var b strings.Builder
+	for l.pos < len(l.src) {
+		switch {
+		case strings.HasPrefix(l.src[l.pos:], `"""`):
+			l.pos += 3
+			return token{kind: tokenString, value: blockStringValue(b.String()), offset: start}, nil
+		case strings.HasPrefix(l.src[l.pos:], `\"""`):
+			b.WriteString(`"""`)
+			l.pos += 4
+		default:
+			b.WriteByte(l.src[l.pos])
+			l.pos++
+		}
+	}
+	return token{}, sqerrors.Errorf("graphql: unterminated block string at offset %d", start)
+}
+
+// blockStringValue returns the value of the given raw block string by removing
+// its common indentation and its leading and trailing blank lines.
+func blockStringValue(raw string) string {
+	lines := strings.Split(strings.NewReplacer("\r\n", "\n", "\r", "\n").Replace(raw), "\n")
+	commonIndent := -1
+	for _, line := range lines[1:] {
+		indent := len(line) - len(strings.TrimLeft(line, " \t"))
+		if indent < len(line) && (commonIndent == -1 || indent < commonIndent) {
+			commonIndent = indent
+		}
+	}
+	if commonIndent > 0 {
+		for i := 1; i < len(lines); i++ {
+			if len(lines[i]) >= commonIndent {
+				lines[i] = lines[i][commonIndent:]
+			} else {
+				lines[i] = ""
+			}
+		}
+	}
+	for len(lines) > 0 && strings.TrimLeft(lines[0], " \t") == "" {
+		lines = lines[1:]
+	}
+	for len(lines) > 0 && strings.TrimLeft(lines[len(lines)-1], " \t") == "" {
+		lines = lines[:len(lines)-1]
+	}
+	return strings.Join(lines, "\n")
+}
+
+func isNameStart(c byte) bool {
+	return c == '_' || (c >= 'a' && c <= 'z') || (c >= 'A' && c <= 'Z')
+}
+
+func isNameContinue(c byte) bool {
+	return isNameStart(c) || isDigit(c)
+}
+
+func isDigit(c byte) bool {
+	return c >= '0' && c <= '9'
+}
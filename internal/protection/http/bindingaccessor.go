@@ -7,6 +7,8 @@
 package http
 
 import (
+	"net/http"
+
 	"github.com/sqreen/go-agent/internal/protection/http/types"
 )
 
@@ -88,7 +90,69 @@ func (r *RequestBindingAccessorContext) Body() RequestBodyBindingAccessorContext
 	return r.RequestReader.Body()
 }
 
+// BodyTruncated returns true when the request body is larger than the body
+// returned by Body(), which is limited by the maximum body capture size.
+func (r *RequestBindingAccessorContext) BodyTruncated() bool {
+	t, ok := r.RequestReader.(interface{ BodyTruncated() bool })
+	return ok && t.BodyTruncated()
+}
+
+// Route returns the route template of the request, such as `/users/:id`, the
+// empty string when it is unknown.
+func (r *RequestBindingAccessorContext) Route() string {
+	if rr, ok := r.RequestReader.(types.RouteReader); ok {
+		return rr.Route()
+	}
+	return ""
+}
+
 type RequestBodyBindingAccessorContext []byte
 
+type ResponseBodyBindingAccessorContext = RequestBodyBindingAccessorContext
+
 func (b RequestBodyBindingAccessorContext) String() string { return string(b) }
 func (b RequestBodyBindingAccessorContext) Bytes() []byte  { return b }
+
+// ResponseBindingAccessorContext is the wrapper type of the response being
+// inspected by the response WAF, providing the binding accessor interface
+// expected by rules. Its methods return nil values when the response is not
+// inspected so that binding accessors on the response are simply ignored.
+type ResponseBindingAccessorContext struct {
+	r types.ResponseReader
+}
+
+func NewResponseBindingAccessorContext(r types.ResponseReader) *ResponseBindingAccessorContext {
+	return &ResponseBindingAccessorContext{r: r}
+}
+
+func (r *ResponseBindingAccessorContext) Status() interface{} {
+	if r.r == nil {
+		return nil
+	}
+	return r.r.Status()
+}
+
+func (r *ResponseBindingAccessorContext) Headers() http.Header {
+	if r.r == nil {
+		return nil
+	}
+	return r.r.Headers()
+}
+
+func (r *ResponseBindingAccessorContext) Header(h string) (*string, error) {
+	if r.r == nil {
+		return nil, nil
+	}
+	return r.r.Header(h), nil
+}
+
+func (r *ResponseBindingAccessorContext) Body() ResponseBodyBindingAccessorContext {
+	if r.r == nil {
+		return nil
+	}
+	return r.r.Body()
+}
+
+func (r *ResponseBindingAccessorContext) BodyTruncated() bool {
+	return r.r != nil && r.r.BodyTruncated()
+}
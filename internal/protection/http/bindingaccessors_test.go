@@ -19,6 +19,7 @@ import (
 	"github.com/sqreen/go-agent/internal/binding-accessor"
 	http_protection "github.com/sqreen/go-agent/internal/protection/http"
 	"github.com/sqreen/go-agent/internal/protection/http/types"
+	"github.com/sqreen/go-agent/internal/rule/callback"
 	"github.com/sqreen/go-agent/tools/testlib"
 	"github.com/stretchr/testify/require"
 )
@@ -253,6 +254,47 @@ func TestRequestBindingAccessors(t *testing.T) {
 	}
 }
 
+func BenchmarkWAFBindingAccessors(b *testing.B) {
+	req := httptest.NewRequest(http.MethodPost, "/foo/bar?a=1&b=2&c=3", strings.NewReader("d=4&e=5"))
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	req.Header.Set("User-Agent", "Sqreen")
+	req.Header.Set("Referer", "https://sqreen.com")
+	require.NoError(b, req.ParseForm())
+
+	rr := requestReaderImpl{
+		r:        req,
+		clientIP: net.IPv4(64, 81, 32, 89),
+	}
+	ctx := callback.WAFBindingAccessorContextType{
+		HTTPRequestBindingAccessorContext: callback.MakeHTTPRequestBindingAccessorContext(rr),
+	}
+
+	for _, expr := range []string{
+		`#.Request.FilteredParams | flat_values`,
+		`#.Request.FilteredParams | flat_keys`,
+		`#.Request.Method`,
+		`#.Request.UserAgent`,
+		`#.Request.Referer`,
+		`#.Request.ClientIP`,
+		`#.Request.Headers | flat_values`,
+		`#.Request.Header['Dont exist']`,
+		`#.Request.URL.RequestURI`,
+		`#.Request.Body.String`,
+	} {
+		ba, err := bindingaccessor.Compile(expr)
+		require.NoError(b, err)
+		b.Run(expr, func(b *testing.B) {
+			b.ReportAllocs()
+			b.ResetTimer()
+			for n := 0; n < b.N; n++ {
+				if _, err := ba(ctx); err != nil {
+					b.Fatal(err)
+				}
+			}
+		})
+	}
+}
+
 type FlattenedResult []interface{}
 
 type requestReaderImpl struct {
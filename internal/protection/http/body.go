@@ -0,0 +1,335 @@
+// Copyright (c) 2016 - 2020 Sqreen. All Rights Reserved.
+// Please refer to our terms for more information:
+// https://www.sqreen.io/terms.html
+
+//sqreen:ignore
+
+package http
+
+import (
+	"bytes"
+	"mime"
+	"strings"
+	"sync"
+)
+
+// bodyBufferPool is the pool of request body capture buffers.
+var bodyBufferPool = sync.Pool{
+	New: func() interface{} { return new(bytes.Buffer) },
+}
+
+// maxPooledBodyBufferSize is the maximum capacity of the buffers put back into
+// the pool so that large buffers are not kept alive.
+const maxPooledBodyBufferSize = 64 * 1024
+
+// maxMultipartHeaderSize is the maximum size of the headers of a multipart
+// part. The multipart body is no longer filtered beyond it.
+const maxMultipartHeaderSize = 8 * 1024
+
+// bodyCapture captures the request body read by the handler up to a maximum
+// size, beyond which the capture is truncated. The body is not captured when
+// its content type is binary, and the content of the file parts of multipart
+// bodies is skipped.
+type bodyCapture struct {
+	buf *bytes.Buffer
+	// max is the maximum capture size.
+	max int
+	// truncated is true when bytes were not captured because of the maximum
+	// size.
+	truncated bool
+	// skip is true when the body must not be captured.
+	skip bool
+	// multipart is the filter of multipart bodies, nil otherwise.
+	multipart *multipartBodyFilter
+}
+
+// init initializes the body capture according to the request content type.
+func (c *bodyCapture) init(contentType string, max int) {
+	c.max = max
+	mediaType, params, err := mime.ParseMediaType(contentType)
+	if err != nil {
+		return
+	}
+	if isBinaryMediaType(mediaType) {
+		c.skip = true
+		return
+	}
+	if boundary := params["boundary"]; strings.HasPrefix(mediaType, "multipart/") && boundary != "" {
+		c.multipart = newMultipartBodyFilter(boundary)
+	}
+}
+
+// Write captures the given body chunk.
+func (c *bodyCapture) Write(p []byte) {
+	switch {
+	case c.skip:
+		return
+	case c.multipart != nil:
+		c.multipart.write(c, p)
+	default:
+		c.write(p)
+	}
+}
+
+func (c *bodyCapture) write(p []byte) {
+	if len(p) == 0 {
+		return
+	}
+	var l int
+	if c.buf != nil {
+		l = c.buf.Len()
+	}
+	n := c.max - l
+	if n <= 0 {
+		c.truncated = true
+		return
+	}
+	if len(p) > n {
+		p = p[:n]
+		c.truncated = true
+	}
+	if c.buf == nil {
+		c.buf = bodyBufferPool.Get().(*bytes.Buffer)
+	}
+	c.buf.Write(p)
+}
+
+// Bytes returns the captured body. It is only valid until release() is called.
+func (c *bodyCapture) Bytes() []byte {
+	if c.buf == nil {
+		return nil
+	}
+	return c.buf.Bytes()
+}
+
+// Truncated returns true when the captured body is truncated.
+func (c *bodyCapture) Truncated() bool { return c.truncated }
+
+// release puts the capture buffer back into the pool.
+func (c *bodyCapture) release() {
+	buf := c.buf
+	if buf == nil {
+		return
+	}
+	c.buf = nil
+	if buf.Cap() > maxPooledBodyBufferSize {
+		return
+	}
+	buf.Reset()
+	bodyBufferPool.Put(buf)
+}
+
+// isBinaryMediaType returns true for the media types of binary contents that
+// are not worth capturing.
+func isBinaryMediaType(mediaType string) bool {
+	switch mediaType {
+	case "application/octet-stream", "application/zip", "application/gzip", "application/pdf":
+		return true
+	}
+	for _, prefix := range []string{"image/", "audio/", "video/", "font/"} {
+		if strings.HasPrefix(mediaType, prefix) {
+			return true
+		}
+	}
+	return false
+}
+
+type multipartBodyFilterState int
+
+const (
+	// The part content, including the preamble before the first part.
+	multipartContent multipartBodyFilterState = iota
+	// The part headers following a delimiter.
+	multipartHeaders
+	// The epilogue after the last part, or the rest of the body when it can no
+	// longer be filtered.
+	multipartEpilogue
+)
+
+// multipartBodyFilter filters a multipart body being written in chunks in
+// order to skip the content of its file parts, whose headers are kept. The
+// parts are identified by looking for their delimiters, which can be split
+// across chunks.
+type multipartBodyFilter struct {
+	state multipartBodyFilterState
+	// delimiter is the part delimiter `\r\n--boundary`.
+	delimiter []byte
+	// pending is the end of the previous chunk which is the beginning of a
+	// delimiter.
+	pending []byte
+	// virtualCRLF is true until the first bytes of the body are written. The
+	// body starts with the first delimiter without its leading CRLF, which is
+	// therefore virtually added to the pending bytes.
+	virtualCRLF bool
+	// header is the headers of the current part being written.
+	header []byte
+	// skip is true when the content of the current part must be skipped.
+	skip bool
+}
+
+func newMultipartBodyFilter(boundary string) *multipartBodyFilter {
+	delimiter := []byte("\r\n--" + boundary)
+	f := &multipartBodyFilter{
+		delimiter:   delimiter,
+		pending:     make([]byte, 0, len(delimiter)),
+		virtualCRLF: true,
+	}
+	f.pending = append(f.pending, "\r\n"...)
+	return f
+}
+
+func (f *multipartBodyFilter) write(c *bodyCapture, p []byte) {
+	for len(p) > 0 {
+		switch f.state {
+		case multipartContent:
+			p = f.writeContent(c, p)
+		case multipartHeaders:
+			p = f.writeHeaders(c, p)
+		default:
+			c.write(p)
+			return
+		}
+	}
+}
+
+// flushPending writes the pending bytes either as part content or as the
+// beginning of a delimiter, without the virtual CRLF.
+func (f *multipartBodyFilter) flushPending(c *bodyCapture, content bool) {
+	pending := f.pending
+	if f.virtualCRLF {
+		f.virtualCRLF = false
+		pending = pending[2:]
+	}
+	if !content || !f.skip {
+		c.write(pending)
+	}
+	f.pending = f.pending[:0]
+}
+
+// writeContent writes the part content until the next delimiter and returns
+// the bytes following it.
+func (f *multipartBodyFilter) writeContent(c *bodyCapture, p []byte) []byte {
+	if l := len(f.pending); l > 0 {
+		// Check if the pending bytes are the beginning of a delimiter
+		rest := f.delimiter[l:]
+		n := len(rest)
+		if len(p) < n {
+			n = len(p)
+		}
+		if bytes.Equal(p[:n], rest[:n]) {
+			if n < len(rest) {
+				f.pending = append(f.pending, p...)
+				return nil
+			}
+			f.flushPending(c, false)
+			c.write(rest)
+			f.startHeaders()
+			return p[n:]
+		}
+		f.flushPending(c, true)
+	}
+	f.virtualCRLF = false
+
+	if i := bytes.Index(p, f.delimiter); i != -1 {
+		f.writePartContent(c, p[:i])
+		c.write(f.delimiter)
+		f.startHeaders()
+		return p[i+len(f.delimiter):]
+	}
+
+	// Keep the end of the chunk when it is the beginning of a delimiter.
+	start := len(p) - len(f.delimiter) + 1
+	if start < 0 {
+		start = 0
+	}
+	for i := start; i < len(p); i++ {
+		if p[i] == '\r' && bytes.HasPrefix(f.delimiter, p[i:]) {
+			f.writePartContent(c, p[:i])
+			f.pending = append(f.pending, p[i:]...)
+			return nil
+		}
+	}
+	f.writePartContent(c, p)
+	return nil
+}
+
+func (f *multipartBodyFilter) writePartContent(c *bodyCapture, p []byte) {
+	if !f.skip {
+		c.write(p)
+	}
+}
+
+func (f *multipartBodyFilter) startHeaders() {
+	f.state = multipartHeaders
+	f.header = f.header[:0]
+	f.skip = false
+}
+
+// writeHeaders accumulates the part headers until their end and returns the
+// bytes following them. The headers are written once complete so that the
+// part content can be skipped when it is a file.
+func (f *multipartBodyFilter) writeHeaders(c *bodyCapture, p []byte) []byte {
+	prev := len(f.header)
+	n := maxMultipartHeaderSize - prev
+	if n > len(p) {
+		n = len(p)
+	}
+	f.header = append(f.header, p[:n]...)
+
+	// The close delimiter is followed by `--` and then the epilogue.
+	if len(f.header) >= 2 && f.header[0] == '-' && f.header[1] == '-' {
+		f.state = multipartEpilogue
+		c.write(f.header)
+		return p[n:]
+	}
+
+	// The headers start with the CRLF ending the delimiter line and end with an
+	// empty line.
+	from := prev - 3
+	if from < 0 {
+		from = 0
+	}
+	if i := bytes.Index(f.header[from:], []byte("\r\n\r\n")); i != -1 {
+		end := from + i + 4
+		c.write(f.header[:end])
+		f.skip = isMultipartFilePart(f.header[:end])
+		f.state = multipartContent
+		return p[end-prev:]
+	}
+
+	if len(f.header) >= maxMultipartHeaderSize {
+		// Too large to be part headers: stop filtering.
+		f.state = multipartEpilogue
+		c.write(f.header)
+		return p[n:]
+	}
+	return nil
+}
+
+// isMultipartFilePart returns true when the given part headers are the ones of
+// a file, or of a binary content.
+func isMultipartFilePart(header []byte) bool {
+	for _, line := range strings.Split(string(header), "\r\n") {
+		i := strings.IndexByte(line, ':')
+		if i == -1 {
+			continue
+		}
+		key, value := strings.TrimSpace(line[:i]), strings.TrimSpace(line[i+1:])
+		switch {
+		case strings.EqualFold(key, "Content-Disposition"):
+			_, params, err := mime.ParseMediaType(value)
+			if err != nil {
+				continue
+			}
+			if _, exists := params["filename"]; exists {
+				return true
+			}
+		case strings.EqualFold(key, "Content-Type"):
+			mediaType, _, err := mime.ParseMediaType(value)
+			if err == nil && isBinaryMediaType(mediaType) {
+				return true
+			}
+		}
+	}
+	return false
+}
@@ -0,0 +1,182 @@
+// Copyright (c) 2016 - 2020 Sqreen. All Rights Reserved.
+// Please refer to our terms for more information:
+// https://www.sqreen.io/terms.html
+
+package http
+
+import (
+	"bytes"
+	"io/ioutil"
+	"mime/multipart"
+	"net"
+	"net/http"
+	"net/textproto"
+	"strings"
+	"testing"
+
+	http_protection_mockups "github.com/sqreen/go-agent/internal/protection/http/_testlib/mockups"
+	middleware_mockups "github.com/sqreen/go-agent/sdk/middleware/_testlib/mockups"
+	"github.com/stretchr/testify/require"
+)
+
+// captureBody captures the given body written in chunks of the given size.
+func captureBody(contentType string, max int, body []byte, chunkSize int) *bodyCapture {
+	var c bodyCapture
+	c.init(contentType, max)
+	for len(body) > 0 {
+		n := chunkSize
+		if n > len(body) {
+			n = len(body)
+		}
+		c.Write(body[:n])
+		body = body[n:]
+	}
+	return &c
+}
+
+func TestBodyCapture(t *testing.T) {
+	body := []byte(strings.Repeat("0123456789", 10))
+
+	t.Run("maximum size", func(t *testing.T) {
+		for _, tc := range []struct {
+			max       int
+			expected  []byte
+			truncated bool
+		}{
+			{max: 1000, expected: body},
+			{max: len(body), expected: body},
+			{max: 42, expected: body[:42], truncated: true},
+			{max: 0, truncated: true},
+		} {
+			for _, chunkSize := range []int{1, 7, len(body)} {
+				c := captureBody("application/x-www-form-urlencoded", tc.max, body, chunkSize)
+				require.Equal(t, tc.expected, c.Bytes())
+				require.Equal(t, tc.truncated, c.Truncated())
+				c.release()
+				require.Nil(t, c.Bytes())
+			}
+		}
+	})
+
+	t.Run("binary content types", func(t *testing.T) {
+		for _, contentType := range []string{
+			"application/octet-stream",
+			"image/png",
+			"Video/MP4",
+		} {
+			c := captureBody(contentType, 1000, body, 10)
+			require.Nil(t, c.Bytes(), contentType)
+			require.False(t, c.Truncated(), contentType)
+		}
+
+		for _, contentType := range []string{
+			"",
+			"oops",
+			"application/json; charset=utf-8",
+			"text/plain",
+		} {
+			c := captureBody(contentType, 1000, body, 10)
+			require.Equal(t, body, c.Bytes(), contentType)
+		}
+	})
+
+	t.Run("multipart", func(t *testing.T) {
+		// newMultipartBody returns a multipart body whose file contents are empty
+		// when withFiles is false.
+		newMultipartBody := func(t *testing.T, preamble string, withFiles bool) (contentType string, body []byte) {
+			var buf bytes.Buffer
+			buf.WriteString(preamble)
+			w := multipart.NewWriter(&buf)
+			require.NoError(t, w.SetBoundary("my-boundary"))
+
+			require.NoError(t, w.WriteField("user", "<script>alert(1)</script>"))
+
+			f, err := w.CreateFormFile("avatar", "avatar.png")
+			require.NoError(t, err)
+			if withFiles {
+				_, err = f.Write(bytes.Repeat([]byte("\x89PNG\r\n--my-boundar"), 1000))
+				require.NoError(t, err)
+			}
+
+			h := make(textproto.MIMEHeader)
+			h.Set("Content-Disposition", `form-data; name="blob"`)
+			h.Set("Content-Type", "application/octet-stream")
+			f, err = w.CreatePart(h)
+			require.NoError(t, err)
+			if withFiles {
+				_, err = f.Write([]byte("binary data\r\n"))
+				require.NoError(t, err)
+			}
+
+			require.NoError(t, w.WriteField("comment", "\r\n--my-boundar\r\n"))
+			require.NoError(t, w.WriteField("empty", ""))
+			require.NoError(t, w.Close())
+			buf.WriteString("epilogue")
+			return w.FormDataContentType(), buf.Bytes()
+		}
+
+		for _, preamble := range []string{"", "preamble\r\n"} {
+			contentType, body := newMultipartBody(t, preamble, true)
+			_, expected := newMultipartBody(t, preamble, false)
+			for chunkSize := 1; chunkSize <= 64; chunkSize++ {
+				c := captureBody(contentType, 1000000, body, chunkSize)
+				require.Equal(t, string(expected), string(c.Bytes()), chunkSize)
+				require.False(t, c.Truncated())
+			}
+			c := captureBody(contentType, 1000000, body, len(body))
+			require.Equal(t, string(expected), string(c.Bytes()))
+
+			// The captured multipart body is also limited
+			c = captureBody(contentType, 100, body, 10)
+			require.Equal(t, string(expected[:100]), string(c.Bytes()))
+			require.True(t, c.Truncated())
+		}
+
+		// Headers too large are no longer filtered
+		var buf bytes.Buffer
+		buf.WriteString("--b\r\nContent-Disposition: form-data; name=\"f\"; filename=\"f\"\r\nX-Large: ")
+		buf.WriteString(strings.Repeat("a", maxMultipartHeaderSize))
+		buf.WriteString("\r\n\r\ncontent\r\n--b--\r\n")
+		c := captureBody("multipart/form-data; boundary=b", 1000000, buf.Bytes(), 100)
+		require.Equal(t, buf.String(), string(c.Bytes()))
+	})
+
+	t.Run("protection context", func(t *testing.T) {
+		for _, tc := range []struct {
+			contentType *string
+			max         int
+			expected    string
+			truncated   bool
+		}{
+			{max: 1000, expected: "my body"},
+			{max: 2, expected: "my", truncated: true},
+			{contentType: strPtr("application/octet-stream"), max: 1000},
+		} {
+			root := &middleware_mockups.RootHTTPProtectionContextMockup{}
+			cfg := &middleware_mockups.HTTPProtectionConfigMockup{}
+			cfg.ExpectHTTPMaxBodyCaptureSize().Return(tc.max)
+			root.ExpectConfig().Return(cfg)
+
+			req := &http_protection_mockups.RequestReaderMockup{}
+			req.On("Header", "Content-Type").Return(tc.contentType)
+
+			p := NewTestProtectionContext(root, net.IPv4(1, 2, 3, 4), nil, req)
+			r, err := http.NewRequest("POST", "/", strings.NewReader("my body"))
+			require.NoError(t, err)
+			r = p.WrapRequest(r)
+			read, err := ioutil.ReadAll(r.Body)
+			require.NoError(t, err)
+			require.Equal(t, "my body", string(read))
+
+			ba := NewRequestBindingAccessorContext(p.RequestReader)
+			require.Equal(t, tc.expected, string(ba.Body()))
+			require.Equal(t, tc.truncated, ba.BodyTruncated())
+
+			root.AssertExpectations(t)
+			cfg.AssertExpectations(t)
+			req.AssertExpectations(t)
+		}
+	})
+}
+
+func strPtr(s string) *string { return &s }
@@ -0,0 +1,125 @@
+// Copyright (c) 2016 - 2020 Sqreen. All Rights Reserved.
+// Please refer to our terms for more information:
+// https://www.sqreen.io/terms.html
+
+//sqreen:ignore
+
+package http
+
+import (
+	"strings"
+
+	"github.com/pkg/errors"
+)
+
+// parseForwardedHeaderValue returns the `for` parameter values of the given
+// RFC 7239 `Forwarded` header value, in the order of the forwarding chain. The
+// returned nodes are either IP addresses without port numbers, or obfuscated
+// identifiers such as `unknown` or `_hidden`.
+//
+// For example, `for=192.0.2.60;proto=http, for="[2001:db8:cafe::17]:4711"`
+// returns `192.0.2.60` and `2001:db8:cafe::17`.
+func parseForwardedHeaderValue(value string) ([]string, error) {
+	var nodes []string
+	for len(value) > 0 {
+		var (
+			element string
+			err     error
+		)
+		element, value, err = cutForwardedHeaderValue(value, ',')
+		if err != nil {
+			return nil, err
+		}
+
+		// Look for the `for` parameter of the forwarded element
+		for len(element) > 0 {
+			var pair string
+			pair, element, _ = cutForwardedHeaderValue(element, ';')
+			eq := strings.IndexByte(pair, '=')
+			if eq == -1 {
+				if strings.TrimSpace(pair) == "" {
+					continue
+				}
+				return nil, errors.Errorf("unexpected forwarded pair `%s`", pair)
+			}
+			if !strings.EqualFold(strings.TrimSpace(pair[:eq]), "for") {
+				continue
+			}
+			node, err := unquoteForwardedValue(strings.TrimSpace(pair[eq+1:]))
+			if err != nil {
+				return nil, err
+			}
+			nodes = append(nodes, forwardedNodeName(node))
+			break
+		}
+	}
+	return nodes, nil
+}
+
+// cutForwardedHeaderValue cuts the given value around the first separator that
+// is not inside a quoted string.
+func cutForwardedHeaderValue(value string, sep byte) (before, after string, err error) {
+	quoted := false
+	for i := 0; i < len(value); i++ {
+		switch c := value[i]; {
+		case c == '\\' && quoted:
+			// Skip the escaped character
+			i++
+		case c == '"':
+			quoted = !quoted
+		case c == sep && !quoted:
+			return value[:i], value[i+1:], nil
+		}
+	}
+	if quoted {
+		return "", "", errors.Errorf("unterminated quoted string in the forwarded value `%s`", value)
+	}
+	return value, "", nil
+}
+
+// unquoteForwardedValue returns the given token or the content of the given
+// quoted string.
+func unquoteForwardedValue(value string) (string, error) {
+	if !strings.HasPrefix(value, `"`) {
+		return value, nil
+	}
+	if len(value) < 2 || !strings.HasSuffix(value, `"`) {
+		return "", errors.Errorf("unexpected quoted string `%s`", value)
+	}
+	value = value[1 : len(value)-1]
+	if !strings.Contains(value, `\`) {
+		return value, nil
+	}
+	var b strings.Builder
+	for i := 0; i < len(value); i++ {
+		if value[i] == '\\' && i+1 < len(value) {
+			i++
+		}
+		b.WriteByte(value[i])
+	}
+	return b.String(), nil
+}
+
+// forwardedNodeName returns the node name of the given node by removing the
+// optional port number and IPv6 brackets.
+func forwardedNodeName(node string) string {
+	if strings.HasPrefix(node, "[") {
+		if end := strings.IndexByte(node, ']'); end != -1 {
+			return node[1:end]
+		}
+		return node
+	}
+	// IPv4 address or obfuscated identifier followed by a port number. IPv6
+	// addresses must be enclosed in brackets but are still tolerated without
+	// when they have no port number.
+	if i := strings.IndexByte(node, ':'); i != -1 && strings.IndexByte(node[i+1:], ':') == -1 {
+		return node[:i]
+	}
+	return node
+}
+
+// isObfuscatedForwardedNode returns true when the given node is an unknown or
+// obfuscated identifier as defined by RFC 7239.
+func isObfuscatedForwardedNode(node string) bool {
+	return node == "unknown" || strings.HasPrefix(node, "_")
+}
@@ -0,0 +1,44 @@
+// Copyright (c) 2016 - 2020 Sqreen. All Rights Reserved.
+// Please refer to our terms for more information:
+// https://www.sqreen.io/terms.html
+
+//sqreen:ignore
+
+package http
+
+import (
+	"time"
+
+	"github.com/sqreen/go-agent/internal/event"
+	"github.com/sqreen/go-agent/internal/protection/http/types"
+)
+
+const (
+	// graphQLLimitsRuleName is the rule name of the attacks of GraphQL queries
+	// exceeding the limits configured in the middleware.
+	graphQLLimitsRuleName = "graphql-query-limits"
+	graphQLAttackType     = "graphql_query_limits"
+)
+
+// SetGraphQLOperation sets the GraphQL operation executed by the request so
+// that it is added to the request record.
+func (p *ProtectionContext) SetGraphQLOperation(typ, name string) {
+	p.requestReader.graphQLOperation = &types.GraphQLOperation{
+		Type: typ,
+		Name: name,
+	}
+}
+
+// BlockGraphQLRequest blocks the request whose GraphQL query exceeds the
+// limits configured in the middleware, and records the attack along with the
+// given information about the exceeded limits. The handler must not be called
+// as for the other blocking protections.
+func (p *ProtectionContext) BlockGraphQLRequest(info interface{}) {
+	p.HandleAttack(true, &event.AttackEvent{
+		Rule:       graphQLLimitsRuleName,
+		Blocked:    true,
+		Timestamp:  time.Now(),
+		Info:       info,
+		AttackType: graphQLAttackType,
+	})
+}
@@ -0,0 +1,56 @@
+// Copyright (c) 2016 - 2020 Sqreen. All Rights Reserved.
+// Please refer to our terms for more information:
+// https://www.sqreen.io/terms.html
+
+package http
+
+import (
+	"net"
+	"testing"
+
+	http_protection_mockups "github.com/sqreen/go-agent/internal/protection/http/_testlib/mockups"
+	"github.com/sqreen/go-agent/internal/protection/http/types"
+	middleware_mockups "github.com/sqreen/go-agent/sdk/middleware/_testlib/mockups"
+	"github.com/stretchr/testify/require"
+)
+
+func TestGraphQL(t *testing.T) {
+	t.Run("operation", func(t *testing.T) {
+		req := &http_protection_mockups.RequestReaderMockup{}
+		for _, method := range []string{"Method", "RequestURI", "Host", "RemoteAddr", "UserAgent", "Referer"} {
+			req.On(method).Return("").Maybe()
+		}
+		for _, method := range []string{"Headers", "URL", "QueryForm", "PostForm", "Params"} {
+			req.On(method).Return(nil).Maybe()
+		}
+		req.On("IsTLS").Return(false).Maybe()
+		p := NewTestProtectionContext(nil, net.IPv4(1, 2, 3, 4), nil, req)
+
+		require.Nil(t, p.requestReader.GraphQLOperation())
+		p.SetGraphQLOperation("mutation", "Like")
+		expected := &types.GraphQLOperation{Type: "mutation", Name: "Like"}
+		require.Equal(t, expected, p.requestReader.GraphQLOperation())
+
+		// The operation is kept in the handled request
+		handled, ok := copyRequest(p.RequestReader).(types.GraphQLOperationReader)
+		require.True(t, ok)
+		require.Equal(t, expected, handled.GraphQLOperation())
+	})
+
+	t.Run("blocking", func(t *testing.T) {
+		root := &middleware_mockups.RootHTTPProtectionContextMockup{}
+		root.ExpectCancelContext()
+		p := NewTestProtectionContext(root, net.IPv4(1, 2, 3, 4), nil, &http_protection_mockups.RequestReaderMockup{})
+
+		info := map[string]interface{}{"depth": 10, "max_depth": 5}
+		p.BlockGraphQLRequest(info)
+		root.AssertExpectations(t)
+
+		attacks := p.events.CloseRecord().AttackEvents
+		require.Len(t, attacks, 1)
+		require.Equal(t, graphQLLimitsRuleName, attacks[0].Rule)
+		require.Equal(t, graphQLAttackType, attacks[0].AttackType)
+		require.True(t, attacks[0].Blocked)
+		require.Equal(t, info, attacks[0].Info)
+	})
+}
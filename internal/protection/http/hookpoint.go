@@ -0,0 +1,41 @@
+// Copyright (c) 2016 - 2020 Sqreen. All Rights Reserved.
+// Please refer to our terms for more information:
+// https://www.sqreen.io/terms.html
+
+//sqreen:ignore
+
+package http
+
+import (
+	"reflect"
+	"runtime"
+	"sync"
+
+	"github.com/sqreen/go-agent/internal/sqlib/sqgo"
+	"github.com/sqreen/go-agent/internal/sqlib/sqhook"
+)
+
+// Hookpoints whose callbacks require extra work from the protection context,
+// which is therefore only done when they are attached.
+var (
+	responseWAFHookpoint            = &hookpoint{fn: (*ProtectionContext).responseWAF}
+	responseBodyMonitoringHookpoint = &hookpoint{fn: (*ProtectionContext).monitorResponseBody}
+)
+
+// hookpoint is an instrumented function of the protection context whose hook
+// is lazily looked up.
+type hookpoint struct {
+	fn   interface{}
+	once sync.Once
+	hook *sqhook.Hook
+}
+
+// attached returns true when callbacks are attached to the hookpoint. It is
+// always false when the program is not instrumented.
+func (h *hookpoint) attached() bool {
+	h.once.Do(func() {
+		symbol := runtime.FuncForPC(reflect.ValueOf(h.fn).Pointer()).Name()
+		h.hook, _ = sqhook.Find(sqgo.Unvendor(symbol))
+	})
+	return h.hook != nil && h.hook.Attached()
+}
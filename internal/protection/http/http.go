@@ -10,8 +10,10 @@ import (
 	"net"
 	"net/http"
 	"net/url"
+	"sync"
 	"time"
 
+	"github.com/google/uuid"
 	"github.com/sqreen/go-agent/internal/actor"
 	"github.com/sqreen/go-agent/internal/event"
 	protection_context "github.com/sqreen/go-agent/internal/protection/context"
@@ -29,6 +31,23 @@ type ProtectionContext struct {
 
 	requestReader *requestReader
 	start         time.Time
+	requestID     string
+
+	// responseInspector is the response writer wrapper allowing to inspect the
+	// response before it gets committed. It is nil when the framework
+	// middleware doesn't support it.
+	responseInspector *ResponseInspector
+
+	// upstreamStart is the start time of the upstream request when the request
+	// is forwarded by a reverse proxy.
+	upstreamStart time.Time
+
+	// paramWAFLock serializes the request parameter inspections performed by
+	// RequestParamWAF() with Close(), as they can be performed by other
+	// goroutines than the request handler one, even after the handler returned.
+	paramWAFLock sync.Mutex
+	// closed is true once Close() copied the request.
+	closed bool
 }
 
 type SecurityResponseStore interface {
@@ -45,8 +64,16 @@ func NewProtectionContext(ctx types.RootProtectionContext, w types.ResponseWrite
 		return nil
 	}
 
+	// The route template known by the router, such as `/users/:id`, can also
+	// be passlisted.
+	if rr, ok := r.(types.RouteReader); ok {
+		if route := rr.Route(); route != "" && ctx.IsPathAllowed(route) {
+			return nil
+		}
+	}
+
 	cfg := ctx.Config()
-	clientIP := ClientIP(r.RemoteAddr(), r.Headers(), cfg.HTTPClientIPHeader(), cfg.HTTPClientIPHeaderFormat())
+	clientIP := ClientIP(r.RemoteAddr(), r.Headers(), cfg.HTTPClientIPHeader(), cfg.HTTPClientIPHeaderFormat(), cfg.HTTPTrustedProxies())
 
 	if ctx.IsIPAllowed(clientIP) {
 		return nil
@@ -98,9 +125,14 @@ type (
 	BodyWAFPrologCallbackType = WAFPrologCallbackType
 	BodyWAFEpilogCallbackType = WAFEpilogCallbackType
 
+	ResponseWAFPrologCallbackType = WAFPrologCallbackType
+	ResponseWAFEpilogCallbackType = WAFEpilogCallbackType
+
 	IdentifyUserPrologCallbackType = func(**ProtectionContext, *map[string]string) (BlockingEpilogCallbackType, error)
 
 	ResponseMonitoringPrologCallbackType = func(**ProtectionContext, *types.ResponseFace) (NonBlockingEpilogCallbackType, error)
+
+	ResponseBodyMonitoringPrologCallbackType = func(**ProtectionContext, *[]byte) (NonBlockingEpilogCallbackType, error)
 )
 
 // Static assert that ProtectionContext implements the expected interfaces.
@@ -156,6 +188,17 @@ func (p *ProtectionContext) waf() error { /* dynamically instrumented */ return
 //go:noinline
 func (p *ProtectionContext) bodyWAF() error { /* dynamically instrumented */ return nil }
 
+// responseWAF is called by the response inspector before committing the
+// response, so that it can still be replaced by a blocking response.
+//go:noinline
+func (p *ProtectionContext) responseWAF() error { /* dynamically instrumented */ return nil }
+
+// monitorResponseBody is called by the response inspector with the response
+// body chunks written after the response was committed, which can therefore no
+// longer be blocked.
+//go:noinline
+func (p *ProtectionContext) monitorResponseBody(chunk []byte) { /* dynamically instrumented */ }
+
 //go:noinline
 func (p *ProtectionContext) addSecurityHeaders() { /* dynamically instrumented */ }
 
@@ -187,6 +230,10 @@ func (p *ProtectionContext) isContextHandlerCanceled() bool {
 func (p *ProtectionContext) HandleAttack(block bool, attack *event.AttackEvent) (blocked bool) {
 	if block {
 		defer p.CancelContext()
+		if i := p.responseInspector; i != nil && i.inspecting {
+			// Replace the response being inspected
+			i.reset()
+		}
 		p.WriteDefaultBlockingResponse()
 		blocked = true
 	}
@@ -210,14 +257,34 @@ func (p *ProtectionContext) Close(response types.ResponseFace) {
 	// Copy everything we need here as it is not safe to keep then after the
 	// request is done because of memory pools reusing them.
 	p.monitorObservedResponse(response)
+	p.paramWAFLock.Lock()
+	p.closed = true
+	request := copyRequest(p.RequestReader)
+	p.paramWAFLock.Unlock()
 	p.RootProtectionContext.Close(&closedProtectionContext{
 		response:   response,
-		request:    copyRequest(p.RequestReader),
+		request:    request,
 		events:     p.events.CloseRecord(),
 		start:      p.start,
 		duration:   duration,
 		sqreenTime: p.SqreenTime().Duration(),
+		requestID:  p.requestID,
 	})
+	p.requestReader.body.release()
+}
+
+// RequestID returns the ID of the request to give to the client so that a
+// report can be correlated with the recorded events. It is the value of the
+// `X-Request-Id` header when present, otherwise a random UUID.
+func (p *ProtectionContext) RequestID() string {
+	if p.requestID == "" {
+		if rid := p.RequestReader.Header("X-Request-Id"); rid != nil && *rid != "" {
+			p.requestID = *rid
+		} else {
+			p.requestID = uuid.New().String()
+		}
+	}
+	return p.requestID
 }
 
 // Write the default blocking response. This method only write the response, it
@@ -242,6 +309,11 @@ func (p *ProtectionContext) WrapRequest(r *http.Request) *http.Request {
 }
 
 func (p *ProtectionContext) wrapBody(body io.ReadCloser) io.ReadCloser {
+	var contentType string
+	if v := p.RequestReader.Header("Content-Type"); v != nil {
+		contentType = *v
+	}
+	p.requestReader.body.init(contentType, p.Config().HTTPMaxBodyCaptureSize())
 	return rawBodyWAF{
 		ReadCloser: body,
 		c:          p,
@@ -253,7 +325,6 @@ func (p *ProtectionContext) wrapBody(body io.ReadCloser) io.ReadCloser {
 // result of a JSON parsing, query-string parsing, etc. The source allows to
 // specify where it was taken from.
 func (p *ProtectionContext) AddRequestParam(name string, param interface{}) {
-	params := p.requestReader.requestParams[name]
 	var v interface{}
 	switch actual := param.(type) {
 	default:
@@ -262,7 +333,73 @@ func (p *ProtectionContext) AddRequestParam(name string, param interface{}) {
 		// Bare Go type so that it doesn't have any method (for the JS conversion)
 		v = map[string][]string(actual)
 	}
-	p.requestReader.requestParams[name] = append(params, v)
+	p.requestReader.updateParams(func(params types.RequestParamMap) {
+		prev := params[name]
+		params[name] = append(prev[:len(prev):len(prev)], v)
+	})
+}
+
+// RequestParamWAF runs the request WAF with the given request parameter, which
+// is only added to the request parameters for the time of the call. It allows
+// to inspect the messages received by the handler without keeping them, such
+// as the messages of streams. A non-nil error is returned when the request
+// must be blocked. Parameters received once the request handler returned are
+// no longer inspected since the protection context is closed. It can be
+// called by other goroutines than the request handler one.
+func (p *ProtectionContext) RequestParamWAF(name string, param interface{}) error {
+	// Serialized with Close() so that the parameter is never copied into the
+	// request record.
+	p.paramWAFLock.Lock()
+	defer p.paramWAFLock.Unlock()
+	if p.closed || p.Context().Err() != nil {
+		return nil
+	}
+
+	// The other parameters of the same name can be added by the handler
+	// meanwhile, so the parameter is removed by index.
+	var i int
+	p.requestReader.updateParams(func(params types.RequestParamMap) {
+		prev := params[name]
+		i = len(prev)
+		params[name] = append(prev[:i:i], param)
+	})
+	defer p.requestReader.updateParams(func(params types.RequestParamMap) {
+		cur := params[name]
+		if len(cur) == 1 {
+			delete(params, name)
+			return
+		}
+		params[name] = append(cur[:i:i], cur[i+1:]...)
+	})
+	return p.waf()
+}
+
+// InspectResponse links the response inspector wrapping the response writer
+// to the protection context so that the response WAF is performed before
+// committing the response. The response is only buffered when callbacks are
+// attached to the response WAF, and the body written once committed is only
+// monitored when callbacks are attached to the response body monitoring.
+func (p *ProtectionContext) InspectResponse(i *ResponseInspector) {
+	i.p = p
+	p.responseInspector = i
+	i.enable(responseWAFHookpoint.attached(), responseBodyMonitoringHookpoint.attached())
+}
+
+// InspectTestResponse is InspectResponse for tests, where the program is not
+// instrumented, always inspecting and monitoring the response as if callbacks
+// were attached to both the response WAF and body monitoring.
+func (p *ProtectionContext) InspectTestResponse(i *ResponseInspector) {
+	p.InspectResponse(i)
+	i.enable(true, true)
+}
+
+// ResponseReader returns the read-only interface to the response being
+// written by the handler. It is nil when the response is not inspected.
+func (p *ProtectionContext) ResponseReader() types.ResponseReader {
+	if p.responseInspector == nil {
+		return nil
+	}
+	return responseReader{i: p.responseInspector}
 }
 
 func (p *ProtectionContext) ClientIP() net.IP {
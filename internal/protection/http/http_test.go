@@ -5,6 +5,7 @@
 package http
 
 import (
+	"context"
 	"fmt"
 	"math/rand"
 	"net"
@@ -93,6 +94,79 @@ func TestProtectionAPI(t *testing.T) {
 		require.Nil(t, p)
 	})
 
+	t.Run("request param waf", func(t *testing.T) {
+		r := &middleware_mockups.RootHTTPProtectionContextMockup{}
+		ctx, cancel := context.WithCancel(context.Background())
+		r.ExpectContext().Return(ctx)
+		p := NewTestProtectionContext(r, net.IPv4(1, 2, 3, 4), nil, &http_protection_mockups.RequestReaderMockup{})
+
+		// The parameters are only added for the time of the call
+		p.AddRequestParam("a", 1)
+		require.NoError(t, p.RequestParamWAF("a", 2))
+		require.NoError(t, p.RequestParamWAF("b", 3))
+		require.Equal(t, types.RequestParamMap{"a": {1}}, p.requestReader.requestParams)
+
+		// No longer inspected once the context is closed
+		cancel()
+		require.NoError(t, p.RequestParamWAF("a", 2))
+	})
+
+	t.Run("request param waf after the handler returned", func(t *testing.T) {
+		// Stream messages can be inspected by another goroutine while the
+		// protection context gets closed, like gorilla/websocket connections
+		// read after the handler returned.
+		r := &middleware_mockups.RootHTTPProtectionContextMockup{}
+		r.ExpectContext().Return(context.Background())
+		req := &http_protection_mockups.RequestReaderMockup{}
+		u, _ := url.Parse("http://test.com/")
+		req.ExpectMethod().Return("GET")
+		req.ExpectURL().Return(u)
+		req.ExpectRequestURI().Return(u.RequestURI())
+		req.ExpectHost().Return(u.Host)
+		req.ExpectRemoteAddr().Return("1.2.3.4:5678")
+		req.ExpectHeaders().Return(nil)
+		req.ExpectIsTLS().Return(false)
+		req.ExpectUserAgent().Return("ua")
+		req.ExpectReferer().Return("referer")
+		req.ExpectQueryForm().Return(nil)
+		req.ExpectPostForm().Return(nil)
+		req.ExpectParams().Return(nil)
+		response := &http_protection_mockups.ResponseMockup{}
+		response.ExpectStatus().Return(200)
+		response.ExpectContentType().Return("text/plain")
+		response.ExpectContentLength().Return(int64(0))
+		r.ExpectClose(mock.MatchedBy(func(closed types.ClosedProtectionContextFace) bool {
+			// The inspected parameters are never recorded
+			params := closed.Request().Params()
+			require.Equal(t, []interface{}{1}, params["a"])
+			require.NotContains(t, params, "b")
+			return true
+		}))
+
+		p := NewTestProtectionContext(r, net.IPv4(1, 2, 3, 4), nil, req)
+		p.AddRequestParam("a", 1)
+
+		started := make(chan struct{})
+		done := make(chan struct{})
+		go func() {
+			defer close(done)
+			close(started)
+			for i := 0; i < 1000; i++ {
+				require.NoError(t, p.RequestParamWAF("a", i))
+				require.NoError(t, p.RequestParamWAF("b", i))
+			}
+		}()
+		<-started
+		for i := 0; i < 1000; i++ {
+			p.AddRequestParam("c", i)
+			_ = p.RequestReader.Params()
+		}
+		p.Close(response)
+		<-done
+		require.Equal(t, []interface{}{1}, p.requestReader.requestParams["a"])
+		require.Len(t, p.requestReader.requestParams["c"], 1000)
+	})
+
 	t.Run("protection/callback api", func(t *testing.T) {
 		ip := net.ParseIP("1.2.3.4")
 		r, cfg, req, w := newMockups(t, ip, false, false)
@@ -251,7 +325,7 @@ func TestGetClientIP(t *testing.T) {
 					req.Header.Set(k, v)
 				}
 
-				ip := ClientIP(req.RemoteAddr, req.Header, "", "")
+				ip := ClientIP(req.RemoteAddr, req.Header, "", "", nil)
 				require.Equal(t, tc.expected, ip.String())
 			})
 		}
@@ -326,14 +400,156 @@ func TestGetClientIP(t *testing.T) {
 						req.Header.Set(k, v)
 					}
 
-					ip := ClientIP(req.RemoteAddr, req.Header, "x-uNiQue-iD", "it just needs to be set for now")
+					ip := ClientIP(req.RemoteAddr, req.Header, "x-uNiQue-iD", "it just needs to be set for now", nil)
 					require.Equal(t, tc.expected, ip.String())
 				})
 			}
 		})
+
+		t.Run("Forwarded", func(t *testing.T) {
+			req := newRequest("127.0.0.1")
+			req.Header.Set("X-Forwarded-For", "8.8.8.8")
+			req.Header.Set("My-Forwarded", `for=unknown, For="[2001:4860:4860::8888]:4711";proto=https, for=10.0.0.1`)
+			ip := ClientIP(req.RemoteAddr, req.Header, "my-forwarded", "forwarded", nil)
+			require.Equal(t, "2001:4860:4860::8888", ip.String())
+
+			// Malformed values are ignored
+			req.Header.Set("My-Forwarded", `for="2001:4860:4860::8888`)
+			ip = ClientIP(req.RemoteAddr, req.Header, "my-forwarded", "forwarded", nil)
+			require.Equal(t, "8.8.8.8", ip.String())
+		})
+	})
+
+	t.Run("Forwarded header", func(t *testing.T) {
+		req := newRequest("127.0.0.1")
+		req.Header.Set("Forwarded", `for=_hidden, for=192.0.2.43:8080;by=10.0.0.1, for=198.51.100.17`)
+		ip := ClientIP(req.RemoteAddr, req.Header, "", "", nil)
+		require.Equal(t, "192.0.2.43", ip.String())
+	})
+
+	t.Run("Trusted proxies", func(t *testing.T) {
+		trustedProxies := []*net.IPNet{
+			{IP: net.IPv4(10, 0, 0, 0), Mask: net.CIDRMask(8, 32)},
+			{IP: net.IPv4(8, 8, 8, 8), Mask: net.CIDRMask(32, 32)},
+		}
+
+		for _, tc := range []struct {
+			name, expected, remoteAddr string
+			headers                    map[string][]string
+			ipHeader, ipHeaderFormat   string
+		}{
+			{
+				name:       "untrusted remote address",
+				expected:   "1.2.3.4",
+				remoteAddr: "1.2.3.4:1234",
+				headers:    map[string][]string{"X-Forwarded-For": {"5.6.7.8"}},
+			},
+			{
+				name:       "spoofed left-most IP addresses",
+				expected:   "5.6.7.8",
+				remoteAddr: "10.0.0.1:1234",
+				headers:    map[string][]string{"X-Forwarded-For": {"1.2.3.4, 5.6.7.8, 8.8.8.8, 10.1.2.3"}},
+			},
+			{
+				name:       "multiple header values",
+				expected:   "5.6.7.8",
+				remoteAddr: "10.0.0.1:1234",
+				headers:    map[string][]string{"X-Forwarded-For": {"1.2.3.4, 5.6.7.8", "8.8.8.8"}},
+			},
+			{
+				name:       "private client IP address",
+				expected:   "192.168.1.1",
+				remoteAddr: "10.0.0.1:1234",
+				headers:    map[string][]string{"X-Forwarded-For": {"192.168.1.1, 10.1.2.3"}},
+			},
+			{
+				name:       "only trusted proxies",
+				expected:   "10.1.2.3",
+				remoteAddr: "10.0.0.1:1234",
+				headers:    map[string][]string{"X-Forwarded-For": {"10.1.2.3, 8.8.8.8"}},
+			},
+			{
+				name:       "no forwarding header",
+				expected:   "10.0.0.1",
+				remoteAddr: "10.0.0.1:1234",
+			},
+			{
+				name:       "Forwarded header",
+				expected:   "2001:db8:cafe::17",
+				remoteAddr: "10.0.0.1:1234",
+				headers:    map[string][]string{"Forwarded": {`for=1.2.3.4, for="[2001:db8:cafe::17]:4711", for=10.1.2.3`}},
+			},
+			{
+				name:       "obfuscated identifier",
+				expected:   "8.8.8.8",
+				remoteAddr: "10.0.0.1:1234",
+				headers:    map[string][]string{"Forwarded": {`for=1.2.3.4, for=_hidden, for=8.8.8.8`}},
+			},
+			{
+				name:       "prioritized header",
+				expected:   "5.6.7.8",
+				remoteAddr: "[::ffff:10.0.0.1]:1234",
+				headers: map[string][]string{
+					"X-Forwarded-For": {"1.2.3.4"},
+					"X-Real-Ip":       {"5.6.7.8"},
+				},
+				ipHeader: "X-Real-Ip",
+			},
+			{
+				name:       "prioritized header with format",
+				expected:   "5.6.7.8",
+				remoteAddr: "10.0.0.1:1234",
+				headers: map[string][]string{
+					"X-Forwarded-For": {"1.2.3.4"},
+					"X-Unique-Id":     {HAProxyUniqueID(net.IPv4(5, 6, 7, 8))},
+				},
+				ipHeader:       "X-Unique-Id",
+				ipHeaderFormat: "haproxy",
+			},
+		} {
+			tc := tc
+			t.Run(tc.name, func(t *testing.T) {
+				req := newRequest(tc.remoteAddr)
+				for k, v := range tc.headers {
+					req.Header[k] = v
+				}
+				ip := ClientIP(req.RemoteAddr, req.Header, tc.ipHeader, tc.ipHeaderFormat, trustedProxies)
+				require.Equal(t, tc.expected, ip.String())
+			})
+		}
 	})
 }
 
+func TestParseForwardedHeaderValue(t *testing.T) {
+	for _, tc := range []struct {
+		value    string
+		expected []string
+	}{
+		{value: ``},
+		{value: `for=192.0.2.60`, expected: []string{"192.0.2.60"}},
+		{value: `For="[2001:db8:cafe::17]:4711"`, expected: []string{"2001:db8:cafe::17"}},
+		{value: `for=192.0.2.60;proto=http;by=203.0.113.43`, expected: []string{"192.0.2.60"}},
+		{value: `proto=http;for=192.0.2.60:8080`, expected: []string{"192.0.2.60"}},
+		{value: `for=192.0.2.43, for=198.51.100.17`, expected: []string{"192.0.2.43", "198.51.100.17"}},
+		{value: `for=unknown, for="_gazonk", for=_hidden:_port`, expected: []string{"unknown", "_gazonk", "_hidden"}},
+		{value: `by=203.0.113.43, for="a\"b,c;d"`, expected: []string{`a"b,c;d`}},
+		{value: `for=2001:db8::1`, expected: []string{"2001:db8::1"}},
+	} {
+		nodes, err := parseForwardedHeaderValue(tc.value)
+		require.NoError(t, err, tc.value)
+		require.Equal(t, tc.expected, nodes, tc.value)
+	}
+
+	for _, tc := range []string{
+		`for="192.0.2.60`,
+		`for`,
+		`for="192.0.2.60;proto=http`,
+	} {
+		_, err := parseForwardedHeaderValue(tc)
+		require.Error(t, err, tc)
+	}
+}
+
 func RandIPv4() net.IP {
 	return net.IPv4(uint8(rand.Uint32()), uint8(rand.Uint32()), uint8(rand.Uint32()), uint8(rand.Uint32()))
 }
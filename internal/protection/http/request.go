@@ -7,7 +7,6 @@
 package http
 
 import (
-	"bytes"
 	"fmt"
 	"io"
 	"net"
@@ -15,6 +14,7 @@ import (
 	"net/textproto"
 	"net/url"
 	"strings"
+	"sync"
 	"time"
 
 	"github.com/pkg/errors"
@@ -32,37 +32,92 @@ type requestReader struct {
 	// requestParams is the set of HTTP request parameters taken from the HTTP
 	// request. The map key is the source (eg. json, query, multipart-form, etc.)
 	// so that we can report it and make it clearer to understand where the value
-	// comes from.
+	// comes from. The map is never modified once set so that it can be read
+	// by concurrent goroutines, such as the ones inspecting stream messages.
+	// Updates replace it with a modified copy under paramsLock.
 	requestParams types.RequestParamMap
+	paramsLock    sync.Mutex
 
-	// bodyReadBuffer is the buffers body reads
-	bodyReadBuffer bytes.Buffer
+	// body is the capture of the body read by the handler.
+	body bodyCapture
+
+	// graphQLOperation is the GraphQL operation executed by the request, nil
+	// when it is not a GraphQL request.
+	graphQLOperation *types.GraphQLOperation
+
+	// upstream is the upstream server the request was forwarded to, nil when
+	// it was not forwarded.
+	upstream *types.Upstream
+
+	// route is the route template of the request set by SetRoute(), which takes
+	// precedence over the one of the underlying request reader.
+	route string
 }
 
-func (r *requestReader) Body() []byte { return r.bodyReadBuffer.Bytes() }
+func (r *requestReader) Body() []byte { return r.body.Bytes() }
+
+// BodyTruncated returns true when the request body is larger than the captured
+// body returned by Body().
+func (r *requestReader) BodyTruncated() bool { return r.body.Truncated() }
+
+func (r *requestReader) GraphQLOperation() *types.GraphQLOperation { return r.graphQLOperation }
+
+func (r *requestReader) Upstream() *types.Upstream { return r.upstream }
+
+func (r *requestReader) Route() string {
+	if r.route != "" {
+		return r.route
+	}
+	if rr, ok := r.RequestReader.(types.RouteReader); ok {
+		return rr.Route()
+	}
+	return ""
+}
 
 func (r *requestReader) ClientIP() net.IP { return r.clientIP }
 
 func (r *requestReader) Params() types.RequestParamMap {
+	requestParams := r.params()
 	params := r.RequestReader.Params()
 	if len(params) == 0 {
-		return r.requestParams
+		return requestParams
 	}
 
-	if len(r.requestParams) == 0 {
+	if len(requestParams) == 0 {
 		return params
 	}
 
-	res := make(types.RequestParamMap, len(params)+len(r.requestParams))
+	res := make(types.RequestParamMap, len(params)+len(requestParams))
 	for n, v := range params {
 		res[n] = v
 	}
-	for n, v := range r.requestParams {
+	for n, v := range requestParams {
 		res[n] = v
 	}
 	return res
 }
 
+// params returns the current request parameters, which must not be modified.
+func (r *requestReader) params() types.RequestParamMap {
+	r.paramsLock.Lock()
+	defer r.paramsLock.Unlock()
+	return r.requestParams
+}
+
+// updateParams replaces the request parameters with a copy modified by the
+// given function. The parameter slices of the copy are shared with the
+// previous map and must therefore be copied before being modified.
+func (r *requestReader) updateParams(update func(params types.RequestParamMap)) {
+	r.paramsLock.Lock()
+	defer r.paramsLock.Unlock()
+	params := make(types.RequestParamMap, len(r.requestParams)+1)
+	for n, v := range r.requestParams {
+		params[n] = v
+	}
+	update(params)
+	r.requestParams = params
+}
+
 type rawBodyWAF struct {
 	io.ReadCloser
 	c *ProtectionContext
@@ -72,7 +127,7 @@ type rawBodyWAF struct {
 func (t rawBodyWAF) Read(p []byte) (n int, err error) {
 	n, err = t.ReadCloser.Read(p)
 	if n > 0 {
-		t.c.requestReader.bodyReadBuffer.Write(p[:n])
+		t.c.requestReader.body.Write(p[:n])
 	}
 
 	if err == io.EOF {
@@ -86,14 +141,40 @@ func (t rawBodyWAF) Read(p []byte) (n int, err error) {
 	return
 }
 
-func ClientIP(remoteAddr string, headers http.Header, prioritizedIPHeader string, prioritizedIPHeaderFormat string) net.IP {
+// Client IP header formats of the `ip_header_format` configuration. Any other
+// value, such as `haproxy`, is the HAProxy unique ID format `%ci:%cp...` for
+// backward compatibility.
+const (
+	// The comma-separated list of IP addresses of `X-Forwarded-For`.
+	clientIPHeaderFormatList = ""
+	// The RFC 7239 `Forwarded` header.
+	clientIPHeaderFormatForwarded = "forwarded"
+)
+
+// ClientIP returns the IP address of the client performing the request.
+//
+// Without trusted proxies, it is the first global IP address found in the
+// prioritized IP header, the IP related headers and the remote address, in
+// this order, or the first private one otherwise.
+//
+// When trusted proxies are given, the headers are only taken into account when
+// the remote address is a trusted proxy, and the client IP address is then the
+// right-most IP address of the forwarding chain that is not a trusted proxy.
+// The forwarding chain is given by the prioritized IP header when set, or
+// otherwise by `X-Forwarded-For` or `Forwarded`.
+func ClientIP(remoteAddr string, headers http.Header, prioritizedIPHeader string, prioritizedIPHeaderFormat string, trustedProxies []*net.IPNet) net.IP {
+	if len(trustedProxies) > 0 {
+		return trustedClientIP(remoteAddr, headers, prioritizedIPHeader, prioritizedIPHeaderFormat, trustedProxies)
+	}
+
 	var privateIP net.IP
-	check := func(value string) net.IP {
-		for _, ip := range strings.Split(value, ",") {
-			ipStr := strings.Trim(ip, " ")
-			ipStr, _ = splitHostPort(ipStr)
-			ip := net.ParseIP(ipStr)
+	check := func(values []string) net.IP {
+		for _, value := range values {
+			ip := parseIPNode(value)
 			if ip == nil {
+				if isObfuscatedForwardedNode(value) {
+					continue
+				}
 				return nil
 			}
 
@@ -110,19 +191,9 @@ func ClientIP(remoteAddr string, headers http.Header, prioritizedIPHeader string
 
 	if prioritizedIPHeader != "" {
 		if value := headers.Get(prioritizedIPHeader); value != "" {
-			if prioritizedIPHeaderFormat != "" {
-				parsed, err := parseClientIPHeaderHeaderValue(prioritizedIPHeaderFormat, value)
-				if err == nil {
-					// Parsing ok, keep its returned value.
-					value = parsed
-				} else {
-					// An error occurred while parsing the header value, so ignore it.
-					value = ""
-				}
-			}
-
-			if value != "" {
-				if ip := check(value); ip != nil {
+			// Ignore the header value when it cannot be parsed.
+			if values, err := clientIPHeaderValues(prioritizedIPHeaderFormat, value); err == nil {
+				if ip := check(values); ip != nil {
 					return ip
 				}
 			}
@@ -131,7 +202,14 @@ func ClientIP(remoteAddr string, headers http.Header, prioritizedIPHeader string
 
 	for _, key := range config.IPRelatedHTTPHeaders {
 		value := headers.Get(key)
-		if ip := check(value); ip != nil {
+		if value == "" {
+			continue
+		}
+		values, err := clientIPHeaderValues(ipRelatedHTTPHeaderFormat(key), value)
+		if err != nil {
+			continue
+		}
+		if ip := check(values); ip != nil {
 			return ip
 		}
 	}
@@ -150,6 +228,109 @@ func ClientIP(remoteAddr string, headers http.Header, prioritizedIPHeader string
 	return privateIP
 }
 
+// trustedClientIP returns the client IP address by walking the forwarding
+// chain from the right and skipping the trusted proxies. The walk stops at the
+// first node that is not an IP address, such as an obfuscated identifier, in
+// which case the last walked IP address is returned as it is the closest known
+// one.
+func trustedClientIP(remoteAddr string, headers http.Header, prioritizedIPHeader string, prioritizedIPHeaderFormat string, trustedProxies []*net.IPNet) net.IP {
+	remoteIPStr, _ := splitHostPort(remoteAddr)
+	clientIP := net.ParseIP(remoteIPStr)
+	if clientIP == nil || !isTrustedProxy(clientIP, trustedProxies) {
+		// The headers can be spoofed by the client when it is not a trusted
+		// proxy.
+		return clientIP
+	}
+
+	var chain []string
+	if prioritizedIPHeader != "" {
+		chain = forwardingChain(headers, prioritizedIPHeader, prioritizedIPHeaderFormat)
+	} else {
+		chain = forwardingChain(headers, "X-Forwarded-For", clientIPHeaderFormatList)
+		if len(chain) == 0 {
+			chain = forwardingChain(headers, "Forwarded", clientIPHeaderFormatForwarded)
+		}
+	}
+
+	for i := len(chain) - 1; i >= 0; i-- {
+		ip := parseIPNode(chain[i])
+		if ip == nil {
+			break
+		}
+		clientIP = ip
+		if !isTrustedProxy(ip, trustedProxies) {
+			break
+		}
+	}
+	return clientIP
+}
+
+// forwardingChain returns the nodes of every value of the given header. They
+// are all returned, or none when a value cannot be parsed, as a partial chain
+// could allow to skip the trusted proxies of the missing part.
+func forwardingChain(headers http.Header, key, format string) (chain []string) {
+	for _, value := range headers[textproto.CanonicalMIMEHeaderKey(key)] {
+		values, err := clientIPHeaderValues(format, value)
+		if err != nil {
+			return nil
+		}
+		chain = append(chain, values...)
+	}
+	return chain
+}
+
+func isTrustedProxy(ip net.IP, trustedProxies []*net.IPNet) bool {
+	for _, network := range trustedProxies {
+		if network.Contains(ip) {
+			return true
+		}
+	}
+	return false
+}
+
+// ipRelatedHTTPHeaderFormat returns the format of the given IP related header.
+func ipRelatedHTTPHeaderFormat(key string) string {
+	switch key {
+	case "Forwarded", "HTTP_FORWARDED":
+		return clientIPHeaderFormatForwarded
+	default:
+		return clientIPHeaderFormatList
+	}
+}
+
+// clientIPHeaderValues returns the list of nodes of the given header value
+// according to its format. The nodes are IP addresses, optionally with a port
+// number, or obfuscated identifiers.
+func clientIPHeaderValues(format, value string) ([]string, error) {
+	switch format {
+	case clientIPHeaderFormatList:
+		values := strings.Split(value, ",")
+		for i, v := range values {
+			values[i] = strings.TrimSpace(v)
+		}
+		return values, nil
+	case clientIPHeaderFormatForwarded:
+		return parseForwardedHeaderValue(value)
+	default:
+		ip, err := parseClientIPHeaderHeaderValue(format, value)
+		if err != nil {
+			return nil, err
+		}
+		return []string{ip}, nil
+	}
+}
+
+// parseIPNode parses the given IP address, optionally followed by a port
+// number. It returns nil when it is not an IP address.
+func parseIPNode(node string) net.IP {
+	node = strings.TrimSpace(node)
+	if ip := net.ParseIP(node); ip != nil {
+		return ip
+	}
+	host, _ := splitHostPort(node)
+	return net.ParseIP(host)
+}
+
 func isGlobal(ip net.IP) bool {
 	if ipv4 := ip.To4(); ipv4 != nil && config.IPv4PublicNetwork.Contains(ipv4) {
 		return false
@@ -194,10 +375,12 @@ func splitHostPort(addr string) (host string, port string) {
 	return addr[:i], addr[i+1:]
 }
 
+// parseClientIPHeaderHeaderValue parses the HAProxy unique ID format
+// `%ci:%cp...`, which is the format of the values other than the list and
+// forwarded formats. So we expect the value to start with the client IP in
+// hexadecimal format (eg. 7F000001) separated by the client port number with a
+// semicolon `:`.
 func parseClientIPHeaderHeaderValue(format, value string) (string, error) {
-	// Hard-coded HA Proxy format for now: `%ci:%cp...` so we expect the value to
-	// start with the client IP in hexadecimal format (eg. 7F000001) separated by
-	// the client port number with a semicolon `:`.
 	sep := strings.IndexRune(value, ':')
 	if sep == -1 {
 		return "", errors.Errorf("unexpected IP address value `%s`", value)
@@ -235,6 +418,9 @@ type handledRequest struct {
 	clientIP   net.IP
 	params     types.RequestParamMap
 	body       []byte
+	graphQL    *types.GraphQLOperation
+	upstream   *types.Upstream
+	route      string
 }
 
 func (h *handledRequest) Headers() http.Header          { return h.headers }
@@ -263,8 +449,14 @@ func (h *handledRequest) Header(header string) (value *string) {
 	return &v[0]
 }
 
+func (h *handledRequest) GraphQLOperation() *types.GraphQLOperation { return h.graphQL }
+
+func (h *handledRequest) Upstream() *types.Upstream { return h.upstream }
+
+func (h *handledRequest) Route() string { return h.route }
+
 func copyRequest(reader types.RequestReader) types.RequestReader {
-	return &handledRequest{
+	h := &handledRequest{
 		headers:    reader.Headers(),
 		method:     reader.Method(),
 		url:        reader.URL(),
@@ -278,8 +470,32 @@ func copyRequest(reader types.RequestReader) types.RequestReader {
 		postForm:   reader.PostForm(),
 		clientIP:   reader.ClientIP(),
 		params:     reader.Params(),
-		body:       reader.Body(),
+		// The body is copied as the capture buffer is reused by other requests.
+		body: copyBytes(reader.Body()),
+	}
+	if r, ok := reader.(types.GraphQLOperationReader); ok {
+		if op := r.GraphQLOperation(); op != nil {
+			opCopy := *op
+			h.graphQL = &opCopy
+		}
+	}
+	if r, ok := reader.(types.UpstreamReader); ok {
+		if u := r.Upstream(); u != nil {
+			upstreamCopy := *u
+			h.upstream = &upstreamCopy
+		}
+	}
+	if r, ok := reader.(types.RouteReader); ok {
+		h.route = r.Route()
+	}
+	return h
+}
+
+func copyBytes(b []byte) []byte {
+	if len(b) == 0 {
+		return nil
 	}
+	return append([]byte(nil), b...)
 }
 
 type closedProtectionContext struct {
@@ -289,6 +505,7 @@ type closedProtectionContext struct {
 	start      time.Time
 	duration   time.Duration
 	sqreenTime time.Duration
+	requestID  string
 }
 
 var _ types.ClosedProtectionContextFace = (*closedProtectionContext)(nil)
@@ -299,3 +516,4 @@ func (c *closedProtectionContext) Response() types.ResponseFace { return c.respo
 func (c *closedProtectionContext) Start() time.Time             { return c.start }
 func (c *closedProtectionContext) Duration() time.Duration      { return c.duration }
 func (c *closedProtectionContext) SqreenTime() time.Duration    { return c.sqreenTime }
+func (c *closedProtectionContext) RequestID() string            { return c.requestID }
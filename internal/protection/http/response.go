@@ -0,0 +1,360 @@
+// Copyright (c) 2016 - 2020 Sqreen. All Rights Reserved.
+// Please refer to our terms for more information:
+// https://www.sqreen.io/terms.html
+
+//sqreen:ignore
+
+package http
+
+import (
+	"bufio"
+	"bytes"
+	"io"
+	"net"
+	"net/http"
+	"net/textproto"
+
+	"github.com/sqreen/go-agent/internal/sqlib/sqerrors"
+)
+
+// ResponseBodyPrefixMaxLen is the maximum number of response body bytes
+// buffered before committing the response, and therefore the maximum size of
+// the response body prefix the response WAF can inspect.
+const ResponseBodyPrefixMaxLen = 4096
+
+// ResponseBodyTailMaxLen is the maximum number of bytes of the response body
+// kept after the response was committed so that the response body monitoring
+// can find values written across several body chunks.
+const ResponseBodyTailMaxLen = 4096
+
+// ResponseInspector wraps the response writer of a request handler in order to
+// inspect the response with the response WAF before it gets committed. The
+// response status code and body are buffered until either the handler wrote
+// more than ResponseBodyPrefixMaxLen bytes, flushed or hijacked the response,
+// or returned. The response WAF then inspects the response headers and body
+// prefix, and can replace the response with a blocking one since nothing was
+// written to the underlying response writer so far. Just like `net/http`'s
+// response buffer, this is transparent to the handler. The response is only
+// buffered when callbacks are attached to the response WAF, and is otherwise
+// written straight to the underlying response writer.
+type ResponseInspector struct {
+	w http.ResponseWriter
+	p *ProtectionContext
+
+	status    int
+	body      bytes.Buffer
+	truncated bool
+	// wroteBody is true when the handler wrote the body, possibly empty, which
+	// implies the default status code like net/http.
+	wroteBody bool
+
+	// committed is true as soon as the response is being committed, including
+	// while it is being inspected. Writes then go straight to the underlying
+	// response writer, which allows the response WAF to write the blocking
+	// response.
+	committed bool
+	// inspecting is true while the response WAF runs.
+	inspecting bool
+	// err is the error returned by the response WAF when it blocked the
+	// response, or http.ErrHijacked once the connection was hijacked. Further
+	// writes fail with this error.
+	err error
+	// onReset is called when the response written by the handler gets
+	// discarded.
+	onReset func()
+
+	// monitoring is true when the body chunks written after the response was
+	// committed are passed to the response body monitoring.
+	monitoring bool
+	// tail is the end of the body written before the monitored body chunk.
+	tail []byte
+}
+
+// NewResponseInspector returns a response inspector of the given response
+// writer. It must be linked to its protection context using
+// ProtectionContext.InspectResponse() and committed using Commit() once the
+// handler returned.
+func NewResponseInspector(w http.ResponseWriter) *ResponseInspector {
+	return &ResponseInspector{w: w}
+}
+
+func (i *ResponseInspector) Header() http.Header {
+	return i.w.Header()
+}
+
+func (i *ResponseInspector) WriteHeader(statusCode int) {
+	if i.err != nil {
+		return
+	}
+	if i.committed {
+		i.w.WriteHeader(statusCode)
+		return
+	}
+	// Like net/http, the status code can no longer be changed once written,
+	// including implicitly by writing the body.
+	if !i.Written() {
+		i.status = statusCode
+	}
+}
+
+func (i *ResponseInspector) Write(b []byte) (n int, err error) {
+	if i.err != nil {
+		return 0, i.err
+	}
+	if i.committed {
+		n, err = i.w.Write(b)
+		i.monitorBody(b[:n])
+		return n, err
+	}
+
+	i.wroteBody = true
+	if l := i.body.Len() + len(b); l <= ResponseBodyPrefixMaxLen {
+		return i.body.Write(b)
+	}
+
+	// The body prefix is full: inspect the response and commit it along with
+	// the bytes fitting in the body prefix before writing the rest.
+	n = ResponseBodyPrefixMaxLen - i.body.Len()
+	i.body.Write(b[:n])
+	i.truncated = true
+	if err := i.Commit(); err != nil {
+		return 0, err
+	}
+	written, err := i.w.Write(b[n:])
+	i.monitorBody(b[n : n+written])
+	return n + written, err
+}
+
+func (i *ResponseInspector) WriteString(s string) (n int, err error) {
+	if i.committed && i.err == nil {
+		// The response body is monitored through Write().
+		if sw, ok := i.w.(io.StringWriter); ok && !i.monitoring {
+			return sw.WriteString(s)
+		}
+	}
+	return i.Write([]byte(s))
+}
+
+func (i *ResponseInspector) ReadFrom(r io.Reader) (n int64, err error) {
+	if !i.committed {
+		// Go through Write() which buffers the body prefix and commits the
+		// response once it is full.
+		return io.Copy(writerOnly{i}, r)
+	}
+	if i.err != nil {
+		return 0, i.err
+	}
+	// The response body is monitored through Write().
+	if rf, ok := i.w.(io.ReaderFrom); ok && !i.monitoring {
+		return rf.ReadFrom(r)
+	}
+	return io.Copy(writerOnly{i}, r)
+}
+
+func (i *ResponseInspector) Flush() {
+	if err := i.Commit(); err != nil {
+		return
+	}
+	if f, ok := i.w.(http.Flusher); ok {
+		f.Flush()
+	}
+}
+
+func (i *ResponseInspector) Hijack() (net.Conn, *bufio.ReadWriter, error) {
+	h, ok := i.w.(http.Hijacker)
+	if !ok {
+		return nil, nil, sqerrors.Errorf("unexpected response writer type `%T`: not a hijacker", i.w)
+	}
+	if err := i.Commit(); err != nil {
+		return nil, nil, err
+	}
+	conn, rw, err := h.Hijack()
+	if err == nil {
+		// The response writer can no longer be used, including by blocking
+		// responses.
+		i.err = http.ErrHijacked
+	}
+	return conn, rw, err
+}
+
+func (i *ResponseInspector) Push(target string, opts *http.PushOptions) error {
+	if p, ok := i.w.(http.Pusher); ok {
+		return p.Push(target, opts)
+	}
+	return http.ErrNotSupported
+}
+
+func (i *ResponseInspector) CloseNotify() <-chan bool {
+	if cn, ok := i.w.(http.CloseNotifier); ok {
+		return cn.CloseNotify()
+	}
+	// Never notified
+	return nil
+}
+
+// monitorBody passes the body chunk written after the response was committed
+// to the response body monitoring. The blocking response written while
+// inspecting the response is not monitored.
+func (i *ResponseInspector) monitorBody(chunk []byte) {
+	if p := i.p; p != nil && i.monitoring && !i.inspecting && len(chunk) > 0 {
+		p.monitorResponseBody(chunk)
+		i.appendBodyTail(chunk)
+	}
+}
+
+// appendBodyTail appends the given body bytes to the body tail, keeping at most
+// its last ResponseBodyTailMaxLen bytes.
+func (i *ResponseInspector) appendBodyTail(b []byte) {
+	if len(b) >= ResponseBodyTailMaxLen {
+		i.tail = append(i.tail[:0], b[len(b)-ResponseBodyTailMaxLen:]...)
+		return
+	}
+	if extra := len(i.tail) + len(b) - ResponseBodyTailMaxLen; extra > 0 {
+		i.tail = append(i.tail[:0], i.tail[extra:]...)
+	}
+	i.tail = append(i.tail, b...)
+}
+
+// enable enables the buffering of the response when it can be inspected by the
+// response WAF. The response is otherwise considered committed from the start
+// so that it is written straight to the underlying response writer. The body
+// written once committed is passed to the response body monitoring when
+// bodyMonitoring is true, and otherwise written using the optimized
+// interfaces of the underlying response writer, such as io.ReaderFrom.
+func (i *ResponseInspector) enable(waf, bodyMonitoring bool) {
+	i.committed = !waf
+	i.monitoring = bodyMonitoring
+}
+
+// Committed returns true when the response was committed.
+func (i *ResponseInspector) Committed() bool {
+	return i.committed
+}
+
+// Written returns true when the handler wrote the response status code or
+// body, even if not committed yet.
+func (i *ResponseInspector) Written() bool {
+	return i.committed || i.status != 0 || i.wroteBody
+}
+
+// Status returns the response status code the handler wrote so far, 0 if
+// none.
+func (i *ResponseInspector) Status() int {
+	return i.status
+}
+
+// OnReset registers a function called when the response written by the
+// handler gets discarded in order to be replaced by a blocking response. It
+// allows wrappers keeping track of the response to reset their state.
+func (i *ResponseInspector) OnReset(fn func()) {
+	i.onReset = fn
+}
+
+// Body returns the response body prefix.
+func (i *ResponseInspector) Body() []byte {
+	return i.body.Bytes()
+}
+
+// BodyTruncated returns true when the handler wrote more than the body prefix.
+func (i *ResponseInspector) BodyTruncated() bool {
+	return i.truncated
+}
+
+// BodyTail returns the end of the body written before the body chunk being
+// monitored.
+func (i *ResponseInspector) BodyTail() []byte {
+	return i.tail
+}
+
+// Commit inspects the response with the response WAF and commits it when not
+// already done. The status code and buffered body are written to the
+// underlying response writer unless the response WAF blocked the response, in
+// which case the blocking error is returned. Commit must be called once the
+// handler returned in order to write the remaining buffered response.
+func (i *ResponseInspector) Commit() error {
+	if i.committed {
+		return i.err
+	}
+	i.committed = true
+
+	if p := i.p; p != nil && !p.isContextHandlerCanceled() {
+		i.inspecting = true
+		err := p.responseWAF()
+		i.inspecting = false
+		if err != nil {
+			i.err = err
+			i.body.Reset()
+			return err
+		}
+	}
+
+	if i.status != 0 {
+		i.w.WriteHeader(i.status)
+	}
+	if i.body.Len() > 0 {
+		if i.monitoring {
+			// The body prefix was inspected by the response WAF and precedes the
+			// monitored body chunks.
+			i.appendBodyTail(i.body.Bytes())
+		}
+		_, err := i.w.Write(i.body.Bytes())
+		return err
+	}
+	return nil
+}
+
+// reset discards the response written by the handler so that a new response
+// can be written instead. It only happens when blocking the response being
+// inspected by the response WAF.
+func (i *ResponseInspector) reset() {
+	headers := i.w.Header()
+	for k := range headers {
+		delete(headers, k)
+	}
+	i.status = 0
+	i.wroteBody = false
+	i.body.Reset()
+	if i.onReset != nil {
+		i.onReset()
+	}
+}
+
+// writerOnly hides every method of a writer but Write() so that io.Copy()
+// doesn't recursively call ReadFrom().
+type writerOnly struct {
+	io.Writer
+}
+
+// responseReader is the read-only interface to the response being inspected.
+type responseReader struct {
+	i *ResponseInspector
+}
+
+func (r responseReader) Status() int {
+	if s := r.i.status; s != 0 {
+		return s
+	}
+	// Some response writers, such as Gin's, keep track of the status code
+	// until the response is written.
+	if w, ok := r.i.w.(interface{ Status() int }); ok {
+		if s := w.Status(); s != 0 {
+			return s
+		}
+	}
+	// Default net/http status code
+	return http.StatusOK
+}
+
+func (r responseReader) Headers() http.Header { return r.i.Header() }
+
+func (r responseReader) Header(h string) *string {
+	v := r.i.Header()[textproto.CanonicalMIMEHeaderKey(h)]
+	if len(v) == 0 {
+		return nil
+	}
+	return &v[0]
+}
+
+func (r responseReader) Body() []byte        { return r.i.Body() }
+func (r responseReader) BodyTruncated() bool { return r.i.BodyTruncated() }
+func (r responseReader) BodyTail() []byte    { return r.i.BodyTail() }
@@ -0,0 +1,243 @@
+// Copyright (c) 2016 - 2020 Sqreen. All Rights Reserved.
+// Please refer to our terms for more information:
+// https://www.sqreen.io/terms.html
+
+package http
+
+import (
+	"bytes"
+	"context"
+	"io"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	middleware_mockups "github.com/sqreen/go-agent/sdk/middleware/_testlib/mockups"
+	"github.com/sqreen/go-agent/tools/testlib"
+	"github.com/stretchr/testify/require"
+)
+
+func TestResponseInspector(t *testing.T) {
+	newProtectionContext := func(t *testing.T, w http.ResponseWriter) (*ProtectionContext, *ResponseInspector, *middleware_mockups.RootHTTPProtectionContextMockup) {
+		root := &middleware_mockups.RootHTTPProtectionContextMockup{}
+		root.ExpectContext().Return(context.Background()).Maybe()
+		i := NewResponseInspector(w)
+		p := NewTestProtectionContext(root, net.IPv4(1, 2, 3, 4), i, nil)
+		p.InspectTestResponse(i)
+		return p, i, root
+	}
+
+	t.Run("response not buffered without response waf", func(t *testing.T) {
+		rec := httptest.NewRecorder()
+		root := &middleware_mockups.RootHTTPProtectionContextMockup{}
+		defer root.AssertExpectations(t)
+		i := NewResponseInspector(rec)
+		p := NewTestProtectionContext(root, net.IPv4(1, 2, 3, 4), i, nil)
+		// The program is not instrumented in tests so that no callbacks can be
+		// attached.
+		p.InspectResponse(i)
+
+		i.WriteHeader(http.StatusCreated)
+		_, err := i.Write([]byte("hello"))
+		require.NoError(t, err)
+
+		// Written straight to the response writer
+		require.True(t, i.Committed())
+		require.Equal(t, http.StatusCreated, rec.Code)
+		require.Equal(t, "hello", rec.Body.String())
+		require.NoError(t, i.Commit())
+		require.Equal(t, "hello", rec.Body.String())
+	})
+
+	t.Run("response buffered until committed", func(t *testing.T) {
+		rec := httptest.NewRecorder()
+		p, i, root := newProtectionContext(t, rec)
+		defer root.AssertExpectations(t)
+
+		i.Header().Set("Content-Type", "text/plain")
+		i.WriteHeader(http.StatusCreated)
+		_, err := i.Write([]byte("hello "))
+		require.NoError(t, err)
+		_, err = i.WriteString("world")
+		require.NoError(t, err)
+
+		// Nothing written so far
+		require.False(t, rec.Flushed)
+		require.Equal(t, 0, rec.Body.Len())
+		require.True(t, i.Written())
+		require.False(t, i.Committed())
+
+		// The response is readable
+		r := p.ResponseReader()
+		require.NotNil(t, r)
+		require.Equal(t, http.StatusCreated, r.Status())
+		require.Equal(t, "text/plain", *r.Header("content-type"))
+		require.Equal(t, []byte("hello world"), r.Body())
+		require.False(t, r.BodyTruncated())
+
+		require.NoError(t, i.Commit())
+		require.True(t, i.Committed())
+		require.Equal(t, http.StatusCreated, rec.Code)
+		require.Equal(t, "hello world", rec.Body.String())
+
+		// Further writes are not buffered
+		_, err = i.Write([]byte("!"))
+		require.NoError(t, err)
+		require.Equal(t, "hello world!", rec.Body.String())
+		require.Equal(t, []byte("hello world"), r.Body())
+	})
+
+	t.Run("body larger than the prefix", func(t *testing.T) {
+		rec := httptest.NewRecorder()
+		_, i, root := newProtectionContext(t, rec)
+		defer root.AssertExpectations(t)
+
+		body := testlib.RandPrintableUSASCIIString(ResponseBodyPrefixMaxLen+1, ResponseBodyPrefixMaxLen+1)
+		n, err := i.Write([]byte(body))
+		require.NoError(t, err)
+		require.Equal(t, len(body), n)
+
+		// Committed because larger than the prefix
+		require.True(t, i.Committed())
+		require.True(t, i.BodyTruncated())
+		require.Equal(t, []byte(body[:ResponseBodyPrefixMaxLen]), i.Body())
+		require.Equal(t, http.StatusOK, rec.Code)
+		require.Equal(t, body, rec.Body.String())
+	})
+
+	t.Run("read from", func(t *testing.T) {
+		rec := httptest.NewRecorder()
+		_, i, root := newProtectionContext(t, rec)
+		defer root.AssertExpectations(t)
+
+		body := testlib.RandPrintableUSASCIIString(3*ResponseBodyPrefixMaxLen, 3*ResponseBodyPrefixMaxLen)
+		n, err := i.ReadFrom(strings.NewReader(body))
+		require.NoError(t, err)
+		require.Equal(t, int64(len(body)), n)
+		require.True(t, i.Committed())
+		require.True(t, i.BodyTruncated())
+		require.Equal(t, body, rec.Body.String())
+	})
+
+	t.Run("flush", func(t *testing.T) {
+		rec := httptest.NewRecorder()
+		_, i, root := newProtectionContext(t, rec)
+		defer root.AssertExpectations(t)
+
+		_, err := i.Write([]byte("hello"))
+		require.NoError(t, err)
+		i.Flush()
+		require.True(t, i.Committed())
+		require.True(t, rec.Flushed)
+		require.Equal(t, "hello", rec.Body.String())
+	})
+
+	t.Run("nothing written", func(t *testing.T) {
+		rec := httptest.NewRecorder()
+		_, i, root := newProtectionContext(t, rec)
+		defer root.AssertExpectations(t)
+
+		require.False(t, i.Written())
+		require.NoError(t, i.Commit())
+		require.False(t, rec.Flushed)
+		require.Equal(t, 0, rec.Body.Len())
+		// Still possible to write after the commit, eg. by the framework.
+		i.WriteHeader(http.StatusNotFound)
+		require.Equal(t, http.StatusNotFound, rec.Code)
+	})
+
+	t.Run("response replaced when blocked while inspected", func(t *testing.T) {
+		rec := httptest.NewRecorder()
+		p, i, root := newProtectionContext(t, rec)
+		defer root.AssertExpectations(t)
+		root.ExpectCancelContext()
+
+		i.Header().Set("Content-Length", "33")
+		i.WriteHeader(http.StatusOK)
+		_, err := i.Write(bytes.Repeat([]byte{'A'}, 33))
+		require.NoError(t, err)
+
+		// Simulate the response WAF blocking the response
+		i.committed = true
+		i.inspecting = true
+		require.True(t, p.HandleAttack(true, nil))
+		i.inspecting = false
+		require.Empty(t, i.Header())
+		require.Equal(t, 0, i.Status())
+		require.Empty(t, i.Body())
+		i.WriteHeader(http.StatusForbidden)
+		_, err = i.Write([]byte("blocked"))
+		require.NoError(t, err)
+		require.Equal(t, http.StatusForbidden, rec.Code)
+		require.Equal(t, "blocked", rec.Body.String())
+	})
+
+	t.Run("body tail", func(t *testing.T) {
+		rec := httptest.NewRecorder()
+		_, i, root := newProtectionContext(t, rec)
+		defer root.AssertExpectations(t)
+
+		_, err := i.Write([]byte("hello"))
+		require.NoError(t, err)
+		require.Empty(t, i.BodyTail())
+		require.NoError(t, i.Commit())
+		// The committed body prefix is the tail of the next chunk
+		require.Equal(t, []byte("hello"), i.BodyTail())
+
+		body := testlib.RandPrintableUSASCIIString(ResponseBodyTailMaxLen, ResponseBodyTailMaxLen)
+		_, err = i.WriteString(body)
+		require.NoError(t, err)
+		require.Equal(t, []byte(body), i.BodyTail())
+		_, err = i.Write([]byte("world"))
+		require.NoError(t, err)
+		require.Equal(t, []byte(body[5:]+"world"), i.BodyTail())
+	})
+
+	t.Run("optimized writes without body monitoring", func(t *testing.T) {
+		for _, monitoring := range []bool{false, true} {
+			w := &optimizedResponseWriter{ResponseRecorder: httptest.NewRecorder()}
+			_, i, root := newProtectionContext(t, w)
+			i.enable(false, monitoring)
+
+			_, err := i.WriteString("hello ")
+			require.NoError(t, err)
+			_, err = i.ReadFrom(strings.NewReader("world"))
+			require.NoError(t, err)
+			require.Equal(t, "hello world", w.Body.String())
+			// The optimized writes are used when the body is not monitored
+			require.Equal(t, !monitoring, w.wroteString)
+			require.Equal(t, !monitoring, w.readFrom)
+			root.AssertExpectations(t)
+		}
+	})
+
+	t.Run("no response inspector", func(t *testing.T) {
+		p := NewTestProtectionContext(nil, nil, httptest.NewRecorder(), nil)
+		require.Nil(t, p.ResponseReader())
+
+		ctx := NewResponseBindingAccessorContext(p.ResponseReader())
+		require.Nil(t, ctx.Status())
+		require.Nil(t, ctx.Headers())
+		require.Nil(t, ctx.Body())
+		require.False(t, ctx.BodyTruncated())
+	})
+}
+
+// optimizedResponseWriter is a response writer implementing the optional
+// io.StringWriter and io.ReaderFrom interfaces, such as net/http's.
+type optimizedResponseWriter struct {
+	*httptest.ResponseRecorder
+	wroteString, readFrom bool
+}
+
+func (w *optimizedResponseWriter) WriteString(s string) (int, error) {
+	w.wroteString = true
+	return w.ResponseRecorder.WriteString(s)
+}
+
+func (w *optimizedResponseWriter) ReadFrom(r io.Reader) (int64, error) {
+	w.readFrom = true
+	return io.Copy(w.ResponseRecorder, r)
+}
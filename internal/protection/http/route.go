@@ -0,0 +1,14 @@
+// Copyright (c) 2016 - 2020 Sqreen. All Rights Reserved.
+// Please refer to our terms for more information:
+// https://www.sqreen.io/terms.html
+
+//sqreen:ignore
+
+package http
+
+// SetRoute sets the route template of the request, such as `/users/:id`, so
+// that it is added to the request record. It takes precedence over the route
+// the middleware function may have got from the router.
+func (p *ProtectionContext) SetRoute(route string) {
+	p.requestReader.route = route
+}
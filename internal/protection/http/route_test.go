@@ -0,0 +1,81 @@
+// Copyright (c) 2016 - 2020 Sqreen. All Rights Reserved.
+// Please refer to our terms for more information:
+// https://www.sqreen.io/terms.html
+
+package http
+
+import (
+	"net"
+	"net/url"
+	"testing"
+
+	http_protection_mockups "github.com/sqreen/go-agent/internal/protection/http/_testlib/mockups"
+	"github.com/sqreen/go-agent/internal/protection/http/types"
+	middleware_mockups "github.com/sqreen/go-agent/sdk/middleware/_testlib/mockups"
+	"github.com/stretchr/testify/require"
+)
+
+// routeRequestReader is a request reader knowing the route of the request.
+type routeRequestReader struct {
+	*http_protection_mockups.RequestReaderMockup
+	route string
+}
+
+func (r routeRequestReader) Route() string { return r.route }
+
+func TestRoute(t *testing.T) {
+	newRequestReader := func(route string) routeRequestReader {
+		req := &http_protection_mockups.RequestReaderMockup{}
+		for _, method := range []string{"Method", "RequestURI", "Host", "RemoteAddr", "UserAgent", "Referer"} {
+			req.On(method).Return("").Maybe()
+		}
+		for _, method := range []string{"Headers", "URL", "QueryForm", "PostForm", "Params"} {
+			req.On(method).Return(nil).Maybe()
+		}
+		req.On("IsTLS").Return(false).Maybe()
+		return routeRequestReader{RequestReaderMockup: req, route: route}
+	}
+
+	t.Run("unknown route", func(t *testing.T) {
+		p := NewTestProtectionContext(nil, net.IPv4(1, 2, 3, 4), nil, &http_protection_mockups.RequestReaderMockup{})
+		require.Empty(t, p.requestReader.Route())
+		require.Empty(t, NewRequestBindingAccessorContext(p.RequestReader).Route())
+	})
+
+	t.Run("route of the request reader", func(t *testing.T) {
+		p := NewTestProtectionContext(nil, net.IPv4(1, 2, 3, 4), nil, newRequestReader("/users/:id"))
+		require.Equal(t, "/users/:id", p.requestReader.Route())
+		require.Equal(t, "/users/:id", NewRequestBindingAccessorContext(p.RequestReader).Route())
+
+		// The route is kept in the handled request
+		handled, ok := copyRequest(p.RequestReader).(types.RouteReader)
+		require.True(t, ok)
+		require.Equal(t, "/users/:id", handled.Route())
+	})
+
+	t.Run("set route", func(t *testing.T) {
+		p := NewTestProtectionContext(nil, net.IPv4(1, 2, 3, 4), nil, newRequestReader("/users/*"))
+		p.SetRoute("/users/{id}")
+		require.Equal(t, "/users/{id}", p.requestReader.Route())
+
+		handled, ok := copyRequest(p.RequestReader).(types.RouteReader)
+		require.True(t, ok)
+		require.Equal(t, "/users/{id}", handled.Route())
+	})
+
+	t.Run("route passlist", func(t *testing.T) {
+		u, err := url.Parse("https://test.com/users/1")
+		require.NoError(t, err)
+		req := &http_protection_mockups.RequestReaderMockup{}
+		req.ExpectURL().Return(u)
+		defer req.AssertExpectations(t)
+
+		r := &middleware_mockups.RootHTTPProtectionContextMockup{}
+		r.ExpectIsPathAllowed(u.Path).Return(false)
+		r.ExpectIsPathAllowed("/users/:id").Return(true)
+		defer r.AssertExpectations(t)
+
+		p := NewProtectionContext(r, &http_protection_mockups.ResponseWriterMockup{}, routeRequestReader{RequestReaderMockup: req, route: "/users/:id"})
+		require.Nil(t, p)
+	})
+}
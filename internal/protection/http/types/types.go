@@ -32,6 +32,11 @@ type RootProtectionContext interface {
 type ConfigReader interface {
 	HTTPClientIPHeader() string
 	HTTPClientIPHeaderFormat() string
+	HTTPTrustedProxies() []*net.IPNet
+	HTTPMaxBodyCaptureSize() int
+	BlockingHTMLTemplate() string
+	BlockingJSONTemplate() string
+	BlockingTextTemplate() string
 }
 
 // RequestReader is the read-only interface to the request.
@@ -78,11 +83,77 @@ func (m *RequestParamMap) Add(key string, value interface{}) {
 	(*m)[key] = append(params, value)
 }
 
+// GraphQLOperation is the GraphQL operation executed by a request.
+type GraphQLOperation struct {
+	// Type is either `query`, `mutation` or `subscription`.
+	Type string
+	// Name is the operation name, empty when the operation is anonymous.
+	Name string
+}
+
+// GraphQLOperationReader is the optional interface of the request readers
+// knowing the GraphQL operation executed by the request.
+type GraphQLOperationReader interface {
+	// GraphQLOperation returns the GraphQL operation of the request, nil when it
+	// is not a GraphQL request.
+	GraphQLOperation() *GraphQLOperation
+}
+
+// Upstream is the upstream server a request was forwarded to by a reverse
+// proxy.
+type Upstream struct {
+	// Target is the upstream URL scheme and host.
+	Target string
+	// Latency is the time the upstream server took to respond, until its
+	// response headers were received.
+	Latency time.Duration
+}
+
+// UpstreamReader is the optional interface of the request readers knowing the
+// upstream server the request was forwarded to.
+type UpstreamReader interface {
+	// Upstream returns the upstream server of the request, nil when it was not
+	// forwarded.
+	Upstream() *Upstream
+}
+
+// RouteReader is the optional interface of request readers knowing the route
+// template of the request, such as `/users/:id`.
+type RouteReader interface {
+	// Route returns the route template of the request, the empty string when
+	// it is unknown.
+	Route() string
+}
+
 // ResponseWriter is the response writer interface.
 type ResponseWriter interface {
 	http.ResponseWriter
 }
 
+// ResponseReader is the read-only interface to the response being written by
+// the handler, before it gets committed.
+type ResponseReader interface {
+	// Status returns the response status code.
+	Status() int
+	Headers() http.Header
+	Header(header string) (value *string)
+	// Body returns the response body prefix buffered before committing the
+	// response.
+	Body() []byte
+	// BodyTruncated returns true when the response body is larger than the
+	// prefix returned by Body().
+	BodyTruncated() bool
+}
+
+// ResponseBodyTailReader is the optional interface of the response readers
+// keeping track of the end of the response body written before the body chunk
+// being monitored, so that values written across several chunks can be found.
+type ResponseBodyTailReader interface {
+	// BodyTail returns the last bytes of the response body written before the
+	// monitored body chunk, up to a maximum length.
+	BodyTail() []byte
+}
+
 // ResponseFace is the interface to the response that was sent by the handler.
 type ResponseFace interface {
 	Status() int
@@ -97,4 +168,7 @@ type ClosedProtectionContextFace interface {
 	Start() time.Time
 	Duration() time.Duration
 	SqreenTime() time.Duration
+	// RequestID returns the request ID given to the client in the blocking
+	// response, or the empty string when no ID was given.
+	RequestID() string
 }
@@ -0,0 +1,39 @@
+// Copyright (c) 2016 - 2020 Sqreen. All Rights Reserved.
+// Please refer to our terms for more information:
+// https://www.sqreen.io/terms.html
+
+//sqreen:ignore
+
+package http
+
+import (
+	"time"
+
+	"github.com/sqreen/go-agent/internal/protection/http/types"
+)
+
+// StartUpstreamRequest records the upstream target the request is forwarded to
+// by a reverse proxy so that it is added to the request record, and starts
+// measuring the upstream latency.
+func (p *ProtectionContext) StartUpstreamRequest(target string) {
+	p.requestReader.upstream = &types.Upstream{Target: target}
+	p.upstreamStart = time.Now()
+}
+
+// EndUpstreamRequest records the upstream latency once the upstream response
+// headers were received, or once the upstream request failed. Only the first
+// call is taken into account.
+func (p *ProtectionContext) EndUpstreamRequest() {
+	if u := p.requestReader.upstream; u != nil && !p.upstreamStart.IsZero() {
+		u.Latency = time.Since(p.upstreamStart)
+		p.upstreamStart = time.Time{}
+	}
+}
+
+// Blocked returns true when the request was blocked, in which case the
+// blocking response was already written. Note that the protection context is
+// also canceled when the client connection is closed, in which case the
+// response can no longer be written either.
+func (p *ProtectionContext) Blocked() bool {
+	return p.Context().Err() != nil
+}
@@ -0,0 +1,18 @@
+// Copyright (c) 2016 - 2020 Sqreen. All Rights Reserved.
+// Please refer to our terms for more information:
+// https://www.sqreen.io/terms.html
+
+//sqreen:ignore
+
+package http
+
+// websocketMessageParamsKey is the request parameter name of the WebSocket
+// message being inspected.
+const websocketMessageParamsKey = "WebSocket Message"
+
+// WebSocketMessageWAF runs the request WAF with the given WebSocket text
+// message of the request connection. A non-nil error is returned when the
+// message must be blocked.
+func (p *ProtectionContext) WebSocketMessageWAF(message string) error {
+	return p.RequestParamWAF(websocketMessageParamsKey, message)
+}
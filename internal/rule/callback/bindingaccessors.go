@@ -8,6 +8,7 @@ package callback
 
 import (
 	"database/sql"
+	"database/sql/driver"
 	"reflect"
 	"strings"
 
@@ -85,7 +86,13 @@ func NewSQLBindingAccessorContext() *SQLBindingAccessorContextType {
 }
 
 func (*SQLBindingAccessorContextType) Dialect(db *sql.DB, dialects map[string]interface{}) (string, error) {
-	drv := sqsql.Unwrap(db.Driver())
+	return sqlDriverDialect(db.Driver(), dialects)
+}
+
+// sqlDriverDialect returns the dialect of the given driver according to the
+// map of dialects to driver package paths.
+func sqlDriverDialect(drv driver.Driver, dialects map[string]interface{}) (string, error) {
+	drv = sqsql.Unwrap(drv)
 	if drv == nil {
 		type errKey struct{}
 		return "", sqerrors.WithKey(sqerrors.New("unexpected nil SQL driver"), errKey{})
@@ -148,12 +155,16 @@ type BindingAccessorContextType struct {
 type WAFBindingAccessorContextType struct {
 	HTTPRequestBindingAccessorContext
 	BindingAccessorResultCache
+	// Response is the response being inspected by the response WAF.
+	Response *http_protection.ResponseBindingAccessorContext
 }
 
 func MakeWAFCallbackBindingAccessorContext(c CallbackContext) (WAFBindingAccessorContextType, error) {
 	switch protCtx := c.ProtectionContext().(type) {
 	case *http_protection.ProtectionContext:
-		return makeHTTPWAFCallbackBindingAccessorContext(protCtx.RequestReader), nil
+		ctx := makeHTTPWAFCallbackBindingAccessorContext(protCtx.RequestReader)
+		ctx.Response = http_protection.NewResponseBindingAccessorContext(protCtx.ResponseReader())
+		return ctx, nil
 	default:
 		return WAFBindingAccessorContextType{}, sqerrors.Errorf("unexpected protection context type `%T`", protCtx)
 	}
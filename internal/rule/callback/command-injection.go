@@ -0,0 +1,291 @@
+// Copyright (c) 2016 - 2020 Sqreen. All Rights Reserved.
+// Please refer to our terms for more information:
+// https://www.sqreen.io/terms.html
+
+//sqreen:ignore
+
+package callback
+
+import (
+	"errors"
+	"os"
+	"sort"
+	"strings"
+
+	"github.com/sqreen/go-agent/internal/event"
+	"github.com/sqreen/go-agent/internal/sqlib/sqassert"
+	"github.com/sqreen/go-agent/internal/sqlib/sqerrors"
+	"github.com/sqreen/go-agent/internal/sqlib/sqhook"
+	"github.com/sqreen/go-agent/sdk/types"
+)
+
+// commandInjectionMinUserInputLen is the minimum length of user inputs to look
+// for in command lines.
+const commandInjectionMinUserInputLen = 2
+
+// Reasons of command injection attacks.
+const (
+	CommandInjectionReasonShell   = "shell_injection"
+	CommandInjectionReasonOption  = "option_injection"
+	CommandInjectionReasonProgram = "program_injection"
+)
+
+var ErrCommandInjectionProtection = errors.New("command injection protection triggered")
+
+// Shell programs whose command-line is given with option `-c`.
+var commandInjectionShells = map[string]struct{}{
+	"sh":      {},
+	"bash":    {},
+	"dash":    {},
+	"zsh":     {},
+	"ksh":     {},
+	"ash":     {},
+	"busybox": {},
+}
+
+// NewCommandInjectionCallback returns the native prolog callback protecting
+// against command injections by hooking `os.StartProcess`, the same hookpoint
+// as the Shellshock callback.
+func NewCommandInjectionCallback(r RuleContext, _ NativeCallbackConfig) (sqhook.PrologCallback, error) {
+	sqassert.NotNil(r)
+	return newCommandInjectionPrologCallback(r), nil
+}
+
+type (
+	CommandInjectionPrologCallbackType = ShellshockPrologCallbackType
+	CommandInjectionEpilogCallbackType = ShellshockEpilogCallbackType
+)
+
+type CommandInjectionAttackInfo struct {
+	Argv []string `json:"argv"`
+	// Command is the shell command-line when the program is a shell.
+	Command string `json:"command,omitempty"`
+	Reason  string `json:"reason"`
+	UserInput
+}
+
+func newCommandInjectionPrologCallback(r RuleContext) CommandInjectionPrologCallbackType {
+	return func(name *string, argv *[]string, _ **os.ProcAttr) (epilog CommandInjectionEpilogCallbackType, prologErr error) {
+		r.Pre(func(c CallbackContext) error {
+			reader, err := requestReaderFromProtectionContext(c.ProtectionContext())
+			if err != nil {
+				type errKey struct{}
+				return sqerrors.WithKey(err, errKey{})
+			}
+
+			command := shellCommand(*name, *argv)
+			var shellTokens []shellToken
+			var reason string
+			input, found := findUserInput(reader, func(value string) bool {
+				if len(value) < commandInjectionMinUserInputLen {
+					return false
+				}
+				if command != "" && strings.Contains(command, value) {
+					if shellTokens == nil {
+						shellTokens = tokenizeShellCommand(command)
+					}
+					if isShellInjection(command, shellTokens, value) {
+						reason = CommandInjectionReasonShell
+						return true
+					}
+				}
+				reason = checkArgvInjection(*name, *argv, value)
+				return reason != ""
+			})
+			if !found {
+				return nil
+			}
+
+			info := CommandInjectionAttackInfo{
+				Argv:      *argv,
+				Command:   command,
+				Reason:    reason,
+				UserInput: input,
+			}
+			if blocked := c.HandleAttack(true, event.WithAttackInfo(info), event.WithStackTrace()); blocked {
+				epilog = func(_ **os.Process, callErr *error) {
+					*callErr = types.SqreenError{Err: ErrCommandInjectionProtection}
+				}
+				prologErr = sqhook.AbortError
+			}
+			return nil
+		})
+		return
+	}
+}
+
+// shellCommand returns the command-line executed by the shell when the program
+// is a shell executing a command-line with `-c` (or `/c` for cmd.exe), and the
+// empty string otherwise.
+func shellCommand(name string, argv []string) string {
+	if len(argv) < 2 {
+		return ""
+	}
+	program := programName(name)
+	if program == "" {
+		program = programName(argv[0])
+	}
+
+	if program == "cmd" {
+		for i, arg := range argv[1:] {
+			if strings.EqualFold(arg, "/c") || strings.EqualFold(arg, "/k") {
+				return strings.Join(argv[i+2:], " ")
+			}
+		}
+		return ""
+	}
+
+	if _, isShell := commandInjectionShells[program]; !isShell {
+		return ""
+	}
+	for i, arg := range argv[1 : len(argv)-1] {
+		if len(arg) > 1 && arg[0] == '-' && arg[1] != '-' && strings.ContainsRune(arg, 'c') {
+			return argv[i+2]
+		}
+	}
+	return ""
+}
+
+// programName returns the lowercase base name of the program path without its
+// `.exe` extension. Both path separators are handled so that Windows paths are
+// also supported when analyzed on other systems.
+func programName(path string) string {
+	if i := strings.LastIndexAny(path, `/\`); i != -1 {
+		path = path[i+1:]
+	}
+	return strings.TrimSuffix(strings.ToLower(path), ".exe")
+}
+
+// checkArgvInjection returns the reason why the user input in the argument
+// vector is an attack, or the empty string when it is not.
+func checkArgvInjection(name string, argv []string, input string) (reason string) {
+	if input == name || len(argv) > 0 && input == argv[0] {
+		return CommandInjectionReasonProgram
+	}
+	// Options start with a dash followed by a letter or another dash, unlike
+	// negative numbers.
+	if input[0] != '-' || !(input[1] == '-' || 'a' <= input[1] && input[1] <= 'z' || 'A' <= input[1] && input[1] <= 'Z') {
+		return ""
+	}
+	if len(argv) < 2 {
+		return ""
+	}
+	for _, arg := range argv[1:] {
+		if strings.HasPrefix(arg, input) {
+			return CommandInjectionReasonOption
+		}
+	}
+	return ""
+}
+
+type shellTokenKind int
+
+const (
+	shellTokenWord shellTokenKind = iota
+	shellTokenOperator
+)
+
+type shellToken struct {
+	kind       shellTokenKind
+	start, end int
+	// expansions are the positions of command substitutions and parameter
+	// expansions in the word.
+	expansions []int
+}
+
+// tokenizeShellCommand splits the shell command-line into words and control
+// or redirection operators, following the POSIX shell quoting rules.
+func tokenizeShellCommand(cmd string) (tokens []shellToken) {
+	var (
+		word   *shellToken
+		quote  byte
+		escape bool
+	)
+	endWord := func(pos int) {
+		if word != nil {
+			word.end = pos
+			tokens = append(tokens, *word)
+			word = nil
+		}
+	}
+
+	for i := 0; i < len(cmd); i++ {
+		c := cmd[i]
+		switch {
+		case escape:
+			escape = false
+		case quote == '\'':
+			if c == '\'' {
+				quote = 0
+			}
+		case c == '\\':
+			escape = true
+		case quote == '"' && c == '"':
+			quote = 0
+		case c == '$' && i+1 < len(cmd) && (cmd[i+1] == '(' || cmd[i+1] == '{' || isShellNameChar(cmd[i+1])), c == '`':
+			if word == nil {
+				word = &shellToken{kind: shellTokenWord, start: i}
+			}
+			word.expansions = append(word.expansions, i)
+			continue
+		case quote == '"':
+		case c == '\'' || c == '"':
+			quote = c
+		case c == ' ' || c == '\t':
+			endWord(i)
+			continue
+		case strings.IndexByte(";&|<>()\n", c) != -1:
+			endWord(i)
+			start := i
+			// Group two-character operators such as `&&`, `||` or `>>`
+			if i+1 < len(cmd) && strings.IndexByte("&|<>", c) != -1 && cmd[i+1] == c {
+				i++
+			}
+			tokens = append(tokens, shellToken{kind: shellTokenOperator, start: start, end: i + 1})
+			continue
+		}
+		if word == nil {
+			word = &shellToken{kind: shellTokenWord, start: i}
+		}
+	}
+	endWord(len(cmd))
+	return tokens
+}
+
+func isShellNameChar(c byte) bool {
+	return 'a' <= c && c <= 'z' || 'A' <= c && c <= 'Z' || '0' <= c && c <= '9' || c == '_'
+}
+
+// isShellInjection returns true when an occurrence of the user input in the
+// shell command-line spans several tokens, is an operator, or contains a
+// command substitution or parameter expansion.
+func isShellInjection(cmd string, tokens []shellToken, input string) bool {
+	for offset := 0; offset < len(cmd); {
+		i := strings.Index(cmd[offset:], input)
+		if i == -1 {
+			return false
+		}
+		start := offset + i
+		end := start + len(input)
+		offset = start + 1
+
+		first := sort.Search(len(tokens), func(i int) bool { return tokens[i].end > start })
+		if first == len(tokens) || tokens[first].start >= end {
+			// Only whitespaces
+			continue
+		}
+		if first+1 < len(tokens) && tokens[first+1].start < end {
+			return true
+		}
+		tok := tokens[first]
+		if tok.kind == shellTokenOperator {
+			return true
+		}
+		for _, pos := range tok.expansions {
+			if start <= pos && pos < end {
+				return true
+			}
+		}
+	}
+	return false
+}
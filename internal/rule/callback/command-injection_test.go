@@ -0,0 +1,159 @@
+// Copyright (c) 2016 - 2020 Sqreen. All Rights Reserved.
+// Please refer to our terms for more information:
+// https://www.sqreen.io/terms.html
+
+package callback_test
+
+import (
+	"errors"
+	"net/url"
+	"os"
+	"testing"
+
+	"github.com/sqreen/go-agent/internal/event"
+	http_protection "github.com/sqreen/go-agent/internal/protection/http"
+	http_protection_mockups "github.com/sqreen/go-agent/internal/protection/http/_testlib/mockups"
+	"github.com/sqreen/go-agent/internal/rule/callback"
+	"github.com/sqreen/go-agent/internal/rule/callback/_testlib/mockups"
+	"github.com/sqreen/go-agent/internal/sqlib/sqhook"
+	sdk_types "github.com/sqreen/go-agent/sdk/types"
+	"github.com/stretchr/testify/mock"
+	"github.com/stretchr/testify/require"
+)
+
+func TestCommandInjectionCallback(t *testing.T) {
+	for _, tc := range []struct {
+		name      string
+		program   string
+		argv      []string
+		params    url.Values
+		reason    string
+		parameter string
+	}{
+		{
+			name:      "shell command separator",
+			program:   "/bin/sh",
+			argv:      []string{"sh", "-c", "ping -c 1 example.com; cat /etc/passwd"},
+			params:    url.Values{"host": []string{"example.com; cat /etc/passwd"}},
+			reason:    callback.CommandInjectionReasonShell,
+			parameter: "QueryForm.host",
+		},
+		{
+			name:      "shell command substitution in double quotes",
+			program:   "/bin/bash",
+			argv:      []string{"bash", "-ec", `echo "$(id)"`},
+			params:    url.Values{"msg": []string{"$(id)"}},
+			reason:    callback.CommandInjectionReasonShell,
+			parameter: "QueryForm.msg",
+		},
+		{
+			name:      "shell pipe",
+			program:   "/bin/sh",
+			argv:      []string{"sh", "-c", "grep x || reboot"},
+			params:    url.Values{"q": []string{"||"}},
+			reason:    callback.CommandInjectionReasonShell,
+			parameter: "QueryForm.q",
+		},
+		{
+			name:      "cmd.exe command separator",
+			program:   `C:\Windows\System32\cmd.exe`,
+			argv:      []string{"cmd.exe", "/C", "dir", "x & del y"},
+			params:    url.Values{"dir": []string{"x & del y"}},
+			reason:    callback.CommandInjectionReasonShell,
+			parameter: "QueryForm.dir",
+		},
+		{
+			name:      "option injection",
+			program:   "/usr/bin/curl",
+			argv:      []string{"curl", "--output=/etc/cron.d/x", "http://example.com"},
+			params:    url.Values{"url": []string{"--output=/etc/cron.d/x"}},
+			reason:    callback.CommandInjectionReasonOption,
+			parameter: "QueryForm.url",
+		},
+		{
+			name:      "program injection",
+			program:   "/usr/bin/reboot",
+			argv:      []string{"/usr/bin/reboot"},
+			params:    url.Values{"cmd": []string{"/usr/bin/reboot"}},
+			reason:    callback.CommandInjectionReasonProgram,
+			parameter: "QueryForm.cmd",
+		},
+		{
+			name:    "single-quoted shell argument",
+			program: "/bin/sh",
+			argv:    []string{"sh", "-c", "ping -c 1 'example.com; cat /etc/passwd'"},
+			params:  url.Values{"host": []string{"example.com; cat /etc/passwd"}},
+		},
+		{
+			name:    "shell argument",
+			program: "/bin/sh",
+			argv:    []string{"sh", "-c", "ping -c 1 example.com"},
+			params:  url.Values{"host": []string{"example.com"}},
+		},
+		{
+			name:    "regular argument",
+			program: "/usr/bin/convert",
+			argv:    []string{"convert", "in.png; reboot", "out.jpg"},
+			params:  url.Values{"file": []string{"in.png; reboot"}, "offset": []string{"-1"}},
+		},
+		{
+			name:    "nil argument vector",
+			program: "/bin/rm",
+			params:  url.Values{"opt": []string{"-rf"}},
+		},
+		{
+			name:    "empty argument vector",
+			program: "/bin/rm",
+			argv:    []string{},
+			params:  url.Values{"opt": []string{"-rf"}},
+		},
+	} {
+		tc := tc
+		t.Run(tc.name, func(t *testing.T) {
+			reader := &http_protection_mockups.RequestReaderMockup{}
+			reader.ExpectQueryForm().Return(tc.params)
+			reader.ExpectPostForm().Return(url.Values(nil))
+			reader.ExpectParams().Return(nil)
+			p := &http_protection.ProtectionContext{RequestReader: reader}
+
+			r := &mockups.NativeRuleContextMockup{}
+			defer r.AssertExpectations(t)
+
+			cb, err := callback.NewCommandInjectionCallback(r, &mockups.NativeCallbackConfigMockup{})
+			require.NoError(t, err)
+			prolog, ok := cb.(callback.CommandInjectionPrologCallbackType)
+			require.True(t, ok)
+
+			attack := tc.reason != ""
+			r.ExpectPre(mock.MatchedBy(func(cb func(c callback.CallbackContext) error) bool {
+				c := &mockups.CallbackContextMockup{}
+				defer c.AssertExpectations(t)
+				c.ExpectProtectionContext().Return(p)
+				if attack {
+					c.ExpectHandleAttack(true, mock.MatchedBy(func(opts []event.AttackEventOption) bool {
+						var attack event.AttackEvent
+						for _, opt := range opts {
+							opt(&attack)
+						}
+						info, ok := attack.Info.(callback.CommandInjectionAttackInfo)
+						return ok && info.Parameter == tc.parameter && info.Reason == tc.reason && len(info.Argv) == len(tc.argv)
+					})).Return(true).Once()
+				}
+				require.NoError(t, cb(c))
+				return true
+			})).Once()
+
+			attr := &os.ProcAttr{}
+			epilog, err := prolog(&tc.program, &tc.argv, &attr)
+			if !attack {
+				require.NoError(t, err)
+				require.Nil(t, epilog)
+				return
+			}
+			require.Equal(t, sqhook.AbortError, err)
+			var callErr error
+			epilog(nil, &callErr)
+			require.True(t, errors.As(callErr, &sdk_types.SqreenError{}))
+		})
+	}
+}
@@ -0,0 +1,301 @@
+// Copyright (c) 2016 - 2020 Sqreen. All Rights Reserved.
+// Please refer to our terms for more information:
+// https://www.sqreen.io/terms.html
+
+//sqreen:ignore
+
+package callback
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"reflect"
+	"strings"
+
+	bindingaccessor "github.com/sqreen/go-agent/internal/binding-accessor"
+	"github.com/sqreen/go-agent/internal/event"
+	http_protection_types "github.com/sqreen/go-agent/internal/protection/http/types"
+	"github.com/sqreen/go-agent/internal/sqlib/sqassert"
+	"github.com/sqreen/go-agent/internal/sqlib/sqerrors"
+	"github.com/sqreen/go-agent/internal/sqlib/sqhook"
+	"github.com/sqreen/go-agent/sdk/types"
+)
+
+var ErrNoSQLInjectionProtection = errors.New("nosql injection protection triggered")
+
+// NewNoSQLInjectionCallback returns the prolog callback protecting against
+// MongoDB operator injections by hooking the bson transformation function of
+// package `go.mongodb.org/mongo-driver/mongo`. The callback is reflected so
+// that it doesn't depend on the driver types and versions: the transformed
+// value is the first argument of type `interface{}`, unless the rule data
+// provides its index under the `argument_index` key.
+func NewNoSQLInjectionCallback(r RuleContext, cfg NativeCallbackConfig) (sqhook.PrologCallback, error) {
+	sqassert.NotNil(r)
+	sqassert.NotNil(cfg)
+
+	argIndex := -1
+	switch data := cfg.Data().(type) {
+	case nil:
+	case map[string]interface{}:
+		if v, exists := data["argument_index"]; exists {
+			index, ok := v.(float64)
+			if !ok || index < 0 || index != float64(int(index)) {
+				return nil, sqerrors.Errorf("unexpected argument index value `%v`", v)
+			}
+			argIndex = int(index)
+		}
+	default:
+		return nil, sqerrors.Errorf("unexpected callback data type: got `%T` instead of `%T`", data, map[string]interface{}{})
+	}
+
+	return newNoSQLInjectionPrologCallback(r, argIndex), nil
+}
+
+type NoSQLInjectionAttackInfo struct {
+	// Operator is the MongoDB query operator found in the user input.
+	Operator string `json:"operator"`
+	UserInput
+}
+
+func newNoSQLInjectionPrologCallback(r RuleContext, argIndex int) sqhook.ReflectedPrologCallback {
+	return func(params []reflect.Value) (epilog sqhook.ReflectedEpilogCallback, prologErr error) {
+		r.Pre(func(c CallbackContext) error {
+			arg, err := noSQLQueryArgument(params, argIndex)
+			if err != nil {
+				type errKey struct{}
+				return sqerrors.WithKey(err, errKey{})
+			}
+
+			reader, err := requestReaderFromProtectionContext(c.ProtectionContext())
+			if err != nil {
+				type errKey struct{}
+				return sqerrors.WithKey(err, errKey{})
+			}
+
+			info, found := findNoSQLInjection(reader, arg)
+			if !found {
+				return nil
+			}
+
+			if blocked := c.HandleAttack(true, event.WithAttackInfo(info), event.WithStackTrace()); blocked {
+				epilog = func(results []reflect.Value) {
+					setErrorResult(results, types.SqreenError{Err: ErrNoSQLInjectionProtection})
+				}
+				prologErr = sqhook.AbortError
+			}
+			return nil
+		})
+		return
+	}
+}
+
+// noSQLQueryArgument returns the value of the transformed argument.
+func noSQLQueryArgument(params []reflect.Value, argIndex int) (reflect.Value, error) {
+	if argIndex >= 0 {
+		if argIndex >= len(params) {
+			return reflect.Value{}, sqerrors.Errorf("argument index `%d` out of range", argIndex)
+		}
+		return params[argIndex].Elem(), nil
+	}
+	for _, param := range params {
+		if arg := param.Elem(); arg.Kind() == reflect.Interface && arg.NumMethod() == 0 {
+			return arg, nil
+		}
+	}
+	return reflect.Value{}, sqerrors.New("could not find any argument of type `interface{}`")
+}
+
+// setErrorResult sets the last error result to the given error.
+func setErrorResult(results []reflect.Value, err error) {
+	errorType := reflect.TypeOf((*error)(nil)).Elem()
+	for i := len(results) - 1; i >= 0; i-- {
+		if res := results[i].Elem(); res.Type() == errorType {
+			res.Set(reflect.ValueOf(err))
+			return
+		}
+	}
+}
+
+// noSQLOperatorMap is a map value having a MongoDB operator key.
+type noSQLOperatorMap struct {
+	name     string
+	operator string
+	value    reflect.Value
+}
+
+// findNoSQLInjection returns the attack information when the query value
+// contains a map of operators coming from the request parameters.
+func findNoSQLInjection(r http_protection_types.RequestReader, query reflect.Value) (info NoSQLInjectionAttackInfo, found bool) {
+	params := r.Params()
+	var userMaps []noSQLOperatorMap
+	maxElements := bindingaccessor.NewValueMaxElements
+	for _, name := range sortedKeys(params) {
+		walkNoSQLOperatorMaps(name, reflect.ValueOf(params[name]), bindingaccessor.MaxExecutionDepth, &maxElements, func(m noSQLOperatorMap) bool {
+			userMaps = append(userMaps, m)
+			return false
+		})
+	}
+	if len(userMaps) == 0 {
+		return NoSQLInjectionAttackInfo{}, false
+	}
+
+	maxElements = bindingaccessor.NewValueMaxElements
+	walkNoSQLOperatorMaps("", query, bindingaccessor.MaxExecutionDepth, &maxElements, func(m noSQLOperatorMap) bool {
+		for _, userMap := range userMaps {
+			if sameValue(m.value, userMap.value, bindingaccessor.MaxExecutionDepth) {
+				info = NoSQLInjectionAttackInfo{
+					Operator: userMap.operator,
+					UserInput: UserInput{
+						Parameter: userMap.name,
+						Value:     formatNoSQLValue(userMap.value),
+					},
+				}
+				found = true
+				return true
+			}
+		}
+		return false
+	})
+	return info, found
+}
+
+// walkNoSQLOperatorMaps calls the visitor function for every map having an
+// operator key, until it returns true. Besides maps, slices and pointers, it
+// walks through structs in order to handle bson documents such as `bson.D`,
+// slices of key-value structs.
+func walkNoSQLOperatorMaps(name string, v reflect.Value, depth int, maxElements *int, visit func(noSQLOperatorMap) bool) (stop bool) {
+	if depth <= 0 || *maxElements <= 0 || !v.IsValid() {
+		return false
+	}
+	*maxElements--
+
+	switch v.Kind() {
+	case reflect.Ptr, reflect.Interface:
+		if v.IsNil() {
+			return false
+		}
+		return walkNoSQLOperatorMaps(name, v.Elem(), depth, maxElements, visit)
+
+	case reflect.Slice, reflect.Array:
+		if v.Type().Elem().Kind() == reflect.Uint8 {
+			return false
+		}
+		for i := 0; i < v.Len(); i++ {
+			elemName := name
+			if v.Len() > 1 {
+				elemName = joinParamName(name, fmt.Sprint(i))
+			}
+			if walkNoSQLOperatorMaps(elemName, v.Index(i), depth-1, maxElements, visit) {
+				return true
+			}
+		}
+		return false
+
+	case reflect.Map:
+		if v.Type().Key().Kind() != reflect.String {
+			return false
+		}
+		for _, k := range sortedKeys(v.Interface()) {
+			if strings.HasPrefix(k, "$") {
+				if visit(noSQLOperatorMap{name: name, operator: k, value: v}) {
+					return true
+				}
+				break
+			}
+		}
+		for _, k := range sortedKeys(v.Interface()) {
+			if walkNoSQLOperatorMaps(joinParamName(name, k), v.MapIndex(reflect.ValueOf(k).Convert(v.Type().Key())), depth-1, maxElements, visit) {
+				return true
+			}
+		}
+		return false
+
+	case reflect.Struct:
+		for i := 0; i < v.NumField(); i++ {
+			if v.Type().Field(i).PkgPath != "" {
+				// Unexported field
+				continue
+			}
+			if walkNoSQLOperatorMaps(name, v.Field(i), depth-1, maxElements, visit) {
+				return true
+			}
+		}
+		return false
+
+	default:
+		return false
+	}
+}
+
+func joinParamName(name, key string) string {
+	if name == "" {
+		return key
+	}
+	return name + "." + key
+}
+
+// sameValue returns true when both values are the same map, or maps having
+// the same content regardless of their types, such as a `bson.M` copy of a
+// `map[string]interface{}` value.
+func sameValue(a, b reflect.Value, depth int) bool {
+	for a.Kind() == reflect.Interface || a.Kind() == reflect.Ptr {
+		if a.IsNil() {
+			break
+		}
+		a = a.Elem()
+	}
+	for b.Kind() == reflect.Interface || b.Kind() == reflect.Ptr {
+		if b.IsNil() {
+			break
+		}
+		b = b.Elem()
+	}
+	if !a.IsValid() || !b.IsValid() {
+		return a.IsValid() == b.IsValid()
+	}
+	if depth <= 0 || a.Kind() != b.Kind() {
+		return false
+	}
+
+	switch a.Kind() {
+	case reflect.Map:
+		if a.Pointer() == b.Pointer() {
+			return true
+		}
+		if a.Len() != b.Len() || a.Type().Key().Kind() != reflect.String || b.Type().Key().Kind() != reflect.String {
+			return false
+		}
+		for _, k := range a.MapKeys() {
+			bv := b.MapIndex(reflect.ValueOf(k.String()).Convert(b.Type().Key()))
+			if !bv.IsValid() || !sameValue(a.MapIndex(k), bv, depth-1) {
+				return false
+			}
+		}
+		return true
+
+	case reflect.Slice, reflect.Array:
+		if a.Len() != b.Len() {
+			return false
+		}
+		for i := 0; i < a.Len(); i++ {
+			if !sameValue(a.Index(i), b.Index(i), depth-1) {
+				return false
+			}
+		}
+		return true
+
+	default:
+		if a.Type() != b.Type() || !a.Type().Comparable() {
+			return false
+		}
+		return a.Interface() == b.Interface()
+	}
+}
+
+func formatNoSQLValue(v reflect.Value) string {
+	if buf, err := json.Marshal(v.Interface()); err == nil {
+		return string(buf)
+	}
+	return fmt.Sprint(v.Interface())
+}
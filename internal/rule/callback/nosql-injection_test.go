@@ -0,0 +1,162 @@
+// Copyright (c) 2016 - 2020 Sqreen. All Rights Reserved.
+// Please refer to our terms for more information:
+// https://www.sqreen.io/terms.html
+
+package callback_test
+
+import (
+	"errors"
+	"net/url"
+	"reflect"
+	"testing"
+
+	"github.com/sqreen/go-agent/internal/event"
+	http_protection "github.com/sqreen/go-agent/internal/protection/http"
+	http_protection_mockups "github.com/sqreen/go-agent/internal/protection/http/_testlib/mockups"
+	http_protection_types "github.com/sqreen/go-agent/internal/protection/http/types"
+	"github.com/sqreen/go-agent/internal/rule/callback"
+	"github.com/sqreen/go-agent/internal/rule/callback/_testlib/mockups"
+	"github.com/sqreen/go-agent/internal/sqlib/sqhook"
+	sdk_types "github.com/sqreen/go-agent/sdk/types"
+	"github.com/stretchr/testify/mock"
+	"github.com/stretchr/testify/require"
+)
+
+// Local types similar to the mongo driver bson documents.
+type (
+	nosqlFakeM map[string]interface{}
+	nosqlFakeE struct {
+		Key   string
+		Value interface{}
+	}
+	nosqlFakeD []nosqlFakeE
+)
+
+func TestNoSQLInjectionCallback(t *testing.T) {
+	t.Run("Constructor", func(t *testing.T) {
+		for _, data := range []interface{}{
+			33,
+			map[string]interface{}{"argument_index": "1"},
+			map[string]interface{}{"argument_index": -1.0},
+			map[string]interface{}{"argument_index": 1.5},
+		} {
+			cfg := &mockups.NativeCallbackConfigMockup{}
+			cfg.ExpectData().Return(data)
+			_, err := callback.NewNoSQLInjectionCallback(&mockups.NativeRuleContextMockup{}, cfg)
+			require.Error(t, err)
+		}
+	})
+
+	userPassword := map[string]interface{}{"$ne": nil}
+	jsonBody := map[string]interface{}{
+		"username": "admin",
+		"password": userPassword,
+	}
+
+	for _, tc := range []struct {
+		name      string
+		data      interface{}
+		filter    interface{}
+		params    interface{}
+		operator  string
+		parameter string
+	}{
+		{
+			name:      "user map in a bson map",
+			filter:    nosqlFakeM{"username": "admin", "password": userPassword},
+			params:    jsonBody,
+			operator:  "$ne",
+			parameter: "json.password",
+		},
+		{
+			name:      "user map in a bson document",
+			data:      map[string]interface{}{"argument_index": 1.0},
+			filter:    nosqlFakeD{{Key: "username", Value: "admin"}, {Key: "password", Value: userPassword}},
+			params:    jsonBody,
+			operator:  "$ne",
+			parameter: "json.password",
+		},
+		{
+			name:      "copy of the user map",
+			filter:    nosqlFakeM{"age": nosqlFakeM{"$gt": 18.0}},
+			params:    map[string]interface{}{"age": map[string]interface{}{"$gt": 18.0}},
+			operator:  "$gt",
+			parameter: "json.age",
+		},
+		{
+			name:   "operators from the application",
+			filter: nosqlFakeM{"username": "admin", "age": nosqlFakeM{"$gt": 18.0}},
+			params: map[string]interface{}{"username": "admin"},
+		},
+		{
+			name:   "user map not in the filter",
+			filter: nosqlFakeM{"username": "admin"},
+			params: jsonBody,
+		},
+	} {
+		tc := tc
+		t.Run(tc.name, func(t *testing.T) {
+			reader := &http_protection_mockups.RequestReaderMockup{}
+			reader.ExpectQueryForm().Return(url.Values(nil)).Maybe()
+			reader.ExpectPostForm().Return(url.Values(nil)).Maybe()
+			reader.ExpectParams().Return(http_protection_types.RequestParamMap{"json": {tc.params}})
+			p := &http_protection.ProtectionContext{RequestReader: reader}
+
+			r := &mockups.NativeRuleContextMockup{}
+			defer r.AssertExpectations(t)
+
+			cfg := &mockups.NativeCallbackConfigMockup{}
+			cfg.ExpectData().Return(tc.data)
+			cb, err := callback.NewNoSQLInjectionCallback(r, cfg)
+			require.NoError(t, err)
+			prolog, ok := cb.(sqhook.ReflectedPrologCallback)
+			require.True(t, ok)
+
+			attack := tc.operator != ""
+			r.ExpectPre(mock.MatchedBy(func(cb func(c callback.CallbackContext) error) bool {
+				c := &mockups.CallbackContextMockup{}
+				defer c.AssertExpectations(t)
+				c.ExpectProtectionContext().Return(p)
+				if attack {
+					c.ExpectHandleAttack(true, mock.MatchedBy(func(opts []event.AttackEventOption) bool {
+						var attack event.AttackEvent
+						for _, opt := range opts {
+							opt(&attack)
+						}
+						info, ok := attack.Info.(callback.NoSQLInjectionAttackInfo)
+						return ok && info.Parameter == tc.parameter && info.Operator == tc.operator
+					})).Return(true).Once()
+				}
+				require.NoError(t, cb(c))
+				return true
+			}))
+
+			// Call the prolog like the hook does, with pointers to the arguments.
+			var (
+				registry   *struct{}
+				filter     = tc.filter
+				mapAllowed = true
+				paramName  = "filter"
+			)
+			epilog, err := prolog([]reflect.Value{
+				reflect.ValueOf(&registry),
+				reflect.ValueOf(&filter),
+				reflect.ValueOf(&mapAllowed),
+				reflect.ValueOf(&paramName),
+			})
+			if !attack {
+				require.NoError(t, err)
+				require.Nil(t, epilog)
+				return
+			}
+			require.Equal(t, sqhook.AbortError, err)
+
+			var (
+				doc     []byte
+				callErr error
+			)
+			epilog([]reflect.Value{reflect.ValueOf(&doc), reflect.ValueOf(&callErr)})
+			require.True(t, errors.As(callErr, &sdk_types.SqreenError{}))
+		})
+	}
+}
@@ -0,0 +1,164 @@
+// Copyright (c) 2016 - 2020 Sqreen. All Rights Reserved.
+// Please refer to our terms for more information:
+// https://www.sqreen.io/terms.html
+
+//sqreen:ignore
+
+package callback
+
+import (
+	"errors"
+	"net"
+	"net/url"
+	"reflect"
+	"strings"
+
+	"github.com/sqreen/go-agent/internal/event"
+	"github.com/sqreen/go-agent/internal/sqlib/sqassert"
+	"github.com/sqreen/go-agent/internal/sqlib/sqerrors"
+	"github.com/sqreen/go-agent/internal/sqlib/sqhook"
+	"github.com/sqreen/go-agent/sdk/types"
+)
+
+var ErrOpenRedirectProtection = errors.New("open redirect protection triggered")
+
+// NewOpenRedirectCallback returns the prolog callback protecting against open
+// redirects by hooking redirection functions such as `net/http.Redirect`,
+// Gin's `Context.Redirect` or Echo's `Context.Redirect`. The callback is
+// reflected so that it doesn't depend on the framework types: the location is
+// the first argument of type `string`. Redirections to the request host are
+// always allowed, and the rule data can provide the list of other allowed
+// hosts under the `allowed_hosts` key, where `*.` prefixes allow every
+// subdomain.
+func NewOpenRedirectCallback(r RuleContext, cfg NativeCallbackConfig) (sqhook.PrologCallback, error) {
+	sqassert.NotNil(r)
+	sqassert.NotNil(cfg)
+
+	var allowedHosts []string
+	switch data := cfg.Data().(type) {
+	case nil:
+	case map[string]interface{}:
+		if v, exists := data["allowed_hosts"]; exists {
+			hosts, ok := v.([]interface{})
+			if !ok {
+				return nil, sqerrors.Errorf("unexpected allowed hosts type: got `%T` instead of `%T`", v, hosts)
+			}
+			for _, host := range hosts {
+				host, ok := host.(string)
+				if !ok || host == "" || host == "*." {
+					return nil, sqerrors.Errorf("unexpected allowed host value `%v`", host)
+				}
+				allowedHosts = append(allowedHosts, strings.ToLower(host))
+			}
+		}
+	default:
+		return nil, sqerrors.Errorf("unexpected callback data type: got `%T` instead of `%T`", data, map[string]interface{}{})
+	}
+
+	return newOpenRedirectPrologCallback(r, allowedHosts), nil
+}
+
+type OpenRedirectAttackInfo struct {
+	Location string `json:"location"`
+	Host     string `json:"host"`
+	UserInput
+}
+
+func newOpenRedirectPrologCallback(r RuleContext, allowedHosts []string) sqhook.ReflectedPrologCallback {
+	return func(params []reflect.Value) (epilog sqhook.ReflectedEpilogCallback, prologErr error) {
+		r.Pre(func(c CallbackContext) error {
+			location, err := redirectLocationArgument(params)
+			if err != nil {
+				type errKey struct{}
+				return sqerrors.WithKey(err, errKey{})
+			}
+
+			host := externalRedirectHost(location)
+			if host == "" {
+				return nil
+			}
+
+			reader, err := requestReaderFromProtectionContext(c.ProtectionContext())
+			if err != nil {
+				type errKey struct{}
+				return sqerrors.WithKey(err, errKey{})
+			}
+
+			if isAllowedRedirectHost(host, reader.Host(), allowedHosts) {
+				return nil
+			}
+
+			input, found := findUserInput(reader, func(value string) bool {
+				return strings.Contains(location, value) && strings.Contains(strings.ToLower(value), host)
+			})
+			if !found {
+				return nil
+			}
+
+			info := OpenRedirectAttackInfo{
+				Location:  location,
+				Host:      host,
+				UserInput: input,
+			}
+			if blocked := c.HandleAttack(true, event.WithAttackInfo(info), event.WithStackTrace()); blocked {
+				epilog = func(results []reflect.Value) {
+					setErrorResult(results, types.SqreenError{Err: ErrOpenRedirectProtection})
+				}
+				prologErr = sqhook.AbortError
+			}
+			return nil
+		})
+		return
+	}
+}
+
+// redirectLocationArgument returns the value of the first string argument.
+func redirectLocationArgument(params []reflect.Value) (string, error) {
+	for _, param := range params {
+		if arg := param.Elem(); arg.Kind() == reflect.String {
+			return arg.String(), nil
+		}
+	}
+	return "", sqerrors.New("could not find any argument of type `string`")
+}
+
+// externalRedirectHost returns the lowercase host name of absolute and
+// protocol-relative locations, and the empty string otherwise. Backslashes are
+// handled like slashes, as browsers do.
+func externalRedirectHost(location string) string {
+	location = strings.TrimLeft(location, " \t\r\n")
+	location = strings.ReplaceAll(location, `\`, "/")
+	if !strings.HasPrefix(location, "//") && !strings.Contains(location, "://") {
+		return ""
+	}
+	if strings.HasPrefix(location, "//") {
+		// Protocol-relative location
+		location = "http:" + location
+	}
+	u, err := url.Parse(location)
+	if err != nil {
+		return ""
+	}
+	return strings.ToLower(u.Hostname())
+}
+
+// isAllowedRedirectHost returns true when the host is the request host or one
+// of the allowed hosts.
+func isAllowedRedirectHost(host, requestHost string, allowedHosts []string) bool {
+	if h, _, err := net.SplitHostPort(requestHost); err == nil {
+		requestHost = h
+	}
+	if strings.EqualFold(host, requestHost) {
+		return true
+	}
+	for _, allowed := range allowedHosts {
+		if domain := strings.TrimPrefix(allowed, "*."); domain != allowed {
+			if strings.HasSuffix(host, "."+domain) {
+				return true
+			}
+		} else if host == allowed {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,164 @@
+// Copyright (c) 2016 - 2020 Sqreen. All Rights Reserved.
+// Please refer to our terms for more information:
+// https://www.sqreen.io/terms.html
+
+package callback_test
+
+import (
+	"errors"
+	"net/http"
+	"net/url"
+	"reflect"
+	"testing"
+
+	"github.com/sqreen/go-agent/internal/event"
+	http_protection "github.com/sqreen/go-agent/internal/protection/http"
+	http_protection_mockups "github.com/sqreen/go-agent/internal/protection/http/_testlib/mockups"
+	"github.com/sqreen/go-agent/internal/rule/callback"
+	"github.com/sqreen/go-agent/internal/rule/callback/_testlib/mockups"
+	"github.com/sqreen/go-agent/internal/sqlib/sqhook"
+	sdk_types "github.com/sqreen/go-agent/sdk/types"
+	"github.com/stretchr/testify/mock"
+	"github.com/stretchr/testify/require"
+)
+
+func TestOpenRedirectCallback(t *testing.T) {
+	t.Run("Constructor", func(t *testing.T) {
+		for _, data := range []interface{}{
+			33,
+			map[string]interface{}{"allowed_hosts": 33},
+			map[string]interface{}{"allowed_hosts": []interface{}{33}},
+			map[string]interface{}{"allowed_hosts": []interface{}{""}},
+		} {
+			cfg := &mockups.NativeCallbackConfigMockup{}
+			cfg.ExpectData().Return(data)
+			_, err := callback.NewOpenRedirectCallback(&mockups.NativeRuleContextMockup{}, cfg)
+			require.Error(t, err)
+		}
+	})
+
+	allowedHosts := map[string]interface{}{"allowed_hosts": []interface{}{"sqreen.com", "*.sqreen.io"}}
+
+	for _, tc := range []struct {
+		name      string
+		location  string
+		params    url.Values
+		host      string
+		parameter string
+	}{
+		{
+			name:      "absolute location",
+			location:  "https://evil.com/login",
+			params:    url.Values{"next": []string{"https://evil.com/login"}},
+			host:      "evil.com",
+			parameter: "QueryForm.next",
+		},
+		{
+			name:      "protocol-relative location",
+			location:  "//evil.com",
+			params:    url.Values{"next": []string{"//evil.com"}},
+			host:      "evil.com",
+			parameter: "QueryForm.next",
+		},
+		{
+			name:      "backslash protocol-relative location",
+			location:  `/\evil.com`,
+			params:    url.Values{"next": []string{`/\evil.com`}},
+			host:      "evil.com",
+			parameter: "QueryForm.next",
+		},
+		{
+			name:      "user host",
+			location:  "https://evil.com/welcome",
+			params:    url.Values{"domain": []string{"evil.com"}},
+			host:      "evil.com",
+			parameter: "QueryForm.domain",
+		},
+		{
+			name:     "relative location",
+			location: "/welcome",
+			params:   url.Values{"next": []string{"/welcome"}},
+		},
+		{
+			name:     "request host",
+			location: "https://my-app.com:8080/welcome",
+			params:   url.Values{"next": []string{"https://my-app.com:8080/welcome"}},
+		},
+		{
+			name:     "allowed host",
+			location: "https://sqreen.com/welcome",
+			params:   url.Values{"next": []string{"https://sqreen.com/welcome"}},
+		},
+		{
+			name:     "allowed subdomain",
+			location: "https://www.sqreen.io/welcome",
+			params:   url.Values{"next": []string{"https://www.sqreen.io/welcome"}},
+		},
+		{
+			name:     "not from user input",
+			location: "https://evil.com/login",
+			params:   url.Values{"next": []string{"/welcome"}},
+		},
+	} {
+		tc := tc
+		t.Run(tc.name, func(t *testing.T) {
+			reader := &http_protection_mockups.RequestReaderMockup{}
+			reader.ExpectHost().Return("my-app.com:8080").Maybe()
+			reader.ExpectQueryForm().Return(tc.params).Maybe()
+			reader.ExpectPostForm().Return(url.Values(nil)).Maybe()
+			reader.ExpectParams().Return(nil).Maybe()
+			p := &http_protection.ProtectionContext{RequestReader: reader}
+
+			r := &mockups.NativeRuleContextMockup{}
+			defer r.AssertExpectations(t)
+
+			cfg := &mockups.NativeCallbackConfigMockup{}
+			cfg.ExpectData().Return(allowedHosts)
+			cb, err := callback.NewOpenRedirectCallback(r, cfg)
+			require.NoError(t, err)
+			prolog, ok := cb.(sqhook.ReflectedPrologCallback)
+			require.True(t, ok)
+
+			attack := tc.host != ""
+			r.ExpectPre(mock.MatchedBy(func(cb func(c callback.CallbackContext) error) bool {
+				c := &mockups.CallbackContextMockup{}
+				defer c.AssertExpectations(t)
+				c.ExpectProtectionContext().Return(p).Maybe()
+				if attack {
+					c.ExpectHandleAttack(true, mock.MatchedBy(func(opts []event.AttackEventOption) bool {
+						var attack event.AttackEvent
+						for _, opt := range opts {
+							opt(&attack)
+						}
+						info, ok := attack.Info.(callback.OpenRedirectAttackInfo)
+						return ok && info.Parameter == tc.parameter && info.Host == tc.host
+					})).Return(true).Once()
+				}
+				require.NoError(t, cb(c))
+				return true
+			}))
+
+			// Call the prolog like the hook of an echo-like redirect method
+			// `func(c *context, code int, url string) error` does, with pointers
+			// to the arguments.
+			var (
+				ctx  *http.Request
+				code = http.StatusFound
+			)
+			epilog, err := prolog([]reflect.Value{
+				reflect.ValueOf(&ctx),
+				reflect.ValueOf(&code),
+				reflect.ValueOf(&tc.location),
+			})
+			if !attack {
+				require.NoError(t, err)
+				require.Nil(t, epilog)
+				return
+			}
+			require.Equal(t, sqhook.AbortError, err)
+			var callErr error
+			epilog([]reflect.Value{reflect.ValueOf(&callErr)})
+			require.True(t, errors.As(callErr, &sdk_types.SqreenError{}))
+		})
+	}
+}
@@ -0,0 +1,232 @@
+// Copyright (c) 2016 - 2020 Sqreen. All Rights Reserved.
+// Please refer to our terms for more information:
+// https://www.sqreen.io/terms.html
+
+//sqreen:ignore
+
+package callback
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+
+	"github.com/sqreen/go-agent/internal/event"
+	"github.com/sqreen/go-agent/internal/sqlib/sqassert"
+	"github.com/sqreen/go-agent/internal/sqlib/sqerrors"
+	"github.com/sqreen/go-agent/internal/sqlib/sqhook"
+	"github.com/sqreen/go-agent/sdk/types"
+)
+
+// pathTraversalMinUserInputLen is the minimum length of user inputs to look for
+// in paths, which is the length of the `..` traversal sequence.
+const pathTraversalMinUserInputLen = 2
+
+// Reasons of path traversal attacks.
+const (
+	PathTraversalReasonTraversal        = "traversal"
+	PathTraversalReasonOutsideBaseDir   = "outside_base_directory"
+	PathTraversalReasonAbsoluteUserPath = "absolute_path"
+)
+
+var ErrPathTraversalProtection = errors.New("path traversal protection triggered")
+
+// NewPathTraversalCallback returns the native callback object protecting
+// against path traversals and local file inclusions by hooking `os` file
+// functions `OpenFile`, `Stat`, `Lstat`, `Remove` and `RemoveAll`. The rule
+// data can provide the list of allowed base directories under the
+// `base_directories` key.
+func NewPathTraversalCallback(r RuleContext, cfg NativeCallbackConfig) (sqhook.PrologCallback, error) {
+	sqassert.NotNil(r)
+	sqassert.NotNil(cfg)
+
+	// The working directory is retrieved once because os.Getwd() may call
+	// hooked os functions.
+	wd, err := os.Getwd()
+	if err != nil {
+		return nil, sqerrors.Wrap(err, "could not get the working directory")
+	}
+
+	var baseDirs []string
+	switch data := cfg.Data().(type) {
+	case nil:
+	case map[string]interface{}:
+		if v, exists := data["base_directories"]; exists {
+			dirs, ok := v.([]interface{})
+			if !ok {
+				return nil, sqerrors.Errorf("unexpected base directories type: got `%T` instead of `%T`", v, dirs)
+			}
+			for _, dir := range dirs {
+				dir, ok := dir.(string)
+				if !ok || dir == "" {
+					return nil, sqerrors.Errorf("unexpected base directory value `%v`", dir)
+				}
+				baseDirs = append(baseDirs, absPath(wd, dir))
+			}
+		}
+	default:
+		return nil, sqerrors.Errorf("unexpected callback data type: got `%T` instead of `%T`", data, map[string]interface{}{})
+	}
+
+	return newPathTraversalCallbackObject(r, wd, baseDirs), nil
+}
+
+type (
+	PathTraversalOpenFilePrologCallbackType = func(*string, *int, *os.FileMode) (PathTraversalOpenFileEpilogCallbackType, error)
+	PathTraversalOpenFileEpilogCallbackType = func(**os.File, *error)
+	PathTraversalStatPrologCallbackType     = func(*string) (PathTraversalStatEpilogCallbackType, error)
+	PathTraversalStatEpilogCallbackType     = func(*os.FileInfo, *error)
+	PathTraversalRemovePrologCallbackType   = func(*string) (PathTraversalRemoveEpilogCallbackType, error)
+	PathTraversalRemoveEpilogCallbackType   = func(*error)
+)
+
+type PathTraversalAttackInfo struct {
+	Path   string `json:"path"`
+	Reason string `json:"reason"`
+	UserInput
+}
+
+// pathTraversalCallbackObject provides the prolog callbacks of every hooked
+// os function signature.
+type pathTraversalCallbackObject struct {
+	r        RuleContext
+	wd       string
+	baseDirs []string
+	prologs  map[reflect.Type]sqhook.PrologCallback
+}
+
+func newPathTraversalCallbackObject(r RuleContext, wd string, baseDirs []string) *pathTraversalCallbackObject {
+	o := &pathTraversalCallbackObject{
+		r:        r,
+		wd:       wd,
+		baseDirs: baseDirs,
+	}
+
+	prologs := []sqhook.PrologCallback{
+		PathTraversalOpenFilePrologCallbackType(func(name *string, _ *int, _ *os.FileMode) (PathTraversalOpenFileEpilogCallbackType, error) {
+			if o.protect(*name) {
+				return func(_ **os.File, err *error) { *err = newPathTraversalError("open", *name) }, sqhook.AbortError
+			}
+			return nil, nil
+		}),
+		PathTraversalStatPrologCallbackType(func(name *string) (PathTraversalStatEpilogCallbackType, error) {
+			if o.protect(*name) {
+				return func(_ *os.FileInfo, err *error) { *err = newPathTraversalError("stat", *name) }, sqhook.AbortError
+			}
+			return nil, nil
+		}),
+		PathTraversalRemovePrologCallbackType(func(name *string) (PathTraversalRemoveEpilogCallbackType, error) {
+			if o.protect(*name) {
+				return func(err *error) { *err = newPathTraversalError("remove", *name) }, sqhook.AbortError
+			}
+			return nil, nil
+		}),
+	}
+
+	o.prologs = make(map[reflect.Type]sqhook.PrologCallback, len(prologs))
+	for _, prolog := range prologs {
+		o.prologs[reflect.TypeOf(prolog)] = prolog
+	}
+	return o
+}
+
+func (o *pathTraversalCallbackObject) PrologCallbackOf(prologType reflect.Type) sqhook.PrologCallback {
+	return o.prologs[prologType]
+}
+
+// newPathTraversalError returns the error the os functions return when
+// aborted, wrapped into a *os.PathError like their other errors.
+func newPathTraversalError(op, path string) error {
+	return &os.PathError{
+		Op:   op,
+		Path: path,
+		Err:  types.SqreenError{Err: ErrPathTraversalProtection},
+	}
+}
+
+// protect returns true when the path was built out of a malicious user input
+// and the request blocked.
+func (o *pathTraversalCallbackObject) protect(path string) (blocked bool) {
+	if path == "" {
+		return false
+	}
+
+	o.r.Pre(func(c CallbackContext) error {
+		reader, err := requestReaderFromProtectionContext(c.ProtectionContext())
+		if err != nil {
+			type errKey struct{}
+			return sqerrors.WithKey(err, errKey{})
+		}
+
+		var reason string
+		input, found := findUserInput(reader, func(value string) bool {
+			if len(value) < pathTraversalMinUserInputLen || !strings.Contains(path, value) {
+				return false
+			}
+			reason = o.checkPath(path, value)
+			return reason != ""
+		})
+		if !found {
+			return nil
+		}
+
+		info := PathTraversalAttackInfo{
+			Path:      path,
+			Reason:    reason,
+			UserInput: input,
+		}
+		blocked = c.HandleAttack(true, event.WithAttackInfo(info), event.WithStackTrace())
+		return nil
+	})
+	return
+}
+
+// checkPath returns the reason why the path built out of the given user input
+// is an attack, or the empty string when it is not.
+func (o *pathTraversalCallbackObject) checkPath(path, input string) (reason string) {
+	if hasTraversalSequence(input) {
+		return PathTraversalReasonTraversal
+	}
+
+	if len(o.baseDirs) > 0 {
+		abs := absPath(o.wd, path)
+		for _, dir := range o.baseDirs {
+			if abs == dir || strings.HasPrefix(abs, dir+string(filepath.Separator)) {
+				return ""
+			}
+		}
+		return PathTraversalReasonOutsideBaseDir
+	}
+
+	// Without base directories, absolute paths fully controlled by the user
+	// are local file inclusions.
+	if filepath.IsAbs(input) && strings.HasPrefix(path, input) {
+		return PathTraversalReasonAbsoluteUserPath
+	}
+	return ""
+}
+
+// absPath returns the clean absolute path of the given path relative to the
+// given working directory.
+func absPath(wd, path string) string {
+	if !filepath.IsAbs(path) {
+		path = filepath.Join(wd, path)
+	}
+	return filepath.Clean(path)
+}
+
+// hasTraversalSequence returns true when the value has a `..` path element.
+func hasTraversalSequence(value string) bool {
+	for _, elem := range strings.FieldsFunc(value, isPathSeparator) {
+		if elem == ".." {
+			return true
+		}
+	}
+	return false
+}
+
+func isPathSeparator(c rune) bool {
+	return c == '/' || c == '\\'
+}
@@ -0,0 +1,170 @@
+// Copyright (c) 2016 - 2020 Sqreen. All Rights Reserved.
+// Please refer to our terms for more information:
+// https://www.sqreen.io/terms.html
+
+package callback_test
+
+import (
+	"errors"
+	"net/url"
+	"os"
+	"reflect"
+	"testing"
+
+	"github.com/sqreen/go-agent/internal/event"
+	http_protection "github.com/sqreen/go-agent/internal/protection/http"
+	http_protection_mockups "github.com/sqreen/go-agent/internal/protection/http/_testlib/mockups"
+	"github.com/sqreen/go-agent/internal/rule/callback"
+	"github.com/sqreen/go-agent/internal/rule/callback/_testlib/mockups"
+	"github.com/sqreen/go-agent/internal/sqlib/sqhook"
+	sdk_types "github.com/sqreen/go-agent/sdk/types"
+	"github.com/stretchr/testify/mock"
+	"github.com/stretchr/testify/require"
+)
+
+func TestPathTraversalCallback(t *testing.T) {
+	t.Run("Constructor", func(t *testing.T) {
+		for _, data := range []interface{}{
+			33,
+			map[string]interface{}{"base_directories": 33},
+			map[string]interface{}{"base_directories": []interface{}{33}},
+			map[string]interface{}{"base_directories": []interface{}{""}},
+		} {
+			cfg := &mockups.NativeCallbackConfigMockup{}
+			cfg.ExpectData().Return(data)
+			_, err := callback.NewPathTraversalCallback(&mockups.NativeRuleContextMockup{}, cfg)
+			require.Error(t, err)
+		}
+	})
+
+	for _, tc := range []struct {
+		name      string
+		data      interface{}
+		path      string
+		params    url.Values
+		reason    string
+		parameter string
+	}{
+		{
+			name:      "traversal sequence",
+			path:      "/var/www/static/../../../etc/passwd",
+			params:    url.Values{"file": []string{"../../../etc/passwd"}},
+			reason:    callback.PathTraversalReasonTraversal,
+			parameter: "QueryForm.file",
+		},
+		{
+			name:      "windows traversal sequence",
+			path:      `C:\www\..\..\windows\win.ini`,
+			params:    url.Values{"file": []string{`..\..\windows\win.ini`}},
+			reason:    callback.PathTraversalReasonTraversal,
+			parameter: "QueryForm.file",
+		},
+		{
+			name:      "absolute path",
+			path:      "/etc/passwd",
+			params:    url.Values{"file": []string{"/etc/passwd"}},
+			reason:    callback.PathTraversalReasonAbsoluteUserPath,
+			parameter: "QueryForm.file",
+		},
+		{
+			name:      "outside the base directories",
+			data:      map[string]interface{}{"base_directories": []interface{}{"/var/www/static"}},
+			path:      "/var/www/private/secret.txt",
+			params:    url.Values{"file": []string{"private/secret.txt"}},
+			reason:    callback.PathTraversalReasonOutsideBaseDir,
+			parameter: "QueryForm.file",
+		},
+		{
+			name:   "inside the base directories",
+			data:   map[string]interface{}{"base_directories": []interface{}{"/var/www/static"}},
+			path:   "/var/www/static/img/logo.png",
+			params: url.Values{"file": []string{"img/logo.png"}},
+		},
+		{
+			name:   "file name",
+			path:   "/var/www/static/img/logo.png",
+			params: url.Values{"file": []string{"logo.png"}},
+		},
+		{
+			name:   "not from user input",
+			path:   "../config.json",
+			params: url.Values{"file": []string{"logo.png"}},
+		},
+	} {
+		tc := tc
+		t.Run(tc.name, func(t *testing.T) {
+			reader := &http_protection_mockups.RequestReaderMockup{}
+			reader.ExpectQueryForm().Return(tc.params)
+			reader.ExpectPostForm().Return(url.Values(nil))
+			reader.ExpectParams().Return(nil)
+			p := &http_protection.ProtectionContext{RequestReader: reader}
+
+			r := &mockups.NativeRuleContextMockup{}
+			defer r.AssertExpectations(t)
+
+			cfg := &mockups.NativeCallbackConfigMockup{}
+			cfg.ExpectData().Return(tc.data)
+			cb, err := callback.NewPathTraversalCallback(r, cfg)
+			require.NoError(t, err)
+			selector, ok := cb.(sqhook.PrologCallbackSelector)
+			require.True(t, ok)
+
+			attack := tc.reason != ""
+			r.ExpectPre(mock.MatchedBy(func(cb func(c callback.CallbackContext) error) bool {
+				c := &mockups.CallbackContextMockup{}
+				defer c.AssertExpectations(t)
+				c.ExpectProtectionContext().Return(p)
+				if attack {
+					c.ExpectHandleAttack(true, mock.MatchedBy(func(opts []event.AttackEventOption) bool {
+						var attack event.AttackEvent
+						for _, opt := range opts {
+							opt(&attack)
+						}
+						info, ok := attack.Info.(callback.PathTraversalAttackInfo)
+						return ok && info.Parameter == tc.parameter && info.Reason == tc.reason
+					})).Return(true).Once()
+				}
+				require.NoError(t, cb(c))
+				return true
+			}))
+
+			t.Run("open file", func(t *testing.T) {
+				var prolog callback.PathTraversalOpenFilePrologCallbackType
+				prolog, ok := selector.PrologCallbackOf(reflect.TypeOf(prolog)).(callback.PathTraversalOpenFilePrologCallbackType)
+				require.True(t, ok)
+
+				flag, perm := os.O_RDONLY, os.FileMode(0)
+				epilog, err := prolog(&tc.path, &flag, &perm)
+				if !attack {
+					require.NoError(t, err)
+					require.Nil(t, epilog)
+					return
+				}
+				require.Equal(t, sqhook.AbortError, err)
+				var callErr error
+				epilog(nil, &callErr)
+				require.True(t, errors.As(callErr, &sdk_types.SqreenError{}))
+				var pathErr *os.PathError
+				require.True(t, errors.As(callErr, &pathErr))
+				require.Equal(t, tc.path, pathErr.Path)
+			})
+
+			t.Run("remove", func(t *testing.T) {
+				var prolog callback.PathTraversalRemovePrologCallbackType
+				prolog, ok := selector.PrologCallbackOf(reflect.TypeOf(prolog)).(callback.PathTraversalRemovePrologCallbackType)
+				require.True(t, ok)
+
+				epilog, err := prolog(&tc.path)
+				if !attack {
+					require.NoError(t, err)
+					require.Nil(t, epilog)
+					return
+				}
+				require.Equal(t, sqhook.AbortError, err)
+				var callErr error
+				epilog(&callErr)
+				require.True(t, errors.As(callErr, &sdk_types.SqreenError{}))
+			})
+		})
+	}
+}
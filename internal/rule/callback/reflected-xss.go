@@ -0,0 +1,227 @@
+// Copyright (c) 2016 - 2020 Sqreen. All Rights Reserved.
+// Please refer to our terms for more information:
+// https://www.sqreen.io/terms.html
+
+//sqreen:ignore
+
+package callback
+
+import (
+	"bytes"
+	"errors"
+	"mime"
+	"net/http"
+	"reflect"
+	"strings"
+
+	"github.com/sqreen/go-agent/internal/event"
+	http_protection "github.com/sqreen/go-agent/internal/protection/http"
+	http_protection_types "github.com/sqreen/go-agent/internal/protection/http/types"
+	"github.com/sqreen/go-agent/internal/sqlib/sqassert"
+	"github.com/sqreen/go-agent/internal/sqlib/sqerrors"
+	"github.com/sqreen/go-agent/internal/sqlib/sqhook"
+	sdk_types "github.com/sqreen/go-agent/sdk/types"
+)
+
+// reflectedXSSMinUserInputLen is the minimum length of user inputs to look for
+// in response bodies, which is the length of the shortest HTML tag.
+const reflectedXSSMinUserInputLen = 3
+
+var ErrReflectedXSSProtection = errors.New("reflected xss protection triggered")
+
+// NewReflectedXSSCallback returns the native callback object protecting
+// against reflected XSS by looking for unescaped HTML payloads of the request
+// parameters in HTML response bodies. It hooks both the response WAF, which is
+// performed before committing the response and can therefore block it, and the
+// response body monitoring of the body written after the response was
+// committed, which can only be reported.
+func NewReflectedXSSCallback(r RuleContext, _ NativeCallbackConfig) (sqhook.PrologCallback, error) {
+	sqassert.NotNil(r)
+	return newReflectedXSSCallbackObject(r), nil
+}
+
+type ReflectedXSSAttackInfo struct {
+	UserInput
+}
+
+// reflectedXSSCallbackObject provides the prolog callbacks of both hookpoints.
+type reflectedXSSCallbackObject struct {
+	prologs map[reflect.Type]sqhook.PrologCallback
+}
+
+func newReflectedXSSCallbackObject(r RuleContext) *reflectedXSSCallbackObject {
+	prologs := []sqhook.PrologCallback{
+		http_protection.ResponseWAFPrologCallbackType(func(p **http_protection.ProtectionContext) (epilog http_protection.ResponseWAFEpilogCallbackType, prologErr error) {
+			r.Pre(func(c CallbackContext) error {
+				resp := (*p).ResponseReader()
+				if resp == nil {
+					return nil
+				}
+				blocked, err := checkReflectedXSS(c, resp, nil, resp.Body(), true)
+				if err != nil {
+					return err
+				}
+				if blocked {
+					epilog = func(err *error) {
+						*err = sdk_types.SqreenError{Err: ErrReflectedXSSProtection}
+					}
+					prologErr = sqhook.AbortError
+				}
+				return nil
+			})
+			return
+		}),
+		http_protection.ResponseBodyMonitoringPrologCallbackType(func(p **http_protection.ProtectionContext, chunk *[]byte) (http_protection.NonBlockingEpilogCallbackType, error) {
+			r.Pre(func(c CallbackContext) error {
+				resp := (*p).ResponseReader()
+				if resp == nil {
+					return nil
+				}
+				// Payloads can be written across several chunks: the chunk is
+				// searched along with the end of the body written before it.
+				var tail []byte
+				if r, ok := resp.(http_protection_types.ResponseBodyTailReader); ok {
+					tail = r.BodyTail()
+				}
+				// The response headers were already written: the attack can only
+				// be reported.
+				_, err := checkReflectedXSS(c, resp, tail, *chunk, false)
+				return err
+			})
+			return nil, nil
+		}),
+	}
+
+	o := &reflectedXSSCallbackObject{
+		prologs: make(map[reflect.Type]sqhook.PrologCallback, len(prologs)),
+	}
+	for _, prolog := range prologs {
+		o.prologs[reflect.TypeOf(prolog)] = prolog
+	}
+	return o
+}
+
+func (o *reflectedXSSCallbackObject) PrologCallbackOf(prologType reflect.Type) sqhook.PrologCallback {
+	return o.prologs[prologType]
+}
+
+// checkReflectedXSS looks for request parameters reflected in the HTML
+// response body and handles the attack when found. The tail is the end of the
+// body written before it, so that parameters starting in the tail and ending
+// in the body are found too.
+func checkReflectedXSS(c CallbackContext, resp http_protection_types.ResponseReader, tail, body []byte, block bool) (blocked bool, err error) {
+	if len(body) == 0 || !isHTMLResponse(resp) {
+		return false, nil
+	}
+
+	reader, err := requestReaderFromProtectionContext(c.ProtectionContext())
+	if err != nil {
+		type errKey struct{}
+		return false, sqerrors.WithKey(err, errKey{})
+	}
+
+	input, found := findUserInput(reader, func(value string) bool {
+		return isXSSPayload(value) && containsAfter(tail, body, []byte(value))
+	})
+	if !found {
+		return false, nil
+	}
+
+	info := ReflectedXSSAttackInfo{UserInput: input}
+	return c.HandleAttack(block, event.WithAttackInfo(info), event.WithStackTrace()), nil
+}
+
+// containsAfter returns true when the value is in the body, or starts in the
+// tail preceding it and ends in the body. Values entirely in the tail are not
+// considered since they were already looked for.
+func containsAfter(tail, body, value []byte) bool {
+	if bytes.Contains(body, value) {
+		return true
+	}
+	n := len(value) - 1
+	if n > len(tail) {
+		n = len(tail)
+	}
+	m := len(value) - 1
+	if m > len(body) {
+		m = len(body)
+	}
+	if n == 0 || m == 0 {
+		return false
+	}
+	// Only the bytes around the boundary can include a value across it
+	boundary := make([]byte, 0, n+m)
+	boundary = append(boundary, tail[len(tail)-n:]...)
+	boundary = append(boundary, body[:m]...)
+	return bytes.Contains(boundary, value)
+}
+
+// isHTMLResponse returns true when the response content type is HTML. When not
+// set, the content type is detected out of the body prefix like net/http does.
+func isHTMLResponse(resp http_protection_types.ResponseReader) bool {
+	var contentType string
+	if ct := resp.Header("Content-Type"); ct != nil {
+		contentType = *ct
+	} else {
+		contentType = http.DetectContentType(resp.Body())
+	}
+	mediaType, _, err := mime.ParseMediaType(contentType)
+	return err == nil && mediaType == "text/html"
+}
+
+// isXSSPayload returns true when the value can inject HTML tags, or
+// attributes executing javascript when it can escape from an attribute value
+// with quotes. HTML-escaped reflections of such values are not found in the
+// body since their special characters are escaped.
+func isXSSPayload(value string) bool {
+	if len(value) < reflectedXSSMinUserInputLen {
+		return false
+	}
+
+	for i := 0; i < len(value)-1; i++ {
+		if value[i] != '<' {
+			continue
+		}
+		// Tags, end tags, comments and processing instructions
+		if c := value[i+1]; 'a' <= c && c <= 'z' || 'A' <= c && c <= 'Z' || c == '/' || c == '!' || c == '?' {
+			return true
+		}
+	}
+
+	if !strings.ContainsAny(value, `"'`) {
+		return false
+	}
+	lower := strings.ToLower(value)
+	return strings.Contains(lower, "javascript:") || hasEventHandlerAttribute(lower)
+}
+
+// hasEventHandlerAttribute returns true when the lowercase value has an event
+// handler attribute such as `onerror=`.
+func hasEventHandlerAttribute(value string) bool {
+	for offset := 0; offset < len(value); {
+		i := strings.Index(value[offset:], "on")
+		if i == -1 {
+			return false
+		}
+		start := offset + i
+		offset = start + 2
+		// The attribute name must start a word
+		if start > 0 && 'a' <= value[start-1] && value[start-1] <= 'z' {
+			continue
+		}
+		j := offset
+		for j < len(value) && 'a' <= value[j] && value[j] <= 'z' {
+			j++
+		}
+		if j == offset {
+			continue
+		}
+		for j < len(value) && (value[j] == ' ' || value[j] == '\t' || value[j] == '\n') {
+			j++
+		}
+		if j < len(value) && value[j] == '=' {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,180 @@
+// Copyright (c) 2016 - 2020 Sqreen. All Rights Reserved.
+// Please refer to our terms for more information:
+// https://www.sqreen.io/terms.html
+
+package callback_test
+
+import (
+	"context"
+	"errors"
+	"net/http/httptest"
+	"net/url"
+	"reflect"
+	"testing"
+
+	"github.com/sqreen/go-agent/internal/event"
+	http_protection "github.com/sqreen/go-agent/internal/protection/http"
+	http_protection_mockups "github.com/sqreen/go-agent/internal/protection/http/_testlib/mockups"
+	"github.com/sqreen/go-agent/internal/rule/callback"
+	"github.com/sqreen/go-agent/internal/rule/callback/_testlib/mockups"
+	"github.com/sqreen/go-agent/internal/sqlib/sqhook"
+	middleware_mockups "github.com/sqreen/go-agent/sdk/middleware/_testlib/mockups"
+	sdk_types "github.com/sqreen/go-agent/sdk/types"
+	"github.com/stretchr/testify/mock"
+	"github.com/stretchr/testify/require"
+)
+
+func TestReflectedXSSCallback(t *testing.T) {
+	for _, tc := range []struct {
+		name        string
+		contentType string
+		body        string
+		params      url.Values
+		parameter   string
+	}{
+		{
+			name:        "reflected script tag",
+			contentType: "text/html; charset=utf-8",
+			body:        "<html><body>Hello <script>alert(1)</script></body></html>",
+			params:      url.Values{"name": []string{"<script>alert(1)</script>"}},
+			parameter:   "QueryForm.name",
+		},
+		{
+			name:      "reflected event handler in a sniffed html body",
+			body:      `<html><body><img src="x" onerror="alert(1)"></body></html>`,
+			params:    url.Values{"src": []string{`x" onerror="alert(1)`}},
+			parameter: "QueryForm.src",
+		},
+		{
+			name:        "escaped reflection",
+			contentType: "text/html",
+			body:        "<html><body>Hello &lt;script&gt;alert(1)&lt;/script&gt;</body></html>",
+			params:      url.Values{"name": []string{"<script>alert(1)</script>"}},
+		},
+		{
+			name:        "not an html response",
+			contentType: "application/json",
+			body:        `{"name":"<script>alert(1)</script>"}`,
+			params:      url.Values{"name": []string{"<script>alert(1)</script>"}},
+		},
+		{
+			name:        "harmless reflection",
+			contentType: "text/html",
+			body:        "<html><body>Hello john, 1 < 2</body></html>",
+			params:      url.Values{"name": []string{"john"}, "expr": []string{"1 < 2"}},
+		},
+	} {
+		tc := tc
+		t.Run(tc.name, func(t *testing.T) {
+			attack := tc.parameter != ""
+			reader := &http_protection_mockups.RequestReaderMockup{}
+			reader.ExpectQueryForm().Return(tc.params).Maybe()
+			reader.ExpectPostForm().Return(url.Values(nil)).Maybe()
+			reader.ExpectParams().Return(nil).Maybe()
+
+			newProtectionContext := func() *http_protection.ProtectionContext {
+				p := &http_protection.ProtectionContext{RequestReader: reader}
+				i := http_protection.NewResponseInspector(httptest.NewRecorder())
+				p.InspectTestResponse(i)
+				if tc.contentType != "" {
+					i.Header().Set("Content-Type", tc.contentType)
+				}
+				_, err := i.Write([]byte(tc.body))
+				require.NoError(t, err)
+				return p
+			}
+
+			newCallback := func(t *testing.T, p *http_protection.ProtectionContext, block bool) (*mockups.NativeRuleContextMockup, sqhook.PrologCallbackSelector) {
+				r := &mockups.NativeRuleContextMockup{}
+				r.ExpectPre(mock.MatchedBy(func(cb func(c callback.CallbackContext) error) bool {
+					c := &mockups.CallbackContextMockup{}
+					defer c.AssertExpectations(t)
+					c.ExpectProtectionContext().Return(p).Maybe()
+					if attack {
+						c.ExpectHandleAttack(block, mock.MatchedBy(func(opts []event.AttackEventOption) bool {
+							var attack event.AttackEvent
+							for _, opt := range opts {
+								opt(&attack)
+							}
+							info, ok := attack.Info.(callback.ReflectedXSSAttackInfo)
+							return ok && info.Parameter == tc.parameter
+						})).Return(block).Once()
+					}
+					require.NoError(t, cb(c))
+					return true
+				}))
+
+				cb, err := callback.NewReflectedXSSCallback(r, &mockups.NativeCallbackConfigMockup{})
+				require.NoError(t, err)
+				selector, ok := cb.(sqhook.PrologCallbackSelector)
+				require.True(t, ok)
+				return r, selector
+			}
+
+			t.Run("response waf", func(t *testing.T) {
+				p := newProtectionContext()
+				r, selector := newCallback(t, p, true)
+				defer r.AssertExpectations(t)
+
+				var prolog http_protection.ResponseWAFPrologCallbackType
+				prolog, ok := selector.PrologCallbackOf(reflect.TypeOf(prolog)).(http_protection.ResponseWAFPrologCallbackType)
+				require.True(t, ok)
+
+				epilog, err := prolog(&p)
+				if !attack {
+					require.NoError(t, err)
+					require.Nil(t, epilog)
+					return
+				}
+				require.Equal(t, sqhook.AbortError, err)
+				var callErr error
+				epilog(&callErr)
+				require.True(t, errors.As(callErr, &sdk_types.SqreenError{}))
+			})
+
+			t.Run("committed response body", func(t *testing.T) {
+				p := newProtectionContext()
+				r, selector := newCallback(t, p, false)
+				defer r.AssertExpectations(t)
+
+				var prolog http_protection.ResponseBodyMonitoringPrologCallbackType
+				prolog, ok := selector.PrologCallbackOf(reflect.TypeOf(prolog)).(http_protection.ResponseBodyMonitoringPrologCallbackType)
+				require.True(t, ok)
+
+				chunk := []byte(tc.body)
+				epilog, err := prolog(&p, &chunk)
+				require.NoError(t, err)
+				require.Nil(t, epilog)
+			})
+
+			t.Run("committed response body split across chunks", func(t *testing.T) {
+				root := &middleware_mockups.RootHTTPProtectionContextMockup{}
+				root.ExpectContext().Return(context.Background()).Maybe()
+				p := http_protection.NewTestProtectionContext(root, nil, nil, reader)
+				i := http_protection.NewResponseInspector(httptest.NewRecorder())
+				p.InspectTestResponse(i)
+				if tc.contentType != "" {
+					i.Header().Set("Content-Type", tc.contentType)
+				}
+				// The first half of the body is committed before the second half
+				// gets monitored.
+				half := len(tc.body) / 2
+				_, err := i.Write([]byte(tc.body[:half]))
+				require.NoError(t, err)
+				require.NoError(t, i.Commit())
+
+				r, selector := newCallback(t, p, false)
+				defer r.AssertExpectations(t)
+
+				var prolog http_protection.ResponseBodyMonitoringPrologCallbackType
+				prolog, ok := selector.PrologCallbackOf(reflect.TypeOf(prolog)).(http_protection.ResponseBodyMonitoringPrologCallbackType)
+				require.True(t, ok)
+
+				chunk := []byte(tc.body[half:])
+				epilog, err := prolog(&p, &chunk)
+				require.NoError(t, err)
+				require.Nil(t, epilog)
+			})
+		})
+	}
+}
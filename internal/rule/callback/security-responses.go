@@ -10,6 +10,8 @@ import (
 	"encoding/json"
 	"net"
 	"net/http"
+	"strconv"
+	"time"
 
 	"github.com/sqreen/go-agent/internal/actor"
 	"github.com/sqreen/go-agent/internal/backend/api"
@@ -27,6 +29,8 @@ const (
 	blockUserEventName    = "sq.action.block_user"
 	redirectIPEventName   = "sq.action.redirect_ip"
 	redirectUserEventName = "sq.action.redirect_user"
+	throttleIPEventName   = "sq.action.throttle_ip"
+	throttleUserEventName = "sq.action.throttle_user"
 )
 
 func NewIPSecurityResponseCallback(r RuleContext, _ NativeCallbackConfig) (sqhook.PrologCallback, error) {
@@ -53,7 +57,16 @@ func newIPSecurityResponsePrologCallback(r RuleContext) http_protection.Blocking
 				return nil
 			}
 
-			writeIPSecurityResponse(p, action, ip)
+			if throttle, ok := action.(actor.ThrottleAction); ok {
+				allowed, retryAfter := throttle.Allow(ip.String())
+				if allowed {
+					return nil
+				}
+				defer handleThrottlingResponse(p, retryAfter)
+				p.TrackEvent(throttleIPEventName).WithProperties(makeBlockedIPEventProperties(action, ip))
+			} else {
+				writeIPSecurityResponse(p, action, ip)
+			}
 
 			epilog = func(e *error) {
 				*e = types.SqreenError{Err: securityResponseError{}}
@@ -100,7 +113,17 @@ func newUserSecurityResponsePrologCallback(r RuleContext) http_protection.Identi
 				return nil
 			}
 
-			writeUserSecurityResponse(p, action, id)
+			if throttle, ok := action.(actor.ThrottleAction); ok {
+				hash := actor.NewUserIdentifiersHash(id)
+				allowed, retryAfter := throttle.Allow(string(hash[:]))
+				if allowed {
+					return nil
+				}
+				defer handleThrottlingResponse(p, retryAfter)
+				p.TrackEvent(throttleUserEventName).WithProperties(makeBlockedUserEventProperties(action, id))
+			} else {
+				writeUserSecurityResponse(p, action, id)
+			}
 
 			epilog = func(e *error) {
 				*e = types.SqreenError{Err: securityResponseError{}}
@@ -139,6 +162,22 @@ func handleRedirectionResponse(p *http_protection.ProtectionContext, location st
 	writeRedirectionResponse(p.ResponseWriter, location)
 }
 
+func handleThrottlingResponse(p *http_protection.ProtectionContext, retryAfter time.Duration) {
+	// Like redirections, bypass the default blocking response.
+	defer p.CancelContext()
+	writeThrottlingResponse(p.ResponseWriter, retryAfter)
+}
+
+func writeThrottlingResponse(w http.ResponseWriter, retryAfter time.Duration) {
+	// Retry-After is a number of seconds: round it up to the next second.
+	seconds := int64((retryAfter + time.Second - 1) / time.Second)
+	if seconds < 1 {
+		seconds = 1
+	}
+	w.Header().Set("Retry-After", strconv.FormatInt(seconds, 10))
+	w.WriteHeader(http.StatusTooManyRequests)
+}
+
 func writeRedirectionResponse(w http.ResponseWriter, location string) {
 	w.Header().Set("Location", location)
 	w.WriteHeader(http.StatusSeeOther)
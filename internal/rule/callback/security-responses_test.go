@@ -6,10 +6,13 @@ package callback_test
 
 import (
 	"errors"
+	"fmt"
 	"net"
 	"net/http"
 	"testing"
+	"time"
 
+	"github.com/sqreen/go-agent/internal/actor"
 	http_protection "github.com/sqreen/go-agent/internal/protection/http"
 	http_protection_mockups "github.com/sqreen/go-agent/internal/protection/http/_testlib/mockups"
 	"github.com/sqreen/go-agent/internal/rule/callback"
@@ -29,6 +32,10 @@ type (
 	RedirectionActionMockup struct {
 		ActionMockup
 	}
+
+	ThrottleActionMockup struct {
+		ActionMockup
+	}
 )
 
 func (a *ActionMockup) ActionID() string {
@@ -39,6 +46,15 @@ func (a *ActionMockup) ExpectActionID() *mock.Call {
 	return a.On("ActionID")
 }
 
+func (a *ThrottleActionMockup) Allow(key string) (bool, time.Duration) {
+	ret := a.Called(key)
+	return ret.Bool(0), ret.Get(1).(time.Duration)
+}
+
+func (a *ThrottleActionMockup) ExpectAllow(key string) *mock.Call {
+	return a.On("Allow", key)
+}
+
 func (a *RedirectionActionMockup) RedirectionURL() string {
 	return a.Called().String(0)
 }
@@ -171,6 +187,65 @@ func TestIPSecurityResponseCallback(t *testing.T) {
 			require.Error(t, err)
 			require.True(t, xerrors.As(err, &types.SqreenError{}))
 		})
+
+		t.Run("with throttling action", func(t *testing.T) {
+			for _, allowed := range []bool{true, false} {
+				allowed := allowed
+				t.Run(fmt.Sprintf("allowed=%v", allowed), func(t *testing.T) {
+					r := &mockups.NativeRuleContextMockup{}
+					defer r.AssertExpectations(t)
+
+					rootCtx := &middleware_mockups.RootHTTPProtectionContextMockup{}
+					defer rootCtx.AssertExpectations(t)
+
+					responseWriterMockup := &http_protection_mockups.ResponseWriterMockup{}
+					defer responseWriterMockup.AssertExpectations(t)
+
+					requestReaderMockup := &http_protection_mockups.RequestReaderMockup{}
+					defer requestReaderMockup.AssertExpectations(t)
+
+					ip := net.ParseIP("1.2.3.4")
+					p := http_protection.NewTestProtectionContext(rootCtx, ip, responseWriterMockup, requestReaderMockup)
+
+					v, err := callback.NewIPSecurityResponseCallback(r, nil /* unused */)
+					require.NoError(t, err)
+
+					actionMockup := &ThrottleActionMockup{}
+					defer actionMockup.AssertExpectations(t)
+					actionMockup.ExpectAllow(ip.String()).Return(allowed, 1500*time.Millisecond)
+
+					headers := http.Header{}
+					if !allowed {
+						responseWriterMockup.ExpectHeader().Return(headers)
+						responseWriterMockup.ExpectWriteHeader(http.StatusTooManyRequests)
+						rootCtx.ExpectCancelContext()
+					}
+
+					rootCtx.ExpectFindActionByIP(ip).Return(actionMockup, true, nil)
+
+					r.ExpectPre(mock.MatchedBy(func(cb func(callback.CallbackContext) error) bool {
+						c := &mockups.CallbackContextMockup{}
+						require.NoError(t, cb(c))
+						return true
+					}))
+
+					prolog := v.(http_protection.BlockingPrologCallbackType)
+					epilog, err := prolog(&p)
+					require.NoError(t, err)
+					if allowed {
+						require.Nil(t, epilog)
+						return
+					}
+					require.NotNil(t, epilog)
+
+					require.Equal(t, "2", headers.Get("Retry-After"))
+
+					epilog(&err)
+					require.Error(t, err)
+					require.True(t, xerrors.As(err, &types.SqreenError{}))
+				})
+			}
+		})
 	})
 
 	t.Run("ip lookup error", func(t *testing.T) {
@@ -341,6 +416,69 @@ func TestUserSecurityResponseCallback(t *testing.T) {
 			require.Error(t, err)
 			require.True(t, xerrors.As(err, &types.SqreenError{}))
 		})
+
+		t.Run("with throttling action", func(t *testing.T) {
+			for _, allowed := range []bool{true, false} {
+				allowed := allowed
+				t.Run(fmt.Sprintf("allowed=%v", allowed), func(t *testing.T) {
+					r := &mockups.NativeRuleContextMockup{}
+					defer r.AssertExpectations(t)
+
+					rootCtx := &middleware_mockups.RootHTTPProtectionContextMockup{}
+					defer rootCtx.AssertExpectations(t)
+
+					responseWriterMockup := &http_protection_mockups.ResponseWriterMockup{}
+					defer responseWriterMockup.AssertExpectations(t)
+
+					requestReaderMockup := &http_protection_mockups.RequestReaderMockup{}
+					defer requestReaderMockup.AssertExpectations(t)
+
+					ip := net.ParseIP("1.2.3.4")
+					p := http_protection.NewTestProtectionContext(rootCtx, ip, responseWriterMockup, requestReaderMockup)
+
+					v, err := callback.NewUserSecurityResponseCallback(r, nil /* unused */)
+					require.NoError(t, err)
+
+					actionMockup := &ThrottleActionMockup{}
+					defer actionMockup.AssertExpectations(t)
+					userID := map[string]string{
+						"uid": "unique user id",
+					}
+					hash := actor.NewUserIdentifiersHash(userID)
+					actionMockup.ExpectAllow(string(hash[:])).Return(allowed, 1500*time.Millisecond)
+
+					headers := http.Header{}
+					if !allowed {
+						responseWriterMockup.ExpectHeader().Return(headers)
+						responseWriterMockup.ExpectWriteHeader(http.StatusTooManyRequests)
+						rootCtx.ExpectCancelContext()
+					}
+
+					rootCtx.ExpectFindActionByUserID(userID).Return(actionMockup, true)
+
+					r.ExpectPre(mock.MatchedBy(func(cb func(callback.CallbackContext) error) bool {
+						c := &mockups.CallbackContextMockup{}
+						require.NoError(t, cb(c))
+						return true
+					}))
+
+					prolog := v.(http_protection.IdentifyUserPrologCallbackType)
+					epilog, err := prolog(&p, &userID)
+					require.NoError(t, err)
+					if allowed {
+						require.Nil(t, epilog)
+						return
+					}
+					require.NotNil(t, epilog)
+
+					require.Equal(t, "2", headers.Get("Retry-After"))
+
+					epilog(&err)
+					require.Error(t, err)
+					require.True(t, xerrors.As(err, &types.SqreenError{}))
+				})
+			}
+		})
 	})
 
 	t.Run("ip lookup error", func(t *testing.T) {
@@ -0,0 +1,297 @@
+// Copyright (c) 2016 - 2020 Sqreen. All Rights Reserved.
+// Please refer to our terms for more information:
+// https://www.sqreen.io/terms.html
+
+//sqreen:ignore
+
+package callback
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"reflect"
+	"sort"
+	"strings"
+	"sync"
+	"unsafe"
+
+	"github.com/sqreen/go-agent/internal/event"
+	"github.com/sqreen/go-agent/internal/sqlib/sqassert"
+	"github.com/sqreen/go-agent/internal/sqlib/sqerrors"
+	"github.com/sqreen/go-agent/internal/sqlib/sqhook"
+	"github.com/sqreen/go-agent/internal/sqlib/sqsql"
+	"github.com/sqreen/go-agent/sdk/types"
+)
+
+// Default map of SQL dialects to driver package paths used when the rule
+// doesn't provide any.
+var defaultSQLDialects = map[string]interface{}{
+	"mysql": []interface{}{
+		"github.com/go-sql-driver/mysql",
+		"github.com/ziutek/mymysql",
+	},
+	"postgresql": []interface{}{
+		"github.com/lib/pq",
+		"github.com/jackc/pgx",
+	},
+	"sqlite": []interface{}{
+		"github.com/mattn/go-sqlite3",
+		"modernc.org/sqlite",
+	},
+	"mssql": []interface{}{
+		"github.com/denisenkom/go-mssqldb",
+		"github.com/microsoft/go-mssqldb",
+	},
+	"oracle": []interface{}{
+		"github.com/godror/godror",
+		"github.com/sijms/go-ora",
+		"gopkg.in/goracle.v2",
+	},
+}
+
+// sqlInjectionMinUserInputLen is the minimum length of user inputs to look for
+// in queries. Shorter ones cannot span several tokens.
+const sqlInjectionMinUserInputLen = 2
+
+var ErrSQLInjectionProtection = errors.New("sql injection protection triggered")
+
+// NewSQLInjectionCallback returns the native callback object protecting
+// against SQL injections by hooking the query, exec and prepare methods of
+// `database/sql` types `*DB`, `*Tx` and `*Conn`. The rule data can provide the
+// map of dialects to driver package paths under the `dialects` key.
+func NewSQLInjectionCallback(r RuleContext, cfg NativeCallbackConfig) (sqhook.PrologCallback, error) {
+	sqassert.NotNil(r)
+	sqassert.NotNil(cfg)
+
+	dialects := defaultSQLDialects
+	switch data := cfg.Data().(type) {
+	case nil:
+	case map[string]interface{}:
+		if v, exists := data["dialects"]; exists {
+			m, ok := v.(map[string]interface{})
+			if !ok {
+				return nil, sqerrors.Errorf("unexpected dialects type: got `%T` instead of `%T`", v, m)
+			}
+			dialects = m
+		}
+	default:
+		return nil, sqerrors.Errorf("unexpected callback data type: got `%T` instead of `%T`", data, map[string]interface{}{})
+	}
+
+	return newSQLInjectionCallbackObject(r, dialects), nil
+}
+
+type (
+	SQLInjectionDBQueryPrologCallbackType     = func(**sql.DB, *context.Context, *string, *[]interface{}) (SQLInjectionQueryEpilogCallbackType, error)
+	SQLInjectionDBExecPrologCallbackType      = func(**sql.DB, *context.Context, *string, *[]interface{}) (SQLInjectionExecEpilogCallbackType, error)
+	SQLInjectionDBPreparePrologCallbackType   = func(**sql.DB, *context.Context, *string) (SQLInjectionPrepareEpilogCallbackType, error)
+	SQLInjectionTxQueryPrologCallbackType     = func(**sql.Tx, *context.Context, *string, *[]interface{}) (SQLInjectionQueryEpilogCallbackType, error)
+	SQLInjectionTxExecPrologCallbackType      = func(**sql.Tx, *context.Context, *string, *[]interface{}) (SQLInjectionExecEpilogCallbackType, error)
+	SQLInjectionTxPreparePrologCallbackType   = func(**sql.Tx, *context.Context, *string) (SQLInjectionPrepareEpilogCallbackType, error)
+	SQLInjectionConnQueryPrologCallbackType   = func(**sql.Conn, *context.Context, *string, *[]interface{}) (SQLInjectionQueryEpilogCallbackType, error)
+	SQLInjectionConnExecPrologCallbackType    = func(**sql.Conn, *context.Context, *string, *[]interface{}) (SQLInjectionExecEpilogCallbackType, error)
+	SQLInjectionConnPreparePrologCallbackType = func(**sql.Conn, *context.Context, *string) (SQLInjectionPrepareEpilogCallbackType, error)
+
+	SQLInjectionQueryEpilogCallbackType   = func(**sql.Rows, *error)
+	SQLInjectionExecEpilogCallbackType    = func(*sql.Result, *error)
+	SQLInjectionPrepareEpilogCallbackType = func(**sql.Stmt, *error)
+)
+
+type SQLInjectionAttackInfo struct {
+	Query   string `json:"query"`
+	Dialect string `json:"dialect"`
+	UserInput
+}
+
+// sqlInjectionCallbackObject provides the prolog callbacks of every hooked
+// database/sql method signature.
+type sqlInjectionCallbackObject struct {
+	r        RuleContext
+	dialects map[string]interface{}
+	// Cache of the dialects per driver type.
+	driverDialects sync.Map
+	prologs        map[reflect.Type]sqhook.PrologCallback
+}
+
+func newSQLInjectionCallbackObject(r RuleContext, dialects map[string]interface{}) *sqlInjectionCallbackObject {
+	o := &sqlInjectionCallbackObject{
+		r:        r,
+		dialects: dialects,
+	}
+
+	abortQuery := func(_ **sql.Rows, err *error) { *err = types.SqreenError{Err: ErrSQLInjectionProtection} }
+	abortExec := func(_ *sql.Result, err *error) { *err = types.SqreenError{Err: ErrSQLInjectionProtection} }
+	abortPrepare := func(_ **sql.Stmt, err *error) { *err = types.SqreenError{Err: ErrSQLInjectionProtection} }
+
+	prologs := []sqhook.PrologCallback{
+		SQLInjectionDBQueryPrologCallbackType(func(db **sql.DB, _ *context.Context, query *string, _ *[]interface{}) (SQLInjectionQueryEpilogCallbackType, error) {
+			if o.protect(*db, *query) {
+				return abortQuery, sqhook.AbortError
+			}
+			return nil, nil
+		}),
+		SQLInjectionDBExecPrologCallbackType(func(db **sql.DB, _ *context.Context, query *string, _ *[]interface{}) (SQLInjectionExecEpilogCallbackType, error) {
+			if o.protect(*db, *query) {
+				return abortExec, sqhook.AbortError
+			}
+			return nil, nil
+		}),
+		SQLInjectionDBPreparePrologCallbackType(func(db **sql.DB, _ *context.Context, query *string) (SQLInjectionPrepareEpilogCallbackType, error) {
+			if o.protect(*db, *query) {
+				return abortPrepare, sqhook.AbortError
+			}
+			return nil, nil
+		}),
+		SQLInjectionTxQueryPrologCallbackType(func(tx **sql.Tx, _ *context.Context, query *string, _ *[]interface{}) (SQLInjectionQueryEpilogCallbackType, error) {
+			if o.protect(sqlDBOf(*tx), *query) {
+				return abortQuery, sqhook.AbortError
+			}
+			return nil, nil
+		}),
+		SQLInjectionTxExecPrologCallbackType(func(tx **sql.Tx, _ *context.Context, query *string, _ *[]interface{}) (SQLInjectionExecEpilogCallbackType, error) {
+			if o.protect(sqlDBOf(*tx), *query) {
+				return abortExec, sqhook.AbortError
+			}
+			return nil, nil
+		}),
+		SQLInjectionTxPreparePrologCallbackType(func(tx **sql.Tx, _ *context.Context, query *string) (SQLInjectionPrepareEpilogCallbackType, error) {
+			if o.protect(sqlDBOf(*tx), *query) {
+				return abortPrepare, sqhook.AbortError
+			}
+			return nil, nil
+		}),
+		SQLInjectionConnQueryPrologCallbackType(func(conn **sql.Conn, _ *context.Context, query *string, _ *[]interface{}) (SQLInjectionQueryEpilogCallbackType, error) {
+			if o.protect(sqlDBOf(*conn), *query) {
+				return abortQuery, sqhook.AbortError
+			}
+			return nil, nil
+		}),
+		SQLInjectionConnExecPrologCallbackType(func(conn **sql.Conn, _ *context.Context, query *string, _ *[]interface{}) (SQLInjectionExecEpilogCallbackType, error) {
+			if o.protect(sqlDBOf(*conn), *query) {
+				return abortExec, sqhook.AbortError
+			}
+			return nil, nil
+		}),
+		SQLInjectionConnPreparePrologCallbackType(func(conn **sql.Conn, _ *context.Context, query *string) (SQLInjectionPrepareEpilogCallbackType, error) {
+			if o.protect(sqlDBOf(*conn), *query) {
+				return abortPrepare, sqhook.AbortError
+			}
+			return nil, nil
+		}),
+	}
+
+	o.prologs = make(map[reflect.Type]sqhook.PrologCallback, len(prologs))
+	for _, prolog := range prologs {
+		o.prologs[reflect.TypeOf(prolog)] = prolog
+	}
+	return o
+}
+
+func (o *sqlInjectionCallbackObject) PrologCallbackOf(prologType reflect.Type) sqhook.PrologCallback {
+	return o.prologs[prologType]
+}
+
+// protect returns true when the query was injected and the request blocked.
+func (o *sqlInjectionCallbackObject) protect(db *sql.DB, query string) (blocked bool) {
+	o.r.Pre(func(c CallbackContext) error {
+		reader, err := requestReaderFromProtectionContext(c.ProtectionContext())
+		if err != nil {
+			type errKey struct{}
+			return sqerrors.WithKey(err, errKey{})
+		}
+
+		dialect := o.dialect(c, db)
+		var tokens []sqsql.Token
+		input, found := findUserInput(reader, func(value string) bool {
+			if len(value) < sqlInjectionMinUserInputLen || !strings.Contains(query, value) {
+				return false
+			}
+			if tokens == nil {
+				tokens = sqsql.Tokenize(query, dialect)
+			}
+			return isSQLInjection(query, tokens, value)
+		})
+		if !found {
+			return nil
+		}
+
+		info := SQLInjectionAttackInfo{
+			Query:     query,
+			Dialect:   dialect.String(),
+			UserInput: input,
+		}
+		blocked = c.HandleAttack(true, event.WithAttackInfo(info), event.WithStackTrace())
+		return nil
+	})
+	return
+}
+
+// dialect returns the SQL dialect of the database driver. The generic dialect
+// is returned when it cannot be inferred.
+func (o *sqlInjectionCallbackObject) dialect(c CallbackContext, db *sql.DB) sqsql.Dialect {
+	if db == nil {
+		return sqsql.DialectGeneric
+	}
+	drv := db.Driver()
+	drvType := reflect.TypeOf(drv)
+	if dialect, ok := o.driverDialects.Load(drvType); ok {
+		return dialect.(sqsql.Dialect)
+	}
+
+	dialect := sqsql.DialectGeneric
+	if name, err := sqlDriverDialect(drv, o.dialects); err != nil {
+		c.Logger().Error(sqerrors.Wrap(err, "sql injection: could not infer the sql dialect"))
+	} else {
+		dialect = sqsql.ParseDialect(name)
+	}
+	o.driverDialects.Store(drvType, dialect)
+	return dialect
+}
+
+// sqlDBOf returns the `*sql.DB` value of `*sql.Tx` and `*sql.Conn` values.
+// They don't give access to it so it is read from their unexported `db` field.
+// A nil value is returned if the field is not found.
+func sqlDBOf(v interface{}) *sql.DB {
+	rv := reflect.ValueOf(v)
+	if rv.Kind() != reflect.Ptr || rv.IsNil() {
+		return nil
+	}
+	f := rv.Elem().FieldByName("db")
+	if !f.IsValid() || f.Type() != reflect.TypeOf((*sql.DB)(nil)) {
+		return nil
+	}
+	return (*sql.DB)(unsafe.Pointer(f.Pointer()))
+}
+
+// isSQLInjection returns true when an occurrence of the user input in the
+// query spans several tokens, or starts a comment or an unterminated token, ie.
+// when the user input changes the query token structure.
+func isSQLInjection(query string, tokens []sqsql.Token, input string) bool {
+	for offset := 0; offset < len(query); {
+		i := strings.Index(query[offset:], input)
+		if i == -1 {
+			return false
+		}
+		start := offset + i
+		end := start + len(input)
+		offset = start + 1
+
+		// First token ending after the start of the input
+		first := sort.Search(len(tokens), func(i int) bool { return tokens[i].End > start })
+		if first == len(tokens) || tokens[first].Start >= end {
+			// Only whitespaces
+			continue
+		}
+		if first+1 < len(tokens) && tokens[first+1].Start < end {
+			return true
+		}
+		switch tok := tokens[first]; tok.Kind {
+		case sqsql.TokenComment, sqsql.TokenInvalid:
+			if tok.Start >= start {
+				return true
+			}
+		}
+	}
+	return false
+}
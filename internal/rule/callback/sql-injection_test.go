@@ -0,0 +1,184 @@
+// Copyright (c) 2016 - 2020 Sqreen. All Rights Reserved.
+// Please refer to our terms for more information:
+// https://www.sqreen.io/terms.html
+
+package callback_test
+
+import (
+	"context"
+	"database/sql"
+	"database/sql/driver"
+	"errors"
+	"net/url"
+	"reflect"
+	"testing"
+
+	"github.com/sqreen/go-agent/internal/event"
+	http_protection "github.com/sqreen/go-agent/internal/protection/http"
+	http_protection_mockups "github.com/sqreen/go-agent/internal/protection/http/_testlib/mockups"
+	"github.com/sqreen/go-agent/internal/rule/callback"
+	"github.com/sqreen/go-agent/internal/rule/callback/_testlib/mockups"
+	"github.com/sqreen/go-agent/internal/sqlib/sqhook"
+	sdk_types "github.com/sqreen/go-agent/sdk/types"
+	"github.com/stretchr/testify/mock"
+	"github.com/stretchr/testify/require"
+)
+
+type sqlInjectionFakeDriver struct{}
+
+func (sqlInjectionFakeDriver) Open(string) (driver.Conn, error) { return sqlInjectionFakeConn{}, nil }
+
+type sqlInjectionFakeConn struct{}
+
+func (sqlInjectionFakeConn) Prepare(string) (driver.Stmt, error) {
+	return nil, errors.New("not implemented")
+}
+func (sqlInjectionFakeConn) Close() error              { return nil }
+func (sqlInjectionFakeConn) Begin() (driver.Tx, error) { return nil, errors.New("not implemented") }
+
+func init() {
+	sql.Register("sqreen-fake-sql-driver", sqlInjectionFakeDriver{})
+}
+
+func TestSQLInjectionCallback(t *testing.T) {
+	t.Run("Constructor", func(t *testing.T) {
+		for _, data := range []interface{}{
+			33,
+			map[string]interface{}{"dialects": 33},
+		} {
+			cfg := &mockups.NativeCallbackConfigMockup{}
+			cfg.ExpectData().Return(data)
+			_, err := callback.NewSQLInjectionCallback(&mockups.NativeRuleContextMockup{}, cfg)
+			require.Error(t, err)
+		}
+	})
+
+	db, err := sql.Open("sqreen-fake-sql-driver", "")
+	require.NoError(t, err)
+	defer db.Close()
+
+	conn, err := db.Conn(context.Background())
+	require.NoError(t, err)
+	defer conn.Close()
+
+	for _, tc := range []struct {
+		name      string
+		query     string
+		params    url.Values
+		attack    bool
+		parameter string
+	}{
+		{
+			name:      "boolean-based injection",
+			query:     "SELECT * FROM users WHERE id = 1 OR 1=1",
+			params:    url.Values{"id": []string{"1 OR 1=1"}},
+			attack:    true,
+			parameter: "QueryForm.id",
+		},
+		{
+			name:      "string escape and comment",
+			query:     "SELECT * FROM users WHERE name = 'admin'#' AND password = 'x'",
+			params:    url.Values{"name": []string{"admin'#"}},
+			attack:    true,
+			parameter: "QueryForm.name",
+		},
+		{
+			// The whole value is a single mysql string thanks to the escaped quote
+			name:   "mysql backslash escape",
+			query:  `SELECT * FROM users WHERE name = 'x\' OR 1=1 -- '`,
+			params: url.Values{"name": []string{`x\' OR 1=1 -- `}},
+		},
+		{
+			name:   "user input in a string literal",
+			query:  "SELECT * FROM users WHERE name = 'john doe'",
+			params: url.Values{"name": []string{"john doe"}},
+		},
+		{
+			name:   "user input equal to an identifier",
+			query:  "SELECT id, name FROM users",
+			params: url.Values{"name": []string{"name"}},
+		},
+		{
+			name:   "user input not in the query",
+			query:  "SELECT * FROM users WHERE id = ?",
+			params: url.Values{"id": []string{"1 OR 1=1"}},
+		},
+	} {
+		tc := tc
+		t.Run(tc.name, func(t *testing.T) {
+			reader := &http_protection_mockups.RequestReaderMockup{}
+			reader.ExpectQueryForm().Return(tc.params)
+			reader.ExpectPostForm().Return(url.Values(nil))
+			reader.ExpectParams().Return(nil)
+			p := &http_protection.ProtectionContext{RequestReader: reader}
+
+			r := &mockups.NativeRuleContextMockup{}
+			defer r.AssertExpectations(t)
+
+			cfg := &mockups.NativeCallbackConfigMockup{}
+			cfg.ExpectData().Return(map[string]interface{}{
+				"dialects": map[string]interface{}{
+					"mysql": []interface{}{"github.com/sqreen/go-agent/internal/rule/callback"},
+				},
+			})
+			cb, err := callback.NewSQLInjectionCallback(r, cfg)
+			require.NoError(t, err)
+			selector, ok := cb.(sqhook.PrologCallbackSelector)
+			require.True(t, ok)
+
+			r.ExpectPre(mock.MatchedBy(func(cb func(c callback.CallbackContext) error) bool {
+				c := &mockups.CallbackContextMockup{}
+				defer c.AssertExpectations(t)
+				c.ExpectProtectionContext().Return(p)
+				if tc.attack {
+					c.ExpectHandleAttack(true, mock.MatchedBy(func(opts []event.AttackEventOption) bool {
+						var attack event.AttackEvent
+						for _, opt := range opts {
+							opt(&attack)
+						}
+						info, ok := attack.Info.(callback.SQLInjectionAttackInfo)
+						return ok && info.Parameter == tc.parameter && info.Dialect == "mysql"
+					})).Return(true).Once()
+				}
+				require.NoError(t, cb(c))
+				return true
+			}))
+
+			t.Run("db query", func(t *testing.T) {
+				var prolog callback.SQLInjectionDBQueryPrologCallbackType
+				prolog, ok := selector.PrologCallbackOf(reflect.TypeOf(prolog)).(callback.SQLInjectionDBQueryPrologCallbackType)
+				require.True(t, ok)
+
+				ctx := context.Background()
+				epilog, err := prolog(&db, &ctx, &tc.query, nil)
+				if !tc.attack {
+					require.NoError(t, err)
+					require.Nil(t, epilog)
+					return
+				}
+				require.Equal(t, sqhook.AbortError, err)
+				var callErr error
+				epilog(nil, &callErr)
+				require.True(t, errors.As(callErr, &sdk_types.SqreenError{}))
+			})
+
+			t.Run("conn exec", func(t *testing.T) {
+				var prolog callback.SQLInjectionConnExecPrologCallbackType
+				prolog, ok := selector.PrologCallbackOf(reflect.TypeOf(prolog)).(callback.SQLInjectionConnExecPrologCallbackType)
+				require.True(t, ok)
+
+				ctx := context.Background()
+				epilog, err := prolog(&conn, &ctx, &tc.query, nil)
+				if !tc.attack {
+					require.NoError(t, err)
+					require.Nil(t, epilog)
+					return
+				}
+				require.Equal(t, sqhook.AbortError, err)
+				var callErr error
+				epilog(nil, &callErr)
+				require.True(t, errors.As(callErr, &sdk_types.SqreenError{}))
+			})
+		})
+	}
+}
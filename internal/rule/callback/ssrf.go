@@ -0,0 +1,172 @@
+// Copyright (c) 2016 - 2020 Sqreen. All Rights Reserved.
+// Please refer to our terms for more information:
+// https://www.sqreen.io/terms.html
+
+//sqreen:ignore
+
+package callback
+
+import (
+	"context"
+	"errors"
+	"net"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/sqreen/go-agent/internal/config"
+	"github.com/sqreen/go-agent/internal/event"
+	"github.com/sqreen/go-agent/internal/sqlib/sqassert"
+	"github.com/sqreen/go-agent/internal/sqlib/sqerrors"
+	"github.com/sqreen/go-agent/internal/sqlib/sqhook"
+	"github.com/sqreen/go-agent/sdk/types"
+)
+
+// ssrfLookupTimeout is the maximum amount of time spent resolving a hostname
+// coming from user input, within the deadline of the outgoing request.
+const ssrfLookupTimeout = 100 * time.Millisecond
+
+var (
+	// Cloud provider metadata hostnames.
+	ssrfMetadataHostnames = []string{
+		"metadata",
+		"metadata.google.internal",
+		"metadata.azure.internal",
+	}
+
+	// Cloud provider metadata IP addresses which are not already part of the
+	// private or link-local networks.
+	ssrfMetadataIPs = []net.IP{
+		net.ParseIP("100.100.100.200"),
+	}
+
+	ErrSSRFProtection = errors.New("ssrf protection triggered")
+)
+
+// NewSSRFCallback returns the native prolog callback protecting against
+// server-side request forgery by hooking `(*net/http.Client).do`.
+func NewSSRFCallback(r RuleContext, cfg NativeCallbackConfig) (sqhook.PrologCallback, error) {
+	sqassert.NotNil(r)
+	return newSSRFPrologCallback(r, net.DefaultResolver), nil
+}
+
+type (
+	SSRFPrologCallbackType = func(**http.Client, **http.Request) (SSRFEpilogCallbackType, error)
+	SSRFEpilogCallbackType = func(**http.Response, *error)
+)
+
+type SSRFAttackInfo struct {
+	URL     string `json:"url"`
+	Host    string `json:"host"`
+	Address string `json:"address"`
+	UserInput
+}
+
+type ipResolver interface {
+	LookupIPAddr(ctx context.Context, host string) ([]net.IPAddr, error)
+}
+
+func newSSRFPrologCallback(r RuleContext, resolver ipResolver) SSRFPrologCallbackType {
+	return func(_ **http.Client, req **http.Request) (epilog SSRFEpilogCallbackType, prologErr error) {
+		r.Pre(func(c CallbackContext) error {
+			if *req == nil || (*req).URL == nil {
+				return nil
+			}
+			u := (*req).URL
+			host := u.Hostname()
+			if host == "" {
+				return nil
+			}
+
+			reader, err := requestReaderFromProtectionContext(c.ProtectionContext())
+			if err != nil {
+				type errKey struct{}
+				return sqerrors.WithKey(err, errKey{})
+			}
+
+			// Only hosts coming from user input are considered.
+			input, found := findUserInput(reader, func(value string) bool {
+				return strings.Contains(strings.ToLower(value), strings.ToLower(host))
+			})
+			if !found {
+				return nil
+			}
+
+			addr, forbidden := ssrfForbiddenAddress((*req).Context(), resolver, host)
+			if !forbidden {
+				return nil
+			}
+
+			info := SSRFAttackInfo{
+				URL:       u.String(),
+				Host:      host,
+				Address:   addr,
+				UserInput: input,
+			}
+			if blocked := c.HandleAttack(true, event.WithAttackInfo(info), event.WithStackTrace()); blocked {
+				epilog = func(_ **http.Response, callErr *error) {
+					*callErr = types.SqreenError{Err: ErrSSRFProtection}
+				}
+				prologErr = sqhook.AbortError
+			}
+			return nil
+		})
+		return
+	}
+}
+
+// ssrfForbiddenAddress returns the first address of the host which is a
+// private, loopback, link-local or metadata address. The host is resolved
+// using the context of the outgoing request when it is not an IP address.
+// Since the transport resolves it again when dialing, a DNS server answering
+// differently to both lookups is not detected.
+func ssrfForbiddenAddress(ctx context.Context, resolver ipResolver, host string) (addr string, forbidden bool) {
+	host = strings.TrimSuffix(strings.ToLower(host), ".")
+	for _, metadata := range ssrfMetadataHostnames {
+		if host == metadata {
+			return host, true
+		}
+	}
+
+	if ip := net.ParseIP(host); ip != nil {
+		return ip.String(), isSSRFForbiddenIP(ip)
+	}
+
+	ctx, cancel := context.WithTimeout(ctx, ssrfLookupTimeout)
+	defer cancel()
+	addrs, err := resolver.LookupIPAddr(ctx, host)
+	if err != nil {
+		// The request will fail the same way.
+		return "", false
+	}
+	for _, addr := range addrs {
+		if isSSRFForbiddenIP(addr.IP) {
+			return addr.IP.String(), true
+		}
+	}
+	return "", false
+}
+
+func isSSRFForbiddenIP(ip net.IP) bool {
+	if ip.IsLoopback() || ip.IsUnspecified() || ip.IsLinkLocalUnicast() || ip.IsLinkLocalMulticast() {
+		return true
+	}
+
+	for _, metadata := range ssrfMetadataIPs {
+		if metadata.Equal(ip) {
+			return true
+		}
+	}
+
+	privateNetworks := config.IPv6PrivateNetworks
+	if ipv4 := ip.To4(); ipv4 != nil {
+		ip = ipv4
+		privateNetworks = config.IPv4PrivateNetworks
+	}
+	for _, network := range privateNetworks {
+		if network.Contains(ip) {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,124 @@
+// Copyright (c) 2016 - 2020 Sqreen. All Rights Reserved.
+// Please refer to our terms for more information:
+// https://www.sqreen.io/terms.html
+
+package callback_test
+
+import (
+	"errors"
+	"net/http"
+	"net/url"
+	"testing"
+
+	"github.com/sqreen/go-agent/internal/event"
+	http_protection "github.com/sqreen/go-agent/internal/protection/http"
+	http_protection_mockups "github.com/sqreen/go-agent/internal/protection/http/_testlib/mockups"
+	"github.com/sqreen/go-agent/internal/protection/http/types"
+	"github.com/sqreen/go-agent/internal/rule/callback"
+	"github.com/sqreen/go-agent/internal/rule/callback/_testlib/mockups"
+	"github.com/sqreen/go-agent/internal/sqlib/sqhook"
+	sdk_types "github.com/sqreen/go-agent/sdk/types"
+	"github.com/stretchr/testify/mock"
+	"github.com/stretchr/testify/require"
+)
+
+func TestSSRFCallback(t *testing.T) {
+	for _, tc := range []struct {
+		name      string
+		url       string
+		query     url.Values
+		params    types.RequestParamMap
+		attack    bool
+		parameter string
+	}{
+		{
+			name:      "loopback address from the query",
+			url:       "http://127.0.0.1:8080/admin",
+			query:     url.Values{"url": []string{"http://127.0.0.1:8080/admin"}},
+			attack:    true,
+			parameter: "QueryForm.url",
+		},
+		{
+			name:      "metadata address from a json parameter",
+			url:       "http://169.254.169.254/latest/meta-data/",
+			params:    types.RequestParamMap{"json": {map[string]interface{}{"hook": []interface{}{"ok", "169.254.169.254"}}}},
+			attack:    true,
+			parameter: "json.hook.1",
+		},
+		{
+			name:      "metadata hostname",
+			url:       "http://metadata.google.internal/computeMetadata/v1/",
+			query:     url.Values{"host": []string{"metadata.google.internal"}},
+			attack:    true,
+			parameter: "QueryForm.host",
+		},
+		{
+			name:      "private ipv6 address",
+			url:       "http://[fd00:ec2::254]/",
+			query:     url.Values{"host": []string{"FD00:EC2::254"}},
+			attack:    true,
+			parameter: "QueryForm.host",
+		},
+		{
+			name:  "private address not coming from user input",
+			url:   "http://10.0.0.1/internal",
+			query: url.Values{"url": []string{"http://example.com"}},
+		},
+		{
+			name:  "public address from user input",
+			url:   "http://1.2.3.4/",
+			query: url.Values{"url": []string{"http://1.2.3.4/"}},
+		},
+	} {
+		tc := tc
+		t.Run(tc.name, func(t *testing.T) {
+			reader := &http_protection_mockups.RequestReaderMockup{}
+			reader.ExpectQueryForm().Return(tc.query)
+			reader.ExpectPostForm().Return(url.Values(nil))
+			reader.ExpectParams().Return(tc.params)
+			p := &http_protection.ProtectionContext{RequestReader: reader}
+
+			r := &mockups.NativeRuleContextMockup{}
+			defer r.AssertExpectations(t)
+
+			cb, err := callback.NewSSRFCallback(r, &mockups.NativeCallbackConfigMockup{})
+			require.NoError(t, err)
+			prolog, ok := cb.(callback.SSRFPrologCallbackType)
+			require.True(t, ok)
+
+			r.ExpectPre(mock.MatchedBy(func(cb func(c callback.CallbackContext) error) bool {
+				c := &mockups.CallbackContextMockup{}
+				defer c.AssertExpectations(t)
+				c.ExpectProtectionContext().Return(p)
+				if tc.attack {
+					c.ExpectHandleAttack(true, mock.MatchedBy(func(opts []event.AttackEventOption) bool {
+						var attack event.AttackEvent
+						for _, opt := range opts {
+							opt(&attack)
+						}
+						info, ok := attack.Info.(callback.SSRFAttackInfo)
+						return ok && info.Parameter == tc.parameter && attack.StackTrace != nil
+					})).Return(true).Once()
+				}
+				require.NoError(t, cb(c))
+				return true
+			})).Once()
+
+			req, err := http.NewRequest(http.MethodGet, tc.url, nil)
+			require.NoError(t, err)
+			epilog, err := prolog(nil, &req)
+
+			if !tc.attack {
+				require.NoError(t, err)
+				require.Nil(t, epilog)
+				return
+			}
+
+			require.Equal(t, sqhook.AbortError, err)
+			require.NotNil(t, epilog)
+			var callErr error
+			epilog(nil, &callErr)
+			require.True(t, errors.As(callErr, &sdk_types.SqreenError{}))
+		})
+	}
+}
@@ -0,0 +1,52 @@
+// Copyright (c) 2016 - 2020 Sqreen. All Rights Reserved.
+// Please refer to our terms for more information:
+// https://www.sqreen.io/terms.html
+
+package callback
+
+import (
+	"context"
+	"net"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+type resolverFunc func(ctx context.Context, host string) ([]net.IPAddr, error)
+
+func (f resolverFunc) LookupIPAddr(ctx context.Context, host string) ([]net.IPAddr, error) {
+	return f(ctx, host)
+}
+
+func TestSSRFForbiddenAddress(t *testing.T) {
+	type key struct{}
+	ctx := context.WithValue(context.Background(), key{}, "request")
+	resolver := resolverFunc(func(lookupCtx context.Context, host string) ([]net.IPAddr, error) {
+		// The lookup is done within the context of the outgoing request
+		require.Equal(t, "request", lookupCtx.Value(key{}))
+		_, hasDeadline := lookupCtx.Deadline()
+		require.True(t, hasDeadline)
+		if err := lookupCtx.Err(); err != nil {
+			return nil, err
+		}
+		switch host {
+		case "internal.example.com":
+			return []net.IPAddr{{IP: net.ParseIP("1.2.3.4")}, {IP: net.ParseIP("10.0.0.1")}}, nil
+		default:
+			return []net.IPAddr{{IP: net.ParseIP("1.2.3.4")}}, nil
+		}
+	})
+
+	addr, forbidden := ssrfForbiddenAddress(ctx, resolver, "internal.example.com")
+	require.True(t, forbidden)
+	require.Equal(t, "10.0.0.1", addr)
+
+	_, forbidden = ssrfForbiddenAddress(ctx, resolver, "example.com")
+	require.False(t, forbidden)
+
+	// Not resolved once the outgoing request is canceled
+	canceled, cancel := context.WithCancel(ctx)
+	cancel()
+	_, forbidden = ssrfForbiddenAddress(canceled, resolver, "internal.example.com")
+	require.False(t, forbidden)
+}
@@ -0,0 +1,162 @@
+// Copyright (c) 2016 - 2020 Sqreen. All Rights Reserved.
+// Please refer to our terms for more information:
+// https://www.sqreen.io/terms.html
+
+//sqreen:ignore
+
+package callback
+
+import (
+	"fmt"
+	"reflect"
+	"sort"
+	"strconv"
+
+	bindingaccessor "github.com/sqreen/go-agent/internal/binding-accessor"
+	http_protection "github.com/sqreen/go-agent/internal/protection/http"
+	http_protection_types "github.com/sqreen/go-agent/internal/protection/http/types"
+	"github.com/sqreen/go-agent/internal/sqlib/sqerrors"
+)
+
+// UserInput is a request parameter value found in the arguments of a
+// sensitive function call, which is the evidence of the attack reported in the
+// attack information of RASP callbacks.
+type UserInput struct {
+	// Parameter is the name of the request parameter, with nested keys and
+	// indexes separated by dots.
+	Parameter string `json:"parameter"`
+	Value     string `json:"value"`
+}
+
+// userInputMatcher returns true when the given user input value was found in
+// the sensitive function call argument.
+type userInputMatcher func(value string) bool
+
+// requestReaderFromProtectionContext returns the request reader of the given
+// protection context.
+func requestReaderFromProtectionContext(p ProtectionContext) (http_protection_types.RequestReader, error) {
+	switch actual := p.(type) {
+	case *http_protection.ProtectionContext:
+		return actual.RequestReader, nil
+	default:
+		return nil, sqerrors.Errorf("unexpected protection context type `%T`", actual)
+	}
+}
+
+// findUserInput walks the request parameters and returns the first string
+// value for which the matcher returns true. The walk is bounded to the same
+// maximum depth and number of elements than binding accessor values.
+func findUserInput(r http_protection_types.RequestReader, match userInputMatcher) (input UserInput, found bool) {
+	w := userInputWalker{
+		match:       match,
+		maxElements: bindingaccessor.NewValueMaxElements,
+	}
+	sources := []struct {
+		name   string
+		values map[string][]string
+	}{
+		{name: "QueryForm", values: r.QueryForm()},
+		{name: "PostForm", values: r.PostForm()},
+	}
+	for _, source := range sources {
+		if w.walkParams(source.name, source.values) {
+			return w.found, true
+		}
+	}
+	for _, name := range sortedKeys(r.Params()) {
+		if w.walk(name, reflect.ValueOf(r.Params()[name]), bindingaccessor.MaxExecutionDepth) {
+			return w.found, true
+		}
+	}
+	return UserInput{}, false
+}
+
+type userInputWalker struct {
+	match       userInputMatcher
+	maxElements int
+	found       UserInput
+}
+
+func (w *userInputWalker) walkParams(source string, params map[string][]string) (found bool) {
+	for _, name := range sortedKeys(params) {
+		if w.walk(source+"."+name, reflect.ValueOf(params[name]), bindingaccessor.MaxExecutionDepth) {
+			return true
+		}
+	}
+	return false
+}
+
+func (w *userInputWalker) walk(name string, v reflect.Value, depth int) (found bool) {
+	if depth <= 0 || w.maxElements <= 0 || !v.IsValid() {
+		return false
+	}
+	w.maxElements--
+
+	switch v.Kind() {
+	case reflect.String:
+		return w.check(name, v.String())
+
+	case reflect.Ptr, reflect.Interface:
+		if v.IsNil() {
+			return false
+		}
+		return w.walk(name, v.Elem(), depth)
+
+	case reflect.Slice, reflect.Array:
+		if v.Type().Elem().Kind() == reflect.Uint8 {
+			if v.Kind() == reflect.Slice {
+				return w.check(name, string(v.Bytes()))
+			}
+			return false
+		}
+		// Slices of a single value, such as url.Values entries, don't need to be
+		// indexed.
+		if v.Len() == 1 {
+			return w.walk(name, v.Index(0), depth-1)
+		}
+		for i := 0; i < v.Len(); i++ {
+			if w.walk(name+"."+strconv.Itoa(i), v.Index(i), depth-1) {
+				return true
+			}
+		}
+		return false
+
+	case reflect.Map:
+		keys := v.MapKeys()
+		sort.Slice(keys, func(i, j int) bool {
+			return fmt.Sprint(keys[i].Interface()) < fmt.Sprint(keys[j].Interface())
+		})
+		for _, k := range keys {
+			if w.walk(name+"."+fmt.Sprint(k.Interface()), v.MapIndex(k), depth-1) {
+				return true
+			}
+		}
+		return false
+
+	default:
+		return false
+	}
+}
+
+func (w *userInputWalker) check(name, value string) (found bool) {
+	if value == "" || !w.match(value) {
+		return false
+	}
+	w.found = UserInput{Parameter: name, Value: value}
+	return true
+}
+
+// sortedKeys returns the map keys in order so that the reported parameter is
+// deterministic when several parameters match.
+func sortedKeys(m interface{}) []string {
+	v := reflect.ValueOf(m)
+	if v.Kind() != reflect.Map {
+		return nil
+	}
+	keys := make([]string, 0, v.Len())
+	for _, k := range v.MapKeys() {
+		keys = append(keys, k.String())
+	}
+	sort.Strings(keys)
+	return keys
+}
@@ -1,4 +1,4 @@
-// Copyright (c) 2016 - 2019 Sqreen. All Rights Reserved.
+// Copyright (c) 2016 - 2020 Sqreen. All Rights Reserved.
 // Please refer to our terms for more information:
 // https://www.sqreen.io/terms.html
 
@@ -7,10 +7,19 @@
 package callback
 
 import (
+	"bytes"
+	"encoding/json"
+	htmltemplate "html/template"
 	"io"
+	"io/ioutil"
+	"strconv"
+	"strings"
+	"sync"
+	texttemplate "text/template"
 
 	"github.com/sqreen/go-agent/internal/backend/api"
 	httpprotection "github.com/sqreen/go-agent/internal/protection/http"
+	"github.com/sqreen/go-agent/internal/protection/http/types"
 	"github.com/sqreen/go-agent/internal/sqlib/sqassert"
 	"github.com/sqreen/go-agent/internal/sqlib/sqerrors"
 	"github.com/sqreen/go-agent/internal/sqlib/sqhook"
@@ -19,7 +28,10 @@ import (
 // NewWriteBlockingHTMLPageCallback returns the native prolog and epilog
 // callbacks modifying the arguments of `httphandler.WriteResponse` in order to
 // modify the http status code and error page that are provided by the rule's
-// data.
+// data. The blocking response format is negotiated according to the request
+// `Accept` header: JSON for API clients, HTML for browsers and plain text
+// otherwise. Each format can be customized by a template file provided by the
+// agent configuration.
 func NewWriteBlockingHTMLPageCallback(r RuleContext, cfg NativeCallbackConfig) (sqhook.PrologCallback, error) {
 	var statusCode = 500 // default status code
 	if data := cfg.Data(); data != nil {
@@ -35,21 +47,266 @@ func NewWriteBlockingHTMLPageCallback(r RuleContext, cfg NativeCallbackConfig) (
 // The prolog callback modifies the function arguments in order to replace the
 // written status code and body.
 func newWriteBlockingHTMLPagePrologCallback(r RuleContext, statusCode int) httpprotection.NonBlockingPrologCallbackType {
+	var (
+		// The templates are loaded once from the configuration of the first
+		// protection context.
+		loadTemplates sync.Once
+		templates     blockingResponseTemplates
+	)
 	return func(ctx **httpprotection.ProtectionContext) (httpprotection.NonBlockingEpilogCallbackType, error) {
-		r.Pre(func(c CallbackContext) error {
+		r.Pre(func(c CallbackContext) (err error) {
 			sqassert.NotNil(ctx)
 			ctx := *ctx
+			loadTemplates.Do(func() {
+				templates, err = loadBlockingResponseTemplates(ctx.Config())
+			})
+
+			var accept string
+			if v := ctx.RequestReader.Header("Accept"); v != nil {
+				accept = *v
+			}
+			format := negotiateBlockingResponseFormat(accept)
+			body, renderErr := templates.render(format, blockingResponseData{
+				RequestID:  ctx.RequestID(),
+				StatusCode: statusCode,
+			})
+			if renderErr != nil && err == nil {
+				err = renderErr
+			}
+
+			ctx.ResponseWriter.Header().Set("Content-Type", blockingResponseContentTypes[format])
 			// Note that the header must be written first since writing the body first
 			// leads to the default OK status.
 			ctx.ResponseWriter.WriteHeader(statusCode)
 			// Write the blocking page. We ignore any return error as this is a best
 			// effort response attempt: we don't want to penalize the server any
 			// further with this request - so no logging, no counting, no retry.
-			_, _ = io.WriteString(ctx.ResponseWriter, blockedBySqreenPage)
-			return nil
+			_, _ = ctx.ResponseWriter.Write(body)
+			// Return the template errors, if any, so that they get logged.
+			return err
 		})
 		return nil, nil
 	}
 }
 
-const blockedBySqreenPage = `<!-- Sorry, you’ve been blocked --><!DOCTYPE html><html lang="en"><head><meta charset="UTF-8"><meta name="viewport" content="width=device-width,initial-scale=1"><title>You've been blocked</title><style>a,body,div,h1,html,span{margin:0;padding:0;border:0;font-size:100%;font:inherit;vertical-align:baseline}body{background:-webkit-radial-gradient(26% 19%,circle,#fff,#f4f7f9);background:radial-gradient(circle at 26% 19%,#fff,#f4f7f9);display:-webkit-box;display:-ms-flexbox;display:flex;-webkit-box-pack:center;-ms-flex-pack:center;justify-content:center;-webkit-box-align:center;-ms-flex-align:center;align-items:center;-ms-flex-line-pack:center;align-content:center;width:100%;min-height:100vh;line-height:1;flex-direction:column}h1,p,svg{display:block}svg{margin:0 auto 4vh}main{text-align:center;flex:1;display:-webkit-box;display:-ms-flexbox;display:flex;-webkit-box-pack:center;-ms-flex-pack:center;justify-content:center;-webkit-box-align:center;-ms-flex-align:center;align-items:center;-ms-flex-line-pack:center;align-content:center;flex-direction:column}h1{font-family:sans-serif;font-weight:600;font-size:34px;color:#1e0936;line-height:1.2}p{font-size:18px;line-height:normal;color:#646464;font-family:sans-serif;font-weight:400}a{color:#4842b7}footer{width:100%;text-align:center}footer p{font-size:16px}</style></head><body><main><svg width="170px" height="193px" viewBox="0 0 170 193" version="1.1" xmlns="http://www.w3.org/2000/svg" xmlns:xlink="http://www.w3.org/1999/xlink" aria-hidden="true"><g id="exports" stroke="none" stroke-width="1" fill="none" fill-rule="evenodd"><g id="Artboard" transform="translate(-186.000000, -189.000000)"><g id="logo-cmyk-indigo" transform="translate(186.000000, 189.000000)"><g id="nest-cmyk-indigo"><ellipse id="sqreen" fill="#B0ACFF" cx="85" cy="96.5" rx="45.7692308" ry="45.7966102"></ellipse><path d="M78.4615385,175.749389 L78.4615385,102.2092 L13.1398162,64.4731256 L13.1398162,129.181112 L36.352167,115.771438 C37.9764468,119.873152 40.1038639,123.720553 42.6582364,127.237412 L18.5723996,141.151695 L78.4615385,175.749389 Z M91.5384615,175.749389 L151.4276,141.151695 L127.341764,127.237412 C129.896136,123.720553 132.023553,119.873152 133.647833,115.771438 L156.860184,129.181112 L156.860184,64.4731256 L91.5384615,102.2092 L91.5384615,175.749389 Z M18.0061522,52.1754237 L85,90.8774777 L151.993848,52.1754237 L91.5384615,17.2506105 L91.5384615,44.565949 C89.3964992,44.2986903 87.2143177,44.1610169 85,44.1610169 C82.7856823,44.1610169 80.6035008,44.2986903 78.4615385,44.565949 L78.4615385,17.2506105 L18.0061522,52.1754237 Z M90.8846156,1.76392358 L164.052491,44.0326866 C167.693904,46.1363149 169.937107,50.0239804 169.937107,54.231237 L169.937107,138.768763 C169.937107,142.97602 167.693904,146.863685 164.052491,148.967313 L90.8846156,191.236076 C87.2432028,193.339705 82.7567972,193.339705 79.1153844,191.236076 L5.94750871,148.967313 C2.30609589,146.863685 0.0628930904,142.97602 0.0628930904,138.768763 L0.0628930904,54.231237 C0.0628930904,50.0239804 2.30609589,46.1363149 5.94750871,44.0326866 L79.1153844,1.76392358 C82.7567972,-0.339704735 87.2432028,-0.339704735 90.8846156,1.76392358 Z" id="app" fill="#4842B7"></path></g></g></g></g></svg><h1>Sorry, you've been blocked</h1><p>Contact the website owner</p></main><footer><p>Security provided by <a href="https://www.sqreen.com/?utm_medium=block_page" target="_blank">Sqreen</a></p></footer></body></html>`
+// blockingResponseFormat is the format of the blocking response, also used as
+// index of the arrays of values per format.
+type blockingResponseFormat int
+
+const (
+	blockingResponseHTML blockingResponseFormat = iota
+	blockingResponseJSON
+	blockingResponseText
+	blockingResponseFormats
+)
+
+var blockingResponseContentTypes = [blockingResponseFormats]string{
+	blockingResponseHTML: "text/html; charset=utf-8",
+	blockingResponseJSON: "application/json",
+	blockingResponseText: "text/plain; charset=utf-8",
+}
+
+// negotiateBlockingResponseFormat returns the blocking response format the
+// most acceptable according to the given `Accept` header value. The preferred
+// format is the one having the greatest quality factor and then the most
+// specific media range, and the formats are otherwise preferred in the order
+// HTML, JSON and plain text. HTML is therefore returned when the header is
+// absent or accepts any media type, as the agent used to do, while plain text
+// is returned when neither of the formats is acceptable.
+func negotiateBlockingResponseFormat(accept string) blockingResponseFormat {
+	if strings.TrimSpace(accept) == "" {
+		return blockingResponseHTML
+	}
+
+	var (
+		quality [blockingResponseFormats]float64
+		// The specificity of the media range the quality factor comes from. It is
+		// -1 when no media range matches the format.
+		specificity = [blockingResponseFormats]int{-1, -1, -1}
+	)
+	for _, mediaRange := range strings.Split(accept, ",") {
+		mediaType, q := parseMediaRange(mediaRange)
+		for format := blockingResponseHTML; format < blockingResponseFormats; format++ {
+			if s := mediaRangeSpecificity(mediaType, format); s > specificity[format] {
+				specificity[format] = s
+				quality[format] = q
+			}
+		}
+	}
+
+	best := blockingResponseText
+	var bestQuality float64
+	bestSpecificity := -1
+	for format := blockingResponseHTML; format < blockingResponseFormats; format++ {
+		if specificity[format] == -1 || quality[format] <= 0 {
+			continue
+		}
+		if quality[format] > bestQuality || (quality[format] == bestQuality && specificity[format] > bestSpecificity) {
+			best = format
+			bestQuality = quality[format]
+			bestSpecificity = specificity[format]
+		}
+	}
+	return best
+}
+
+// parseMediaRange returns the lower-cased media type of the given media range
+// of an `Accept` header along with its quality factor, which is 1 by default.
+func parseMediaRange(mediaRange string) (mediaType string, quality float64) {
+	params := strings.Split(mediaRange, ";")
+	mediaType = strings.ToLower(strings.TrimSpace(params[0]))
+	quality = 1
+	for _, param := range params[1:] {
+		kv := strings.SplitN(param, "=", 2)
+		if len(kv) != 2 || strings.TrimSpace(kv[0]) != "q" {
+			continue
+		}
+		if q, err := strconv.ParseFloat(strings.TrimSpace(kv[1]), 64); err == nil && q >= 0 && q <= 1 {
+			quality = q
+		}
+	}
+	return mediaType, quality
+}
+
+// mediaRangeSpecificity returns how specifically the given media range matches
+// the format: 2 for a full media type, 1 for a subtype wildcard, 0 for the
+// full wildcard and -1 when it doesn't match. JSON also matches the media types
+// having the `+json` structured syntax suffix, such as
+// `application/problem+json`.
+func mediaRangeSpecificity(mediaType string, format blockingResponseFormat) int {
+	if mediaType == "*/*" {
+		return 0
+	}
+	var typ, subtype string
+	switch format {
+	case blockingResponseHTML:
+		typ, subtype = "text", "html"
+	case blockingResponseJSON:
+		typ, subtype = "application", "json"
+	case blockingResponseText:
+		typ, subtype = "text", "plain"
+	}
+	switch {
+	case mediaType == typ+"/"+subtype:
+		return 2
+	case format == blockingResponseJSON && strings.HasPrefix(mediaType, "application/") && strings.HasSuffix(mediaType, "+json"):
+		return 2
+	case mediaType == typ+"/*":
+		return 1
+	default:
+		return -1
+	}
+}
+
+// blockingResponseData is the data given to the blocking response templates.
+type blockingResponseData struct {
+	// RequestID is the ID of the request the client can communicate to the
+	// support so that the report can be correlated with the recorded attack.
+	RequestID string
+	// StatusCode is the HTTP status code of the blocking response.
+	StatusCode int
+}
+
+// blockingResponseTemplate is the common interface of HTML and text templates.
+type blockingResponseTemplate interface {
+	Execute(w io.Writer, data interface{}) error
+}
+
+// blockingResponseTemplates are the blocking response templates per format.
+type blockingResponseTemplates [blockingResponseFormats]blockingResponseTemplate
+
+var defaultBlockingResponseTemplates = blockingResponseTemplates{
+	blockingResponseHTML: mustParseBlockingResponseTemplate(blockingResponseHTML, blockedBySqreenPage),
+	blockingResponseJSON: mustParseBlockingResponseTemplate(blockingResponseJSON, blockedBySqreenJSON),
+	blockingResponseText: mustParseBlockingResponseTemplate(blockingResponseText, blockedBySqreenText),
+}
+
+func mustParseBlockingResponseTemplate(format blockingResponseFormat, text string) blockingResponseTemplate {
+	t, err := parseBlockingResponseTemplate(format, "default", text)
+	if err != nil {
+		panic(err)
+	}
+	return t
+}
+
+// loadBlockingResponseTemplates returns the blocking response templates using
+// the template files of the configuration when set, and the default ones
+// otherwise. The default template is also used when a template file cannot be
+// loaded, and the returned error then describes every template error.
+func loadBlockingResponseTemplates(cfg types.ConfigReader) (templates blockingResponseTemplates, err error) {
+	templates = defaultBlockingResponseTemplates
+	if cfg == nil {
+		return templates, nil
+	}
+	var errs sqerrors.ErrorCollection
+	for format, path := range [blockingResponseFormats]string{
+		blockingResponseHTML: cfg.BlockingHTMLTemplate(),
+		blockingResponseJSON: cfg.BlockingJSONTemplate(),
+		blockingResponseText: cfg.BlockingTextTemplate(),
+	} {
+		if path == "" {
+			continue
+		}
+		buf, err := ioutil.ReadFile(path)
+		if err != nil {
+			errs.Add(sqerrors.Wrap(err, "could not read the blocking response template file"))
+			continue
+		}
+		t, err := parseBlockingResponseTemplate(blockingResponseFormat(format), path, string(buf))
+		if err != nil {
+			errs.Add(sqerrors.Wrapf(err, "could not parse the blocking response template file `%s`", path))
+			continue
+		}
+		templates[format] = t
+	}
+	if len(errs) > 0 {
+		return templates, errs
+	}
+	return templates, nil
+}
+
+// parseBlockingResponseTemplate parses the given template. HTML templates are
+// parsed by package `html/template` in order to escape the values according to
+// their context, while the others are parsed by package `text/template` and
+// can use function `json` to write JSON values.
+func parseBlockingResponseTemplate(format blockingResponseFormat, name, text string) (blockingResponseTemplate, error) {
+	if format == blockingResponseHTML {
+		t, err := htmltemplate.New(name).Parse(text)
+		if err != nil {
+			return nil, err
+		}
+		return t, nil
+	}
+	t, err := texttemplate.New(name).Funcs(texttemplate.FuncMap{"json": marshalJSONTemplateValue}).Parse(text)
+	if err != nil {
+		return nil, err
+	}
+	return t, nil
+}
+
+func marshalJSONTemplateValue(v interface{}) (string, error) {
+	buf, err := json.Marshal(v)
+	return string(buf), err
+}
+
+// render returns the blocking response body of the given format. The default
+// template is used when the template execution fails.
+func (t *blockingResponseTemplates) render(format blockingResponseFormat, data blockingResponseData) ([]byte, error) {
+	var buf bytes.Buffer
+	err := t[format].Execute(&buf, data)
+	if err == nil {
+		return buf.Bytes(), nil
+	}
+	buf.Reset()
+	if defaultErr := defaultBlockingResponseTemplates[format].Execute(&buf, data); defaultErr != nil {
+		return nil, sqerrors.Wrap(defaultErr, "could not execute the default blocking response template")
+	}
+	return buf.Bytes(), sqerrors.Wrap(err, "could not execute the blocking response template")
+}
+
+const blockedBySqreenJSON = `{"status":{{.StatusCode}},"message":"Sorry, you've been blocked","request_id":{{json .RequestID}}}`
+
+const blockedBySqreenText = `Sorry, you've been blocked. Contact the website owner with the request ID {{.RequestID}}.
+`
+
+const blockedBySqreenPage = `<!-- Sorry, you’ve been blocked --><!DOCTYPE html><html lang="en"><head><meta charset="UTF-8"><meta name="viewport" content="width=device-width,initial-scale=1"><title>You've been blocked</title><style>a,body,div,h1,html,span{margin:0;padding:0;border:0;font-size:100%;font:inherit;vertical-align:baseline}body{background:-webkit-radial-gradient(26% 19%,circle,#fff,#f4f7f9);background:radial-gradient(circle at 26% 19%,#fff,#f4f7f9);display:-webkit-box;display:-ms-flexbox;display:flex;-webkit-box-pack:center;-ms-flex-pack:center;justify-content:center;-webkit-box-align:center;-ms-flex-align:center;align-items:center;-ms-flex-line-pack:center;align-content:center;width:100%;min-height:100vh;line-height:1;flex-direction:column}h1,p,svg{display:block}svg{margin:0 auto 4vh}main{text-align:center;flex:1;display:-webkit-box;display:-ms-flexbox;display:flex;-webkit-box-pack:center;-ms-flex-pack:center;justify-content:center;-webkit-box-align:center;-ms-flex-align:center;align-items:center;-ms-flex-line-pack:center;align-content:center;flex-direction:column}h1{font-family:sans-serif;font-weight:600;font-size:34px;color:#1e0936;line-height:1.2}p{font-size:18px;line-height:normal;color:#646464;font-family:sans-serif;font-weight:400}a{color:#4842b7}footer{width:100%;text-align:center}footer p{font-size:16px}</style></head><body><main><svg width="170px" height="193px" viewBox="0 0 170 193" version="1.1" xmlns="http://www.w3.org/2000/svg" xmlns:xlink="http://www.w3.org/1999/xlink" aria-hidden="true"><g id="exports" stroke="none" stroke-width="1" fill="none" fill-rule="evenodd"><g id="Artboard" transform="translate(-186.000000, -189.000000)"><g id="logo-cmyk-indigo" transform="translate(186.000000, 189.000000)"><g id="nest-cmyk-indigo"><ellipse id="sqreen" fill="#B0ACFF" cx="85" cy="96.5" rx="45.7692308" ry="45.7966102"></ellipse><path d="M78.4615385,175.749389 L78.4615385,102.2092 L13.1398162,64.4731256 L13.1398162,129.181112 L36.352167,115.771438 C37.9764468,119.873152 40.1038639,123.720553 42.6582364,127.237412 L18.5723996,141.151695 L78.4615385,175.749389 Z M91.5384615,175.749389 L151.4276,141.151695 L127.341764,127.237412 C129.896136,123.720553 132.023553,119.873152 133.647833,115.771438 L156.860184,129.181112 L156.860184,64.4731256 L91.5384615,102.2092 L91.5384615,175.749389 Z M18.0061522,52.1754237 L85,90.8774777 L151.993848,52.1754237 L91.5384615,17.2506105 L91.5384615,44.565949 C89.3964992,44.2986903 87.2143177,44.1610169 85,44.1610169 C82.7856823,44.1610169 80.6035008,44.2986903 78.4615385,44.565949 L78.4615385,17.2506105 L18.0061522,52.1754237 Z M90.8846156,1.76392358 L164.052491,44.0326866 C167.693904,46.1363149 169.937107,50.0239804 169.937107,54.231237 L169.937107,138.768763 C169.937107,142.97602 167.693904,146.863685 164.052491,148.967313 L90.8846156,191.236076 C87.2432028,193.339705 82.7567972,193.339705 79.1153844,191.236076 L5.94750871,148.967313 C2.30609589,146.863685 0.0628930904,142.97602 0.0628930904,138.768763 L0.0628930904,54.231237 C0.0628930904,50.0239804 2.30609589,46.1363149 5.94750871,44.0326866 L79.1153844,1.76392358 C82.7567972,-0.339704735 87.2432028,-0.339704735 90.8846156,1.76392358 Z" id="app" fill="#4842B7"></path></g></g></g></g></svg><h1>Sorry, you've been blocked</h1><p>Contact the website owner with the request ID {{.RequestID}}</p></main><footer><p>Security provided by <a href="https://www.sqreen.com/?utm_medium=block_page" target="_blank">Sqreen</a></p></footer></body></html>`
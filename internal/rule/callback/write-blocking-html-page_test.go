@@ -1,9 +1,29 @@
-// Copyright (c) 2016 - 2019 Sqreen. All Rights Reserved.
+// Copyright (c) 2016 - 2020 Sqreen. All Rights Reserved.
 // Please refer to our terms for more information:
 // https://www.sqreen.io/terms.html
 
 package callback_test
 
+import (
+	"encoding/json"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/sqreen/go-agent/internal/backend/api"
+	http_protection "github.com/sqreen/go-agent/internal/protection/http"
+	http_protection_mockups "github.com/sqreen/go-agent/internal/protection/http/_testlib/mockups"
+	"github.com/sqreen/go-agent/internal/rule/callback"
+	"github.com/sqreen/go-agent/internal/rule/callback/_testlib/mockups"
+	middleware_mockups "github.com/sqreen/go-agent/sdk/middleware/_testlib/mockups"
+	"github.com/stretchr/testify/mock"
+	"github.com/stretchr/testify/require"
+)
+
 //func TestNewWriteCustomErrorPageCallbacks(t *testing.T) {
 //	RunNativeCallbackTest(t, TestConfig{
 //		CallbacksCtor: callback.NewWriteBlockingHTMLPageCallback,
@@ -52,3 +72,183 @@ package callback_test
 //		}
 //	}
 //}
+
+// blockingResponseTest allows to call the blocking response prolog with
+// requests having the given headers and to get the written response along with
+// the error returned by the rule callback.
+type blockingResponseTest struct {
+	prolog http_protection.NonBlockingPrologCallbackType
+	rule   *mockups.NativeRuleContextMockup
+	cfg    *middleware_mockups.HTTPProtectionConfigMockup
+	err    error
+}
+
+func newBlockingResponseTest(t *testing.T, data interface{}, cfg *middleware_mockups.HTTPProtectionConfigMockup) *blockingResponseTest {
+	test := &blockingResponseTest{
+		rule: &mockups.NativeRuleContextMockup{},
+		cfg:  cfg,
+	}
+	test.rule.ExpectPre(mock.MatchedBy(func(cb func(callback.CallbackContext) error) bool {
+		test.err = cb(&mockups.CallbackContextMockup{})
+		return true
+	}))
+
+	cbCfg := &mockups.NativeCallbackConfigMockup{}
+	cbCfg.ExpectData().Return(data)
+	prolog, err := callback.NewWriteBlockingHTMLPageCallback(test.rule, cbCfg)
+	require.NoError(t, err)
+	test.prolog = prolog.(http_protection.NonBlockingPrologCallbackType)
+	return test
+}
+
+func (test *blockingResponseTest) do(t *testing.T, headers map[string]string) *httptest.ResponseRecorder {
+	rootCtx := &middleware_mockups.RootHTTPProtectionContextMockup{}
+	rootCtx.ExpectConfig().Return(test.cfg).Maybe()
+
+	requestReaderMockup := &http_protection_mockups.RequestReaderMockup{}
+	for _, header := range []string{"Accept", "X-Request-Id"} {
+		if value, exists := headers[header]; exists {
+			requestReaderMockup.On("Header", header).Return(&value)
+		} else {
+			requestReaderMockup.On("Header", header).Return(nil).Maybe()
+		}
+	}
+	defer requestReaderMockup.AssertExpectations(t)
+
+	rec := httptest.NewRecorder()
+	p := http_protection.NewTestProtectionContext(rootCtx, net.IPv4(1, 2, 3, 4), rec, requestReaderMockup)
+	epilog, err := test.prolog(&p)
+	require.NoError(t, err)
+	require.Nil(t, epilog)
+	return rec
+}
+
+func TestWriteBlockingHTMLPageCallback(t *testing.T) {
+	t.Run("content negotiation", func(t *testing.T) {
+		test := newBlockingResponseTest(t, nil, middleware_mockups.NewHTTPProtectionConfigMockup())
+		for _, tc := range []struct {
+			accept      *string
+			contentType string
+		}{
+			{contentType: "text/html; charset=utf-8"},
+			{accept: strPtr(""), contentType: "text/html; charset=utf-8"},
+			{accept: strPtr("*/*"), contentType: "text/html; charset=utf-8"},
+			{accept: strPtr("text/html,application/xhtml+xml,application/xml;q=0.9,*/*;q=0.8"), contentType: "text/html; charset=utf-8"},
+			{accept: strPtr("application/json"), contentType: "application/json"},
+			{accept: strPtr("application/json, text/plain, */*"), contentType: "application/json"},
+			{accept: strPtr("application/problem+json"), contentType: "application/json"},
+			{accept: strPtr("text/html;q=0.5, application/*"), contentType: "application/json"},
+			{accept: strPtr("text/*, application/json;q=0.9"), contentType: "text/html; charset=utf-8"},
+			{accept: strPtr("text/plain"), contentType: "text/plain; charset=utf-8"},
+			{accept: strPtr("text/html;q=0, */*"), contentType: "application/json"},
+			{accept: strPtr("image/png"), contentType: "text/plain; charset=utf-8"},
+			{accept: strPtr("oops;;q=,"), contentType: "text/plain; charset=utf-8"},
+		} {
+			headers := map[string]string{}
+			if tc.accept != nil {
+				headers["Accept"] = *tc.accept
+			}
+			rec := test.do(t, headers)
+			require.NoError(t, test.err)
+			require.Equal(t, http.StatusInternalServerError, rec.Code, headers)
+			require.Equal(t, tc.contentType, rec.Header().Get("Content-Type"), headers)
+		}
+	})
+
+	t.Run("default templates", func(t *testing.T) {
+		test := newBlockingResponseTest(t, &api.CustomErrorPageRuleDataEntry{StatusCode: 403}, middleware_mockups.NewHTTPProtectionConfigMockup())
+
+		rec := test.do(t, map[string]string{"Accept": "application/json", "X-Request-Id": `my "request" id`})
+		require.NoError(t, test.err)
+		require.Equal(t, http.StatusForbidden, rec.Code)
+		var body struct {
+			Status    int    `json:"status"`
+			RequestID string `json:"request_id"`
+		}
+		require.NoError(t, json.Unmarshal(rec.Body.Bytes(), &body))
+		require.Equal(t, http.StatusForbidden, body.Status)
+		require.Equal(t, `my "request" id`, body.RequestID)
+
+		rec = test.do(t, map[string]string{"Accept": "text/plain", "X-Request-Id": "my-request-id"})
+		require.NoError(t, test.err)
+		require.Contains(t, rec.Body.String(), "my-request-id")
+
+		rec = test.do(t, map[string]string{"X-Request-Id": "<my-request-id>"})
+		require.NoError(t, test.err)
+		require.Contains(t, rec.Body.String(), "&lt;my-request-id&gt;")
+
+		// A request ID is generated when the request has none
+		rec = test.do(t, map[string]string{"Accept": "application/json"})
+		require.NoError(t, test.err)
+		require.NoError(t, json.Unmarshal(rec.Body.Bytes(), &body))
+		require.NotEmpty(t, body.RequestID)
+	})
+
+	t.Run("custom templates", func(t *testing.T) {
+		dir, err := ioutil.TempDir("", "callback")
+		require.NoError(t, err)
+		defer os.RemoveAll(dir)
+
+		htmlTemplate := filepath.Join(dir, "blocked.html")
+		require.NoError(t, ioutil.WriteFile(htmlTemplate, []byte(`<p>{{.StatusCode}} {{.RequestID}}</p>`), 0644))
+		jsonTemplate := filepath.Join(dir, "blocked.json")
+		require.NoError(t, ioutil.WriteFile(jsonTemplate, []byte(`{"id":{{json .RequestID}}}`), 0644))
+
+		cfg := &middleware_mockups.HTTPProtectionConfigMockup{}
+		defer cfg.AssertExpectations(t)
+		cfg.ExpectBlockingHTMLTemplate().Return(htmlTemplate).Once()
+		cfg.ExpectBlockingJSONTemplate().Return(jsonTemplate).Once()
+		cfg.ExpectBlockingTextTemplate().Return("").Once()
+
+		// The templates are loaded once
+		test := newBlockingResponseTest(t, nil, cfg)
+		for i := 0; i < 2; i++ {
+			rec := test.do(t, map[string]string{"Accept": "text/html", "X-Request-Id": "<id>"})
+			require.NoError(t, test.err)
+			require.Equal(t, "<p>500 &lt;id&gt;</p>", rec.Body.String())
+
+			rec = test.do(t, map[string]string{"Accept": "application/json", "X-Request-Id": "id"})
+			require.NoError(t, test.err)
+			require.Equal(t, `{"id":"id"}`, rec.Body.String())
+
+			rec = test.do(t, map[string]string{"Accept": "text/plain", "X-Request-Id": "id"})
+			require.NoError(t, test.err)
+			require.Contains(t, rec.Body.String(), "Sorry, you've been blocked")
+		}
+	})
+
+	t.Run("invalid custom templates", func(t *testing.T) {
+		dir, err := ioutil.TempDir("", "callback")
+		require.NoError(t, err)
+		defer os.RemoveAll(dir)
+
+		textTemplate := filepath.Join(dir, "blocked.txt")
+		require.NoError(t, ioutil.WriteFile(textTemplate, []byte(`{{.Oops`), 0644))
+		jsonTemplate := filepath.Join(dir, "blocked.json")
+		require.NoError(t, ioutil.WriteFile(jsonTemplate, []byte(`{{.Oops}}`), 0644))
+
+		cfg := &middleware_mockups.HTTPProtectionConfigMockup{}
+		cfg.ExpectBlockingHTMLTemplate().Return(filepath.Join(dir, "not-found.html"))
+		cfg.ExpectBlockingJSONTemplate().Return(jsonTemplate)
+		cfg.ExpectBlockingTextTemplate().Return(textTemplate)
+
+		test := newBlockingResponseTest(t, nil, cfg)
+
+		// The loading errors are returned once and the default templates are used
+		rec := test.do(t, map[string]string{"Accept": "text/html", "X-Request-Id": "id"})
+		require.Error(t, test.err)
+		require.Contains(t, rec.Body.String(), "Sorry, you've been blocked")
+
+		rec = test.do(t, map[string]string{"Accept": "text/plain", "X-Request-Id": "id"})
+		require.NoError(t, test.err)
+		require.Contains(t, rec.Body.String(), "Sorry, you've been blocked")
+
+		// Template execution errors fallback to the default template
+		rec = test.do(t, map[string]string{"Accept": "application/json", "X-Request-Id": "id"})
+		require.Error(t, test.err)
+		require.Equal(t, http.StatusInternalServerError, rec.Code)
+		require.Contains(t, rec.Body.String(), `"request_id":"id"`)
+	})
+}
+
+func strPtr(s string) *string { return &s }
@@ -43,6 +43,20 @@ func NewNativeCallback(name string, ctx *nativeRuleContext, cfg callback.NativeC
 		callbackCtor = callback.NewIPDenyListCallback
 	case "Shellshock":
 		callbackCtor = callback.NewShellshockCallback
+	case "SSRF":
+		callbackCtor = callback.NewSSRFCallback
+	case "SQLInjection":
+		callbackCtor = callback.NewSQLInjectionCallback
+	case "CommandInjection":
+		callbackCtor = callback.NewCommandInjectionCallback
+	case "PathTraversal", "LFI":
+		callbackCtor = callback.NewPathTraversalCallback
+	case "NoSQLInjection":
+		callbackCtor = callback.NewNoSQLInjectionCallback
+	case "ReflectedXSS":
+		callbackCtor = callback.NewReflectedXSSCallback
+	case "OpenRedirect":
+		callbackCtor = callback.NewOpenRedirectCallback
 	}
 	return callbackCtor(ctx, cfg)
 }
@@ -51,6 +51,7 @@ import (
 	"runtime"
 	"sort"
 	"strings"
+	"sync"
 	"sync/atomic"
 	"unsafe"
 
@@ -69,7 +70,10 @@ type symbolIndexType map[string]*Hook
 // index of hooks by symbol string. The index is lazily created when symbols
 // are searched. Note that due to the large amount of hooks, we avoid having
 // a map of hook pointer in order to avoid GC overhead.
-var index = make(symbolIndexType)
+var (
+	index     = make(symbolIndexType)
+	indexLock sync.Mutex
+)
 
 type Hook struct {
 	// Symbol name of the function the hook is associated with.
@@ -94,6 +98,13 @@ type (
 	PrologCallbackGetter interface {
 		PrologCallback() PrologCallback
 	}
+	// PrologCallbackSelector is implemented by callback objects providing
+	// prolog functions for several hook signatures, such as the same protection
+	// attached to methods of different types. The returned prolog callback is
+	// nil when the given prolog type is not supported.
+	PrologCallbackSelector interface {
+		PrologCallbackOf(prologType reflect.Type) PrologCallback
+	}
 	ReflectedPrologCallback = func(params []reflect.Value) (epilog ReflectedEpilogCallback, err error)
 	ReflectedEpilogCallback = func(results []reflect.Value)
 )
@@ -133,8 +144,14 @@ func Health(expectedVersion string) error {
 }
 
 // Find returns the hook associated to the given symbol string when it was
-// created using `New()`, nil otherwise.
+// created using `New()`, nil otherwise. It can be called concurrently.
 func Find(symbol string) (*Hook, error) {
+	if _sqreen_instrumentation_descriptor == nil {
+		// The program is not instrumented
+		return nil, nil
+	}
+	indexLock.Lock()
+	defer indexLock.Unlock()
 	return index.find(symbol)
 }
 
@@ -246,6 +263,11 @@ func (h *Hook) String() string {
 	return fmt.Sprintf("%s (%s)", h.symbol, h.prologFuncType)
 }
 
+// Attached returns true when a prolog function is attached to the hook.
+func (h *Hook) Attached() bool {
+	return atomic.LoadPointer(h.prologVarAddr) != nil
+}
+
 // Attach atomically attaches a prolog function to the hook. The hook can be
 // disabled with a `nil` prolog value.
 func (h *Hook) Attach(prologs ...PrologCallback) error {
@@ -266,6 +288,11 @@ func (h *Hook) Attach(prologs ...PrologCallback) error {
 				prolog = makePrologCallback(h, actual)
 			case PrologCallbackGetter:
 				prolog = actual.PrologCallback()
+			case PrologCallbackSelector:
+				prolog = actual.PrologCallbackOf(h.prologFuncType)
+				if prolog == nil {
+					return sqerrors.Errorf("no prolog callback of type `%s` for hook `%s`", h.prologFuncType, h)
+				}
 			default:
 				// Final type
 				break loop
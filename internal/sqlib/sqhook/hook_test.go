@@ -186,6 +186,17 @@ func (p prologCallbackGetter) PrologCallback() sqhook.PrologCallback {
 	return p.prolog
 }
 
+type prologCallbackSelector []sqhook.PrologCallback
+
+func (s prologCallbackSelector) PrologCallbackOf(prologType reflect.Type) sqhook.PrologCallback {
+	for _, prolog := range s {
+		if reflect.TypeOf(prolog) == prologType {
+			return prolog
+		}
+	}
+	return nil
+}
+
 func TestAttach(t *testing.T) {
 	for _, tc := range []struct {
 		Symbol         string
@@ -336,6 +347,20 @@ func TestAttach(t *testing.T) {
 					})
 				})
 
+				t.Run("prolog callback selector", func(t *testing.T) {
+					t.Run("having the expected prolog type", func(t *testing.T) {
+						err = hook.Attach(prologCallbackSelector{func() {}, expectedProlog.Interface()})
+						require.NoError(t, err)
+						// Read back the prolog variable
+						checkPrologAddr(t, expectedProlog.Pointer())
+					})
+
+					t.Run("not having the expected prolog type", func(t *testing.T) {
+						err = hook.Attach(prologCallbackSelector{func() {}})
+						require.Error(t, err)
+					})
+				})
+
 				t.Run("multiple prolog callbacks", func(t *testing.T) {
 					var reflected sqhook.ReflectedPrologCallback = func(params []reflect.Value) (epilog sqhook.ReflectedEpilogCallback, err error) {
 						return nil, nil
@@ -395,8 +420,10 @@ func TestEnableDisable(t *testing.T) {
 	expectedProlog := reflect.MakeFunc(prologType, func(args []reflect.Value) (results []reflect.Value) {
 		return []reflect.Value{{}, {}}
 	})
+	require.False(t, hook.Attached())
 	err = hook.Attach(expectedProlog.Interface())
 	require.NoError(t, err)
+	require.True(t, hook.Attached())
 
 	// Read the prolog variable and check it points to the previous prolog
 	// function
@@ -416,6 +443,7 @@ func TestEnableDisable(t *testing.T) {
 	// Walk the prolog var value in order to get the function pointer
 	prolog = loadProlog()
 	require.Equal(t, unsafe.Pointer(nil), prolog)
+	require.False(t, hook.Attached())
 }
 
 func TestStringer(t *testing.T) {
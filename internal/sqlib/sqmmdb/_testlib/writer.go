@@ -0,0 +1,231 @@
+// Copyright (c) 2016 - 2020 Sqreen. All Rights Reserved.
+// Please refer to our terms for more information:
+// https://www.sqreen.io/terms.html
+
+// Package testlib provides a MaxMind DB writer to create test databases.
+package testlib
+
+import (
+	"encoding/binary"
+	"fmt"
+	"math"
+	"net"
+	"sort"
+)
+
+// NewMMDB returns the content of a MaxMind DB file of the given IP version and
+// record size, and associating the given networks in CIDR notation to their
+// record values. IPv4 networks of IPv6 databases are stored in the `::/96`
+// subtree. Networks must not overlap. Repeated strings are stored once and
+// referenced using pointers.
+func NewMMDB(ipVersion, recordSize int, networks map[string]interface{}) []byte {
+	type node [2]int
+	const (
+		empty = -1
+		// data records are stored as negative values below empty
+		dataBase = -2
+	)
+	nodes := []node{{empty, empty}}
+	data := &encoder{strings: map[string]int{}}
+
+	// Insert the networks in a deterministic order
+	cidrs := make([]string, 0, len(networks))
+	for cidr := range networks {
+		cidrs = append(cidrs, cidr)
+	}
+	sort.Strings(cidrs)
+
+	for _, cidr := range cidrs {
+		_, ipnet, err := net.ParseCIDR(cidr)
+		if err != nil {
+			panic(err)
+		}
+		ones, _ := ipnet.Mask.Size()
+		ip := ipnet.IP
+		if ip4 := ip.To4(); ip4 != nil {
+			ip = ip4
+			if ipVersion == 6 {
+				ip = append(make(net.IP, 12), ip4...)
+				ones += 96
+			}
+		}
+		offset := data.encode(networks[cidr])
+
+		n := 0
+		for i := 0; i < ones; i++ {
+			bit := int(ip[i/8]>>(7-uint(i%8))) & 1
+			if i == ones-1 {
+				nodes[n][bit] = dataBase - offset
+				break
+			}
+			if nodes[n][bit] < 0 {
+				nodes = append(nodes, node{empty, empty})
+				nodes[n][bit] = len(nodes) - 1
+			}
+			n = nodes[n][bit]
+		}
+	}
+
+	nodeCount := len(nodes)
+	var tree []byte
+	for _, n := range nodes {
+		var records [2]uint32
+		for i, v := range n {
+			switch {
+			case v == empty:
+				records[i] = uint32(nodeCount)
+			case v <= dataBase:
+				records[i] = uint32(nodeCount + 16 + dataBase - v)
+			default:
+				records[i] = uint32(v)
+			}
+		}
+		tree = append(tree, encodeNode(recordSize, records)...)
+	}
+
+	buf := append(tree, make([]byte, 16)...)
+	buf = append(buf, data.buf...)
+	buf = append(buf, "\xAB\xCD\xEFMaxMind.com"...)
+	metadata := &encoder{strings: map[string]int{}}
+	metadata.encode(map[string]interface{}{
+		"binary_format_major_version": uint16(2),
+		"binary_format_minor_version": uint16(0),
+		"build_epoch":                 uint64(1600000000),
+		"database_type":               "Test-DB",
+		"description":                 map[string]interface{}{"en": "Test DB"},
+		"ip_version":                  uint16(ipVersion),
+		"languages":                   []interface{}{"en"},
+		"node_count":                  uint32(nodeCount),
+		"record_size":                 uint16(recordSize),
+	})
+	return append(buf, metadata.buf...)
+}
+
+func encodeNode(recordSize int, records [2]uint32) []byte {
+	l, r := records[0], records[1]
+	switch recordSize {
+	case 24:
+		return []byte{byte(l >> 16), byte(l >> 8), byte(l), byte(r >> 16), byte(r >> 8), byte(r)}
+	case 28:
+		return []byte{byte(l >> 16), byte(l >> 8), byte(l), byte(l>>24)<<4 | byte(r>>24)&0x0F, byte(r >> 16), byte(r >> 8), byte(r)}
+	case 32:
+		b := make([]byte, 8)
+		binary.BigEndian.PutUint32(b, l)
+		binary.BigEndian.PutUint32(b[4:], r)
+		return b
+	default:
+		panic(fmt.Sprintf("unexpected record size %d", recordSize))
+	}
+}
+
+type encoder struct {
+	buf     []byte
+	strings map[string]int
+}
+
+// encode appends the value to the buffer and returns its offset.
+func (e *encoder) encode(v interface{}) int {
+	offset := len(e.buf)
+	switch actual := v.(type) {
+	case string:
+		if ptr, exists := e.strings[actual]; exists {
+			e.pointer(ptr)
+			break
+		}
+		e.strings[actual] = offset
+		e.control(2, len(actual))
+		e.buf = append(e.buf, actual...)
+	case float64:
+		e.control(3, 8)
+		e.buf = append(e.buf, make([]byte, 8)...)
+		binary.BigEndian.PutUint64(e.buf[len(e.buf)-8:], math.Float64bits(actual))
+	case []byte:
+		e.control(4, len(actual))
+		e.buf = append(e.buf, actual...)
+	case uint16:
+		e.uint(5, uint64(actual))
+	case uint32:
+		e.uint(6, uint64(actual))
+	case map[string]interface{}:
+		keys := make([]string, 0, len(actual))
+		for k := range actual {
+			keys = append(keys, k)
+		}
+		sort.Strings(keys)
+		e.control(7, len(actual))
+		for _, k := range keys {
+			e.encode(k)
+			e.encode(actual[k])
+		}
+	case int32:
+		e.control(8, 4)
+		e.buf = append(e.buf, byte(actual>>24), byte(actual>>16), byte(actual>>8), byte(actual))
+	case uint64:
+		e.uint(9, actual)
+	case []interface{}:
+		e.control(11, len(actual))
+		for _, v := range actual {
+			e.encode(v)
+		}
+	case bool:
+		size := 0
+		if actual {
+			size = 1
+		}
+		e.control(14, size)
+	default:
+		panic(fmt.Sprintf("unexpected value type %T", v))
+	}
+	return offset
+}
+
+// uint encodes the unsigned integer using the minimum number of bytes.
+func (e *encoder) uint(typ int, v uint64) {
+	var b []byte
+	for ; v > 0; v >>= 8 {
+		b = append([]byte{byte(v)}, b...)
+	}
+	e.control(typ, len(b))
+	e.buf = append(e.buf, b...)
+}
+
+func (e *encoder) control(typ, size int) {
+	var ctrl byte
+	var ext []byte
+	if typ > 7 {
+		ext = []byte{byte(typ - 7)}
+	} else {
+		ctrl = byte(typ) << 5
+	}
+	var sizeBytes []byte
+	switch {
+	case size < 29:
+		ctrl |= byte(size)
+	case size < 285:
+		ctrl |= 29
+		sizeBytes = []byte{byte(size - 29)}
+	case size < 65821:
+		ctrl |= 30
+		size -= 285
+		sizeBytes = []byte{byte(size >> 8), byte(size)}
+	default:
+		ctrl |= 31
+		size -= 65821
+		sizeBytes = []byte{byte(size >> 16), byte(size >> 8), byte(size)}
+	}
+	e.buf = append(e.buf, ctrl)
+	e.buf = append(e.buf, ext...)
+	e.buf = append(e.buf, sizeBytes...)
+}
+
+func (e *encoder) pointer(offset int) {
+	switch {
+	case offset < 2048:
+		e.buf = append(e.buf, 1<<5|byte(offset>>8), byte(offset))
+	case offset < 526336:
+		offset -= 2048
+		e.buf = append(e.buf, 1<<5|1<<3|byte(offset>>16), byte(offset>>8), byte(offset))
+	default:
+		panic("unsupported pointer size")
+	}
+}
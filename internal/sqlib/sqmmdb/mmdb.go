@@ -0,0 +1,452 @@
+// Copyright (c) 2016 - 2020 Sqreen. All Rights Reserved.
+// Please refer to our terms for more information:
+// https://www.sqreen.io/terms.html
+
+// Package sqmmdb implements a minimal reader of MaxMind DB files (MMDB) such as
+// the GeoIP2/GeoLite2 Country and ASN databases, following the MaxMind DB file
+// format specification version 2. The whole file is loaded in memory and
+// decoded lazily during the lookups, which only allocate the returned record
+// values.
+package sqmmdb
+
+import (
+	"bytes"
+	"encoding/binary"
+	"io/ioutil"
+	"math"
+	"math/big"
+	"net"
+
+	"github.com/sqreen/go-agent/internal/sqlib/sqerrors"
+)
+
+// metadataStartMarker is the byte sequence preceding the metadata section,
+// which is located in the last 128KiB of the file.
+var metadataStartMarker = []byte("\xAB\xCD\xEFMaxMind.com")
+
+const (
+	maxMetadataSize = 128 * 1024
+	// Size of the data section separator following the search tree.
+	dataSectionSeparatorSize = 16
+	// Maximum depth of nested maps and arrays the decoder accepts.
+	maxDataDepth = 32
+)
+
+// Data field types.
+const (
+	typeExtended = iota
+	typePointer
+	typeString
+	typeDouble
+	typeBytes
+	typeUint16
+	typeUint32
+	typeMap
+	typeInt32
+	typeUint64
+	typeUint128
+	typeArray
+	typeContainer
+	typeEndMarker
+	typeBool
+	typeFloat
+)
+
+// Reader is a MaxMind DB reader. It is safe for concurrent use.
+type Reader struct {
+	Metadata Metadata
+
+	tree        []byte
+	data        []byte
+	nodeCount   uint
+	recordSize  uint
+	ipv4Start   uint
+	nodeByteLen uint
+}
+
+// Metadata is the database metadata.
+type Metadata struct {
+	DatabaseType string
+	IPVersion    uint
+	NodeCount    uint
+	RecordSize   uint
+	BuildEpoch   uint64
+	Languages    []string
+}
+
+// Open reads the MaxMind DB file at the given path.
+func Open(path string) (*Reader, error) {
+	buf, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, sqerrors.Wrap(err, "could not read the database file")
+	}
+	r, err := FromBytes(buf)
+	if err != nil {
+		return nil, sqerrors.Wrapf(err, "could not load the database file `%s`", path)
+	}
+	return r, nil
+}
+
+// FromBytes returns a reader of the given MaxMind DB file content. The buffer
+// must not be modified after the call.
+func FromBytes(buf []byte) (*Reader, error) {
+	start := len(buf) - maxMetadataSize
+	if start < 0 {
+		start = 0
+	}
+	i := bytes.LastIndex(buf[start:], metadataStartMarker)
+	if i == -1 {
+		return nil, sqerrors.New("invalid database: metadata section not found")
+	}
+	metadataStart := start + i + len(metadataStartMarker)
+
+	v, _, err := decoder{data: buf[metadataStart:]}.decode(0, 0)
+	if err != nil {
+		return nil, sqerrors.Wrap(err, "invalid database metadata")
+	}
+	m, ok := v.(map[string]interface{})
+	if !ok {
+		return nil, sqerrors.Errorf("invalid database metadata: unexpected type `%T`", v)
+	}
+
+	var metadata Metadata
+	metadata.DatabaseType, _ = m["database_type"].(string)
+	metadata.BuildEpoch, _ = m["build_epoch"].(uint64)
+	if languages, ok := m["languages"].([]interface{}); ok {
+		for _, l := range languages {
+			if l, ok := l.(string); ok {
+				metadata.Languages = append(metadata.Languages, l)
+			}
+		}
+	}
+	for _, field := range []struct {
+		key string
+		dst *uint
+	}{
+		{key: "node_count", dst: &metadata.NodeCount},
+		{key: "record_size", dst: &metadata.RecordSize},
+		{key: "ip_version", dst: &metadata.IPVersion},
+	} {
+		v, ok := m[field.key].(uint64)
+		if !ok || v > math.MaxUint32 {
+			return nil, sqerrors.Errorf("invalid database metadata: unexpected `%s` value `%v`", field.key, m[field.key])
+		}
+		*field.dst = uint(v)
+	}
+
+	switch metadata.RecordSize {
+	case 24, 28, 32:
+	default:
+		return nil, sqerrors.Errorf("invalid database metadata: unsupported record size `%d`", metadata.RecordSize)
+	}
+	if metadata.IPVersion != 4 && metadata.IPVersion != 6 {
+		return nil, sqerrors.Errorf("invalid database metadata: unsupported ip version `%d`", metadata.IPVersion)
+	}
+
+	nodeByteLen := metadata.RecordSize / 4
+	treeSize := metadata.NodeCount * nodeByteLen
+	dataStart := treeSize + dataSectionSeparatorSize
+	dataEnd := uint(metadataStart - len(metadataStartMarker))
+	if dataStart > dataEnd {
+		return nil, sqerrors.New("invalid database: the search tree size exceeds the file size")
+	}
+
+	r := &Reader{
+		Metadata:    metadata,
+		tree:        buf[:treeSize],
+		data:        buf[dataStart:dataEnd],
+		nodeCount:   metadata.NodeCount,
+		recordSize:  metadata.RecordSize,
+		nodeByteLen: nodeByteLen,
+	}
+
+	// IPv4 addresses are stored in IPv6 databases under the ::/96 subtree, whose
+	// node is looked up once for all.
+	if metadata.IPVersion == 6 {
+		node := uint(0)
+		for i := 0; i < 96 && node < r.nodeCount; i++ {
+			node = r.readRecord(node, 0)
+		}
+		r.ipv4Start = node
+	}
+
+	return r, nil
+}
+
+// Lookup returns the decoded record of the given IP address and true when
+// found, false otherwise. Maps are decoded into `map[string]interface{}`
+// values, arrays into `[]interface{}`, unsigned integers into `uint64` (or
+// `*big.Int` for 128-bit ones), signed integers into `int64`, and floating
+// point numbers into `float64`.
+func (r *Reader) Lookup(ip net.IP) (record interface{}, found bool, err error) {
+	offset, found, err := r.lookupOffset(ip)
+	if err != nil || !found {
+		return nil, false, err
+	}
+	record, _, err = decoder{data: r.data}.decode(offset, 0)
+	if err != nil {
+		return nil, false, sqerrors.Wrapf(err, "could not decode the record of `%s`", ip)
+	}
+	return record, true, nil
+}
+
+func (r *Reader) lookupOffset(ip net.IP) (offset uint, found bool, err error) {
+	var (
+		node uint
+		bits []byte
+	)
+	if ipv4 := ip.To4(); ipv4 != nil {
+		bits = ipv4
+		if r.Metadata.IPVersion == 6 {
+			node = r.ipv4Start
+		}
+	} else if ipv6 := ip.To16(); ipv6 != nil {
+		if r.Metadata.IPVersion == 4 {
+			return 0, false, sqerrors.Errorf("could not lookup the IPv6 address `%s` in an IPv4 database", ip)
+		}
+		bits = ipv6
+	} else {
+		return 0, false, sqerrors.Errorf("invalid IP address `%s`", ip)
+	}
+
+	for i := 0; i < len(bits)*8 && node < r.nodeCount; i++ {
+		bit := uint(bits[i/8]>>(7-uint(i%8))) & 1
+		node = r.readRecord(node, bit)
+	}
+
+	switch {
+	case node == r.nodeCount:
+		// Empty record
+		return 0, false, nil
+	case node > r.nodeCount:
+		offset = node - r.nodeCount - dataSectionSeparatorSize
+		if offset >= uint(len(r.data)) {
+			return 0, false, sqerrors.Errorf("invalid database: out of bounds data section pointer `%d`", offset)
+		}
+		return offset, true, nil
+	default:
+		return 0, false, sqerrors.New("invalid database: the search tree is deeper than the address size")
+	}
+}
+
+// readRecord returns the left (bit 0) or right (bit 1) record of the node.
+func (r *Reader) readRecord(node, bit uint) uint {
+	b := r.tree[node*r.nodeByteLen : (node+1)*r.nodeByteLen]
+	switch r.recordSize {
+	case 24:
+		b = b[bit*3:]
+		return uint(b[0])<<16 | uint(b[1])<<8 | uint(b[2])
+	case 28:
+		if bit == 0 {
+			return uint(b[3]&0xF0)<<20 | uint(b[0])<<16 | uint(b[1])<<8 | uint(b[2])
+		}
+		return uint(b[3]&0x0F)<<24 | uint(b[4])<<16 | uint(b[5])<<8 | uint(b[6])
+	default:
+		return uint(binary.BigEndian.Uint32(b[bit*4:]))
+	}
+}
+
+// decoder decodes the data fields of a data section.
+type decoder struct {
+	data []byte
+}
+
+// decode returns the value of the data field at the given offset along with
+// the offset of the next field.
+func (d decoder) decode(offset uint, depth int) (value interface{}, next uint, err error) {
+	if depth > maxDataDepth {
+		return nil, 0, sqerrors.New("maximum data structure depth exceeded")
+	}
+
+	typ, size, offset, err := d.decodeControl(offset)
+	if err != nil {
+		return nil, 0, err
+	}
+
+	if typ == typePointer {
+		pointer, next, err := d.decodePointer(size, offset)
+		if err != nil {
+			return nil, 0, err
+		}
+		// The pointed value is decoded but the next field follows the pointer.
+		value, _, err := d.decode(pointer, depth+1)
+		return value, next, err
+	}
+
+	// Every map entry or array element takes at least one byte, so that the
+	// remaining data length bounds the size hint of malformed containers.
+	sizeHint := size
+	if remaining := uint(len(d.data)) - offset; sizeHint > remaining {
+		sizeHint = remaining
+	}
+
+	switch typ {
+	case typeMap:
+		m := make(map[string]interface{}, sizeHint)
+		for i := uint(0); i < size; i++ {
+			var k, v interface{}
+			if k, offset, err = d.decode(offset, depth+1); err != nil {
+				return nil, 0, err
+			}
+			key, ok := k.(string)
+			if !ok {
+				return nil, 0, sqerrors.Errorf("unexpected map key type `%T`", k)
+			}
+			if v, offset, err = d.decode(offset, depth+1); err != nil {
+				return nil, 0, err
+			}
+			m[key] = v
+		}
+		return m, offset, nil
+
+	case typeArray:
+		a := make([]interface{}, 0, sizeHint)
+		for i := uint(0); i < size; i++ {
+			var v interface{}
+			if v, offset, err = d.decode(offset, depth+1); err != nil {
+				return nil, 0, err
+			}
+			a = append(a, v)
+		}
+		return a, offset, nil
+
+	case typeBool:
+		if size > 1 {
+			return nil, 0, sqerrors.Errorf("invalid boolean size `%d`", size)
+		}
+		return size == 1, offset, nil
+	}
+
+	next = offset + size
+	if next > uint(len(d.data)) || next < offset {
+		return nil, 0, sqerrors.Errorf("out of bounds data field of type `%d`", typ)
+	}
+	b := d.data[offset:next]
+
+	switch typ {
+	case typeString:
+		return string(b), next, nil
+	case typeBytes:
+		return append([]byte(nil), b...), next, nil
+	case typeDouble:
+		if size != 8 {
+			return nil, 0, sqerrors.Errorf("invalid double size `%d`", size)
+		}
+		return math.Float64frombits(binary.BigEndian.Uint64(b)), next, nil
+	case typeFloat:
+		if size != 4 {
+			return nil, 0, sqerrors.Errorf("invalid float size `%d`", size)
+		}
+		return float64(math.Float32frombits(binary.BigEndian.Uint32(b))), next, nil
+	case typeUint16, typeUint32, typeUint64:
+		if max := uintSize(typ); size > max {
+			return nil, 0, sqerrors.Errorf("invalid unsigned integer size `%d`", size)
+		}
+		var v uint64
+		for _, c := range b {
+			v = v<<8 | uint64(c)
+		}
+		return v, next, nil
+	case typeInt32:
+		if size > 4 {
+			return nil, 0, sqerrors.Errorf("invalid int32 size `%d`", size)
+		}
+		var v uint32
+		for _, c := range b {
+			v = v<<8 | uint32(c)
+		}
+		return int64(int32(v)), next, nil
+	case typeUint128:
+		if size > 16 {
+			return nil, 0, sqerrors.Errorf("invalid uint128 size `%d`", size)
+		}
+		return new(big.Int).SetBytes(b), next, nil
+	default:
+		return nil, 0, sqerrors.Errorf("unexpected data field type `%d`", typ)
+	}
+}
+
+// uintSize returns the maximum payload size of the unsigned integer type.
+func uintSize(typ int) uint {
+	switch typ {
+	case typeUint16:
+		return 2
+	case typeUint32:
+		return 4
+	default:
+		return 8
+	}
+}
+
+// decodeControl decodes the control byte of the data field at the given offset
+// and returns its type, its payload size and the offset of its payload.
+func (d decoder) decodeControl(offset uint) (typ int, size uint, next uint, err error) {
+	if offset >= uint(len(d.data)) {
+		return 0, 0, 0, sqerrors.Errorf("out of bounds data field offset `%d`", offset)
+	}
+	ctrl := d.data[offset]
+	offset++
+	typ = int(ctrl >> 5)
+
+	if typ == typePointer {
+		// The size bits are specific to the pointer and decoded by decodePointer.
+		return typ, uint(ctrl & 0x1F), offset, nil
+	}
+
+	if typ == typeExtended {
+		if offset >= uint(len(d.data)) {
+			return 0, 0, 0, sqerrors.New("unexpected end of the extended data field type")
+		}
+		typ = 7 + int(d.data[offset])
+		offset++
+		if typ <= typeMap {
+			return 0, 0, 0, sqerrors.Errorf("invalid extended data field type `%d`", typ)
+		}
+	}
+
+	size = uint(ctrl & 0x1F)
+	if size >= 29 {
+		n := size - 28
+		if offset+n > uint(len(d.data)) {
+			return 0, 0, 0, sqerrors.New("unexpected end of the data field size")
+		}
+		var v uint
+		for _, c := range d.data[offset : offset+n] {
+			v = v<<8 | uint(c)
+		}
+		switch n {
+		case 1:
+			size = 29 + v
+		case 2:
+			size = 285 + v
+		default:
+			size = 65821 + v
+		}
+		offset += n
+	}
+	return typ, size, offset, nil
+}
+
+// decodePointer decodes the pointer of the given control byte size bits and
+// returns the data section offset it points to along with the offset of the
+// next field.
+func (d decoder) decodePointer(sizeBits, offset uint) (pointer, next uint, err error) {
+	n := (sizeBits>>3)&0x3 + 1
+	if offset+n > uint(len(d.data)) {
+		return 0, 0, sqerrors.New("unexpected end of the pointer")
+	}
+	var v uint
+	if n != 4 {
+		v = sizeBits & 0x7
+	}
+	for _, c := range d.data[offset : offset+n] {
+		v = v<<8 | uint(c)
+	}
+	switch n {
+	case 2:
+		v += 2048
+	case 3:
+		v += 526336
+	}
+	return v, offset + n, nil
+}
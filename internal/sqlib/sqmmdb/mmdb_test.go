@@ -0,0 +1,174 @@
+// Copyright (c) 2016 - 2020 Sqreen. All Rights Reserved.
+// Please refer to our terms for more information:
+// https://www.sqreen.io/terms.html
+
+package sqmmdb_test
+
+import (
+	"fmt"
+	"io/ioutil"
+	"net"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/sqreen/go-agent/internal/sqlib/sqmmdb"
+	"github.com/sqreen/go-agent/internal/sqlib/sqmmdb/_testlib"
+	"github.com/stretchr/testify/require"
+)
+
+func TestReader(t *testing.T) {
+	franceRecord := map[string]interface{}{
+		"country": map[string]interface{}{
+			"iso_code":   "FR",
+			"geoname_id": uint32(3017382),
+		},
+		"autonomous_system_number":       uint32(3215),
+		"autonomous_system_organization": "Orange",
+	}
+	expectedFranceRecord := map[string]interface{}{
+		"country": map[string]interface{}{
+			"iso_code":   "FR",
+			"geoname_id": uint64(3017382),
+		},
+		"autonomous_system_number":       uint64(3215),
+		"autonomous_system_organization": "Orange",
+	}
+
+	for _, ipVersion := range []int{4, 6} {
+		for _, recordSize := range []int{24, 28, 32} {
+			ipVersion, recordSize := ipVersion, recordSize
+			t.Run(fmt.Sprintf("ipv%d/%d-bit records", ipVersion, recordSize), func(t *testing.T) {
+				networks := map[string]interface{}{
+					"1.2.3.0/24": franceRecord,
+					"1.2.4.4/32": map[string]interface{}{
+						"country": map[string]interface{}{"iso_code": "FR"},
+					},
+					"10.0.0.0/8": "private",
+				}
+				if ipVersion == 6 {
+					networks["2001:db8::/32"] = map[string]interface{}{
+						"country": map[string]interface{}{"iso_code": "US"},
+					}
+				}
+				r, err := sqmmdb.FromBytes(testlib.NewMMDB(ipVersion, recordSize, networks))
+				require.NoError(t, err)
+				require.Equal(t, "Test-DB", r.Metadata.DatabaseType)
+				require.Equal(t, uint(ipVersion), r.Metadata.IPVersion)
+				require.Equal(t, uint(recordSize), r.Metadata.RecordSize)
+				require.Equal(t, []string{"en"}, r.Metadata.Languages)
+
+				for _, tc := range []struct {
+					ip       string
+					expected interface{}
+				}{
+					{ip: "1.2.3.4", expected: expectedFranceRecord},
+					{ip: "1.2.3.255", expected: expectedFranceRecord},
+					{ip: "::ffff:1.2.3.4", expected: expectedFranceRecord},
+					{ip: "1.2.4.4", expected: map[string]interface{}{
+						"country": map[string]interface{}{"iso_code": "FR"},
+					}},
+					{ip: "1.2.4.5"},
+					{ip: "1.2.2.255"},
+					{ip: "10.11.12.13", expected: "private"},
+					{ip: "11.0.0.0"},
+				} {
+					record, found, err := r.Lookup(net.ParseIP(tc.ip))
+					require.NoError(t, err, tc.ip)
+					require.Equal(t, tc.expected != nil, found, tc.ip)
+					require.Equal(t, tc.expected, record, tc.ip)
+				}
+
+				if ipVersion == 4 {
+					_, _, err := r.Lookup(net.ParseIP("2001:db8::1"))
+					require.Error(t, err)
+					return
+				}
+
+				record, found, err := r.Lookup(net.ParseIP("2001:db8::1"))
+				require.NoError(t, err)
+				require.True(t, found)
+				require.Equal(t, map[string]interface{}{
+					"country": map[string]interface{}{"iso_code": "US"},
+				}, record)
+
+				_, found, err = r.Lookup(net.ParseIP("2001:db9::1"))
+				require.NoError(t, err)
+				require.False(t, found)
+			})
+		}
+	}
+
+	t.Run("data types", func(t *testing.T) {
+		long := string(make([]byte, 70000))
+		value := map[string]interface{}{
+			"string":  "string",
+			"pointer": "string",
+			"empty":   "",
+			"long":    long,
+			"double":  1.5,
+			"bytes":   []byte{1, 2, 3},
+			"uint16":  uint16(65535),
+			"uint32":  uint32(0),
+			"int32":   int32(-42),
+			"uint64":  uint64(1) << 63,
+			"array":   []interface{}{"a", uint16(1), map[string]interface{}{}},
+			"true":    true,
+			"false":   false,
+		}
+		r, err := sqmmdb.FromBytes(testlib.NewMMDB(6, 28, map[string]interface{}{"0.0.0.0/1": value}))
+		require.NoError(t, err)
+		record, found, err := r.Lookup(net.ParseIP("1.2.3.4"))
+		require.NoError(t, err)
+		require.True(t, found)
+		require.Equal(t, map[string]interface{}{
+			"string":  "string",
+			"pointer": "string",
+			"empty":   "",
+			"long":    long,
+			"double":  1.5,
+			"bytes":   []byte{1, 2, 3},
+			"uint16":  uint64(65535),
+			"uint32":  uint64(0),
+			"int32":   int64(-42),
+			"uint64":  uint64(1) << 63,
+			"array":   []interface{}{"a", uint64(1), map[string]interface{}{}},
+			"true":    true,
+			"false":   false,
+		}, record)
+	})
+
+	t.Run("invalid databases", func(t *testing.T) {
+		valid := testlib.NewMMDB(6, 24, map[string]interface{}{"1.2.3.0/24": "value"})
+		for name, buf := range map[string][]byte{
+			"empty":          nil,
+			"no metadata":    valid[:len(valid)-100],
+			"truncated":      valid[len(valid)-120:],
+			"bad metadata":   append(append([]byte{}, valid[:len(valid)-1]...), 0xFF),
+			"garbage":        []byte("\xAB\xCD\xEFMaxMind.com\xFF\xFF\xFF"),
+			"not a metadata": []byte("\xAB\xCD\xEFMaxMind.com\x46test"),
+		} {
+			_, err := sqmmdb.FromBytes(buf)
+			require.Error(t, err, name)
+		}
+	})
+
+	t.Run("Open", func(t *testing.T) {
+		dir, err := ioutil.TempDir("", "sqmmdb")
+		require.NoError(t, err)
+		defer os.RemoveAll(dir)
+
+		path := filepath.Join(dir, "test.mmdb")
+		require.NoError(t, ioutil.WriteFile(path, testlib.NewMMDB(4, 24, map[string]interface{}{"1.2.3.0/24": "value"}), 0644))
+
+		r, err := sqmmdb.Open(path)
+		require.NoError(t, err)
+		record, found, err := r.Lookup(net.ParseIP("1.2.3.4"))
+		require.NoError(t, err)
+		require.True(t, found)
+		require.Equal(t, "value", record)
+
+		_, err = sqmmdb.Open(filepath.Join(dir, "not-found.mmdb"))
+		require.Error(t, err)
+	})
+}
@@ -0,0 +1,320 @@
+// Copyright (c) 2016 - 2020 Sqreen. All Rights Reserved.
+// Please refer to our terms for more information:
+// https://www.sqreen.io/terms.html
+
+package sqsql
+
+import "strings"
+
+// Dialect is a SQL dialect whose lexical rules are known by the tokenizer.
+type Dialect int
+
+const (
+	// DialectGeneric follows the ANSI SQL lexical rules.
+	DialectGeneric Dialect = iota
+	DialectMySQL
+	DialectPostgreSQL
+	DialectSQLite
+	DialectMSSQL
+	DialectOracle
+)
+
+// ParseDialect returns the dialect of the given name, or DialectGeneric when
+// unknown.
+func ParseDialect(name string) Dialect {
+	switch strings.ToLower(name) {
+	case "mysql", "mariadb":
+		return DialectMySQL
+	case "postgresql", "postgres", "pgsql":
+		return DialectPostgreSQL
+	case "sqlite", "sqlite3":
+		return DialectSQLite
+	case "mssql", "sqlserver":
+		return DialectMSSQL
+	case "oracle":
+		return DialectOracle
+	default:
+		return DialectGeneric
+	}
+}
+
+func (d Dialect) String() string {
+	switch d {
+	case DialectMySQL:
+		return "mysql"
+	case DialectPostgreSQL:
+		return "postgresql"
+	case DialectSQLite:
+		return "sqlite"
+	case DialectMSSQL:
+		return "mssql"
+	case DialectOracle:
+		return "oracle"
+	default:
+		return "generic"
+	}
+}
+
+// TokenKind is the lexical category of a token.
+type TokenKind int
+
+const (
+	// TokenWord is a keyword or an identifier.
+	TokenWord TokenKind = iota
+	TokenQuotedIdentifier
+	TokenString
+	TokenNumber
+	TokenPlaceholder
+	TokenComment
+	// TokenOperator is an operator or a punctuation character.
+	TokenOperator
+	// TokenInvalid is an unterminated string, quoted identifier or comment.
+	TokenInvalid
+)
+
+// Token is the location of a token in the query, as the range of bytes
+// [Start, End).
+type Token struct {
+	Kind       TokenKind
+	Start, End int
+}
+
+// Tokenize splits the query into its list of tokens according to the lexical
+// rules of the given dialect. Whitespaces are not part of the tokens.
+func Tokenize(query string, dialect Dialect) []Token {
+	t := tokenizer{query: query, dialect: dialect}
+	var tokens []Token
+	for {
+		t.skipWhitespaces()
+		if t.pos >= len(query) {
+			return tokens
+		}
+		start := t.pos
+		kind := t.next()
+		tokens = append(tokens, Token{Kind: kind, Start: start, End: t.pos})
+	}
+}
+
+type tokenizer struct {
+	query   string
+	pos     int
+	dialect Dialect
+}
+
+func (t *tokenizer) peek(offset int) byte {
+	if i := t.pos + offset; i < len(t.query) {
+		return t.query[i]
+	}
+	return 0
+}
+
+func (t *tokenizer) skipWhitespaces() {
+	for t.pos < len(t.query) && isSpace(t.query[t.pos]) {
+		t.pos++
+	}
+}
+
+func (t *tokenizer) next() TokenKind {
+	c := t.peek(0)
+	switch {
+	case c == '-' && t.peek(1) == '-' && (t.dialect != DialectMySQL || isSpace(t.peek(2)) || t.pos+2 == len(t.query)):
+		return t.lineComment()
+	case c == '#' && t.dialect == DialectMySQL:
+		return t.lineComment()
+	case c == '/' && t.peek(1) == '*':
+		return t.blockComment()
+	case c == '\'':
+		return t.quoted('\'', '\'', TokenString)
+	case c == '"':
+		if t.dialect == DialectMySQL {
+			return t.quoted('"', '"', TokenString)
+		}
+		return t.quoted('"', '"', TokenQuotedIdentifier)
+	case c == '`' && (t.dialect == DialectMySQL || t.dialect == DialectSQLite):
+		return t.quoted('`', '`', TokenQuotedIdentifier)
+	case c == '[' && (t.dialect == DialectMSSQL || t.dialect == DialectSQLite):
+		return t.quoted('[', ']', TokenQuotedIdentifier)
+	case c == '$' && t.dialect == DialectPostgreSQL:
+		return t.dollar()
+	case c == '?' && t.dialect != DialectPostgreSQL:
+		t.pos++
+		return TokenPlaceholder
+	case (c == ':' && t.dialect == DialectOracle || c == '@' && t.dialect == DialectMSSQL) && isWordChar(t.peek(1)):
+		t.pos++
+		t.word()
+		return TokenPlaceholder
+	case isDigit(c) || c == '.' && isDigit(t.peek(1)):
+		return t.number()
+	case isWordChar(c):
+		t.word()
+		return TokenWord
+	case isOperatorChar(c):
+		return t.operator()
+	default:
+		t.pos++
+		return TokenOperator
+	}
+}
+
+func (t *tokenizer) lineComment() TokenKind {
+	end := strings.IndexByte(t.query[t.pos:], '\n')
+	if end == -1 {
+		t.pos = len(t.query)
+	} else {
+		t.pos += end
+	}
+	return TokenComment
+}
+
+func (t *tokenizer) blockComment() TokenKind {
+	// PostgreSQL block comments can be nested.
+	depth := 0
+	for t.pos < len(t.query) {
+		switch {
+		case t.peek(0) == '/' && t.peek(1) == '*':
+			depth++
+			t.pos += 2
+		case t.peek(0) == '*' && t.peek(1) == '/':
+			depth--
+			t.pos += 2
+			if depth == 0 || t.dialect != DialectPostgreSQL {
+				return TokenComment
+			}
+		default:
+			t.pos++
+		}
+	}
+	return TokenInvalid
+}
+
+// quoted consumes a string or quoted identifier delimited by the given
+// characters. The closing delimiter is escaped by doubling it, or by a
+// backslash in MySQL strings.
+func (t *tokenizer) quoted(open, close byte, kind TokenKind) TokenKind {
+	t.pos++ // opening character
+	for t.pos < len(t.query) {
+		c := t.query[t.pos]
+		switch {
+		case c == '\\' && kind == TokenString && t.dialect == DialectMySQL:
+			t.pos += 2
+		case c == close && t.peek(1) == close:
+			t.pos += 2
+		case c == close:
+			t.pos++
+			return kind
+		default:
+			t.pos++
+		}
+	}
+	t.pos = len(t.query)
+	return TokenInvalid
+}
+
+// dollar consumes a PostgreSQL positional parameter `$n` or dollar-quoted
+// string `$tag$...$tag$`.
+func (t *tokenizer) dollar() TokenKind {
+	if isDigit(t.peek(1)) {
+		t.pos++
+		for isDigit(t.peek(0)) {
+			t.pos++
+		}
+		return TokenPlaceholder
+	}
+
+	end := strings.IndexByte(t.query[t.pos+1:], '$')
+	if end == -1 {
+		t.pos++
+		return TokenOperator
+	}
+	tag := t.query[t.pos : t.pos+end+2]
+	for _, c := range []byte(tag[1 : len(tag)-1]) {
+		if !isWordChar(c) {
+			t.pos++
+			return TokenOperator
+		}
+	}
+
+	t.pos += len(tag)
+	end = strings.Index(t.query[t.pos:], tag)
+	if end == -1 {
+		t.pos = len(t.query)
+		return TokenInvalid
+	}
+	t.pos += end + len(tag)
+	return TokenString
+}
+
+func (t *tokenizer) number() TokenKind {
+	if t.peek(0) == '0' && (t.peek(1) == 'x' || t.peek(1) == 'X') {
+		t.pos += 2
+		for isHexDigit(t.peek(0)) {
+			t.pos++
+		}
+		return TokenNumber
+	}
+	for isDigit(t.peek(0)) {
+		t.pos++
+	}
+	if t.peek(0) == '.' {
+		t.pos++
+		for isDigit(t.peek(0)) {
+			t.pos++
+		}
+	}
+	if c := t.peek(0); c == 'e' || c == 'E' {
+		offset := 1
+		if c := t.peek(1); c == '+' || c == '-' {
+			offset++
+		}
+		if isDigit(t.peek(offset)) {
+			t.pos += offset
+			for isDigit(t.peek(0)) {
+				t.pos++
+			}
+		}
+	}
+	return TokenNumber
+}
+
+func (t *tokenizer) word() {
+	for isWordChar(t.peek(0)) || isDigit(t.peek(0)) || t.peek(0) == '$' {
+		t.pos++
+	}
+}
+
+func (t *tokenizer) operator() TokenKind {
+	t.pos++
+	for isOperatorChar(t.peek(0)) {
+		// Stop before the start of a comment.
+		if c := t.peek(0); c == '-' && t.peek(1) == '-' || c == '/' && t.peek(1) == '*' {
+			break
+		}
+		t.pos++
+	}
+	return TokenOperator
+}
+
+func isSpace(c byte) bool {
+	switch c {
+	case ' ', '\t', '\n', '\r', '\f', '\v':
+		return true
+	default:
+		return false
+	}
+}
+
+func isDigit(c byte) bool { return '0' <= c && c <= '9' }
+
+func isHexDigit(c byte) bool {
+	return isDigit(c) || 'a' <= c && c <= 'f' || 'A' <= c && c <= 'F'
+}
+
+// isWordChar returns true for the characters a keyword or identifier can
+// start with. Non-ASCII characters are considered part of identifiers.
+func isWordChar(c byte) bool {
+	return 'a' <= c && c <= 'z' || 'A' <= c && c <= 'Z' || c == '_' || c >= 0x80
+}
+
+func isOperatorChar(c byte) bool {
+	return strings.IndexByte("<>=!|&~^+-*/%:", c) != -1
+}
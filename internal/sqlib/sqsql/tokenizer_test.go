@@ -0,0 +1,158 @@
+// Copyright (c) 2016 - 2020 Sqreen. All Rights Reserved.
+// Please refer to our terms for more information:
+// https://www.sqreen.io/terms.html
+
+package sqsql_test
+
+import (
+	"testing"
+
+	"github.com/sqreen/go-agent/internal/sqlib/sqsql"
+	"github.com/stretchr/testify/require"
+)
+
+func TestTokenize(t *testing.T) {
+	type token struct {
+		kind  sqsql.TokenKind
+		value string
+	}
+
+	for _, tc := range []struct {
+		name     string
+		query    string
+		dialect  sqsql.Dialect
+		expected []token
+	}{
+		{
+			name:    "simple query",
+			query:   "SELECT * FROM users WHERE id=42 AND name = 'it''s' LIMIT ?",
+			dialect: sqsql.DialectGeneric,
+			expected: []token{
+				{sqsql.TokenWord, "SELECT"},
+				{sqsql.TokenOperator, "*"},
+				{sqsql.TokenWord, "FROM"},
+				{sqsql.TokenWord, "users"},
+				{sqsql.TokenWord, "WHERE"},
+				{sqsql.TokenWord, "id"},
+				{sqsql.TokenOperator, "="},
+				{sqsql.TokenNumber, "42"},
+				{sqsql.TokenWord, "AND"},
+				{sqsql.TokenWord, "name"},
+				{sqsql.TokenOperator, "="},
+				{sqsql.TokenString, "'it''s'"},
+				{sqsql.TokenWord, "LIMIT"},
+				{sqsql.TokenPlaceholder, "?"},
+			},
+		},
+		{
+			name:    "mysql strings, identifiers and comments",
+			query:   "SELECT `a` FROM t WHERE b = \"x\\\"y\" # comment\n AND c--1",
+			dialect: sqsql.DialectMySQL,
+			expected: []token{
+				{sqsql.TokenWord, "SELECT"},
+				{sqsql.TokenQuotedIdentifier, "`a`"},
+				{sqsql.TokenWord, "FROM"},
+				{sqsql.TokenWord, "t"},
+				{sqsql.TokenWord, "WHERE"},
+				{sqsql.TokenWord, "b"},
+				{sqsql.TokenOperator, "="},
+				{sqsql.TokenString, "\"x\\\"y\""},
+				{sqsql.TokenComment, "# comment"},
+				{sqsql.TokenWord, "AND"},
+				{sqsql.TokenWord, "c"},
+				{sqsql.TokenOperator, "--"},
+				{sqsql.TokenNumber, "1"},
+			},
+		},
+		{
+			name:    "postgresql parameters, casts and dollar-quoted strings",
+			query:   `SELECT "a"::text, $$it's$$ FROM t WHERE b = $1 /* x /* y */ z */`,
+			dialect: sqsql.DialectPostgreSQL,
+			expected: []token{
+				{sqsql.TokenWord, "SELECT"},
+				{sqsql.TokenQuotedIdentifier, `"a"`},
+				{sqsql.TokenOperator, "::"},
+				{sqsql.TokenWord, "text"},
+				{sqsql.TokenOperator, ","},
+				{sqsql.TokenString, "$$it's$$"},
+				{sqsql.TokenWord, "FROM"},
+				{sqsql.TokenWord, "t"},
+				{sqsql.TokenWord, "WHERE"},
+				{sqsql.TokenWord, "b"},
+				{sqsql.TokenOperator, "="},
+				{sqsql.TokenPlaceholder, "$1"},
+				{sqsql.TokenComment, "/* x /* y */ z */"},
+			},
+		},
+		{
+			name:    "mssql identifiers and parameters",
+			query:   "SELECT [a]]b] FROM t WHERE c = @p1",
+			dialect: sqsql.DialectMSSQL,
+			expected: []token{
+				{sqsql.TokenWord, "SELECT"},
+				{sqsql.TokenQuotedIdentifier, "[a]]b]"},
+				{sqsql.TokenWord, "FROM"},
+				{sqsql.TokenWord, "t"},
+				{sqsql.TokenWord, "WHERE"},
+				{sqsql.TokenWord, "c"},
+				{sqsql.TokenOperator, "="},
+				{sqsql.TokenPlaceholder, "@p1"},
+			},
+		},
+		{
+			name:    "numbers",
+			query:   "1 1.5 .5 1e10 2E-3 0xFF",
+			dialect: sqsql.DialectGeneric,
+			expected: []token{
+				{sqsql.TokenNumber, "1"},
+				{sqsql.TokenNumber, "1.5"},
+				{sqsql.TokenNumber, ".5"},
+				{sqsql.TokenNumber, "1e10"},
+				{sqsql.TokenNumber, "2E-3"},
+				{sqsql.TokenNumber, "0xFF"},
+			},
+		},
+		{
+			name:    "unterminated string",
+			query:   "WHERE a = 'oops",
+			dialect: sqsql.DialectGeneric,
+			expected: []token{
+				{sqsql.TokenWord, "WHERE"},
+				{sqsql.TokenWord, "a"},
+				{sqsql.TokenOperator, "="},
+				{sqsql.TokenInvalid, "'oops"},
+			},
+		},
+		{
+			name:    "unterminated comment",
+			query:   "a /* b",
+			dialect: sqsql.DialectGeneric,
+			expected: []token{
+				{sqsql.TokenWord, "a"},
+				{sqsql.TokenInvalid, "/* b"},
+			},
+		},
+		{
+			name:     "empty query",
+			query:    " \n\t",
+			dialect:  sqsql.DialectGeneric,
+			expected: nil,
+		},
+	} {
+		tc := tc
+		t.Run(tc.name, func(t *testing.T) {
+			var actual []token
+			for _, tok := range sqsql.Tokenize(tc.query, tc.dialect) {
+				actual = append(actual, token{tok.Kind, tc.query[tok.Start:tok.End]})
+			}
+			require.Equal(t, tc.expected, actual)
+		})
+	}
+}
+
+func TestParseDialect(t *testing.T) {
+	require.Equal(t, sqsql.DialectMySQL, sqsql.ParseDialect("MySQL"))
+	require.Equal(t, sqsql.DialectPostgreSQL, sqsql.ParseDialect("postgresql"))
+	require.Equal(t, sqsql.DialectGeneric, sqsql.ParseDialect("oops"))
+	require.Equal(t, "sqlite", sqsql.ParseDialect("sqlite3").String())
+}
@@ -0,0 +1,72 @@
+// Copyright (c) 2016 - 2020 Sqreen. All Rights Reserved.
+// Please refer to our terms for more information:
+// https://www.sqreen.io/terms.html
+
+//sqreen:ignore
+
+package sdk
+
+import (
+	go_context "context"
+
+	"github.com/sqreen/go-agent/internal"
+)
+
+// JobContext is Sqreen's context of a background job which is not an HTTP
+// request, such as a queue consumer or a cron job. Its methods allow to record
+// security events and monitor the user activity of the job like a regular
+// request context. The recorded events are sent along with the job name once
+// the job context is closed.
+//
+// Note that user security responses only apply to HTTP requests so that
+// `Identify()` never returns an error.
+type JobContext interface {
+	Context
+
+	// Close closes the job context and sends its events. It must be called once
+	// the job is done.
+	Close()
+}
+
+type jobContext struct {
+	context
+	close func()
+}
+
+// NewJobContext returns a new job context for the job of the given name, along
+// with the Go context derived from the given one and carrying it, so that
+// `FromContext()` also returns it in the functions called by the job. The Go
+// context is canceled once the job context is closed. If Sqreen is disabled or
+// not started yet, it returns a disabled job context that will ignore
+// everything.
+//
+// Usage example:
+//
+//	func payout(ctx context.Context, p *Payout) error {
+//		job, ctx := sdk.NewJobContext(ctx, "payout")
+//		defer job.Close()
+//
+//		uid := sdk.EventUserIdentifiersMap{"uid": p.UserID}
+//		job.ForUser(uid).TrackEvent("payout").WithStructuredProperties(sdk.EventProperties{
+//			"amount": p.Amount,
+//		})
+//		return process(ctx, p)
+//	}
+func NewJobContext(ctx go_context.Context, name string) (JobContext, go_context.Context) {
+	if ctx == nil {
+		ctx = go_context.Background()
+	}
+
+	internal.Start()
+	p, ctx := internal.NewJobProtectionContext(ctx, name)
+	if p == nil {
+		return jobContext{context: context{events: disabledEventRecorder{}}}, ctx
+	}
+	return jobContext{context: context{events: p}, close: p.Close}, ctx
+}
+
+func (j jobContext) Close() {
+	if j.close != nil {
+		j.close()
+	}
+}
@@ -0,0 +1,34 @@
+// Copyright (c) 2016 - 2020 Sqreen. All Rights Reserved.
+// Please refer to our terms for more information:
+// https://www.sqreen.io/terms.html
+
+package sdk_test
+
+import (
+	"context"
+	"testing"
+
+	"github.com/sqreen/go-agent/sdk"
+	"github.com/stretchr/testify/require"
+)
+
+func TestNewJobContext(t *testing.T) {
+	t.Run("disabled agent", func(t *testing.T) {
+		type key struct{}
+		ctx := context.WithValue(context.Background(), key{}, "value")
+		job, jobCtx := sdk.NewJobContext(ctx, "my job")
+		require.NotNil(t, job)
+		require.Equal(t, "value", jobCtx.Value(key{}))
+		dummySDKTest(t, job)
+		dummySDKTest(t, sdk.FromContext(jobCtx))
+		require.NotPanics(t, job.Close)
+	})
+
+	t.Run("nil context", func(t *testing.T) {
+		job, jobCtx := sdk.NewJobContext(nil, "my job")
+		require.NotNil(t, job)
+		require.NotNil(t, jobCtx)
+		dummySDKTest(t, job)
+		require.NotPanics(t, job.Close)
+	})
+}
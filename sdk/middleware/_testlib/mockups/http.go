@@ -142,6 +142,11 @@ func NewHTTPProtectionConfigMockup() *HTTPProtectionConfigMockup {
 	m := &HTTPProtectionConfigMockup{}
 	m.ExpectHTTPClientIPHeader().Return("").Maybe()
 	m.ExpectHTTPClientIPHeaderFormat().Return("").Maybe()
+	m.ExpectHTTPTrustedProxies().Return(nil).Maybe()
+	m.ExpectHTTPMaxBodyCaptureSize().Return(1024 * 1024).Maybe()
+	m.ExpectBlockingHTMLTemplate().Return("").Maybe()
+	m.ExpectBlockingJSONTemplate().Return("").Maybe()
+	m.ExpectBlockingTextTemplate().Return("").Maybe()
 	return m
 }
 
@@ -160,3 +165,44 @@ func (c *HTTPProtectionConfigMockup) HTTPClientIPHeaderFormat() string {
 func (c *HTTPProtectionConfigMockup) ExpectHTTPClientIPHeaderFormat() *mock.Call {
 	return c.On("HTTPClientIPHeaderFormat")
 }
+
+func (c *HTTPProtectionConfigMockup) HTTPTrustedProxies() []*net.IPNet {
+	networks, _ := c.Called().Get(0).([]*net.IPNet)
+	return networks
+}
+
+func (c *HTTPProtectionConfigMockup) ExpectHTTPTrustedProxies() *mock.Call {
+	return c.On("HTTPTrustedProxies")
+}
+
+func (c *HTTPProtectionConfigMockup) HTTPMaxBodyCaptureSize() int {
+	return c.Called().Int(0)
+}
+
+func (c *HTTPProtectionConfigMockup) ExpectHTTPMaxBodyCaptureSize() *mock.Call {
+	return c.On("HTTPMaxBodyCaptureSize")
+}
+
+func (c *HTTPProtectionConfigMockup) BlockingHTMLTemplate() string {
+	return c.Called().String(0)
+}
+
+func (c *HTTPProtectionConfigMockup) ExpectBlockingHTMLTemplate() *mock.Call {
+	return c.On("BlockingHTMLTemplate")
+}
+
+func (c *HTTPProtectionConfigMockup) BlockingJSONTemplate() string {
+	return c.Called().String(0)
+}
+
+func (c *HTTPProtectionConfigMockup) ExpectBlockingJSONTemplate() *mock.Call {
+	return c.On("BlockingJSONTemplate")
+}
+
+func (c *HTTPProtectionConfigMockup) BlockingTextTemplate() string {
+	return c.Called().String(0)
+}
+
+func (c *HTTPProtectionConfigMockup) ExpectBlockingTextTemplate() *mock.Call {
+	return c.On("BlockingTextTemplate")
+}
@@ -92,8 +92,21 @@ func middlewareHandlerFromRootProtectionContext(ctx types.RootProtectionContext,
 		return next(c)
 	}
 
+	// Inspect the response written by the handler before it gets committed to
+	// the client.
+	resp := c.Response()
+	inspector := http_protection.NewResponseInspector(resp.Writer)
+	inspector.OnReset(func() {
+		resp.Committed = false
+		resp.Status = http.StatusOK
+		resp.Size = 0
+	})
+	resp.Writer = inspector
+	p.InspectResponse(inspector)
+
 	defer func() {
-		p.Close(newObservedResponse(c.Response(), err))
+		_ = inspector.Commit()
+		p.Close(newObservedResponse(resp, err))
 	}()
 
 	return middlewareHandlerFromProtectionContext(p, next, c)
@@ -208,6 +221,11 @@ func (r *requestReaderImpl) RemoteAddr() string {
 	return r.c.Request().RemoteAddr
 }
 
+// Route returns the route template of the request, such as `/users/:id`.
+func (r *requestReaderImpl) Route() string {
+	return r.c.Path()
+}
+
 // response observed by the response writer
 type observedResponse struct {
 	contentType   string
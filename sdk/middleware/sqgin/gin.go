@@ -7,6 +7,7 @@
 package sqgin
 
 import (
+	"bufio"
 	"net"
 	"net/http"
 	"net/textproto"
@@ -73,13 +74,19 @@ func Middleware() gin.HandlerFunc {
 
 func middlewareHandlerFromRootProtectionContext(ctx types.RootProtectionContext, c *gin.Context) {
 	r := &requestReaderImpl{c: c}
-	p := http_protection.NewProtectionContext(ctx, c.Writer, r)
+	w := newResponseWriter(c.Writer)
+	p := http_protection.NewProtectionContext(ctx, w, r)
 	if p == nil {
 		c.Next()
 		return
 	}
 
+	p.InspectResponse(w.inspector)
+	c.Writer = w
+
 	defer func() {
+		// Commit what the handler wrote and is still buffered
+		_ = w.inspector.Commit()
 		p.Close(newObservedResponse(c.Writer))
 	}()
 
@@ -200,12 +207,75 @@ func (r *requestReaderImpl) RemoteAddr() string {
 	return r.c.Request.RemoteAddr
 }
 
+// Route returns the route template of the request, such as `/users/:id`. The
+// context method returning it is only available since Gin v1.5.0.
+func (r *requestReaderImpl) Route() string {
+	if c, ok := interface{}(r.c).(interface{ FullPath() string }); ok {
+		return c.FullPath()
+	}
+	return ""
+}
+
+// responseWriterImpl wraps Gin's response writer in order to inspect the
+// response body before it gets committed. Gin's response writer already
+// delays writing the status code until the response body is written, so that
+// only the body writes go through the response inspector.
 type responseWriterImpl struct {
 	gin.ResponseWriter
+	inspector *http_protection.ResponseInspector
+}
+
+func newResponseWriter(w gin.ResponseWriter) *responseWriterImpl {
+	return &responseWriterImpl{
+		ResponseWriter: w,
+		inspector:      http_protection.NewResponseInspector(w),
+	}
 }
 
-func (w *responseWriterImpl) closeResponseWriter() types.ResponseFace {
-	return newObservedResponse(w)
+func (w *responseWriterImpl) Write(b []byte) (int, error) {
+	w.writeStatus()
+	return w.inspector.Write(b)
+}
+
+func (w *responseWriterImpl) WriteString(s string) (int, error) {
+	w.writeStatus()
+	return w.inspector.WriteString(s)
+}
+
+// writeStatus writes the status code Gin kept track of so far to the response
+// inspector when the body is about to be written for the first time. Gin's
+// context sets the status code directly to its own response writer, and the
+// status code can no longer be changed once the body is written.
+func (w *responseWriterImpl) writeStatus() {
+	if !w.inspector.Written() {
+		w.inspector.WriteHeader(w.ResponseWriter.Status())
+	}
+}
+
+func (w *responseWriterImpl) WriteHeaderNow() {
+	if err := w.inspector.Commit(); err != nil {
+		return
+	}
+	w.ResponseWriter.WriteHeaderNow()
+}
+
+func (w *responseWriterImpl) Flush() {
+	w.inspector.Flush()
+}
+
+func (w *responseWriterImpl) Hijack() (net.Conn, *bufio.ReadWriter, error) {
+	return w.inspector.Hijack()
+}
+
+func (w *responseWriterImpl) Written() bool {
+	return w.inspector.Written() || w.ResponseWriter.Written()
+}
+
+func (w *responseWriterImpl) Size() int {
+	if !w.inspector.Committed() && w.inspector.Written() {
+		return len(w.inspector.Body())
+	}
+	return w.ResponseWriter.Size()
 }
 
 // response observed by the response writer
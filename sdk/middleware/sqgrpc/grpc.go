@@ -0,0 +1,327 @@
+// Copyright (c) 2016 - 2020 Sqreen. All Rights Reserved.
+// Please refer to our terms for more information:
+// https://www.sqreen.io/terms.html
+
+//sqreen:ignore
+
+// Package sqgrpc provides Sqreen's gRPC server interceptors to monitor and
+// protect the received calls.
+package sqgrpc
+
+import (
+	"context"
+	"net"
+	"net/http"
+	"net/textproto"
+	"net/url"
+	"strings"
+
+	"github.com/sqreen/go-agent/internal"
+	protection_context "github.com/sqreen/go-agent/internal/protection/context"
+	http_protection "github.com/sqreen/go-agent/internal/protection/http"
+	"github.com/sqreen/go-agent/internal/protection/http/types"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/credentials"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/peer"
+	"google.golang.org/grpc/status"
+)
+
+// requestMessageParamsKey is the request parameter name of the messages
+// received by the call.
+const requestMessageParamsKey = "grpc_request"
+
+// errBlocked is the error returned to the client when the call gets blocked.
+var errBlocked = status.Error(codes.PermissionDenied, "blocked")
+
+// UnaryServerInterceptor is Sqreen's unary server interceptor to monitor and
+// protect the received unary calls. The call is protected like an HTTP request
+// whose headers are the incoming metadata and whose parameters include the
+// decoded request message. Blocked calls return a `PermissionDenied` status.
+//
+// SDK methods can be called from the method handlers by using their context
+// with `sdk.FromContext()`.
+//
+// Usage example:
+//
+//	s := grpc.NewServer(
+//		grpc.UnaryInterceptor(sqgrpc.UnaryServerInterceptor()),
+//		grpc.StreamInterceptor(sqgrpc.StreamServerInterceptor()),
+//	)
+//
+//	func (s *server) GetUser(ctx context.Context, req *pb.GetUserRequest) (*pb.User, error) {
+//		// Globally identify the user and check if the call should be aborted.
+//		uid := sdk.EventUserIdentifiersMap{"uid": req.GetId()}
+//		if err := sdk.FromContext(ctx).ForUser(uid).Identify(); err != nil {
+//			// Return to stop further handling the call
+//			return nil, err
+//		}
+//		// ... not blocked ...
+//	}
+func UnaryServerInterceptor() grpc.UnaryServerInterceptor {
+	internal.Start()
+	return func(ctx context.Context, req interface{}, info *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (interface{}, error) {
+		root, cancel := internal.NewRootHTTPProtectionContext(ctx)
+		if root == nil {
+			return handler(ctx, req)
+		}
+		defer cancel()
+		return unaryHandlerFromRootProtectionContext(root, ctx, req, info, handler)
+	}
+}
+
+// StreamServerInterceptor is Sqreen's stream server interceptor to monitor and
+// protect the received streaming calls. Every message received by the handler
+// is protected as a request parameter of the call. Reading a blocked message
+// returns a `PermissionDenied` status, which should be returned by the
+// handler.
+func StreamServerInterceptor() grpc.StreamServerInterceptor {
+	internal.Start()
+	return func(srv interface{}, ss grpc.ServerStream, info *grpc.StreamServerInfo, handler grpc.StreamHandler) error {
+		root, cancel := internal.NewRootHTTPProtectionContext(ss.Context())
+		if root == nil {
+			return handler(srv, ss)
+		}
+		defer cancel()
+		return streamHandlerFromRootProtectionContext(root, srv, ss, info, handler)
+	}
+}
+
+func unaryHandlerFromRootProtectionContext(root types.RootProtectionContext, ctx context.Context, req interface{}, info *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (interface{}, error) {
+	w := &responseWriterImpl{}
+	p := http_protection.NewProtectionContext(root, w, newRequestReader(ctx, info.FullMethod))
+	if p == nil {
+		return handler(ctx, req)
+	}
+	return unaryHandlerFromProtectionContext(p, w, ctx, req, handler)
+}
+
+func streamHandlerFromRootProtectionContext(root types.RootProtectionContext, srv interface{}, ss grpc.ServerStream, info *grpc.StreamServerInfo, handler grpc.StreamHandler) error {
+	w := &responseWriterImpl{}
+	p := http_protection.NewProtectionContext(root, w, newRequestReader(ss.Context(), info.FullMethod))
+	if p == nil {
+		return handler(srv, ss)
+	}
+	return streamHandlerFromProtectionContext(p, w, srv, ss, handler)
+}
+
+type protectionContext interface {
+	AddRequestParam(name string, param interface{})
+	RequestParamWAF(name string, param interface{}) error
+	Before() error
+	After() error
+	Close(types.ResponseFace)
+}
+
+func unaryHandlerFromProtectionContext(p protectionContext, w *responseWriterImpl, ctx context.Context, req interface{}, handler grpc.UnaryHandler) (interface{}, error) {
+	// The response is observed once the call is done, including when blocked.
+	defer func() { p.Close(newObservedResponse(w)) }()
+	ctx = context.WithValue(ctx, protection_context.ContextKey, p)
+	p.AddRequestParam(requestMessageParamsKey, req)
+	if err := p.Before(); err != nil {
+		return nil, errBlocked
+	}
+	res, err := handler(ctx, req)
+	// Handler-based protection such as user security responses or RASP
+	// protection may lead to aborted calls.
+	if err := p.After(); err != nil {
+		return nil, errBlocked
+	}
+	return res, err
+}
+
+func streamHandlerFromProtectionContext(p protectionContext, w *responseWriterImpl, srv interface{}, ss grpc.ServerStream, handler grpc.StreamHandler) error {
+	// The response is observed once the call is done, including when blocked.
+	defer func() { p.Close(newObservedResponse(w)) }()
+	ss = &serverStream{
+		ServerStream: ss,
+		ctx:          context.WithValue(ss.Context(), protection_context.ContextKey, p),
+		p:            p,
+	}
+	if err := p.Before(); err != nil {
+		return errBlocked
+	}
+	err := handler(srv, ss)
+	if err := p.After(); err != nil {
+		return errBlocked
+	}
+	return err
+}
+
+// serverStream wraps the server stream in order to provide the handler with
+// the context including the protection context, and to protect the received
+// messages.
+type serverStream struct {
+	grpc.ServerStream
+	ctx context.Context
+	p   protectionContext
+}
+
+func (s *serverStream) Context() context.Context {
+	return s.ctx
+}
+
+func (s *serverStream) RecvMsg(m interface{}) error {
+	if err := s.ServerStream.RecvMsg(m); err != nil {
+		return err
+	}
+	// Messages can be received by other goroutines than the handler one, which
+	// RequestParamWAF() allows.
+	if err := s.p.RequestParamWAF(requestMessageParamsKey, m); err != nil {
+		return errBlocked
+	}
+	return nil
+}
+
+// requestReaderImpl is the request reader of a gRPC call, which is an HTTP/2
+// POST request to the method path.
+type requestReaderImpl struct {
+	fullMethod string
+	headers    http.Header
+	authority  string
+	peer       *peer.Peer
+}
+
+func newRequestReader(ctx context.Context, fullMethod string) *requestReaderImpl {
+	r := &requestReaderImpl{fullMethod: fullMethod}
+	md, _ := metadata.FromIncomingContext(ctx)
+	r.headers = make(http.Header, len(md))
+	for k, v := range md {
+		if strings.HasPrefix(k, ":") {
+			if k == ":authority" && len(v) > 0 {
+				r.authority = v[0]
+			}
+			// Pseudo-header
+			continue
+		}
+		r.headers[textproto.CanonicalMIMEHeaderKey(k)] = v
+	}
+	r.peer, _ = peer.FromContext(ctx)
+	return r
+}
+
+func (r *requestReaderImpl) Body() []byte {
+	// not called
+	// TODO: rework the interfaces to avoid that useless method
+	return nil
+}
+
+func (r *requestReaderImpl) UserAgent() string {
+	return r.headers.Get("User-Agent")
+}
+
+func (r *requestReaderImpl) Referer() string {
+	return r.headers.Get("Referer")
+}
+
+func (r *requestReaderImpl) ClientIP() net.IP {
+	return nil // Delegated to the middleware according the agent configuration
+}
+
+func (r *requestReaderImpl) Method() string {
+	return http.MethodPost
+}
+
+func (r *requestReaderImpl) URL() *url.URL {
+	return &url.URL{Path: r.fullMethod}
+}
+
+func (r *requestReaderImpl) RequestURI() string {
+	return r.fullMethod
+}
+
+func (r *requestReaderImpl) Host() string {
+	return r.authority
+}
+
+func (r *requestReaderImpl) IsTLS() bool {
+	if r.peer == nil {
+		return false
+	}
+	_, ok := r.peer.AuthInfo.(credentials.TLSInfo)
+	return ok
+}
+
+func (r *requestReaderImpl) Params() types.RequestParamMap {
+	return nil
+}
+
+func (r *requestReaderImpl) QueryForm() url.Values {
+	return nil
+}
+
+func (r *requestReaderImpl) PostForm() url.Values {
+	return nil
+}
+
+func (r *requestReaderImpl) Headers() http.Header {
+	return r.headers
+}
+
+func (r *requestReaderImpl) Header(h string) *string {
+	v := r.headers[textproto.CanonicalMIMEHeaderKey(h)]
+	if len(v) == 0 {
+		return nil
+	}
+	return &v[0]
+}
+
+func (r *requestReaderImpl) RemoteAddr() string {
+	if r.peer == nil || r.peer.Addr == nil {
+		return ""
+	}
+	return r.peer.Addr.String()
+}
+
+// responseWriterImpl is the response writer given to the protection context.
+// gRPC responses are written by the gRPC server so that it only keeps track of
+// the status code of the blocking responses, while their bodies are discarded
+// since blocked calls are rather translated into `PermissionDenied` statuses.
+type responseWriterImpl struct {
+	headers http.Header
+	status  int
+}
+
+func (w *responseWriterImpl) Header() http.Header {
+	if w.headers == nil {
+		w.headers = make(http.Header)
+	}
+	return w.headers
+}
+
+func (w *responseWriterImpl) WriteHeader(statusCode int) {
+	if w.status == 0 {
+		w.status = statusCode
+	}
+}
+
+func (w *responseWriterImpl) Write(b []byte) (int, error) {
+	w.WriteHeader(http.StatusOK)
+	return len(b), nil
+}
+
+// response observed by the response writer
+type observedResponse struct {
+	status int
+}
+
+func newObservedResponse(w *responseWriterImpl) *observedResponse {
+	return &observedResponse{status: w.status}
+}
+
+func (r *observedResponse) Status() int {
+	if status := r.status; status != 0 {
+		return status
+	}
+	// gRPC responses are always successful HTTP/2 responses
+	return http.StatusOK
+}
+
+func (r *observedResponse) ContentType() string {
+	return "application/grpc"
+}
+
+func (r *observedResponse) ContentLength() int64 {
+	return 0
+}
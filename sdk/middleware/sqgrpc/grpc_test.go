@@ -0,0 +1,334 @@
+// Copyright (c) 2016 - 2020 Sqreen. All Rights Reserved.
+// Please refer to our terms for more information:
+// https://www.sqreen.io/terms.html
+
+package sqgrpc
+
+import (
+	"context"
+	"errors"
+	"io"
+	"net"
+	"net/http"
+	"testing"
+
+	"github.com/sqreen/go-agent/internal/protection/http/types"
+	"github.com/sqreen/go-agent/sdk"
+	"github.com/sqreen/go-agent/sdk/middleware/_testlib/mockups"
+	"github.com/stretchr/testify/mock"
+	"github.com/stretchr/testify/require"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
+	"google.golang.org/grpc/test/bufconn"
+	"google.golang.org/protobuf/types/known/wrapperspb"
+)
+
+func TestInterceptors(t *testing.T) {
+	t.Run("sdk methods", func(t *testing.T) {
+		for _, tc := range []struct {
+			name string
+			ctx  types.RootProtectionContext
+		}{
+			{name: "without agent"},
+			{
+				name: "with agent",
+				ctx: func() types.RootProtectionContext {
+					ctx := mockups.NewRootHTTPProtectionContextMockup(context.Background(), mock.Anything, mock.Anything)
+					ctx.ExpectClose(mock.MatchedBy(func(closed types.ClosedProtectionContextFace) bool {
+						require.Len(t, closed.Events().CustomEvents, 1)
+						require.Equal(t, http.StatusOK, closed.Response().Status())
+						return true
+					}))
+					return ctx
+				}(),
+			},
+		} {
+			tc := tc
+			t.Run(tc.name, func(t *testing.T) {
+				handler := func(ctx context.Context) {
+					sdk.FromContext(ctx).TrackEvent("my event")
+				}
+				client, stop := newTestServer(t, testService{handler: handler},
+					grpc.UnaryInterceptor(func(ctx context.Context, req interface{}, info *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (interface{}, error) {
+						return unaryHandlerFromRootProtectionContext(tc.ctx, ctx, req, info, handler)
+					}),
+					grpc.StreamInterceptor(func(srv interface{}, ss grpc.ServerStream, info *grpc.StreamServerInfo, handler grpc.StreamHandler) error {
+						return streamHandlerFromRootProtectionContext(tc.ctx, srv, ss, info, handler)
+					}),
+				)
+				defer stop()
+
+				res, err := client.unary(context.Background(), "hello")
+				require.NoError(t, err)
+				require.Equal(t, "hello", res)
+
+				res, err = client.stream(context.Background(), "hello")
+				require.NoError(t, err)
+				require.Equal(t, "hello", res)
+
+				if ctx, ok := tc.ctx.(*mockups.RootHTTPProtectionContextMockup); ok {
+					ctx.AssertExpectations(t)
+				}
+			})
+		}
+	})
+
+	t.Run("request reader", func(t *testing.T) {
+		var r *requestReaderImpl
+		client, stop := newTestServer(t, testService{},
+			grpc.UnaryInterceptor(func(ctx context.Context, req interface{}, info *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (interface{}, error) {
+				r = newRequestReader(ctx, info.FullMethod)
+				return handler(ctx, req)
+			}),
+		)
+		defer stop()
+		ctx := metadata.AppendToOutgoingContext(context.Background(), "x-forwarded-for", "1.2.3.4", "referer", "https://sqreen.com")
+		_, err := client.unary(ctx, "hello")
+		require.NoError(t, err)
+
+		require.Equal(t, "POST", r.Method())
+		require.Equal(t, "/test.Test/Unary", r.URL().Path)
+		require.Equal(t, "/test.Test/Unary", r.RequestURI())
+		require.Equal(t, "bufnet", r.Host())
+		require.Equal(t, "bufconn", r.RemoteAddr())
+		require.False(t, r.IsTLS())
+		require.Contains(t, r.UserAgent(), "grpc-go")
+		require.Equal(t, "https://sqreen.com", r.Referer())
+		require.Equal(t, "1.2.3.4", *r.Header("X-Forwarded-For"))
+		require.Equal(t, []string{"1.2.3.4"}, r.Headers()["X-Forwarded-For"])
+		require.Nil(t, r.Header("X-Unknown"))
+		for k := range r.Headers() {
+			require.NotEqual(t, ':', k[0], "pseudo-headers should be skipped")
+		}
+	})
+
+	t.Run("request messages", func(t *testing.T) {
+		var p fakeProtectionContext
+		client, stop := newTestServer(t, testService{}, fakeInterceptors(&p)...)
+		defer stop()
+
+		_, err := client.unary(context.Background(), "unary")
+		require.NoError(t, err)
+		require.Len(t, p.params[requestMessageParamsKey], 1)
+		require.Equal(t, "unary", p.params[requestMessageParamsKey][0].(*wrapperspb.StringValue).GetValue())
+
+		_, err = client.stream(context.Background(), "stream")
+		require.NoError(t, err)
+		require.Len(t, p.inspected, 1)
+		require.Equal(t, "stream", p.inspected[0].(*wrapperspb.StringValue).GetValue())
+		require.Equal(t, []int{http.StatusOK, http.StatusOK}, p.statuses)
+	})
+
+	t.Run("blocking", func(t *testing.T) {
+		for _, tc := range []struct {
+			name          string
+			p             fakeProtectionContext
+			handlerCalled bool
+		}{
+			{
+				name: "before the handler",
+				p:    fakeProtectionContext{beforeErr: errors.New("blocked")},
+			},
+			{
+				name:          "in the handler",
+				p:             fakeProtectionContext{afterErr: errors.New("blocked")},
+				handlerCalled: true,
+			},
+		} {
+			tc := tc
+			t.Run(tc.name, func(t *testing.T) {
+				var called int
+				client, stop := newTestServer(t, testService{handler: func(context.Context) { called++ }}, fakeInterceptors(&tc.p)...)
+				defer stop()
+
+				_, err := client.unary(context.Background(), "hello")
+				require.Equal(t, codes.PermissionDenied, status.Code(err))
+
+				_, err = client.stream(context.Background(), "hello")
+				require.Equal(t, codes.PermissionDenied, status.Code(err))
+
+				if tc.handlerCalled {
+					require.Equal(t, 2, called)
+				} else {
+					require.Equal(t, 0, called)
+				}
+
+				// The blocking responses are observed
+				require.Equal(t, []int{http.StatusForbidden, http.StatusForbidden}, tc.p.statuses)
+			})
+		}
+
+		t.Run("stream message", func(t *testing.T) {
+			p := fakeProtectionContext{wafErr: errors.New("blocked")}
+			client, stop := newTestServer(t, testService{}, fakeInterceptors(&p)...)
+			defer stop()
+			_, err := client.stream(context.Background(), "hello")
+			require.Equal(t, codes.PermissionDenied, status.Code(err))
+		})
+	})
+}
+
+// fakeProtectionContext is a protection context writing a forbidden blocking
+// response when one of its errors is returned.
+type fakeProtectionContext struct {
+	params                      map[string][]interface{}
+	inspected                   []interface{}
+	beforeErr, afterErr, wafErr error
+	// w is the response writer of the current call.
+	w *responseWriterImpl
+	// statuses are the response statuses of the closed calls.
+	statuses []int
+}
+
+func (p *fakeProtectionContext) AddRequestParam(name string, param interface{}) {
+	if p.params == nil {
+		p.params = make(map[string][]interface{})
+	}
+	p.params[name] = append(p.params[name], param)
+}
+
+func (p *fakeProtectionContext) RequestParamWAF(name string, param interface{}) error {
+	p.inspected = append(p.inspected, param)
+	return p.wafErr
+}
+
+func (p *fakeProtectionContext) Before() error { return p.block(p.beforeErr) }
+func (p *fakeProtectionContext) After() error  { return p.block(p.afterErr) }
+
+func (p *fakeProtectionContext) block(err error) error {
+	if err != nil {
+		p.w.WriteHeader(http.StatusForbidden)
+	}
+	return err
+}
+
+func (p *fakeProtectionContext) Close(r types.ResponseFace) {
+	p.statuses = append(p.statuses, r.Status())
+}
+
+func fakeInterceptors(p *fakeProtectionContext) []grpc.ServerOption {
+	return []grpc.ServerOption{
+		grpc.UnaryInterceptor(func(ctx context.Context, req interface{}, _ *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (interface{}, error) {
+			p.w = &responseWriterImpl{}
+			return unaryHandlerFromProtectionContext(p, p.w, ctx, req, handler)
+		}),
+		grpc.StreamInterceptor(func(srv interface{}, ss grpc.ServerStream, _ *grpc.StreamServerInfo, handler grpc.StreamHandler) error {
+			p.w = &responseWriterImpl{}
+			return streamHandlerFromProtectionContext(p, p.w, srv, ss, handler)
+		}),
+	}
+}
+
+// testService is a test service echoing the string messages it receives, with
+// a unary method and a bidirectional streaming method. Its handler is called
+// with the call context.
+type testService struct {
+	handler func(ctx context.Context)
+}
+
+func (s testService) call(ctx context.Context) {
+	if s.handler != nil {
+		s.handler(ctx)
+	}
+}
+
+var testServiceDesc = grpc.ServiceDesc{
+	ServiceName: "test.Test",
+	HandlerType: (*interface{})(nil),
+	Methods: []grpc.MethodDesc{
+		{
+			MethodName: "Unary",
+			Handler: func(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+				in := new(wrapperspb.StringValue)
+				if err := dec(in); err != nil {
+					return nil, err
+				}
+				handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+					srv.(testService).call(ctx)
+					return req, nil
+				}
+				if interceptor == nil {
+					return handler(ctx, in)
+				}
+				info := &grpc.UnaryServerInfo{Server: srv, FullMethod: "/test.Test/Unary"}
+				return interceptor(ctx, in, info, handler)
+			},
+		},
+	},
+	Streams: []grpc.StreamDesc{
+		{
+			StreamName:    "Echo",
+			ServerStreams: true,
+			ClientStreams: true,
+			Handler: func(srv interface{}, stream grpc.ServerStream) error {
+				srv.(testService).call(stream.Context())
+				for {
+					in := new(wrapperspb.StringValue)
+					if err := stream.RecvMsg(in); err == io.EOF {
+						return nil
+					} else if err != nil {
+						return err
+					}
+					if err := stream.SendMsg(in); err != nil {
+						return err
+					}
+				}
+			},
+		},
+	},
+}
+
+type testClient struct {
+	conn *grpc.ClientConn
+}
+
+// newTestServer starts an in-process server of the test service with the given
+// options and returns its client along with the function stopping them.
+func newTestServer(t *testing.T, service testService, opts ...grpc.ServerOption) (client testClient, stop func()) {
+	lis := bufconn.Listen(1024 * 1024)
+	s := grpc.NewServer(opts...)
+	s.RegisterService(&testServiceDesc, service)
+	go func() { _ = s.Serve(lis) }()
+
+	dialer := func(context.Context, string) (net.Conn, error) { return lis.Dial() }
+	conn, err := grpc.Dial("bufnet", grpc.WithContextDialer(dialer), grpc.WithInsecure())
+	require.NoError(t, err)
+	return testClient{conn: conn}, func() {
+		_ = conn.Close()
+		s.Stop()
+	}
+}
+
+func (c testClient) unary(ctx context.Context, msg string) (string, error) {
+	out := new(wrapperspb.StringValue)
+	if err := c.conn.Invoke(ctx, "/test.Test/Unary", wrapperspb.String(msg), out); err != nil {
+		return "", err
+	}
+	return out.GetValue(), nil
+}
+
+// stream sends the given message to the streaming method and returns the
+// echoed message.
+func (c testClient) stream(ctx context.Context, msg string) (string, error) {
+	stream, err := c.conn.NewStream(ctx, &testServiceDesc.Streams[0], "/test.Test/Echo")
+	if err != nil {
+		return "", err
+	}
+	if err := stream.SendMsg(wrapperspb.String(msg)); err != nil && err != io.EOF {
+		return "", err
+	}
+	if err := stream.CloseSend(); err != nil {
+		return "", err
+	}
+	out := new(wrapperspb.StringValue)
+	if err := stream.RecvMsg(out); err != nil {
+		return "", err
+	}
+	// Wait for the end of the call
+	if err := stream.RecvMsg(out); err != io.EOF {
+		return "", err
+	}
+	return out.GetValue(), nil
+}
@@ -0,0 +1,226 @@
+// Copyright (c) 2016 - 2020 Sqreen. All Rights Reserved.
+// Please refer to our terms for more information:
+// https://www.sqreen.io/terms.html
+
+package sqhttp
+
+import (
+	"bytes"
+	"encoding/json"
+	"encoding/xml"
+	"io"
+	"io/ioutil"
+	"mime"
+	"mime/multipart"
+	"net/http"
+	"net/url"
+	"strings"
+)
+
+// DefaultMaxParsedBodySize is the default maximum size of the request bodies
+// parsed when the body parsing is enabled.
+const DefaultMaxParsedBodySize = 1024 * 1024
+
+// Names of the request parameters added by the body parsers.
+const (
+	jsonBodyParamsKey      = "json"
+	xmlBodyParamsKey       = "xml"
+	formBodyParamsKey      = "form"
+	multipartBodyParamsKey = "multipart"
+)
+
+// maxXMLDepth is the maximum depth of the parsed XML documents.
+const maxXMLDepth = 32
+
+// bodyParser parses a request body into request parameters. The body can be
+// incomplete when larger than the maximum parsed body size.
+type bodyParser func(p requestParamAdder, body []byte, complete bool, params map[string]string)
+
+type requestParamAdder interface {
+	AddRequestParam(name string, param interface{})
+}
+
+// parseRequestBody reads ahead at most maxSize bytes of the request body in
+// order to parse it according to its content type and add the resulting
+// request parameters. The handler can still read the entire body.
+func parseRequestBody(p requestParamAdder, r *http.Request, maxSize int64) {
+	if r.Body == nil || r.Body == http.NoBody {
+		return
+	}
+	parse, params := newBodyParser(r.Header.Get("Content-Type"))
+	if parse == nil {
+		return
+	}
+
+	buf, complete := readAheadRequestBody(r, maxSize)
+	parse(p, buf, complete, params)
+}
+
+// readAheadRequestBody reads ahead at most maxSize bytes of the request body
+// and replaces it by a body returning the bytes read ahead followed by the rest
+// of the body. The returned boolean is false when the body was not entirely
+// read.
+func readAheadRequestBody(r *http.Request, maxSize int64) (buf []byte, complete bool) {
+	body := r.Body
+	buf, err := ioutil.ReadAll(io.LimitReader(body, maxSize+1))
+	r.Body = readAheadBody{
+		Reader: io.MultiReader(bytes.NewReader(buf), body),
+		Closer: body,
+	}
+	// Read errors are returned to the handler when it reads the rest of the
+	// body.
+	complete = err == nil && int64(len(buf)) <= maxSize
+	if int64(len(buf)) > maxSize {
+		buf = buf[:maxSize]
+	}
+	return buf, complete
+}
+
+type readAheadBody struct {
+	io.Reader
+	io.Closer
+}
+
+// newBodyParser returns the body parser of the given content type, nil when it
+// is not supported.
+func newBodyParser(contentType string) (bodyParser, map[string]string) {
+	mediaType, params, err := mime.ParseMediaType(contentType)
+	if err != nil {
+		return nil, nil
+	}
+	switch {
+	case mediaType == "application/json" || strings.HasSuffix(mediaType, "+json"):
+		return parseJSONBody, params
+	case mediaType == "application/xml" || mediaType == "text/xml" || strings.HasSuffix(mediaType, "+xml"):
+		return parseXMLBody, params
+	case mediaType == "application/x-www-form-urlencoded":
+		return parseFormBody, params
+	case mediaType == "multipart/form-data" && params["boundary"] != "":
+		return parseMultipartBody, params
+	default:
+		return nil, nil
+	}
+}
+
+func parseJSONBody(p requestParamAdder, body []byte, complete bool, _ map[string]string) {
+	if !complete {
+		return
+	}
+	var v interface{}
+	if err := json.Unmarshal(body, &v); err != nil {
+		return
+	}
+	p.AddRequestParam(jsonBodyParamsKey, v)
+}
+
+func parseFormBody(p requestParamAdder, body []byte, complete bool, _ map[string]string) {
+	if !complete {
+		return
+	}
+	values, err := url.ParseQuery(string(body))
+	if err != nil || len(values) == 0 {
+		return
+	}
+	p.AddRequestParam(formBodyParamsKey, values)
+}
+
+// parseMultipartBody adds the values of the multipart form fields along with
+// the file names and content types of the file fields. The file contents are
+// ignored. Incomplete bodies are parsed until the first incomplete part.
+func parseMultipartBody(p requestParamAdder, body []byte, _ bool, params map[string]string) {
+	fields := make(map[string][]interface{})
+	r := multipart.NewReader(bytes.NewReader(body), params["boundary"])
+	for {
+		part, err := r.NextPart()
+		if err != nil {
+			break
+		}
+		name := part.FormName()
+		if name == "" {
+			continue
+		}
+		if filename := part.FileName(); filename != "" {
+			fields[name] = append(fields[name], map[string]string{
+				"filename":     filename,
+				"content_type": part.Header.Get("Content-Type"),
+			})
+			continue
+		}
+		value, err := ioutil.ReadAll(part)
+		if err != nil {
+			break
+		}
+		fields[name] = append(fields[name], string(value))
+	}
+	if len(fields) == 0 {
+		return
+	}
+	p.AddRequestParam(multipartBodyParamsKey, fields)
+}
+
+// parseXMLBody adds the XML document as a tree of maps whose keys are the
+// element and attribute names. Attribute names are prefixed with `@` and the
+// text of elements also having children or attributes has the key `#text`.
+func parseXMLBody(p requestParamAdder, body []byte, complete bool, _ map[string]string) {
+	if !complete {
+		return
+	}
+	d := xml.NewDecoder(bytes.NewReader(body))
+	for {
+		tok, err := d.Token()
+		if err != nil {
+			return
+		}
+		if start, ok := tok.(xml.StartElement); ok {
+			v, err := decodeXMLElement(d, start, 1)
+			if err != nil {
+				return
+			}
+			p.AddRequestParam(xmlBodyParamsKey, map[string]interface{}{start.Name.Local: v})
+			return
+		}
+	}
+}
+
+func decodeXMLElement(d *xml.Decoder, start xml.StartElement, depth int) (interface{}, error) {
+	if depth > maxXMLDepth {
+		return nil, errMaxXMLDepth
+	}
+	element := make(map[string]interface{})
+	for _, attr := range start.Attr {
+		element["@"+attr.Name.Local] = attr.Value
+	}
+	var text strings.Builder
+	for {
+		tok, err := d.Token()
+		if err != nil {
+			return nil, err
+		}
+		switch t := tok.(type) {
+		case xml.StartElement:
+			child, err := decodeXMLElement(d, t, depth+1)
+			if err != nil {
+				return nil, err
+			}
+			children, _ := element[t.Name.Local].([]interface{})
+			element[t.Name.Local] = append(children, child)
+		case xml.CharData:
+			text.Write(t)
+		case xml.EndElement:
+			s := strings.TrimSpace(text.String())
+			if len(element) == 0 {
+				return s, nil
+			}
+			if s != "" {
+				element["#text"] = s
+			}
+			return element, nil
+		}
+	}
+}
+
+type xmlDepthError struct{}
+
+func (xmlDepthError) Error() string { return "maximum xml depth exceeded" }
+
+var errMaxXMLDepth = xmlDepthError{}
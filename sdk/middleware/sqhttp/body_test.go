@@ -0,0 +1,174 @@
+// Copyright (c) 2016 - 2020 Sqreen. All Rights Reserved.
+// Please refer to our terms for more information:
+// https://www.sqreen.io/terms.html
+
+package sqhttp
+
+import (
+	"bytes"
+	"io/ioutil"
+	"mime/multipart"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+
+	"github.com/sqreen/go-agent/internal/protection/http/types"
+	"github.com/stretchr/testify/require"
+)
+
+type fakeProtectionContext struct {
+	params           map[string][]interface{}
+	graphQLOperation *types.GraphQLOperation
+	blocked          interface{}
+}
+
+func (p *fakeProtectionContext) AddRequestParam(name string, param interface{}) {
+	if p.params == nil {
+		p.params = make(map[string][]interface{})
+	}
+	p.params[name] = append(p.params[name], param)
+}
+
+func (p *fakeProtectionContext) SetGraphQLOperation(typ, name string) {
+	p.graphQLOperation = &types.GraphQLOperation{Type: typ, Name: name}
+}
+
+func (p *fakeProtectionContext) BlockGraphQLRequest(info interface{}) {
+	p.blocked = info
+}
+
+func (*fakeProtectionContext) WrapRequest(r *http.Request) *http.Request { return r }
+func (*fakeProtectionContext) Before() error                             { return nil }
+func (*fakeProtectionContext) After() error                              { return nil }
+
+// serveParsedBody serves the given body through the middleware handler and
+// returns the resulting request parameters. It also checks the handler still
+// reads the entire body.
+func serveParsedBody(t *testing.T, contentType, body string, maxSize int64) map[string][]interface{} {
+	var p fakeProtectionContext
+	req, err := http.NewRequest("POST", "/", strings.NewReader(body))
+	require.NoError(t, err)
+	if contentType != "" {
+		req.Header.Set("Content-Type", contentType)
+	}
+	called := false
+	h := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		called = true
+		read, err := ioutil.ReadAll(r.Body)
+		require.NoError(t, err)
+		require.Equal(t, body, string(read))
+		require.NoError(t, r.Body.Close())
+	})
+	cfg := middlewareConfig{}
+	WithBodyParsing(maxSize)(&cfg)
+	middlewareHandlerFromProtectionContext(&p, h, httptest.NewRecorder(), &requestReaderImpl{Request: req}, &cfg)
+	require.True(t, called)
+	return p.params
+}
+
+func TestBodyParsing(t *testing.T) {
+	t.Run("disabled by default", func(t *testing.T) {
+		var p fakeProtectionContext
+		req, err := http.NewRequest("POST", "/", strings.NewReader(`{"a":1}`))
+		require.NoError(t, err)
+		req.Header.Set("Content-Type", "application/json")
+		middlewareHandlerFromProtectionContext(&p, http.NotFoundHandler(), httptest.NewRecorder(), &requestReaderImpl{Request: req}, &middlewareConfig{})
+		require.Nil(t, p.params)
+	})
+
+	t.Run("json", func(t *testing.T) {
+		body := `{"user":{"name":"<script>"},"ids":[1,2]}`
+		expected := map[string][]interface{}{
+			jsonBodyParamsKey: {
+				map[string]interface{}{
+					"user": map[string]interface{}{"name": "<script>"},
+					"ids":  []interface{}{1.0, 2.0},
+				},
+			},
+		}
+		require.Equal(t, expected, serveParsedBody(t, "application/json; charset=utf-8", body, 0))
+		require.Equal(t, expected, serveParsedBody(t, "application/vnd.api+json", body, 0))
+		// Too large or invalid
+		require.Nil(t, serveParsedBody(t, "application/json", body, 10))
+		require.Nil(t, serveParsedBody(t, "application/json", `{"oops"`, 0))
+	})
+
+	t.Run("urlencoded", func(t *testing.T) {
+		body := "a=1&a=2&b=%3Cscript%3E"
+		require.Equal(t, map[string][]interface{}{
+			formBodyParamsKey: {url.Values{"a": {"1", "2"}, "b": {"<script>"}}},
+		}, serveParsedBody(t, "application/x-www-form-urlencoded", body, 0))
+		require.Nil(t, serveParsedBody(t, "application/x-www-form-urlencoded", body, 5))
+	})
+
+	t.Run("xml", func(t *testing.T) {
+		body := `<?xml version="1.0"?><user id="42"><name>bob</name><role>a</role><role>b</role><bio>hi <b>there</b></bio></user>`
+		require.Equal(t, map[string][]interface{}{
+			xmlBodyParamsKey: {
+				map[string]interface{}{
+					"user": map[string]interface{}{
+						"@id":  "42",
+						"name": []interface{}{"bob"},
+						"role": []interface{}{"a", "b"},
+						"bio": []interface{}{map[string]interface{}{
+							"b":     []interface{}{"there"},
+							"#text": "hi",
+						}},
+					},
+				},
+			},
+		}, serveParsedBody(t, "text/xml", body, 0))
+		require.Nil(t, serveParsedBody(t, "application/xml", body, 20))
+		require.Nil(t, serveParsedBody(t, "application/xml", "<a><b></a>", 0))
+
+		deep := strings.Repeat("<a>", maxXMLDepth+1) + strings.Repeat("</a>", maxXMLDepth+1)
+		require.Nil(t, serveParsedBody(t, "application/xml", deep, 0))
+	})
+
+	t.Run("multipart", func(t *testing.T) {
+		var buf bytes.Buffer
+		w := multipart.NewWriter(&buf)
+		require.NoError(t, w.WriteField("user", "<script>"))
+		f, err := w.CreateFormFile("avatar", "avatar.png")
+		require.NoError(t, err)
+		_, err = f.Write(bytes.Repeat([]byte("x"), 1000))
+		require.NoError(t, err)
+		require.NoError(t, w.WriteField("comment", "hello"))
+		require.NoError(t, w.Close())
+		body := buf.String()
+
+		require.Equal(t, map[string][]interface{}{
+			multipartBodyParamsKey: {
+				map[string][]interface{}{
+					"user": {"<script>"},
+					"avatar": {map[string]string{
+						"filename":     "avatar.png",
+						"content_type": "application/octet-stream",
+					}},
+					"comment": {"hello"},
+				},
+			},
+		}, serveParsedBody(t, w.FormDataContentType(), body, 0))
+
+		// The parts fitting into the limit are still parsed
+		require.Equal(t, map[string][]interface{}{
+			multipartBodyParamsKey: {
+				map[string][]interface{}{
+					"user": {"<script>"},
+					"avatar": {map[string]string{
+						"filename":     "avatar.png",
+						"content_type": "application/octet-stream",
+					}},
+				},
+			},
+		}, serveParsedBody(t, w.FormDataContentType(), body, 500))
+	})
+
+	t.Run("unsupported content types", func(t *testing.T) {
+		require.Nil(t, serveParsedBody(t, "", `{"a":1}`, 0))
+		require.Nil(t, serveParsedBody(t, "text/plain", `{"a":1}`, 0))
+		require.Nil(t, serveParsedBody(t, "multipart/form-data", `{"a":1}`, 0))
+	})
+}
@@ -0,0 +1,112 @@
+// Copyright (c) 2016 - 2020 Sqreen. All Rights Reserved.
+// Please refer to our terms for more information:
+// https://www.sqreen.io/terms.html
+
+package sqhttp
+
+import (
+	"net/http"
+
+	"github.com/sqreen/go-agent/internal/protection/graphql"
+)
+
+// Names of the request parameters added by the GraphQL inspection.
+const (
+	graphQLVariablesParamsKey = "graphql_variables"
+	graphQLArgumentsParamsKey = "graphql_arguments"
+)
+
+// GraphQLOptions are the options of the GraphQL request inspection.
+type GraphQLOptions struct {
+	// Paths are the URL paths of the GraphQL endpoints. Every request is
+	// inspected when empty.
+	Paths []string
+	// MaxDepth is the maximum depth of the selection sets of the GraphQL
+	// operations, where the root fields are at depth 1. Deeper requests are
+	// blocked. Disabled when zero.
+	MaxDepth int
+	// MaxAliases is the maximum number of aliased fields of the GraphQL
+	// operations. Requests with more aliases are blocked. Disabled when zero.
+	MaxAliases int
+}
+
+// WithGraphQL enables the inspection of GraphQL requests. Their query document
+// is parsed so that the variables and literal arguments of the executed
+// operation are protected as request parameters, with keys being the paths to
+// their values. The operation type and name are added to the request record.
+//
+// Requests exceeding the optional depth and alias limits are blocked. The
+// request body is read ahead of the handler up to the maximum size given to
+// `WithBodyParsing()`, or DefaultMaxParsedBodySize by default.
+func WithGraphQL(opts GraphQLOptions) MiddlewareOption {
+	return func(cfg *middlewareConfig) {
+		cfg.graphQL = &opts
+	}
+}
+
+// graphQLLimitsInfo is the attack information of GraphQL requests exceeding
+// the limits.
+type graphQLLimitsInfo map[string]interface{}
+
+// inspectGraphQLRequest inspects the GraphQL request, if any, and adds its
+// variables and arguments as request parameters. The returned limits info is
+// not nil when the request exceeds the limits and must be blocked.
+func inspectGraphQLRequest(p protectionContext, r *http.Request, opts *GraphQLOptions, maxBodySize int64) graphQLLimitsInfo {
+	if len(opts.Paths) > 0 && !isGraphQLEndpoint(opts.Paths, r.URL.Path) {
+		return nil
+	}
+
+	var body []byte
+	if r.Method == http.MethodPost && r.Body != nil && r.Body != http.NoBody {
+		buf, complete := readAheadRequestBody(r, maxBodySize)
+		if !complete {
+			return nil
+		}
+		body = buf
+	}
+
+	req, err := graphql.ReadHTTPRequest(r.Method, r.Header.Get("Content-Type"), r.URL.Query(), body)
+	if err != nil || req == nil {
+		return nil
+	}
+	result, err := graphql.Inspect(req)
+	if err != nil {
+		if err == graphql.ErrMaxNesting && opts.MaxDepth > 0 {
+			// Too deep to be parsed, and therefore deeper than any sensible limit
+			return graphQLLimitsInfo{"max_depth": opts.MaxDepth}
+		}
+		return nil
+	}
+
+	p.SetGraphQLOperation(result.OperationType, result.OperationName)
+	if len(result.Variables) > 0 {
+		p.AddRequestParam(graphQLVariablesParamsKey, result.Variables)
+	}
+	if len(result.Arguments) > 0 {
+		p.AddRequestParam(graphQLArgumentsParamsKey, result.Arguments)
+	}
+
+	if (opts.MaxDepth > 0 && result.Depth > opts.MaxDepth) || (opts.MaxAliases > 0 && result.Aliases > opts.MaxAliases) {
+		info := graphQLLimitsInfo{
+			"depth":   result.Depth,
+			"aliases": result.Aliases,
+		}
+		if opts.MaxDepth > 0 {
+			info["max_depth"] = opts.MaxDepth
+		}
+		if opts.MaxAliases > 0 {
+			info["max_aliases"] = opts.MaxAliases
+		}
+		return info
+	}
+	return nil
+}
+
+func isGraphQLEndpoint(paths []string, path string) bool {
+	for _, p := range paths {
+		if p == path {
+			return true
+		}
+	}
+	return false
+}
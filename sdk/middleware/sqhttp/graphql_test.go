@@ -0,0 +1,133 @@
+// Copyright (c) 2016 - 2020 Sqreen. All Rights Reserved.
+// Please refer to our terms for more information:
+// https://www.sqreen.io/terms.html
+
+package sqhttp
+
+import (
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+
+	"github.com/sqreen/go-agent/internal/protection/http/types"
+	"github.com/stretchr/testify/require"
+)
+
+func TestGraphQL(t *testing.T) {
+	const query = `query GetUser($id: ID!) { user(id: $id) { a: posts(q: "<script>") { b: title } } }`
+	body := `{"query":` + `"` + strings.ReplaceAll(query, `"`, `\"`) + `","variables":{"id":"' OR 1=1"}}`
+
+	// serve serves the given request through the middleware handler and returns
+	// the protection context along with whether the handler was called. It also
+	// checks the handler still reads the entire body.
+	serve := func(t *testing.T, req *http.Request, opts GraphQLOptions) (*fakeProtectionContext, bool) {
+		var p fakeProtectionContext
+		var expectedBody string
+		if req.Body != nil {
+			b, err := ioutil.ReadAll(req.Body)
+			require.NoError(t, err)
+			expectedBody = string(b)
+			req.Body = ioutil.NopCloser(strings.NewReader(expectedBody))
+		}
+		called := false
+		h := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			called = true
+			if r.Body != nil {
+				read, err := ioutil.ReadAll(r.Body)
+				require.NoError(t, err)
+				require.Equal(t, expectedBody, string(read))
+			}
+		})
+		cfg := middlewareConfig{}
+		WithGraphQL(opts)(&cfg)
+		middlewareHandlerFromProtectionContext(&p, h, httptest.NewRecorder(), &requestReaderImpl{Request: req}, &cfg)
+		return &p, called
+	}
+
+	newPOSTRequest := func(t *testing.T, path, body string) *http.Request {
+		req, err := http.NewRequest("POST", path, strings.NewReader(body))
+		require.NoError(t, err)
+		req.Header.Set("Content-Type", "application/json")
+		return req
+	}
+
+	expectedParams := map[string][]interface{}{
+		graphQLVariablesParamsKey: {map[string]interface{}{"id": "' OR 1=1"}},
+		graphQLArgumentsParamsKey: {map[string][]interface{}{"user.posts.q": {"<script>"}}},
+	}
+
+	t.Run("post", func(t *testing.T) {
+		p, called := serve(t, newPOSTRequest(t, "/graphql", body), GraphQLOptions{})
+		require.True(t, called)
+		require.Equal(t, expectedParams, p.params)
+		require.Equal(t, &types.GraphQLOperation{Type: "query", Name: "GetUser"}, p.graphQLOperation)
+		require.Nil(t, p.blocked)
+	})
+
+	t.Run("get", func(t *testing.T) {
+		q := url.Values{"query": {query}, "variables": {`{"id":"' OR 1=1"}`}}
+		req, err := http.NewRequest("GET", "/graphql?"+q.Encode(), nil)
+		require.NoError(t, err)
+		p, called := serve(t, req, GraphQLOptions{})
+		require.True(t, called)
+		require.Equal(t, expectedParams, p.params)
+		require.Equal(t, &types.GraphQLOperation{Type: "query", Name: "GetUser"}, p.graphQLOperation)
+	})
+
+	t.Run("paths", func(t *testing.T) {
+		p, called := serve(t, newPOSTRequest(t, "/other", body), GraphQLOptions{Paths: []string{"/graphql"}})
+		require.True(t, called)
+		require.Nil(t, p.params)
+		require.Nil(t, p.graphQLOperation)
+
+		p, called = serve(t, newPOSTRequest(t, "/graphql", body), GraphQLOptions{Paths: []string{"/graphql"}})
+		require.True(t, called)
+		require.Equal(t, expectedParams, p.params)
+	})
+
+	t.Run("not graphql requests", func(t *testing.T) {
+		for _, body := range []string{`{"a":"b"}`, `oops`, `{"query":"{ oops"}`} {
+			p, called := serve(t, newPOSTRequest(t, "/graphql", body), GraphQLOptions{MaxDepth: 1})
+			require.True(t, called)
+			require.Nil(t, p.params)
+			require.Nil(t, p.graphQLOperation)
+			require.Nil(t, p.blocked)
+		}
+	})
+
+	t.Run("limits", func(t *testing.T) {
+		for _, tc := range []struct {
+			opts    GraphQLOptions
+			blocked interface{}
+		}{
+			{opts: GraphQLOptions{MaxDepth: 3, MaxAliases: 2}},
+			{
+				opts:    GraphQLOptions{MaxDepth: 2},
+				blocked: graphQLLimitsInfo{"depth": 3, "aliases": 2, "max_depth": 2},
+			},
+			{
+				opts:    GraphQLOptions{MaxDepth: 3, MaxAliases: 1},
+				blocked: graphQLLimitsInfo{"depth": 3, "aliases": 2, "max_depth": 3, "max_aliases": 1},
+			},
+		} {
+			p, called := serve(t, newPOSTRequest(t, "/graphql", body), tc.opts)
+			require.Equal(t, tc.blocked, p.blocked)
+			require.Equal(t, tc.blocked == nil, called)
+			// The request is still inspected
+			require.Equal(t, expectedParams, p.params)
+		}
+
+		// Too deep to be parsed
+		deep := `{"query":"` + strings.Repeat("{ a ", 200) + strings.Repeat("}", 200) + `"}`
+		p, called := serve(t, newPOSTRequest(t, "/graphql", deep), GraphQLOptions{MaxDepth: 10})
+		require.False(t, called)
+		require.Equal(t, graphQLLimitsInfo{"max_depth": 10}, p.blocked)
+
+		p, called = serve(t, newPOSTRequest(t, "/graphql", deep), GraphQLOptions{})
+		require.True(t, called)
+		require.Nil(t, p.blocked)
+	})
+}
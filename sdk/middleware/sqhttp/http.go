@@ -5,6 +5,9 @@
 package sqhttp
 
 import (
+	"bufio"
+	"io"
+	"io/ioutil"
 	"net"
 	"net/http"
 	"net/textproto"
@@ -47,8 +50,20 @@ import (
 //	}
 //	http.Handle("/foo", sqhttp.Middleware(http.HandlerFunc(fn)))
 //
-func Middleware(next http.Handler) http.Handler {
+// Options can be passed to enable optional features, such as the request body
+// parsing with `WithBodyParsing()`, the GraphQL request inspection with
+// `WithGraphQL()` or the WebSocket message inspection with
+// `WithWebSocketInspection()`.
+//
+// The route template of the request is recorded when the next handler is an
+// `*http.ServeMux`, using the pattern of the handler it matches. Other routers
+// can set it using the SDK method `SetRoute()`.
+func Middleware(next http.Handler, opts ...MiddlewareOption) http.Handler {
 	internal.Start()
+	var cfg middlewareConfig
+	for _, opt := range opts {
+		opt(&cfg)
+	}
 	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
 		ctx, cancel := internal.NewRootHTTPProtectionContext(r.Context())
 		if ctx == nil {
@@ -56,13 +71,51 @@ func Middleware(next http.Handler) http.Handler {
 			return
 		}
 		defer cancel()
-		middlewareHandlerFromRootProtectionContext(ctx, next, w, r)
+		middlewareHandlerFromRootProtectionContext(ctx, next, w, r, &cfg)
 	})
 }
-func middlewareHandlerFromRootProtectionContext(ctx types.RootProtectionContext, next http.Handler, w http.ResponseWriter, r *http.Request) {
+
+// MiddlewareOption is an option of the middleware.
+type MiddlewareOption func(*middlewareConfig)
+
+type middlewareConfig struct {
+	// maxParsedBodySize is the maximum size of the parsed request bodies. The
+	// body parsing is disabled when zero.
+	maxParsedBodySize int64
+	// graphQL is the GraphQL inspection configuration, nil when disabled.
+	graphQL *GraphQLOptions
+	// maxWebSocketMessageSize is the maximum size of the inspected WebSocket
+	// messages. The WebSocket inspection is disabled when zero.
+	maxWebSocketMessageSize int
+	// reverseProxy is true when the handler is a reverse proxy.
+	reverseProxy bool
+}
+
+// WithBodyParsing enables the parsing of JSON, XML, URL-encoded and multipart
+// request bodies so that their values are protected as request parameters.
+// Multipart bodies are parsed into their field values along with the file names
+// and content types of their file fields.
+//
+// At most maxSize bytes of the body are read ahead of the handler, which still
+// reads the entire body. Larger bodies are not parsed, except the multipart
+// parts fitting into this limit. DefaultMaxParsedBodySize is used when maxSize
+// is not strictly positive.
+func WithBodyParsing(maxSize int64) MiddlewareOption {
+	if maxSize <= 0 {
+		maxSize = DefaultMaxParsedBodySize
+	}
+	return func(cfg *middlewareConfig) {
+		cfg.maxParsedBodySize = maxSize
+	}
+}
+
+func middlewareHandlerFromRootProtectionContext(ctx types.RootProtectionContext, next http.Handler, w http.ResponseWriter, r *http.Request, cfg *middlewareConfig) {
 	// requestReader is a pointer value in order to change the inner request
 	// pointer with the new one created by http.(*Request).WithContext below
 	requestReader := &requestReaderImpl{Request: r}
+	if mux, ok := next.(*http.ServeMux); ok {
+		_, requestReader.route = mux.Handler(r)
+	}
 	responseWriter, responseWriterObserver := wrapResponseWriter(w)
 	p := http_protection.NewProtectionContext(ctx, responseWriter, requestReader)
 	if p == nil {
@@ -70,25 +123,62 @@ func middlewareHandlerFromRootProtectionContext(ctx types.RootProtectionContext,
 		return
 	}
 
+	p.InspectResponse(responseWriterObserver.ResponseInspector)
+	responseWriterObserver.OnReset(func() {
+		// The handler response was replaced by the blocking response
+		responseWriterObserver.status = 0
+		responseWriterObserver.written = 0
+	})
+	if cfg.reverseProxy {
+		responseWriterObserver.blocked = p.Blocked
+	}
+	if cfg.maxWebSocketMessageSize > 0 && isWebSocketUpgrade(r) {
+		responseWriterObserver.onHijack = func(conn net.Conn, rw *bufio.ReadWriter) (net.Conn, *bufio.ReadWriter) {
+			return newWebSocketConn(conn, rw, p.WebSocketMessageWAF, cfg.maxWebSocketMessageSize)
+		}
+	}
+
 	defer func() {
+		// Commit what the handler wrote and is still buffered
+		_ = responseWriterObserver.Commit()
 		p.Close(newObservedResponse(responseWriterObserver))
 	}()
 
-	middlewareHandlerFromProtectionContext(p, next, responseWriter, requestReader)
+	middlewareHandlerFromProtectionContext(p, next, responseWriter, requestReader, cfg)
 }
 
 type protectionContext interface {
+	AddRequestParam(name string, param interface{})
+	SetGraphQLOperation(typ, name string)
+	BlockGraphQLRequest(info interface{})
 	WrapRequest(*http.Request) *http.Request
 	Before() error
 	After() error
 }
 
-func middlewareHandlerFromProtectionContext(p protectionContext, next http.Handler, w http.ResponseWriter, r *requestReaderImpl) {
+func middlewareHandlerFromProtectionContext(p protectionContext, next http.Handler, w http.ResponseWriter, r *requestReaderImpl, cfg *middlewareConfig) {
+	if cfg.maxParsedBodySize > 0 {
+		// Parsed before wrapping the request body so that the read-ahead bytes
+		// are still captured when the handler reads them.
+		parseRequestBody(p, r.Request, cfg.maxParsedBodySize)
+	}
+	var graphQLLimits graphQLLimitsInfo
+	if cfg.graphQL != nil {
+		maxBodySize := cfg.maxParsedBodySize
+		if maxBodySize == 0 {
+			maxBodySize = DefaultMaxParsedBodySize
+		}
+		graphQLLimits = inspectGraphQLRequest(p, r.Request, cfg.graphQL, maxBodySize)
+	}
 	r.Request = p.WrapRequest(r.Request)
 
 	if err := p.Before(); err != nil {
 		return
 	}
+	if graphQLLimits != nil {
+		p.BlockGraphQLRequest(graphQLLimits)
+		return
+	}
 	next.ServeHTTP(w, r.Request)
 	if err := p.After(); err != nil {
 		return
@@ -98,6 +188,9 @@ func middlewareHandlerFromProtectionContext(p protectionContext, next http.Handl
 type requestReaderImpl struct {
 	*http.Request
 	queryForm url.Values
+	// route is the pattern of the ServeMux handler matching the request, the
+	// empty string when the next handler is not a ServeMux.
+	route string
 }
 
 func (r *requestReaderImpl) Body() []byte {
@@ -180,10 +273,21 @@ func (r *requestReaderImpl) RemoteAddr() string {
 	return r.Request.RemoteAddr
 }
 
+func (r *requestReaderImpl) Route() string {
+	return r.route
+}
+
 type responseWriterObserver struct {
-	http.ResponseWriter
+	*http_protection.ResponseInspector
 	status  int
 	written int
+	// onHijack, when set, returns the connection and buffered reader and writer
+	// to return instead of the hijacked ones.
+	onHijack func(net.Conn, *bufio.ReadWriter) (net.Conn, *bufio.ReadWriter)
+	// blocked, when set, returns true when the request was blocked so that the
+	// further writes are discarded instead of failing. It allows handlers such
+	// as reverse proxies to go on without aborting the blocking response.
+	blocked func() bool
 }
 
 // response observed by the response writer
@@ -228,19 +332,52 @@ func (r *observedResponse) ContentLength() int64 {
 	return int64(r.contentLength)
 }
 
-func (w *responseWriterObserver) Header() http.Header {
-	return w.ResponseWriter.Header()
+func (w *responseWriterObserver) Write(b []byte) (int, error) {
+	written, err := w.ResponseInspector.Write(b)
+	if err == nil {
+		w.written += written
+	} else if w.discarded() {
+		return len(b), nil
+	}
+	return written, err
 }
 
-func (w *responseWriterObserver) Write(b []byte) (int, error) {
-	written, err := w.ResponseWriter.Write(b)
+func (w *responseWriterObserver) WriteString(s string) (int, error) {
+	written, err := w.ResponseInspector.WriteString(s)
 	if err == nil {
 		w.written += written
+	} else if w.discarded() {
+		return len(s), nil
 	}
 	return written, err
 }
 
+func (w *responseWriterObserver) ReadFrom(r io.Reader) (int64, error) {
+	written, err := w.ResponseInspector.ReadFrom(r)
+	w.written += int(written)
+	if err != nil && w.discarded() {
+		discarded, err := io.Copy(ioutil.Discard, r)
+		return written + discarded, err
+	}
+	return written, err
+}
+
+// discarded returns true when the writes failing because the request was
+// blocked should be discarded.
+func (w *responseWriterObserver) discarded() bool {
+	return w.blocked != nil && w.blocked()
+}
+
 func (w *responseWriterObserver) WriteHeader(statusCode int) {
 	w.status = statusCode
-	w.ResponseWriter.WriteHeader(statusCode)
+	w.ResponseInspector.WriteHeader(statusCode)
+}
+
+func (w *responseWriterObserver) Hijack() (net.Conn, *bufio.ReadWriter, error) {
+	conn, rw, err := w.ResponseInspector.Hijack()
+	if err != nil || w.onHijack == nil {
+		return conn, rw, err
+	}
+	conn, rw = w.onHijack(conn, rw)
+	return conn, rw, nil
 }
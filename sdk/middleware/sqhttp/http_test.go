@@ -390,7 +390,7 @@ func TestMiddleware(t *testing.T) {
 
 func middleware(ctx types.RootProtectionContext, next http.Handler) http.Handler {
 	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
-		middlewareHandlerFromRootProtectionContext(ctx, next, w, r)
+		middlewareHandlerFromRootProtectionContext(ctx, next, w, r, &middlewareConfig{})
 	})
 }
 
@@ -416,4 +416,40 @@ func TestRequestReaderImpl(t *testing.T) {
 			require.Equal(t, []interface{}{[]string{`a`, `bb`, `cc`, `foo`, `bar`, `zyz`, `"\"\\"\\\"`}}, frameworkParams[urlSegmentsFrameworkParamsKey])
 		})
 	})
+
+	t.Run("route", func(t *testing.T) {
+		// serve serves the request through the middleware and returns the route
+		// of the closed request record.
+		serve := func(t *testing.T, next http.Handler, path string) (route string) {
+			root := mockups.NewRootHTTPProtectionContextMockup(context.Background(), mock.Anything, mock.Anything)
+			root.ExpectClose(mock.MatchedBy(func(closed types.ClosedProtectionContextFace) bool {
+				r, ok := closed.Request().(types.RouteReader)
+				require.True(t, ok)
+				route = r.Route()
+				return true
+			}))
+			defer root.AssertExpectations(t)
+			req := httptest.NewRequest("GET", path, nil)
+			middlewareHandlerFromRootProtectionContext(root, next, httptest.NewRecorder(), req, &middlewareConfig{})
+			return route
+		}
+
+		mux := http.NewServeMux()
+		mux.HandleFunc("/users/", func(http.ResponseWriter, *http.Request) {})
+		mux.HandleFunc("/custom/", func(_ http.ResponseWriter, r *http.Request) {
+			sdk.FromContext(r.Context()).SetRoute("/custom/:id")
+		})
+
+		t.Run("servemux pattern", func(t *testing.T) {
+			require.Equal(t, "/users/", serve(t, mux, "/users/1"))
+		})
+
+		t.Run("sdk", func(t *testing.T) {
+			require.Equal(t, "/custom/:id", serve(t, mux, "/custom/1"))
+		})
+
+		t.Run("unknown route", func(t *testing.T) {
+			require.Empty(t, serve(t, http.NotFoundHandler(), "/users/1"))
+		})
+	})
 }
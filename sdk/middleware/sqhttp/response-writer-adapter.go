@@ -9,6 +9,19 @@ import (
 	"net/http"
 )
 
+// responseWriterWrapper is a response writer wrapper implementing every
+// optional response writer interface, usually by forwarding the call to the
+// wrapped response writer.
+type responseWriterWrapper interface {
+	http.ResponseWriter
+	http.Flusher
+	http.Pusher
+	http.CloseNotifier
+	http.Hijacker
+	io.ReaderFrom
+	io.StringWriter
+}
+
 type (
 	flusherPusherCloseNotifierHijackerReaderFromStringWriter struct {
 		http.ResponseWriter
@@ -707,388 +720,392 @@ type (
 	}
 )
 
-func adaptResponseWriter(wrapper, wrapped http.ResponseWriter) http.ResponseWriter {
-	switch actual := wrapped.(type) {
+// adaptResponseWriter returns a response writer implementing the same optional
+// interfaces as the wrapped response writer, all of them being implemented by
+// the wrapper.
+func adaptResponseWriter(wrapper responseWriterWrapper, wrapped http.ResponseWriter) http.ResponseWriter {
+	switch wrapped.(type) {
 
 	case FlusherPusherCloseNotifierHijackerReaderFromStringWriter:
 		return flusherPusherCloseNotifierHijackerReaderFromStringWriter{
 			ResponseWriter: wrapper,
-			FlusherPusherCloseNotifierHijackerReaderFromStringWriter: actual,
+			FlusherPusherCloseNotifierHijackerReaderFromStringWriter: wrapper,
 		}
 
 	case PusherCloseNotifierHijackerReaderFromStringWriter:
 		return pusherCloseNotifierHijackerReaderFromStringWriter{
 			ResponseWriter: wrapper,
-			PusherCloseNotifierHijackerReaderFromStringWriter: actual,
+			PusherCloseNotifierHijackerReaderFromStringWriter: wrapper,
 		}
 
 	case FlusherCloseNotifierHijackerReaderFromStringWriter:
 		return flusherCloseNotifierHijackerReaderFromStringWriter{
 			ResponseWriter: wrapper,
-			FlusherCloseNotifierHijackerReaderFromStringWriter: actual,
+			FlusherCloseNotifierHijackerReaderFromStringWriter: wrapper,
 		}
 
 	case FlusherPusherCloseNotifierHijackerStringWriter:
 		return flusherPusherCloseNotifierHijackerStringWriter{
 			ResponseWriter: wrapper,
-			FlusherPusherCloseNotifierHijackerStringWriter: actual,
+			FlusherPusherCloseNotifierHijackerStringWriter: wrapper,
 		}
 
 	case FlusherPusherHijackerReaderFromStringWriter:
 		return flusherPusherHijackerReaderFromStringWriter{
 			ResponseWriter: wrapper,
-			FlusherPusherHijackerReaderFromStringWriter: actual,
+			FlusherPusherHijackerReaderFromStringWriter: wrapper,
 		}
 
 	case FlusherPusherCloseNotifierReaderFromStringWriter:
 		return flusherPusherCloseNotifierReaderFromStringWriter{
 			ResponseWriter: wrapper,
-			FlusherPusherCloseNotifierReaderFromStringWriter: actual,
+			FlusherPusherCloseNotifierReaderFromStringWriter: wrapper,
 		}
 
 	case FlusherPusherCloseNotifierHijackerReaderFrom:
 		return flusherPusherCloseNotifierHijackerReaderFrom{
 			ResponseWriter: wrapper,
-			FlusherPusherCloseNotifierHijackerReaderFrom: actual,
+			FlusherPusherCloseNotifierHijackerReaderFrom: wrapper,
 		}
 
 	case FlusherPusherCloseNotifierReaderFrom:
 		return flusherPusherCloseNotifierReaderFrom{
 			ResponseWriter:                       wrapper,
-			FlusherPusherCloseNotifierReaderFrom: actual,
+			FlusherPusherCloseNotifierReaderFrom: wrapper,
 		}
 
 	case FlusherHijackerReaderFromStringWriter:
 		return flusherHijackerReaderFromStringWriter{
 			ResponseWriter:                        wrapper,
-			FlusherHijackerReaderFromStringWriter: actual,
+			FlusherHijackerReaderFromStringWriter: wrapper,
 		}
 
 	case FlusherPusherCloseNotifierHijacker:
 		return flusherPusherCloseNotifierHijacker{
 			ResponseWriter:                     wrapper,
-			FlusherPusherCloseNotifierHijacker: actual,
+			FlusherPusherCloseNotifierHijacker: wrapper,
 		}
 
 	case FlusherPusherHijackerStringWriter:
 		return flusherPusherHijackerStringWriter{
 			ResponseWriter:                    wrapper,
-			FlusherPusherHijackerStringWriter: actual,
+			FlusherPusherHijackerStringWriter: wrapper,
 		}
 
 	case PusherHijackerReaderFromStringWriter:
 		return pusherHijackerReaderFromStringWriter{
 			ResponseWriter:                       wrapper,
-			PusherHijackerReaderFromStringWriter: actual,
+			PusherHijackerReaderFromStringWriter: wrapper,
 		}
 
 	case PusherCloseNotifierHijackerStringWriter:
 		return pusherCloseNotifierHijackerStringWriter{
 			ResponseWriter:                          wrapper,
-			PusherCloseNotifierHijackerStringWriter: actual,
+			PusherCloseNotifierHijackerStringWriter: wrapper,
 		}
 
 	case CloseNotifierHijackerReaderFromStringWriter:
 		return closeNotifierHijackerReaderFromStringWriter{
 			ResponseWriter: wrapper,
-			CloseNotifierHijackerReaderFromStringWriter: actual,
+			CloseNotifierHijackerReaderFromStringWriter: wrapper,
 		}
 
 	case PusherCloseNotifierReaderFromStringWriter:
 		return pusherCloseNotifierReaderFromStringWriter{
 			ResponseWriter: wrapper,
-			PusherCloseNotifierReaderFromStringWriter: actual,
+			PusherCloseNotifierReaderFromStringWriter: wrapper,
 		}
 
 	case FlusherCloseNotifierReaderFromStringWriter:
 		return flusherCloseNotifierReaderFromStringWriter{
 			ResponseWriter: wrapper,
-			FlusherCloseNotifierReaderFromStringWriter: actual,
+			FlusherCloseNotifierReaderFromStringWriter: wrapper,
 		}
 
 	case PusherCloseNotifierHijackerReaderFrom:
 		return pusherCloseNotifierHijackerReaderFrom{
 			ResponseWriter:                        wrapper,
-			PusherCloseNotifierHijackerReaderFrom: actual,
+			PusherCloseNotifierHijackerReaderFrom: wrapper,
 		}
 
 	case FlusherPusherReaderFromStringWriter:
 		return flusherPusherReaderFromStringWriter{
 			ResponseWriter:                      wrapper,
-			FlusherPusherReaderFromStringWriter: actual,
+			FlusherPusherReaderFromStringWriter: wrapper,
 		}
 
 	case FlusherCloseNotifierHijackerReaderFrom:
 		return flusherCloseNotifierHijackerReaderFrom{
 			ResponseWriter:                         wrapper,
-			FlusherCloseNotifierHijackerReaderFrom: actual,
+			FlusherCloseNotifierHijackerReaderFrom: wrapper,
 		}
 
 	case FlusherPusherHijackerReaderFrom:
 		return flusherPusherHijackerReaderFrom{
 			ResponseWriter:                  wrapper,
-			FlusherPusherHijackerReaderFrom: actual,
+			FlusherPusherHijackerReaderFrom: wrapper,
 		}
 
 	case FlusherCloseNotifierHijackerStringWriter:
 		return flusherCloseNotifierHijackerStringWriter{
 			ResponseWriter:                           wrapper,
-			FlusherCloseNotifierHijackerStringWriter: actual,
+			FlusherCloseNotifierHijackerStringWriter: wrapper,
 		}
 
 	case FlusherPusherCloseNotifierStringWriter:
 		return flusherPusherCloseNotifierStringWriter{
 			ResponseWriter:                         wrapper,
-			FlusherPusherCloseNotifierStringWriter: actual,
+			FlusherPusherCloseNotifierStringWriter: wrapper,
 		}
 
 	case FlusherCloseNotifierReaderFrom:
 		return flusherCloseNotifierReaderFrom{
 			ResponseWriter:                 wrapper,
-			FlusherCloseNotifierReaderFrom: actual,
+			FlusherCloseNotifierReaderFrom: wrapper,
 		}
 
 	case FlusherReaderFromStringWriter:
 		return flusherReaderFromStringWriter{
 			ResponseWriter:                wrapper,
-			FlusherReaderFromStringWriter: actual,
+			FlusherReaderFromStringWriter: wrapper,
 		}
 
 	case PusherCloseNotifierReaderFrom:
 		return pusherCloseNotifierReaderFrom{
 			ResponseWriter:                wrapper,
-			PusherCloseNotifierReaderFrom: actual,
+			PusherCloseNotifierReaderFrom: wrapper,
 		}
 
 	case PusherHijackerReaderFrom:
 		return pusherHijackerReaderFrom{
 			ResponseWriter:           wrapper,
-			PusherHijackerReaderFrom: actual,
+			PusherHijackerReaderFrom: wrapper,
 		}
 
 	case PusherReaderFromStringWriter:
 		return pusherReaderFromStringWriter{
 			ResponseWriter:               wrapper,
-			PusherReaderFromStringWriter: actual,
+			PusherReaderFromStringWriter: wrapper,
 		}
 
 	case CloseNotifierHijackerReaderFrom:
 		return closeNotifierHijackerReaderFrom{
 			ResponseWriter:                  wrapper,
-			CloseNotifierHijackerReaderFrom: actual,
+			CloseNotifierHijackerReaderFrom: wrapper,
 		}
 
 	case FlusherPusherReaderFrom:
 		return flusherPusherReaderFrom{
 			ResponseWriter:          wrapper,
-			FlusherPusherReaderFrom: actual,
+			FlusherPusherReaderFrom: wrapper,
 		}
 
 	case CloseNotifierReaderFromStringWriter:
 		return closeNotifierReaderFromStringWriter{
 			ResponseWriter:                      wrapper,
-			CloseNotifierReaderFromStringWriter: actual,
+			CloseNotifierReaderFromStringWriter: wrapper,
 		}
 
 	case FlusherHijackerStringWriter:
 		return flusherHijackerStringWriter{
 			ResponseWriter:              wrapper,
-			FlusherHijackerStringWriter: actual,
+			FlusherHijackerStringWriter: wrapper,
 		}
 
 	case FlusherHijackerReaderFrom:
 		return flusherHijackerReaderFrom{
 			ResponseWriter:            wrapper,
-			FlusherHijackerReaderFrom: actual,
+			FlusherHijackerReaderFrom: wrapper,
 		}
 
 	case PusherCloseNotifierHijacker:
 		return pusherCloseNotifierHijacker{
 			ResponseWriter:              wrapper,
-			PusherCloseNotifierHijacker: actual,
+			PusherCloseNotifierHijacker: wrapper,
 		}
 
 	case FlusherCloseNotifierHijacker:
 		return flusherCloseNotifierHijacker{
 			ResponseWriter:               wrapper,
-			FlusherCloseNotifierHijacker: actual,
+			FlusherCloseNotifierHijacker: wrapper,
 		}
 
 	case FlusherPusherStringWriter:
 		return flusherPusherStringWriter{
 			ResponseWriter:            wrapper,
-			FlusherPusherStringWriter: actual,
+			FlusherPusherStringWriter: wrapper,
 		}
 
 	case FlusherPusherHijacker:
 		return flusherPusherHijacker{
 			ResponseWriter:        wrapper,
-			FlusherPusherHijacker: actual,
+			FlusherPusherHijacker: wrapper,
 		}
 
 	case FlusherCloseNotifierStringWriter:
 		return flusherCloseNotifierStringWriter{
 			ResponseWriter:                   wrapper,
-			FlusherCloseNotifierStringWriter: actual,
+			FlusherCloseNotifierStringWriter: wrapper,
 		}
 
 	case PusherCloseNotifierStringWriter:
 		return pusherCloseNotifierStringWriter{
 			ResponseWriter:                  wrapper,
-			PusherCloseNotifierStringWriter: actual,
+			PusherCloseNotifierStringWriter: wrapper,
 		}
 
 	case CloseNotifierHijackerStringWriter:
 		return closeNotifierHijackerStringWriter{
 			ResponseWriter:                    wrapper,
-			CloseNotifierHijackerStringWriter: actual,
+			CloseNotifierHijackerStringWriter: wrapper,
 		}
 
 	case FlusherPusherCloseNotifier:
 		return flusherPusherCloseNotifier{
 			ResponseWriter:             wrapper,
-			FlusherPusherCloseNotifier: actual,
+			FlusherPusherCloseNotifier: wrapper,
 		}
 
 	case PusherHijackerStringWriter:
 		return pusherHijackerStringWriter{
 			ResponseWriter:             wrapper,
-			PusherHijackerStringWriter: actual,
+			PusherHijackerStringWriter: wrapper,
 		}
 
 	case HijackerReaderFromStringWriter:
 		return hijackerReaderFromStringWriter{
 			ResponseWriter:                 wrapper,
-			HijackerReaderFromStringWriter: actual,
+			HijackerReaderFromStringWriter: wrapper,
 		}
 
 	case PusherCloseNotifier:
 		return pusherCloseNotifier{
 			ResponseWriter:      wrapper,
-			PusherCloseNotifier: actual,
+			PusherCloseNotifier: wrapper,
 		}
 
 	case FlusherPusher:
 		return flusherPusher{
 			ResponseWriter: wrapper,
-			FlusherPusher:  actual,
+			FlusherPusher:  wrapper,
 		}
 
 	case CloseNotifierStringWriter:
 		return closeNotifierStringWriter{
 			ResponseWriter:            wrapper,
-			CloseNotifierStringWriter: actual,
+			CloseNotifierStringWriter: wrapper,
 		}
 
 	case PusherStringWriter:
 		return pusherStringWriter{
 			ResponseWriter:     wrapper,
-			PusherStringWriter: actual,
+			PusherStringWriter: wrapper,
 		}
 
 	case FlusherStringWriter:
 		return flusherStringWriter{
 			ResponseWriter:      wrapper,
-			FlusherStringWriter: actual,
+			FlusherStringWriter: wrapper,
 		}
 
 	case ReaderFromStringWriter:
 		return readerFromStringWriter{
 			ResponseWriter:         wrapper,
-			ReaderFromStringWriter: actual,
+			ReaderFromStringWriter: wrapper,
 		}
 
 	case HijackerReaderFrom:
 		return hijackerReaderFrom{
 			ResponseWriter:     wrapper,
-			HijackerReaderFrom: actual,
+			HijackerReaderFrom: wrapper,
 		}
 
 	case CloseNotifierReaderFrom:
 		return closeNotifierReaderFrom{
 			ResponseWriter:          wrapper,
-			CloseNotifierReaderFrom: actual,
+			CloseNotifierReaderFrom: wrapper,
 		}
 
 	case PusherReaderFrom:
 		return pusherReaderFrom{
 			ResponseWriter:   wrapper,
-			PusherReaderFrom: actual,
+			PusherReaderFrom: wrapper,
 		}
 
 	case FlusherReaderFrom:
 		return flusherReaderFrom{
 			ResponseWriter:    wrapper,
-			FlusherReaderFrom: actual,
+			FlusherReaderFrom: wrapper,
 		}
 
 	case HijackerStringWriter:
 		return hijackerStringWriter{
 			ResponseWriter:       wrapper,
-			HijackerStringWriter: actual,
+			HijackerStringWriter: wrapper,
 		}
 
 	case FlusherCloseNotifier:
 		return flusherCloseNotifier{
 			ResponseWriter:       wrapper,
-			FlusherCloseNotifier: actual,
+			FlusherCloseNotifier: wrapper,
 		}
 
 	case CloseNotifierHijacker:
 		return closeNotifierHijacker{
 			ResponseWriter:        wrapper,
-			CloseNotifierHijacker: actual,
+			CloseNotifierHijacker: wrapper,
 		}
 
 	case PusherHijacker:
 		return pusherHijacker{
 			ResponseWriter: wrapper,
-			PusherHijacker: actual,
+			PusherHijacker: wrapper,
 		}
 
 	case FlusherHijacker:
 		return flusherHijacker{
 			ResponseWriter:  wrapper,
-			FlusherHijacker: actual,
+			FlusherHijacker: wrapper,
 		}
 
 	case ReaderFrom:
 		return readerFrom{
 			ResponseWriter: wrapper,
-			ReaderFrom:     actual,
+			ReaderFrom:     wrapper,
 		}
 
 	case Flusher:
 		return flusher{
 			ResponseWriter: wrapper,
-			Flusher:        actual,
+			Flusher:        wrapper,
 		}
 
 	case CloseNotifier:
 		return closeNotifier{
 			ResponseWriter: wrapper,
-			CloseNotifier:  actual,
+			CloseNotifier:  wrapper,
 		}
 
 	case StringWriter:
 		return stringWriter{
 			ResponseWriter: wrapper,
-			StringWriter:   actual,
+			StringWriter:   wrapper,
 		}
 
 	case Pusher:
 		return pusher{
 			ResponseWriter: wrapper,
-			Pusher:         actual,
+			Pusher:         wrapper,
 		}
 
 	case Hijacker:
 		return hijacker{
 			ResponseWriter: wrapper,
-			Hijacker:       actual,
+			Hijacker:       wrapper,
 		}
 
 	default:
-		return wrapper
+		// Only expose the http.ResponseWriter methods of the wrapper
+		return struct{ http.ResponseWriter }{wrapper}
 	}
 }
@@ -5,7 +5,9 @@
 package sqhttp
 
 import (
+	"bufio"
 	"io"
+	"net"
 	"net/http"
 	"testing"
 
@@ -27,7 +29,7 @@ func TestAdaptResponseWriter(t *testing.T) {
 			myFakeResponseWriter: wrapper,
 		}
 
-		w := adaptResponseWriter(wrapper, wrapped)
+		w := adaptResponseWriter(myFakeResponseWriterWrapper{wrapper}, wrapped)
 
 		// The wrapper should implement the interface
 		_, ok := w.(http.Flusher)
@@ -39,13 +41,27 @@ func TestAdaptResponseWriter(t *testing.T) {
 		require.Equal(t, 42, wrapper.status)
 	})
 
+	t.Run("no optional interface", func(t *testing.T) {
+		wrapper := &myFakeResponseWriter{}
+		w := adaptResponseWriter(myFakeResponseWriterWrapper{wrapper}, httptestResponseWriter{})
+
+		// Only the http.ResponseWriter interface should be implemented
+		_, ok := w.(http.Flusher)
+		require.False(t, ok)
+		_, ok = w.(io.StringWriter)
+		require.False(t, ok)
+
+		w.WriteHeader(42)
+		require.Equal(t, 42, wrapper.status)
+	})
+
 	t.Run("Flusher+StringWriter", func(t *testing.T) {
 		wrapper := &myFakeResponseWriter{}
 		wrapped := myFakeResponseWriterFlusherStringWriter{
 			myFakeResponseWriter: wrapper,
 		}
 
-		w := adaptResponseWriter(wrapper, wrapped)
+		w := adaptResponseWriter(myFakeResponseWriterWrapper{wrapper}, wrapped)
 
 		// The wrapper should implement the interface
 		_, ok := w.(interface {
@@ -69,6 +85,21 @@ func (*myFakeResponseWriter) Header() http.Header       { return nil }
 func (*myFakeResponseWriter) Write([]byte) (int, error) { return 0, nil }
 func (w *myFakeResponseWriter) WriteHeader(s int)       { w.status = s }
 
+// myFakeResponseWriterWrapper implements the optional interfaces a response
+// writer wrapper must implement.
+type myFakeResponseWriterWrapper struct {
+	*myFakeResponseWriter
+}
+
+func (myFakeResponseWriterWrapper) Flush()                               {}
+func (myFakeResponseWriterWrapper) Push(string, *http.PushOptions) error { return nil }
+func (myFakeResponseWriterWrapper) CloseNotify() <-chan bool             { return nil }
+func (myFakeResponseWriterWrapper) Hijack() (net.Conn, *bufio.ReadWriter, error) {
+	return nil, nil, nil
+}
+func (myFakeResponseWriterWrapper) ReadFrom(io.Reader) (int64, error) { return 0, nil }
+func (myFakeResponseWriterWrapper) WriteString(string) (int, error)   { return 0, nil }
+
 type myFakeFlusher struct{}
 
 func (myFakeFlusher) Flush() {}
@@ -84,3 +115,9 @@ type myFakeResponseWriterFlusherStringWriter struct {
 }
 
 func (myFakeResponseWriterFlusherStringWriter) WriteString(string) (int, error) { return 0, nil }
+
+type httptestResponseWriter struct{}
+
+func (httptestResponseWriter) Header() http.Header       { return nil }
+func (httptestResponseWriter) Write([]byte) (int, error) { return 0, nil }
+func (httptestResponseWriter) WriteHeader(int)           {}
@@ -6,10 +6,16 @@ package sqhttp
 
 import (
 	"net/http"
+
+	http_protection "github.com/sqreen/go-agent/internal/protection/http"
 )
 
+// wrapResponseWriter returns the response writer to pass to the handler. It
+// observes the response and inspects it before it gets committed, while
+// implementing the same optional interfaces as w.
 func wrapResponseWriter(w http.ResponseWriter) (http.ResponseWriter, *responseWriterObserver) {
-	wrapper := &responseWriterObserver{ResponseWriter: w}
-	w = adaptResponseWriter(wrapper, w)
-	return w, wrapper
+	wrapper := &responseWriterObserver{
+		ResponseInspector: http_protection.NewResponseInspector(w),
+	}
+	return adaptResponseWriter(wrapper, w), wrapper
 }
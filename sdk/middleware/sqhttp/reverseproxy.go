@@ -0,0 +1,120 @@
+// Copyright (c) 2016 - 2020 Sqreen. All Rights Reserved.
+// Please refer to our terms for more information:
+// https://www.sqreen.io/terms.html
+
+package sqhttp
+
+import (
+	"log"
+	"net/http"
+	"net/http/httputil"
+	"net/url"
+
+	protection_context "github.com/sqreen/go-agent/internal/protection/context"
+	http_protection "github.com/sqreen/go-agent/internal/protection/http"
+	"github.com/sqreen/go-agent/internal/sqlib/sqerrors"
+)
+
+// ReverseProxy returns the handler of the given reverse proxy protected by the
+// middleware, which accepts the same options. The reverse proxy is copied so
+// that its hooks are not modified:
+//
+//   - `Director`, or `Rewrite` since Go 1.20, records the upstream target in
+//     the request record.
+//   - `ModifyResponse` records the upstream latency in the request record and
+//     stops forwarding the upstream response when the request was blocked
+//     meanwhile, such as while the request body was being sent upstream.
+//   - `ErrorHandler` is no longer called once the request was blocked so that
+//     the blocking response is not replaced by the proxy error response.
+//
+// The upstream response is written through the middleware and therefore goes
+// through the response protections. When blocked, the rest of the upstream
+// response is discarded instead of aborting the blocking response.
+//
+// Usage example:
+//
+//	target, _ := url.Parse("http://upstream:8080")
+//	proxy := httputil.NewSingleHostReverseProxy(target)
+//	http.Handle("/", sqhttp.ReverseProxy(proxy))
+func ReverseProxy(proxy *httputil.ReverseProxy, opts ...MiddlewareOption) http.Handler {
+	opts = append(opts[:len(opts):len(opts)], withReverseProxy)
+	return Middleware(hookReverseProxy(proxy), opts...)
+}
+
+func withReverseProxy(cfg *middlewareConfig) {
+	cfg.reverseProxy = true
+}
+
+// errBlockedUpstreamResponse is the error returned by the ModifyResponse hook
+// when the request was blocked during the upstream request.
+var errBlockedUpstreamResponse = sqerrors.New("sqhttp: the request was blocked during the upstream request")
+
+func hookReverseProxy(proxy *httputil.ReverseProxy) *httputil.ReverseProxy {
+	hooked := *proxy
+
+	if director := proxy.Director; director != nil {
+		hooked.Director = func(r *http.Request) {
+			director(r)
+			if p := reverseProxyProtectionContext(r); p != nil {
+				p.StartUpstreamRequest(upstreamTarget(r.URL))
+			}
+		}
+	}
+	hookReverseProxyRewrite(&hooked, proxy)
+
+	modifyResponse := proxy.ModifyResponse
+	hooked.ModifyResponse = func(res *http.Response) error {
+		if p := reverseProxyProtectionContext(res.Request); p != nil {
+			p.EndUpstreamRequest()
+			if p.Blocked() {
+				return errBlockedUpstreamResponse
+			}
+		}
+		if modifyResponse != nil {
+			return modifyResponse(res)
+		}
+		return nil
+	}
+
+	errorHandler := proxy.ErrorHandler
+	hooked.ErrorHandler = func(w http.ResponseWriter, r *http.Request, err error) {
+		if p := reverseProxyProtectionContext(r); p != nil {
+			p.EndUpstreamRequest()
+			if p.Blocked() {
+				// The blocking response was already written
+				return
+			}
+		}
+		if errorHandler != nil {
+			errorHandler(w, r, err)
+			return
+		}
+		// Default error handler of the reverse proxy
+		if proxy.ErrorLog != nil {
+			proxy.ErrorLog.Printf("http: proxy error: %v", err)
+		} else {
+			log.Printf("http: proxy error: %v", err)
+		}
+		w.WriteHeader(http.StatusBadGateway)
+	}
+
+	return &hooked
+}
+
+// reverseProxyProtectionContext returns the protection context of the given
+// request, or of the outgoing request created by the reverse proxy out of it,
+// nil when the request is not protected.
+func reverseProxyProtectionContext(r *http.Request) *http_protection.ProtectionContext {
+	if r == nil {
+		return nil
+	}
+	p, _ := r.Context().Value(protection_context.ContextKey).(*http_protection.ProtectionContext)
+	return p
+}
+
+// upstreamTarget returns the upstream target of the given outgoing request URL,
+// which is its scheme and host.
+func upstreamTarget(u *url.URL) string {
+	target := url.URL{Scheme: u.Scheme, Host: u.Host}
+	return target.String()
+}
@@ -0,0 +1,26 @@
+// Copyright (c) 2016 - 2020 Sqreen. All Rights Reserved.
+// Please refer to our terms for more information:
+// https://www.sqreen.io/terms.html
+
+//go:build go1.20
+// +build go1.20
+
+package sqhttp
+
+import "net/http/httputil"
+
+// hookReverseProxyRewrite hooks the `Rewrite` function of the reverse proxy,
+// available since Go 1.20, to record the upstream target of the outgoing
+// request in the request record.
+func hookReverseProxyRewrite(hooked, proxy *httputil.ReverseProxy) {
+	rewrite := proxy.Rewrite
+	if rewrite == nil {
+		return
+	}
+	hooked.Rewrite = func(pr *httputil.ProxyRequest) {
+		rewrite(pr)
+		if p := reverseProxyProtectionContext(pr.In); p != nil {
+			p.StartUpstreamRequest(upstreamTarget(pr.Out.URL))
+		}
+	}
+}
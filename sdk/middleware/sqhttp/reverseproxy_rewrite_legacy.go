@@ -0,0 +1,14 @@
+// Copyright (c) 2016 - 2020 Sqreen. All Rights Reserved.
+// Please refer to our terms for more information:
+// https://www.sqreen.io/terms.html
+
+//go:build !go1.20
+// +build !go1.20
+
+package sqhttp
+
+import "net/http/httputil"
+
+// hookReverseProxyRewrite does nothing as the `Rewrite` function of reverse
+// proxies is only available since Go 1.20.
+func hookReverseProxyRewrite(_, _ *httputil.ReverseProxy) {}
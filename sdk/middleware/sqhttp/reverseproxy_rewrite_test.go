@@ -0,0 +1,61 @@
+// Copyright (c) 2016 - 2020 Sqreen. All Rights Reserved.
+// Please refer to our terms for more information:
+// https://www.sqreen.io/terms.html
+
+//go:build go1.20
+// +build go1.20
+
+package sqhttp
+
+import (
+	"context"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"net/http/httputil"
+	"net/url"
+	"testing"
+
+	"github.com/sqreen/go-agent/internal/protection/http/types"
+	"github.com/sqreen/go-agent/sdk/middleware/_testlib/mockups"
+	"github.com/stretchr/testify/mock"
+	"github.com/stretchr/testify/require"
+)
+
+func TestReverseProxyRewrite(t *testing.T) {
+	upstream := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		require.Equal(t, "/path", r.URL.Path)
+		w.WriteHeader(http.StatusTeapot)
+		_, _ = io.WriteString(w, "upstream")
+	}))
+	defer upstream.Close()
+	target, err := url.Parse(upstream.URL)
+	require.NoError(t, err)
+
+	var recorded *types.Upstream
+	root := mockups.NewRootHTTPProtectionContextMockup(context.Background(), mock.Anything, mock.Anything)
+	root.ExpectClose(mock.MatchedBy(func(closed types.ClosedProtectionContextFace) bool {
+		r, ok := closed.Request().(types.UpstreamReader)
+		require.True(t, ok)
+		recorded = r.Upstream()
+		return true
+	}))
+	defer root.AssertExpectations(t)
+
+	proxy := &httputil.ReverseProxy{
+		Rewrite: func(pr *httputil.ProxyRequest) {
+			pr.SetURL(target)
+		},
+	}
+	rec := httptest.NewRecorder()
+	req := httptest.NewRequest("GET", "/path", nil)
+	middlewareHandlerFromRootProtectionContext(root, hookReverseProxy(proxy), rec, req, &middlewareConfig{reverseProxy: true})
+
+	require.Equal(t, http.StatusTeapot, rec.Code)
+	require.Equal(t, "upstream", rec.Body.String())
+	require.NotNil(t, recorded)
+	require.Equal(t, upstream.URL, recorded.Target)
+	require.True(t, recorded.Latency > 0)
+	// The given proxy is not modified
+	require.Nil(t, proxy.Director)
+}
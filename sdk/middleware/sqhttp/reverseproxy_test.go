@@ -0,0 +1,122 @@
+// Copyright (c) 2016 - 2020 Sqreen. All Rights Reserved.
+// Please refer to our terms for more information:
+// https://www.sqreen.io/terms.html
+
+package sqhttp
+
+import (
+	"context"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"net/http/httputil"
+	"net/url"
+	"testing"
+
+	"github.com/sqreen/go-agent/internal/protection/http/types"
+	"github.com/sqreen/go-agent/sdk/middleware/_testlib/mockups"
+	"github.com/stretchr/testify/mock"
+	"github.com/stretchr/testify/require"
+)
+
+func TestReverseProxy(t *testing.T) {
+	// serve serves a request through the protected reverse proxy to the given
+	// upstream URL, and returns the response along with the upstream of the
+	// closed request record.
+	serve := func(t *testing.T, ctx context.Context, upstream string, proxy *httputil.ReverseProxy) (*httptest.ResponseRecorder, *types.Upstream) {
+		target, err := url.Parse(upstream)
+		require.NoError(t, err)
+		if proxy == nil {
+			proxy = &httputil.ReverseProxy{}
+		}
+		proxy.Director = httputil.NewSingleHostReverseProxy(target).Director
+
+		var recorded *types.Upstream
+		root := mockups.NewRootHTTPProtectionContextMockup(ctx, mock.Anything, mock.Anything)
+		root.ExpectClose(mock.MatchedBy(func(closed types.ClosedProtectionContextFace) bool {
+			r, ok := closed.Request().(types.UpstreamReader)
+			require.True(t, ok)
+			recorded = r.Upstream()
+			return true
+		}))
+		defer root.AssertExpectations(t)
+
+		rec := httptest.NewRecorder()
+		req := httptest.NewRequest("GET", "/path", nil)
+		middlewareHandlerFromRootProtectionContext(root, hookReverseProxy(proxy), rec, req, &middlewareConfig{reverseProxy: true})
+		return rec, recorded
+	}
+
+	t.Run("upstream response", func(t *testing.T) {
+		upstream := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			require.Equal(t, "/path", r.URL.Path)
+			w.WriteHeader(http.StatusTeapot)
+			_, _ = io.WriteString(w, "upstream")
+		}))
+		defer upstream.Close()
+
+		modified := false
+		proxy := &httputil.ReverseProxy{
+			ModifyResponse: func(*http.Response) error {
+				modified = true
+				return nil
+			},
+		}
+		rec, recorded := serve(t, context.Background(), upstream.URL, proxy)
+		require.True(t, modified)
+		require.Equal(t, http.StatusTeapot, rec.Code)
+		require.Equal(t, "upstream", rec.Body.String())
+		require.NotNil(t, recorded)
+		require.Equal(t, upstream.URL, recorded.Target)
+		require.True(t, recorded.Latency > 0)
+
+		// The given proxy is not modified
+		require.Nil(t, proxy.ErrorHandler)
+	})
+
+	t.Run("upstream error", func(t *testing.T) {
+		upstream := httptest.NewServer(nil)
+		upstream.Close()
+
+		t.Run("default error handler", func(t *testing.T) {
+			rec, recorded := serve(t, context.Background(), upstream.URL, nil)
+			require.Equal(t, http.StatusBadGateway, rec.Code)
+			require.NotNil(t, recorded)
+			require.Equal(t, upstream.URL, recorded.Target)
+		})
+
+		t.Run("custom error handler", func(t *testing.T) {
+			proxy := &httputil.ReverseProxy{
+				ErrorHandler: func(w http.ResponseWriter, _ *http.Request, _ error) {
+					w.WriteHeader(http.StatusServiceUnavailable)
+				},
+			}
+			rec, _ := serve(t, context.Background(), upstream.URL, proxy)
+			require.Equal(t, http.StatusServiceUnavailable, rec.Code)
+		})
+	})
+
+	t.Run("blocked during the upstream request", func(t *testing.T) {
+		// The protection context gets canceled when blocking
+		ctx, cancel := context.WithCancel(context.Background())
+		upstream := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			cancel()
+			_, _ = io.WriteString(w, "upstream")
+		}))
+		defer upstream.Close()
+
+		proxy := &httputil.ReverseProxy{
+			ModifyResponse: func(*http.Response) error {
+				t.Error("unexpected call to the modify response hook")
+				return nil
+			},
+			ErrorHandler: func(http.ResponseWriter, *http.Request, error) {
+				t.Error("unexpected call to the error handler")
+			},
+		}
+		rec, recorded := serve(t, ctx, upstream.URL, proxy)
+		require.Empty(t, rec.Body.String())
+		require.NotNil(t, recorded)
+		require.True(t, recorded.Latency > 0)
+	})
+}
@@ -0,0 +1,344 @@
+// Copyright (c) 2016 - 2020 Sqreen. All Rights Reserved.
+// Please refer to our terms for more information:
+// https://www.sqreen.io/terms.html
+
+package sqhttp
+
+import (
+	"bufio"
+	"encoding/binary"
+	"io"
+	"net"
+	"net/http"
+	"strings"
+	"sync"
+)
+
+// DefaultMaxWebSocketMessageSize is the default maximum size of the inspected
+// WebSocket messages.
+const DefaultMaxWebSocketMessageSize = 1024 * 1024
+
+// WithWebSocketInspection enables the inspection of the text messages received
+// by WebSocket connections. When the handler hijacks the connection of a
+// WebSocket upgrade request, such as gorilla/websocket's `Upgrader` does, the
+// returned connection decodes the WebSocket frames read by the handler and
+// runs every text message through the WAF within the request protection
+// context. A text message is only returned to the handler once the WAF allowed
+// it. Otherwise, the connection is closed with the policy-violation close code
+// 1008 and reading it returns the blocking error.
+//
+// Messages larger than maxMessageSize are not inspected, nor are fragmented
+// messages interleaved with too many control frames. DefaultMaxWebSocketMessageSize
+// is used when maxMessageSize is not strictly positive. Compressed messages are
+// not inspected either, and messages are no longer inspected once the handler
+// returned.
+func WithWebSocketInspection(maxMessageSize int) MiddlewareOption {
+	if maxMessageSize <= 0 {
+		maxMessageSize = DefaultMaxWebSocketMessageSize
+	}
+	return func(cfg *middlewareConfig) {
+		cfg.maxWebSocketMessageSize = maxMessageSize
+	}
+}
+
+// isWebSocketUpgrade returns true when the given request is a WebSocket
+// upgrade request.
+func isWebSocketUpgrade(r *http.Request) bool {
+	return headerContainsToken(r.Header, "Connection", "upgrade") && headerContainsToken(r.Header, "Upgrade", "websocket")
+}
+
+func headerContainsToken(h http.Header, name, token string) bool {
+	for _, v := range h[name] {
+		for _, t := range strings.Split(v, ",") {
+			if strings.EqualFold(strings.TrimSpace(t), token) {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+type webSocketMessageWAF func(message string) error
+
+// newWebSocketConn returns the inspected WebSocket connection of the given
+// hijacked connection, along with the new buffered reader and writer to use
+// instead of the hijacked ones. The hijacked reader can indeed have buffered
+// bytes, and reading it would bypass the inspection.
+func newWebSocketConn(conn net.Conn, rw *bufio.ReadWriter, waf webSocketMessageWAF, maxMessageSize int) (net.Conn, *bufio.ReadWriter) {
+	c := &webSocketConn{
+		Conn:           conn,
+		src:            rw.Reader,
+		waf:            waf,
+		maxMessageSize: maxMessageSize,
+	}
+	return c, bufio.NewReadWriter(bufio.NewReader(c), rw.Writer)
+}
+
+// webSocketConn is a server-side WebSocket connection decoding the frames
+// received from the client in order to inspect the text messages. The bytes of
+// a text message, including the control frames interleaved with its fragments,
+// are held back until the WAF allowed the message.
+type webSocketConn struct {
+	net.Conn
+	// src is the reader of the hijacked connection.
+	src            io.Reader
+	waf            webSocketMessageWAF
+	maxMessageSize int
+
+	readMu sync.Mutex
+	// buf is the read buffer.
+	buf []byte
+	// ready are the bytes that can be returned to the reader.
+	ready []byte
+	// held are the bytes of the text message being inspected.
+	held []byte
+	// err is the error returned once ready is empty.
+	err error
+
+	frame webSocketFrameDecoder
+	// message is the payload of the text message being inspected.
+	message []byte
+	// inspecting is true while holding a text message.
+	inspecting bool
+}
+
+func (c *webSocketConn) Read(p []byte) (n int, err error) {
+	c.readMu.Lock()
+	defer c.readMu.Unlock()
+	for len(c.ready) == 0 {
+		if c.err != nil {
+			return 0, c.err
+		}
+		if c.buf == nil {
+			c.buf = make([]byte, 4096)
+		}
+		n, err := c.src.Read(c.buf)
+		if n > 0 {
+			if blockErr := c.decode(c.buf[:n]); blockErr != nil {
+				// The messages read before the blocked one are still returned
+				c.block()
+				c.held = nil
+				c.err = blockErr
+				continue
+			}
+		}
+		if err != nil {
+			// The incomplete message cannot be inspected
+			c.ready = append(c.ready, c.held...)
+			c.held = nil
+			if !c.frame.headerDone {
+				c.ready = append(c.ready, c.frame.header...)
+			}
+			c.err = err
+		}
+	}
+	n = copy(p, c.ready)
+	c.ready = c.ready[n:]
+	return n, nil
+}
+
+// decode decodes the given bytes and makes them ready to be read unless they
+// belong to a text message being inspected. The WAF error is returned when the
+// text message must be blocked.
+func (c *webSocketConn) decode(b []byte) error {
+	for len(b) > 0 {
+		f := &c.frame
+		if !f.headerDone {
+			// The header is held by the decoder until complete so that the
+			// frame type is known.
+			n := f.writeHeader(b)
+			b = b[n:]
+			if !f.headerDone {
+				return nil
+			}
+			c.startFrame()
+			c.hold(f.header)
+		} else {
+			n := f.remaining
+			if uint64(len(b)) < n {
+				n = uint64(len(b))
+			}
+			chunk := b[:n]
+			c.hold(chunk)
+			if c.inspecting && !f.isControl() {
+				c.appendPayload(chunk)
+			}
+			f.remaining -= n
+			b = b[n:]
+		}
+		if f.remaining == 0 {
+			if err := c.endFrame(); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// hold holds the given bytes while inspecting a text message, otherwise makes
+// them ready to be read. The message is released uninspected when the held
+// bytes exceed the maximum message size, plus an allowance for the frame
+// headers and the interleaved control frames.
+func (c *webSocketConn) hold(b []byte) {
+	if !c.inspecting {
+		c.ready = append(c.ready, b...)
+		return
+	}
+	c.held = append(c.held, b...)
+	if len(c.held) > c.maxMessageSize+webSocketMaxHeldOverhead {
+		// Too large to be held
+		c.release()
+	}
+}
+
+// startFrame is called once the frame header was decoded in order to start
+// inspecting text messages. Binary and compressed text messages are not
+// inspected.
+func (c *webSocketConn) startFrame() {
+	f := &c.frame
+	if f.opcode == webSocketOpText && !f.compressed() && !c.inspecting {
+		c.inspecting = true
+		c.message = c.message[:0]
+	}
+}
+
+func (c *webSocketConn) appendPayload(chunk []byte) {
+	f := &c.frame
+	if len(c.message)+len(chunk) > c.maxMessageSize {
+		// Too large to be inspected
+		c.release()
+		return
+	}
+	start := len(c.message)
+	c.message = append(c.message, chunk...)
+	if f.masked {
+		for i := start; i < len(c.message); i++ {
+			c.message[i] ^= f.mask[f.maskOffset%4]
+			f.maskOffset++
+		}
+	}
+}
+
+// endFrame is called once the whole frame was decoded.
+func (c *webSocketConn) endFrame() error {
+	f := &c.frame
+	defer f.reset()
+	if f.isControl() || !f.fin || !c.inspecting {
+		return nil
+	}
+	if err := c.waf(string(c.message)); err != nil {
+		return err
+	}
+	c.release()
+	return nil
+}
+
+// release stops inspecting the current message and makes its held bytes ready
+// to be read.
+func (c *webSocketConn) release() {
+	c.ready = append(c.ready, c.held...)
+	c.held = c.held[:0]
+	c.message = c.message[:0]
+	c.inspecting = false
+}
+
+// block closes the connection with the policy-violation close code.
+func (c *webSocketConn) block() {
+	// Unmasked close frame with the 2-byte close code
+	frame := []byte{0x80 | webSocketOpClose, 2, 0, 0}
+	binary.BigEndian.PutUint16(frame[2:], webSocketClosePolicyViolation)
+	_, _ = c.Conn.Write(frame)
+	_ = c.Conn.Close()
+}
+
+// webSocketMaxHeldOverhead is the number of bytes that can be held besides the
+// text message payload, such as its frame headers and the control frames
+// interleaved with its fragments.
+const webSocketMaxHeldOverhead = 4096
+
+const (
+	webSocketOpText  = 0x1
+	webSocketOpClose = 0x8
+
+	webSocketClosePolicyViolation = 1008
+)
+
+// webSocketFrameDecoder decodes the header of a WebSocket frame written in
+// chunks and keeps track of the remaining payload length.
+type webSocketFrameDecoder struct {
+	header     []byte
+	headerDone bool
+
+	fin        bool
+	rsv1       bool
+	opcode     byte
+	masked     bool
+	mask       [4]byte
+	maskOffset int
+	remaining  uint64
+}
+
+// writeHeader accumulates the frame header bytes and returns the number of
+// bytes of b that belong to it.
+func (f *webSocketFrameDecoder) writeHeader(b []byte) int {
+	var n int
+	for n < len(b) && !f.headerDone {
+		f.header = append(f.header, b[n])
+		n++
+		f.parseHeader()
+	}
+	return n
+}
+
+// parseHeader parses the header once complete.
+func (f *webSocketFrameDecoder) parseHeader() {
+	h := f.header
+	if len(h) < 2 {
+		return
+	}
+	size := 2
+	length := uint64(h[1] & 0x7f)
+	switch length {
+	case 126:
+		size += 2
+	case 127:
+		size += 8
+	}
+	masked := h[1]&0x80 != 0
+	if masked {
+		size += 4
+	}
+	if len(h) < size {
+		return
+	}
+
+	f.fin = h[0]&0x80 != 0
+	f.rsv1 = h[0]&0x40 != 0
+	f.opcode = h[0] & 0x0f
+	f.masked = masked
+	offset := 2
+	switch length {
+	case 126:
+		length = uint64(binary.BigEndian.Uint16(h[2:]))
+		offset += 2
+	case 127:
+		length = binary.BigEndian.Uint64(h[2:])
+		offset += 8
+	}
+	if masked {
+		copy(f.mask[:], h[offset:])
+	}
+	f.remaining = length
+	f.headerDone = true
+}
+
+func (f *webSocketFrameDecoder) isControl() bool { return f.opcode&0x8 != 0 }
+
+// compressed returns true when the message is compressed by the
+// permessage-deflate extension, which is signaled by the RSV1 bit of its first
+// frame.
+func (f *webSocketFrameDecoder) compressed() bool { return f.rsv1 }
+
+func (f *webSocketFrameDecoder) reset() {
+	*f = webSocketFrameDecoder{header: f.header[:0]}
+}
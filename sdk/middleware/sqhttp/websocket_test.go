@@ -0,0 +1,207 @@
+// Copyright (c) 2016 - 2020 Sqreen. All Rights Reserved.
+// Please refer to our terms for more information:
+// https://www.sqreen.io/terms.html
+
+package sqhttp
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"io"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"strings"
+	"testing"
+	"testing/iotest"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestWebSocket(t *testing.T) {
+	t.Run("upgrade requests", func(t *testing.T) {
+		req, err := http.NewRequest("GET", "/ws", nil)
+		require.NoError(t, err)
+		require.False(t, isWebSocketUpgrade(req))
+		req.Header.Set("Connection", "keep-alive, Upgrade")
+		req.Header.Set("Upgrade", "WebSocket")
+		require.True(t, isWebSocketUpgrade(req))
+		req.Header.Set("Upgrade", "h2c")
+		require.False(t, isWebSocketUpgrade(req))
+	})
+
+	errBlocked := errors.New("blocked")
+	// newConn returns an inspected connection reading the given client data and
+	// blocking the messages containing "attack".
+	newConn := func(data io.Reader, maxMessageSize int) (*fakeConn, net.Conn, *bufio.ReadWriter, *[]string) {
+		var inspected []string
+		waf := func(message string) error {
+			inspected = append(inspected, message)
+			if strings.Contains(message, "attack") {
+				return errBlocked
+			}
+			return nil
+		}
+		hijacked := &fakeConn{}
+		rw := bufio.NewReadWriter(bufio.NewReader(data), bufio.NewWriter(hijacked))
+		conn, rw := newWebSocketConn(hijacked, rw, waf, maxMessageSize)
+		return hijacked, conn, rw, &inspected
+	}
+
+	t.Run("allowed messages", func(t *testing.T) {
+		for _, tc := range []struct {
+			name      string
+			frames    [][]byte
+			inspected []string
+		}{
+			{
+				name:      "text message",
+				frames:    [][]byte{clientFrame(true, 0x1, "hello")},
+				inspected: []string{"hello"},
+			},
+			{
+				name: "fragmented message with interleaved control frames",
+				frames: [][]byte{
+					clientFrame(false, 0x1, "hel"),
+					clientFrame(true, 0x9, "ping"),
+					clientFrame(false, 0x0, "lo "),
+					clientFrame(true, 0x0, "world"),
+					clientFrame(true, 0x1, strings.Repeat("a", 60)),
+				},
+				inspected: []string{"hello world", strings.Repeat("a", 60)},
+			},
+			{
+				name: "binary and compressed messages",
+				frames: [][]byte{
+					clientFrame(true, 0x2, "attack"),
+					clientFrame(true, 0x40|0x1, "attack"),
+				},
+			},
+			{
+				name: "too large message",
+				frames: [][]byte{
+					clientFrame(false, 0x1, "attack "),
+					clientFrame(true, 0x0, strings.Repeat("a", 100)),
+				},
+			},
+		} {
+			tc := tc
+			t.Run(tc.name, func(t *testing.T) {
+				data := bytes.Join(tc.frames, nil)
+				// Also read byte per byte to split the frame headers
+				for _, r := range []io.Reader{bytes.NewReader(data), iotest.OneByteReader(bytes.NewReader(data))} {
+					hijacked, conn, rw, inspected := newConn(r, 64)
+					read, err := ioutil.ReadAll(conn)
+					require.NoError(t, err)
+					require.Equal(t, data, read)
+					require.Equal(t, tc.inspected, *inspected)
+					require.False(t, hijacked.closed)
+					// The new buffered reader reads the connection
+					_, err = rw.ReadByte()
+					require.Equal(t, io.EOF, err)
+				}
+			})
+		}
+	})
+
+	t.Run("endless control frames", func(t *testing.T) {
+		// Pings interleaved with the fragments of a text message
+		ping := clientFrame(true, 0x9, "ping")
+		frames := [][]byte{clientFrame(false, 0x1, "an ")}
+		for i := 0; i < 2*webSocketMaxHeldOverhead/len(ping); i++ {
+			frames = append(frames, ping)
+		}
+		frames = append(frames, clientFrame(true, 0x0, "attack"))
+		data := bytes.Join(frames, nil)
+
+		_, conn, _, _ := newConn(bytes.NewReader(data), 64)
+		c := conn.(*webSocketConn)
+		// The held bytes are limited while decoding
+		for _, frame := range frames {
+			require.NoError(t, c.decode(frame))
+			require.True(t, len(c.held) <= 64+webSocketMaxHeldOverhead)
+		}
+		require.False(t, c.inspecting)
+		require.True(t, len(c.ready) > webSocketMaxHeldOverhead)
+
+		// The message is released uninspected
+		hijacked, conn, _, inspected := newConn(bytes.NewReader(data), 64)
+		read, err := ioutil.ReadAll(conn)
+		require.NoError(t, err)
+		require.Equal(t, data, read)
+		require.Empty(t, *inspected)
+		require.False(t, hijacked.closed)
+	})
+
+	t.Run("blocked message", func(t *testing.T) {
+		allowed := clientFrame(true, 0x1, "hello")
+		data := bytes.Join([][]byte{
+			allowed,
+			clientFrame(false, 0x1, "an "),
+			clientFrame(true, 0x0, "attack"),
+			clientFrame(true, 0x1, "not read"),
+		}, nil)
+		hijacked, conn, _, inspected := newConn(bytes.NewReader(data), 64)
+		read, err := ioutil.ReadAll(conn)
+		require.Equal(t, errBlocked, err)
+		// Only the allowed message was read
+		require.Equal(t, allowed, read)
+		require.Equal(t, []string{"hello", "an attack"}, *inspected)
+		require.True(t, hijacked.closed)
+		require.Equal(t, []byte{0x88, 2, 0x03, 0xf0}, hijacked.written.Bytes())
+		// Further reads fail
+		_, err = conn.Read(make([]byte, 1))
+		require.Equal(t, errBlocked, err)
+	})
+
+	t.Run("incomplete message", func(t *testing.T) {
+		data := clientFrame(true, 0x1, "attack")
+		data = data[:len(data)-1]
+		_, conn, _, inspected := newConn(bytes.NewReader(data), 64)
+		read, err := ioutil.ReadAll(conn)
+		require.NoError(t, err)
+		require.Equal(t, data, read)
+		require.Empty(t, *inspected)
+	})
+}
+
+// clientFrame returns a masked WebSocket frame of the given opcode, which can
+// include the RSV1 bit, and payload.
+func clientFrame(fin bool, opcode byte, payload string) []byte {
+	var b bytes.Buffer
+	if fin {
+		opcode |= 0x80
+	}
+	b.WriteByte(opcode)
+	switch l := len(payload); {
+	case l < 126:
+		b.WriteByte(0x80 | byte(l))
+	case l <= 0xffff:
+		b.WriteByte(0x80 | 126)
+		_ = binary.Write(&b, binary.BigEndian, uint16(l))
+	default:
+		b.WriteByte(0x80 | 127)
+		_ = binary.Write(&b, binary.BigEndian, uint64(l))
+	}
+	mask := [4]byte{0x12, 0x34, 0x56, 0x78}
+	b.Write(mask[:])
+	for i := range payload {
+		b.WriteByte(payload[i] ^ mask[i%4])
+	}
+	return b.Bytes()
+}
+
+type fakeConn struct {
+	net.Conn
+	written bytes.Buffer
+	closed  bool
+}
+
+func (c *fakeConn) Write(b []byte) (int, error) { return c.written.Write(b) }
+
+func (c *fakeConn) Close() error {
+	c.closed = true
+	return nil
+}
@@ -48,6 +48,15 @@ type (
 		//	sqreen.TrackEvent("my.event").WithUserIdentifiers(uid).WithProperties(props)
 		//
 		TrackEvent(name string) TrackEvent
+
+		// SetRoute sets the route template of the request, such as
+		// `/users/:id`, for custom routers the middleware function cannot get
+		// it from. It is used to group the requests of the same route in the
+		// request records, instead of their raw paths.
+		//
+		//	sdk.FromContext(ctx).SetRoute("/users/:id")
+		//
+		SetRoute(route string)
 	}
 
 	context struct {
@@ -130,6 +139,10 @@ func (ctx context) TrackEvent(event string) TrackEvent {
 	return trackEvent{event: ctx.events.TrackEvent(event)}
 }
 
+func (ctx context) SetRoute(route string) {
+	ctx.events.SetRoute(route)
+}
+
 // EventPropertyMap is the type used to represent extra event properties.
 //
 //	props := sdk.EventPropertyMap{
@@ -142,6 +155,20 @@ type EventPropertyMap map[string]string
 
 func (m EventPropertyMap) MarshalJSON() ([]byte, error) { return json.Marshal(map[string]string(m)) }
 
+// EventProperties is the type used to represent extra event properties whose
+// values can be any JSON-marshalable value, such as numbers, booleans or
+// nested maps, slices and structures. Properties whose JSON representation
+// is more than 10 levels deep or larger than 8KB are dropped.
+//
+//	props := sdk.EventProperties{
+//		"amount": 42.5,
+//		"items":  []string{"a", "b"},
+//		"card":   map[string]interface{}{"type": "visa", "3ds": true},
+//	}
+//	sdk.FromContext(ctx).TrackEvent("my.event").WithStructuredProperties(props)
+//
+type EventProperties map[string]interface{}
+
 type (
 	// TrackEvent is a custom security event. Its methods allow to further
 	// define the event, such as a unique user identifier or extra properties.
@@ -164,6 +191,18 @@ type (
 		//
 		WithProperties(properties EventPropertyMap) TrackEvent
 
+		// WithStructuredProperties adds custom properties to the event whose
+		// values can be any JSON-marshalable value. It replaces the properties
+		// previously set with `WithProperties()`.
+		//
+		//	props := sdk.EventProperties{
+		//		"amount": 42.5,
+		//		"items":  []string{"a", "b"},
+		//	}
+		//	sdk.FromContext(ctx).TrackEvent("my.event").WithStructuredProperties(props)
+		//
+		WithStructuredProperties(properties EventProperties) TrackEvent
+
 		// WithTimestamp adds a custom timestamp to the event. By default, the timestamp
 		// is set to `time.Now()` value at the time of the call to the event creation.
 		//
@@ -200,6 +239,20 @@ func (e trackEvent) WithProperties(p EventPropertyMap) TrackEvent {
 	return e
 }
 
+// WithStructuredProperties adds custom properties to the event whose values
+// can be any JSON-marshalable value.
+//
+//	props := sdk.EventProperties{
+//		"amount": 42.5,
+//		"items":  []string{"a", "b"},
+//	}
+//	sdk.FromContext(ctx).TrackEvent("my.event").WithStructuredProperties(props)
+//
+func (e trackEvent) WithStructuredProperties(p EventProperties) TrackEvent {
+	e.event.WithStructuredProperties(p)
+	return e
+}
+
 // WithUserIdentifier associates the given user identifier map `id` to the
 // event.
 //
@@ -380,6 +433,7 @@ type (
 	// add options further defining the event, such as a extra properties, etc.
 	UserEvent interface {
 		WithProperties(properties EventPropertyMap) UserEvent
+		WithStructuredProperties(properties EventProperties) UserEvent
 		WithTimestamp(timestamp time.Time) UserEvent
 	}
 
@@ -411,6 +465,20 @@ func (e userEvent) WithProperties(p EventPropertyMap) UserEvent {
 	return e
 }
 
+// WithStructuredProperties adds custom properties to the event whose values
+// can be any JSON-marshalable value.
+//
+//	props := sdk.EventProperties{
+//		"amount": 42.5,
+//		"items":  []string{"a", "b"},
+//	}
+//	sdk.FromContext(ctx).ForUser(uid).TrackEvent("my.event").WithStructuredProperties(props)
+//
+func (e userEvent) WithStructuredProperties(p EventProperties) UserEvent {
+	e.event.WithStructuredProperties(p)
+	return e
+}
+
 // Identify globally associates the given UserContext identifiers to the current
 // request and returns a non-nil error if the user was blocked by Sqreen. Note
 // that when an error is returned, the request was already answered with your
@@ -440,8 +508,10 @@ type disabledEventRecorder struct{}
 
 func (disabledEventRecorder) WithTimestamp(time.Time)                            {}
 func (disabledEventRecorder) WithProperties(protection_context.EventProperties)  {}
+func (disabledEventRecorder) WithStructuredProperties(map[string]interface{})     {}
 func (disabledEventRecorder) WithUserIdentifiers(map[string]string)              {}
 func (d disabledEventRecorder) TrackEvent(string) protection_context.CustomEvent { return d }
 func (disabledEventRecorder) TrackUserSignup(map[string]string)                  {}
 func (disabledEventRecorder) TrackUserAuth(map[string]string, bool)              {}
 func (disabledEventRecorder) IdentifyUser(map[string]string) error               { return nil }
+func (disabledEventRecorder) SetRoute(string)                                    {}
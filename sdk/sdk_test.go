@@ -8,6 +8,7 @@ import (
 	"context"
 	"encoding/json"
 	"errors"
+	"math/rand"
 	"testing"
 	"time"
 
@@ -39,6 +40,7 @@ func TestFromContext(t *testing.T) {
 		recorder.ExpectWithUserIdentifiers(mock.Anything).Return(recorder)
 		recorder.ExpectWithTimestamp(mock.Anything).Return(recorder)
 		recorder.ExpectWithProperties(mock.Anything).Return(recorder)
+		recorder.ExpectWithStructuredProperties(mock.Anything).Return(recorder)
 
 		ctx := context.WithValue(context.Background(), protection_context.ContextKey, recorder)
 		sq := sdk.FromContext(ctx)
@@ -54,6 +56,7 @@ func TestFromContext(t *testing.T) {
 		recorder.ExpectWithUserIdentifiers(mock.Anything).Return(recorder)
 		recorder.ExpectWithTimestamp(mock.Anything).Return(recorder)
 		recorder.ExpectWithProperties(mock.Anything).Return(recorder)
+		recorder.ExpectWithStructuredProperties(mock.Anything).Return(recorder)
 
 		ctx := context.WithValue(context.Background(), protection_context.ContextKey.String, recorder)
 		sq := sdk.FromContext(ctx)
@@ -113,6 +116,25 @@ func TestTrackEvent(t *testing.T) {
 		sdk.FromContext(ctx).TrackEvent(eventID).WithProperties(properties)
 	})
 
+	t.Run("with structured properties", func(t *testing.T) {
+		ctx, recorder := newMockups()
+		defer recorder.AssertExpectations(t)
+
+		trackEventRecorder := &_testlib.EventRecorderMockup{}
+		defer trackEventRecorder.AssertExpectations(t)
+
+		eventID := testlib.RandUTF8String(1, 50)
+		recorder.ExpectTrackEvent(eventID).Return(trackEventRecorder).Once()
+
+		properties := sdk.EventProperties{
+			testlib.RandUTF8String(1, 50): rand.Int(),
+			testlib.RandUTF8String(1, 50): []string{testlib.RandUTF8String(1, 50)},
+		}
+		trackEventRecorder.ExpectWithStructuredProperties(map[string]interface{}(properties)).Once()
+
+		sdk.FromContext(ctx).TrackEvent(eventID).WithStructuredProperties(properties)
+	})
+
 	t.Run("with timestamp", func(t *testing.T) {
 		ctx, recorder := newMockups()
 		defer recorder.AssertExpectations(t)
@@ -337,6 +359,8 @@ func dummySDKTest(t *testing.T, sqreen sdk.Context) {
 	event = event.WithUserIdentifiers(userID)
 	props := sdk.EventPropertyMap{testlib.RandPrintableUSASCIIString(2, 30): testlib.RandPrintableUSASCIIString(2, 30)}
 	event = event.WithProperties(props)
+	structuredProps := sdk.EventProperties{testlib.RandPrintableUSASCIIString(2, 30): rand.Int()}
+	event = event.WithStructuredProperties(structuredProps)
 	uid := sdk.EventUserIdentifiersMap{testlib.RandPrintableUSASCIIString(2, 30): testlib.RandPrintableUSASCIIString(2, 30)}
 	sqUser := sqreen.ForUser(uid)
 	sqUser = sqUser.TrackSignup()
@@ -346,6 +370,7 @@ func dummySDKTest(t *testing.T, sqreen sdk.Context) {
 	require.NoError(t, sqUser.Identify())
 	sqUserEvent := sqUser.TrackEvent(testlib.RandPrintableUSASCIIString(0, 50))
 	sqUserEvent = sqUserEvent.WithProperties(props)
+	sqUserEvent = sqUserEvent.WithStructuredProperties(structuredProps)
 	sqUserEvent = sqUserEvent.WithTimestamp(time.Now())
 }
 
@@ -0,0 +1,136 @@
+// Copyright (c) 2016 - 2020 Sqreen. All Rights Reserved.
+// Please refer to our terms for more information:
+// https://www.sqreen.io/terms.html
+
+// Command sqreen-binding-accessor-tool validates binding accessor expressions
+// and evaluates them against a sample HTTP request, so that rule authors can
+// find mistakes before the agent reports them at run time.
+package main
+
+import (
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"strings"
+
+	"github.com/sqreen/go-agent/internal/protection/http/types"
+	"github.com/sqreen/go-agent/internal/version"
+)
+
+func main() {
+	log.SetFlags(0)
+	log.SetPrefix("sqreen: ")
+	log.SetOutput(os.Stderr)
+
+	var (
+		rulesFile   = flag.String("rules", "", "")
+		requestFile = flag.String("request", "", "")
+	)
+	flag.Usage = printUsage
+	flag.Parse()
+
+	var expressions []expression
+	if *rulesFile != "" {
+		f, err := os.Open(*rulesFile)
+		if err != nil {
+			log.Fatalln(err)
+		}
+		expressions, err = loadRuleExpressions(f)
+		f.Close()
+		if err != nil {
+			log.Fatalf("could not load the rules file `%s`: %v", *rulesFile, err)
+		}
+	}
+	for _, expr := range flag.Args() {
+		expressions = append(expressions, expression{Source: "command line", Expr: expr})
+	}
+	if len(expressions) == 0 {
+		log.Println("no binding accessor expressions to check")
+		printUsage()
+	}
+
+	var req types.RequestReader
+	if *requestFile != "" {
+		f, err := os.Open(*requestFile)
+		if err != nil {
+			log.Fatalln(err)
+		}
+		req, err = loadRequest(f)
+		f.Close()
+		if err != nil {
+			log.Fatalf("could not load the request file `%s`: %v", *requestFile, err)
+		}
+	}
+
+	if ok := run(os.Stdout, expressions, req); !ok {
+		os.Exit(1)
+	}
+}
+
+// run checks the given expressions and evaluates them when the request is not
+// nil. The report is written into w. It returns false when at least one
+// expression is invalid.
+func run(w io.Writer, expressions []expression, req types.RequestReader) (ok bool) {
+	ok = true
+	for _, e := range expressions {
+		result := check(e, req)
+		fmt.Fprintf(w, "%s: `%s`\n", e.Source, e.Expr)
+		if caps := result.Capabilities; len(caps) > 0 {
+			fmt.Fprintf(w, "    capabilities: %s\n", strings.Join(caps, ", "))
+		}
+		for _, err := range result.Errors {
+			ok = false
+			fmt.Fprintf(w, "    error: %v\n", err)
+		}
+		switch {
+		case result.Skipped != "":
+			fmt.Fprintf(w, "    evaluation skipped: %s\n", result.Skipped)
+		case result.Evaluated:
+			fmt.Fprintf(w, "    value: %s\n", formatValue(result.Value))
+		}
+	}
+	return ok
+}
+
+func formatValue(v interface{}) string {
+	var buf strings.Builder
+	enc := json.NewEncoder(&buf)
+	enc.SetEscapeHTML(false)
+	if err := enc.Encode(v); err != nil {
+		return fmt.Sprintf("%#v", v)
+	}
+	return strings.TrimSuffix(buf.String(), "\n")
+}
+
+func printUsage() {
+	const usageFormat = `Usage: %s [-rules FILE] [-request FILE] [EXPRESSION...]
+
+Sqreen's binding accessor tool for Go agent v%s. It compiles the binding
+accessor expressions found in a rules file, or given on the command line, and
+reports the binding accessor capabilities each of them requires (request,
+func, sql, rule or lib). Rules whose hookpoint configuration do not declare the
+required capabilities are reported as errors.
+
+The expressions can also be evaluated against a sample HTTP request in order to
+check their resulting values.
+
+Options:
+        -h
+                Print this usage message.
+        -rules FILE
+                JSON rules file, either a rules pack object having a "rules"
+                key, or an array of rules.
+        -request FILE
+                Sample HTTP request to evaluate the expressions with, either a
+                raw HTTP request, or a JSON object with the optional keys
+                "method", "url", "headers", "body", "remote_addr", "client_ip"
+                and "params".
+
+The exit code is 1 when at least one expression is invalid.
+`
+	_, _ = fmt.Fprintf(os.Stderr, usageFormat, os.Args[0], version.Version())
+	os.Exit(2)
+}
@@ -0,0 +1,217 @@
+// Copyright (c) 2016 - 2020 Sqreen. All Rights Reserved.
+// Please refer to our terms for more information:
+// https://www.sqreen.io/terms.html
+
+package main
+
+import (
+	"bytes"
+	"net"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+const testRules = `{
+  "pack_id": "pack",
+  "rules": [
+    {
+      "name": "js-rule",
+      "hookpoint": {
+        "strategy": "reflected",
+        "klass": "database/sql",
+        "method": "*DB.Query",
+        "callback_class": "",
+        "arguments_options": {
+          "binding_accessor": { "capabilities": [ "func" ] }
+        }
+      },
+      "data": { "values": [ "a", "b" ] },
+      "callbacks": {
+        "pre": [ "#.Func.Args[2]", "#.Request.FilteredParams", "#.Rule.Data.Values", "function pre(){}" ]
+      }
+    },
+    {
+      "name": "waf-rule",
+      "hookpoint": { "klass": "", "method": "", "callback_class": "WAF" },
+      "data": {
+        "values": [
+          { "type": "waf", "binding_accessors": [ "#.Request.Params | flat_values" ], "waf_rules": "{}" }
+        ]
+      },
+      "callbacks": {}
+    },
+    {
+      "name": "function-waf-rule",
+      "hookpoint": {
+        "strategy": "reflected",
+        "klass": "os",
+        "method": "OpenFile",
+        "callback_class": "",
+        "arguments_options": {
+          "binding_accessor": { "capabilities": [ "func", "request" ] }
+        }
+      },
+      "callbacks": {
+        "type": "function_waf",
+        "pre": [ { "name": "server.request.query", "value": "#.Request.QueryForm" } ]
+      }
+    }
+  ]
+}`
+
+func TestLoadRuleExpressions(t *testing.T) {
+	for _, rules := range []string{
+		testRules,
+		testRules[strings.Index(testRules, "[") : strings.LastIndex(testRules, "]")+1],
+	} {
+		expressions, err := loadRuleExpressions(strings.NewReader(rules))
+		require.NoError(t, err)
+		require.Len(t, expressions, 6)
+
+		require.Equal(t, "rule `js-rule` pre callback", expressions[0].Source)
+		require.Equal(t, "#.Func.Args[2]", expressions[0].Expr)
+		require.Equal(t, []string{"func"}, expressions[0].Capabilities)
+		require.Equal(t, []interface{}{[]string{"a", "b"}}, expressions[0].RuleData)
+		require.Equal(t, "#.Request.FilteredParams", expressions[1].Expr)
+		require.Equal(t, "#.Rule.Data.Values", expressions[2].Expr)
+
+		require.Equal(t, "rule `waf-rule` waf data", expressions[3].Source)
+		require.Equal(t, "#.Request.Params | flat_values", expressions[3].Expr)
+		require.Equal(t, []string{"request"}, expressions[3].Capabilities)
+
+		require.Equal(t, "rule `function-waf-rule` pre callback", expressions[4].Source)
+		require.Equal(t, "server.request.query", expressions[4].Expr)
+		require.Equal(t, "#.Request.QueryForm", expressions[5].Expr)
+	}
+
+	_, err := loadRuleExpressions(strings.NewReader(`{"rules": 33}`))
+	require.Error(t, err)
+}
+
+func TestRequiredCapabilities(t *testing.T) {
+	for _, tc := range []struct {
+		expr    string
+		caps    []string
+		unknown []string
+	}{
+		{expr: "#", caps: nil},
+		{expr: "#.Request.Header('user-agent')", caps: []string{"request"}},
+		{expr: "#.Lib.Array.Prepend(#.Func.Args[0], #.SQL.Dialect)", caps: []string{"func", "lib", "sql"}},
+		{expr: "#.Rule.Data.Values | flat_keys", caps: []string{"rule"}},
+		{expr: "#.Request.Header('#.Func')", caps: []string{"request"}},
+		{expr: "#.Request.Header(\"#.Func\")", caps: []string{"request"}},
+		{expr: "#.Foo.Bar", unknown: []string{"Foo"}},
+		{expr: "server.request.query", caps: nil},
+	} {
+		tc := tc
+		t.Run(tc.expr, func(t *testing.T) {
+			caps, unknown := requiredCapabilities(tc.expr)
+			require.Equal(t, tc.caps, caps)
+			require.Equal(t, tc.unknown, unknown)
+		})
+	}
+}
+
+func TestLoadRequest(t *testing.T) {
+	t.Run("raw http request", func(t *testing.T) {
+		raw := "POST /foo?a=1 HTTP/1.1\n" +
+			"Host: example.com\n" +
+			"Content-Type: application/x-www-form-urlencoded\n" +
+			"X-Forwarded-For: 1.2.3.4\n" +
+			"Content-Length: 5\n" +
+			"\n" +
+			"b=two"
+		req, err := loadRequest(strings.NewReader(raw))
+		require.NoError(t, err)
+		require.Equal(t, "POST", req.Method())
+		require.Equal(t, "/foo?a=1", req.RequestURI())
+		require.Equal(t, "example.com", req.Host())
+		require.Equal(t, "1", req.QueryForm().Get("a"))
+		require.Equal(t, "two", req.PostForm().Get("b"))
+		require.Equal(t, []byte("b=two"), req.Body())
+		require.Equal(t, "1.2.3.4", req.ClientIP().String())
+		require.Equal(t, "1.2.3.4", *req.Header("x-forwarded-for"))
+		require.Nil(t, req.Header("user-agent"))
+	})
+
+	t.Run("json request fixture", func(t *testing.T) {
+		fixture := `{
+			"method": "PUT",
+			"url": "/foo?a=1",
+			"headers": { "Host": "example.com", "Accept": [ "text/html", "*/*" ] },
+			"body": "{\"b\": 2}",
+			"remote_addr": "5.6.7.8:1234",
+			"params": { "id": [ "33" ] }
+		}`
+		req, err := loadRequest(strings.NewReader(fixture))
+		require.NoError(t, err)
+		require.Equal(t, "PUT", req.Method())
+		require.Equal(t, "example.com", req.Host())
+		require.Equal(t, []string{"text/html", "*/*"}, req.Headers()["Accept"])
+		require.Equal(t, []byte(`{"b": 2}`), req.Body())
+		require.Equal(t, "5.6.7.8", req.ClientIP().String())
+		require.Equal(t, []interface{}{"33"}, req.Params()["id"])
+	})
+
+	t.Run("json request fixture with a client ip", func(t *testing.T) {
+		req, err := loadRequest(strings.NewReader(`{ "client_ip": "::1" }`))
+		require.NoError(t, err)
+		require.Equal(t, "GET", req.Method())
+		require.Equal(t, "/", req.URL().Path)
+		require.True(t, req.ClientIP().Equal(net.IPv6loopback))
+
+		_, err = loadRequest(strings.NewReader(`{ "client_ip": "oops" }`))
+		require.Error(t, err)
+	})
+}
+
+func TestRun(t *testing.T) {
+	expressions, err := loadRuleExpressions(strings.NewReader(testRules))
+	require.NoError(t, err)
+
+	req, err := loadRequest(strings.NewReader(`{ "url": "/?a=<script>" }`))
+	require.NoError(t, err)
+
+	t.Run("lint only", func(t *testing.T) {
+		var out bytes.Buffer
+		ok := run(&out, expressions, nil)
+		require.False(t, ok)
+		report := out.String()
+		require.Contains(t, report, "rule `js-rule` pre callback: `#.Request.FilteredParams`\n    capabilities: request\n    error: capability `request` is required but not declared by the rule\n")
+		require.Contains(t, report, "rule `js-rule` pre callback: `#.Rule.Data.Values`\n    capabilities: rule\n    error: capability `rule` is required but not declared by the rule\n")
+		require.NotContains(t, report, "value:")
+	})
+
+	t.Run("evaluation", func(t *testing.T) {
+		var out bytes.Buffer
+		ok := run(&out, []expression{
+			{Source: "command line", Expr: "#.Request.FilteredParams | flat_values"},
+			{Source: "command line", Expr: "#.Rule.Data.Values", RuleData: []string{"a", "b"}},
+			{Source: "command line", Expr: "#.Func.Args[0]"},
+		}, req)
+		require.True(t, ok)
+		require.Equal(t, "command line: `#.Request.FilteredParams | flat_values`\n"+
+			"    capabilities: request\n"+
+			"    value: [\"<script>\"]\n"+
+			"command line: `#.Rule.Data.Values`\n"+
+			"    capabilities: rule\n"+
+			"    value: [\"a\",\"b\"]\n"+
+			"command line: `#.Func.Args[0]`\n"+
+			"    capabilities: func\n"+
+			"    evaluation skipped: the `func` capability requires the arguments of an actual function call\n",
+			out.String())
+	})
+
+	t.Run("invalid expressions", func(t *testing.T) {
+		var out bytes.Buffer
+		ok := run(&out, []expression{
+			{Source: "command line", Expr: "#.Request.Oops"},
+			{Source: "command line", Expr: "#.Request |"},
+		}, req)
+		require.False(t, ok)
+		require.Contains(t, out.String(), "command line: `#.Request.Oops`\n    capabilities: request\n    error: ")
+		require.Contains(t, out.String(), "command line: `#.Request |`\n    capabilities: request\n    error: ")
+	})
+}
@@ -0,0 +1,160 @@
+// Copyright (c) 2016 - 2020 Sqreen. All Rights Reserved.
+// Please refer to our terms for more information:
+// https://www.sqreen.io/terms.html
+
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"net/textproto"
+	"net/url"
+
+	http_protection "github.com/sqreen/go-agent/internal/protection/http"
+	"github.com/sqreen/go-agent/internal/protection/http/types"
+)
+
+// requestFixture is the JSON representation of a sample HTTP request.
+type requestFixture struct {
+	Method     string                 `json:"method"`
+	URL        string                 `json:"url"`
+	Headers    map[string]headerValue `json:"headers"`
+	Body       string                 `json:"body"`
+	RemoteAddr string                 `json:"remote_addr"`
+	ClientIP   string                 `json:"client_ip"`
+	// Params are the request parameters parsed by the framework, such as the
+	// route parameters.
+	Params types.RequestParamMap `json:"params"`
+}
+
+// headerValue is a header value given either as a string or an array of
+// strings.
+type headerValue []string
+
+func (v *headerValue) UnmarshalJSON(data []byte) error {
+	var str string
+	if err := json.Unmarshal(data, &str); err == nil {
+		*v = headerValue{str}
+		return nil
+	}
+	return json.Unmarshal(data, (*[]string)(v))
+}
+
+// loadRequest reads the sample HTTP request in r. It is either a JSON request
+// fixture, or a raw HTTP request.
+func loadRequest(r io.Reader) (types.RequestReader, error) {
+	buf, err := ioutil.ReadAll(r)
+	if err != nil {
+		return nil, err
+	}
+
+	if trimmed := bytes.TrimSpace(buf); len(trimmed) > 0 && trimmed[0] == '{' {
+		var fixture requestFixture
+		if err := json.Unmarshal(trimmed, &fixture); err != nil {
+			return nil, err
+		}
+		return newRequestFromFixture(&fixture)
+	}
+
+	req, err := http.ReadRequest(bufio.NewReader(bytes.NewReader(buf)))
+	if err != nil {
+		return nil, err
+	}
+	body, err := ioutil.ReadAll(req.Body)
+	if err != nil && err != io.ErrUnexpectedEOF {
+		return nil, err
+	}
+	return newRequestReader(req, body, nil, nil), nil
+}
+
+func newRequestFromFixture(f *requestFixture) (types.RequestReader, error) {
+	method := f.Method
+	if method == "" {
+		method = http.MethodGet
+	}
+	target := f.URL
+	if target == "" {
+		target = "/"
+	}
+	req, err := http.NewRequest(method, target, nil)
+	if err != nil {
+		return nil, err
+	}
+	req.RequestURI = req.URL.RequestURI()
+	req.RemoteAddr = f.RemoteAddr
+	for k, values := range f.Headers {
+		for _, v := range values {
+			req.Header.Add(k, v)
+		}
+	}
+	if host := req.Header.Get("Host"); host != "" {
+		req.Host = host
+	}
+
+	var clientIP net.IP
+	if f.ClientIP != "" {
+		clientIP = net.ParseIP(f.ClientIP)
+		if clientIP == nil {
+			return nil, fmt.Errorf("invalid client ip `%s`", f.ClientIP)
+		}
+	}
+	return newRequestReader(req, []byte(f.Body), f.Params, clientIP), nil
+}
+
+// newRequestReader returns a request reader of the given request. The client
+// IP is computed from the request when nil.
+func newRequestReader(req *http.Request, body []byte, params types.RequestParamMap, clientIP net.IP) *requestReader {
+	if req.RemoteAddr == "" {
+		req.RemoteAddr = "127.0.0.1:12345"
+	}
+	req.Body = ioutil.NopCloser(bytes.NewReader(body))
+	req.ContentLength = int64(len(body))
+	// Malformed forms are part of the requests worth testing, so the values
+	// parsed before the error are kept.
+	_ = req.ParseForm()
+	if clientIP == nil {
+		clientIP = http_protection.ClientIP(req.RemoteAddr, req.Header, "", "", nil)
+	}
+	return &requestReader{
+		Request:  req,
+		body:     body,
+		params:   params,
+		clientIP: clientIP,
+	}
+}
+
+// requestReader implements the request reader interface of a sample request
+// whose body has already been entirely read.
+type requestReader struct {
+	*http.Request
+	body     []byte
+	params   types.RequestParamMap
+	clientIP net.IP
+}
+
+func (r *requestReader) Header(h string) (value *string) {
+	v := r.Request.Header[textproto.CanonicalMIMEHeaderKey(h)]
+	if len(v) == 0 {
+		return nil
+	}
+	return &v[0]
+}
+
+func (r *requestReader) Headers() http.Header          { return r.Request.Header }
+func (r *requestReader) Method() string                { return r.Request.Method }
+func (r *requestReader) URL() *url.URL                 { return r.Request.URL }
+func (r *requestReader) RequestURI() string            { return r.Request.RequestURI }
+func (r *requestReader) Host() string                  { return r.Request.Host }
+func (r *requestReader) RemoteAddr() string            { return r.Request.RemoteAddr }
+func (r *requestReader) IsTLS() bool                   { return r.Request.TLS != nil }
+func (r *requestReader) QueryForm() url.Values         { return r.Request.URL.Query() }
+func (r *requestReader) PostForm() url.Values          { return r.Request.PostForm }
+func (r *requestReader) ClientIP() net.IP              { return r.clientIP }
+func (r *requestReader) Params() types.RequestParamMap { return r.params }
+func (r *requestReader) Body() []byte                  { return r.body }
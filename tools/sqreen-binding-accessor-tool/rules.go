@@ -0,0 +1,281 @@
+// Copyright (c) 2016 - 2020 Sqreen. All Rights Reserved.
+// Please refer to our terms for more information:
+// https://www.sqreen.io/terms.html
+
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"reflect"
+	"sort"
+
+	"github.com/sqreen/go-agent/internal/backend/api"
+	bindingaccessor "github.com/sqreen/go-agent/internal/binding-accessor"
+	"github.com/sqreen/go-agent/internal/protection/http/types"
+	"github.com/sqreen/go-agent/internal/rule/callback"
+)
+
+// expression is a binding accessor expression to check, along with the
+// information of the rule it comes from, if any.
+type expression struct {
+	// Source describes where the expression comes from.
+	Source string
+	// Expr is the binding accessor expression.
+	Expr string
+	// Capabilities are the binding accessor capabilities declared by the rule.
+	// They are not checked when nil.
+	Capabilities []string
+	// RuleData is the rule data accessible to the expression through its
+	// `rule` capability.
+	RuleData interface{}
+}
+
+// Binding accessor capabilities, indexed by their root field in the binding
+// accessor context.
+var capabilitiesByField = map[string]string{
+	"Request": "request",
+	"Func":    "func",
+	"SQL":     "sql",
+	"Rule":    "rule",
+	"Lib":     "lib",
+}
+
+// loadRuleExpressions reads the JSON rules in r and returns the binding
+// accessor expressions they contain. Both rules packs, as returned by the
+// backend, and arrays of rules are accepted.
+func loadRuleExpressions(r io.Reader) ([]expression, error) {
+	buf, err := ioutil.ReadAll(r)
+	if err != nil {
+		return nil, err
+	}
+
+	var rules []api.Rule
+	if trimmed := bytes.TrimSpace(buf); len(trimmed) > 0 && trimmed[0] == '[' {
+		err = json.Unmarshal(trimmed, &rules)
+	} else {
+		var pack api.RulesPackResponse
+		err = json.Unmarshal(trimmed, &pack)
+		rules = pack.Rules
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	var expressions []expression
+	for i := range rules {
+		expressions = append(expressions, ruleExpressions(&rules[i])...)
+	}
+	return expressions, nil
+}
+
+// ruleExpressions returns the binding accessor expressions of the given rule,
+// found in its callbacks and in its WAF data.
+func ruleExpressions(r *api.Rule) []expression {
+	var (
+		expressions []expression
+		data        = ruleDataValues(r.Data.Values)
+		caps        []string
+	)
+	if cfg := r.Hookpoint.Config; cfg != nil {
+		caps = cfg.BindingAccessor.Capabilities
+		if caps == nil {
+			caps = []string{}
+		}
+	}
+
+	add := func(source string, exprs ...string) {
+		for _, expr := range exprs {
+			expressions = append(expressions, expression{
+				Source:       fmt.Sprintf("rule `%s` %s", r.Name, source),
+				Expr:         expr,
+				Capabilities: caps,
+				RuleData:     data,
+			})
+		}
+	}
+
+	switch callbacks := r.Callbacks.RuleCallbacksNode.(type) {
+	case *api.RuleJSCallbacks:
+		// The last element is the JS source code of the callback
+		if l := len(callbacks.Pre); l > 1 {
+			add("pre callback", callbacks.Pre[:l-1]...)
+		}
+		if l := len(callbacks.Post); l > 1 {
+			add("post callback", callbacks.Post[:l-1]...)
+		}
+	case *api.RuleFunctionWAFCallbacks:
+		add("pre callback", sortedKeyValues(callbacks.Pre)...)
+		add("post callback", sortedKeyValues(callbacks.Post)...)
+	}
+
+	for _, entry := range r.Data.Values {
+		waf, ok := entry.Value.(*api.WAFRuleDataEntry)
+		if !ok {
+			continue
+		}
+		for _, expr := range waf.BindingAccessors {
+			// The WAF binding accessor context only provides the request
+			expressions = append(expressions, expression{
+				Source:       fmt.Sprintf("rule `%s` waf data", r.Name),
+				Expr:         expr,
+				Capabilities: []string{"request"},
+			})
+		}
+	}
+
+	return expressions
+}
+
+// ruleDataValues returns the rule data the way the agent provides it to
+// binding accessors.
+func ruleDataValues(values []api.RuleDataEntry) interface{} {
+	l := len(values)
+	if l == 0 {
+		return nil
+	}
+	if l == 1 && values[0].Value != nil && reflect.TypeOf(values[0].Value).Kind() != reflect.Slice {
+		return values[0].Value
+	}
+	data := make([]interface{}, l)
+	for i := range values {
+		data[i] = values[i].Value
+	}
+	return data
+}
+
+// sortedKeyValues returns the keys and values of the map m, sorted by key so
+// that the report is stable.
+func sortedKeyValues(m map[string]string) []string {
+	keys := make([]string, 0, len(m))
+	for k := range m {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	kv := make([]string, 0, 2*len(keys))
+	for _, k := range keys {
+		kv = append(kv, k, m[k])
+	}
+	return kv
+}
+
+// requiredCapabilities returns the sorted list of capabilities the expression
+// requires, according to the root fields it accesses in the binding accessor
+// context (eg. `#.Request` requires the `request` capability). Root fields not
+// provided by the binding accessor context are returned as unknown.
+func requiredCapabilities(expr string) (caps []string, unknown []string) {
+	capSet := map[string]struct{}{}
+	unknownSet := map[string]struct{}{}
+	for i := 0; i < len(expr); i++ {
+		switch c := expr[i]; c {
+		case '\'', '"':
+			// Skip string literals
+			for i++; i < len(expr) && expr[i] != c; i++ {
+				if expr[i] == '\\' {
+					i++
+				}
+			}
+		case '#':
+			if i+1 >= len(expr) || expr[i+1] != '.' {
+				continue
+			}
+			start := i + 2
+			end := start
+			for end < len(expr) && isIdentifierChar(expr[end]) {
+				end++
+			}
+			if field := expr[start:end]; field != "" {
+				if cap, exists := capabilitiesByField[field]; exists {
+					capSet[cap] = struct{}{}
+				} else {
+					unknownSet[field] = struct{}{}
+				}
+			}
+			i = end - 1
+		}
+	}
+	return sortedSet(capSet), sortedSet(unknownSet)
+}
+
+func isIdentifierChar(c byte) bool {
+	return c == '_' || ('a' <= c && c <= 'z') || ('A' <= c && c <= 'Z') || ('0' <= c && c <= '9')
+}
+
+func sortedSet(set map[string]struct{}) []string {
+	if len(set) == 0 {
+		return nil
+	}
+	l := make([]string, 0, len(set))
+	for v := range set {
+		l = append(l, v)
+	}
+	sort.Strings(l)
+	return l
+}
+
+// checkResult is the result of checking an expression.
+type checkResult struct {
+	Capabilities []string
+	Errors       []error
+	Evaluated    bool
+	Value        interface{}
+	// Skipped is the reason why the evaluation was not possible.
+	Skipped string
+}
+
+// check compiles the expression, checks its capabilities and evaluates it
+// against the request when not nil.
+func check(e expression, req types.RequestReader) (result checkResult) {
+	program, err := bindingaccessor.Compile(e.Expr)
+	if err != nil {
+		result.Errors = append(result.Errors, err)
+	}
+
+	caps, unknown := requiredCapabilities(e.Expr)
+	result.Capabilities = caps
+	for _, field := range unknown {
+		result.Errors = append(result.Errors, fmt.Errorf("unknown binding accessor context field `%s`", field))
+	}
+	if e.Capabilities != nil {
+		for _, cap := range caps {
+			if !contains(e.Capabilities, cap) {
+				result.Errors = append(result.Errors, fmt.Errorf("capability `%s` is required but not declared by the rule", cap))
+			}
+		}
+	}
+
+	if req == nil || len(result.Errors) > 0 {
+		return result
+	}
+	if contains(caps, "func") {
+		result.Skipped = "the `func` capability requires the arguments of an actual function call"
+		return result
+	}
+
+	ctx := &callback.BindingAccessorContextType{
+		Lib:                               callback.NewLibraryBindingAccessorContext(),
+		SQL:                               callback.NewSQLBindingAccessorContext(),
+		Rule:                              callback.NewRuleBindingAccessorContext(e.RuleData),
+		HTTPRequestBindingAccessorContext: callback.NewHTTPRequestBindingAccessorContext(req),
+	}
+	value, err := program(ctx)
+	if err != nil {
+		result.Errors = append(result.Errors, err)
+		return result
+	}
+	result.Evaluated = true
+	result.Value = value
+	return result
+}
+
+func contains(l []string, v string) bool {
+	for _, e := range l {
+		if e == v {
+			return true
+		}
+	}
+	return false
+}